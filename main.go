@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
@@ -65,11 +69,37 @@ var g_browserCDPEndpoint = ""
 var g_browserCDPPort = 0
 var g_browserCDPExecutable = ""
 var g_browserCDPUserDataDir = ""
+var g_flowParams map[string]any
+var g_resumeRunID = ""
+var g_retention *tsplay_core.FlowArtifactRetentionPolicy
+var g_browserTrace = ""
+var g_browserEngine = ""
+var g_stopTracing = func() {}
+var g_reports []tsplay_core.FlowReportTarget
+
+// repeatedFlag collects every occurrence of a flag that may be given more than
+// once, such as -var name=value.
+type repeatedFlag []string
+
+func (values *repeatedFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *repeatedFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
 
 func main() {
 	action := flag.String("action", "cli", "Start Cli Mod | Web Mod | GPT Mod | MCP Stdio | MCP Tool | File Server | Workbench API | Install Playwright")
 	tsfile := flag.String("script", "", "tsplay script file")
 	flowfile := flag.String("flow", "", "tsplay flow file")
+	var flowVars repeatedFlag
+	flag.Var(&flowVars, "var", "flow parameter as name=value for -flow; may be repeated and overrides -vars-file")
+	var reports repeatedFlag
+	flag.Var(&reports, "report", "write a report of the -flow run as junit:path or html:path; may be repeated")
+	flowVarsFile := flag.String("vars-file", "", "JSON object file with flow parameter values for -flow")
+	resumeRunID := flag.String("resume", "", "run id of a failed -flow run to resume from its failed step; reads the checkpoint under -artifact-root")
 	addr := flag.String("addr", ":8082", "server listen address")
 	flowRoot := flag.String("flow-root", tsplay_core.DefaultMCPFlowPathRoot, "allowed root directory for MCP flow_path")
 	artifactRoot := flag.String("artifact-root", tsplay_core.DefaultMCPArtifactRoot, "allowed root directory for MCP file input/output paths")
@@ -99,17 +129,55 @@ func main() {
 	browserCDPPort := flag.Int("browser-cdp-port", 0, "attach to an existing Chromium browser over CDP using this local debugging port, for example 9222")
 	browserCDPExecutable := flag.String("browser-cdp-executable", "", "optional Chrome/Chromium/Edge executable path for -browser-cdp-launch; auto-detected when omitted")
 	browserCDPUserDataDir := flag.String("browser-cdp-user-data-dir", "", "optional user data directory for -browser-cdp-launch; defaults under the artifact root")
+	browserEngine := flag.String("browser-engine", "", "browser engine for -flow, -action test, Lua scripts, the CLI and -action save-session profiles: chromium, firefox or webkit; overrides browser.engine. -action install-playwright accepts a comma-separated list such as chromium,firefox,webkit")
+	browserTrace := flag.String("browser-trace", "", "record a Playwright trace to <run root>/trace.zip when running -flow: off, on or retain-on-failure; overrides browser.trace")
 	sessionName := flag.String("session-name", "", "saved session name for session management actions")
 	storageStatePath := flag.String("storage-state-path", "", "storage state path for save-session actions")
 	storageStateJSON := flag.String("storage-state-json", "", "inline storage state JSON for save-session actions")
+	proxyJSON := flag.String("proxy-json", "", "proxy JSON saved with save-session, e.g. {\"server\":\"http://proxy:3128\",\"connection\":\"corp\"}; credentials come from TSPLAY_PROXY_<CONNECTION>_USERNAME/PASSWORD")
+	fingerprintJSON := flag.String("fingerprint-json", "", "browser fingerprint JSON saved with save-session, e.g. {\"device\":\"iPhone 13\",\"locale\":\"zh-CN\"}")
 	profileName := flag.String("profile-name", "", "persistent profile name for save-session actions")
 	profileSession := flag.String("profile-session", "", "persistent profile session name for save-session actions")
 	sessionFormat := flag.String("session-format", "all", "snippet format for export-session action")
+	secretName := flag.String("secret-name", "", "secret name for set-secret and delete-secret actions; set-secret reads the value from stdin")
+	scheduleFile := flag.String("schedule", "", "schedule file (YAML or JSON) for -action scheduler and scheduler-history")
+	scheduleJob := flag.String("schedule-job", "", "only show history of this schedule job for -action scheduler-history")
+	runStatus := flag.String("run-status", "", "only show runs with this status, for example failed or skipped")
+	runFlowName := flag.String("run-flow", "", "only show runs of this flow name for -action list-runs")
+	runSession := flag.String("run-session", "", "only show runs of this session id for -action list-runs")
+	runSince := flag.String("since", "", "only show runs started at or after this time for -action list-runs: RFC 3339, YYYY-MM-DD, or a lookback such as 24h or 7d")
+	runUntil := flag.String("until", "", "only show runs started before this time for -action list-runs")
+	runID := flag.String("run-id", "", "run id for -action get-run")
+	limit := flag.Int("limit", 50, "maximum number of records to print; 0 prints all")
+	keepLast := flag.Int("keep-last", 0, "retention: keep the newest N runs of every flow for -action gc-artifacts and -gc-after-run")
+	maxAge := flag.String("max-age", "", "retention: delete runs older than this lookback, for example 72h or 30d")
+	maxBytes := flag.String("max-bytes", "", "retention: delete the oldest runs until the artifact root fits, for example 500MB or 2GB")
+	keepFailed := flag.Bool("keep-failed", true, "retention: never delete failed runs")
+	dryRun := flag.Bool("dry-run", false, "only report what -action gc-artifacts would delete")
+	gcAfterRun := flag.Bool("gc-after-run", false, "apply the retention flags after each -flow, scheduler or MCP run")
+	mcpAllRuns := flag.Bool("mcp-all-runs", false, "admin only: let tsplay.list_runs and tsplay.get_run see the runs of every MCP session, not just the caller's")
+	testDir := flag.String("test-dir", "script/regression", "directory of flow files for -action test")
+	testWorkers := flag.Int("workers", 1, "how many flows -action test runs at once")
+	testRetries := flag.Int("retries", 0, "rerun a failed flow up to this many times for -action test; flows that pass on a retry are reported as flaky")
+	testTags := flag.String("tags", "", "comma-separated flow tags for -action test; prefix a tag with ! to exclude it, for example smoke,!slow")
+	testFilter := flag.String("test-filter", "", "only run flows whose path or name contains this text or matches this glob for -action test")
+	testSetup := flag.String("setup", "", "flow run once before the tests of -action test; defaults to setup.flow.yaml in -test-dir")
+	testTeardown := flag.String("teardown", "", "flow run once after the tests of -action test; defaults to teardown.flow.yaml in -test-dir")
+	otelEndpoint := flag.String("otel-endpoint", "", "export OpenTelemetry spans to this OTLP/HTTP collector, for example http://127.0.0.1:4318; defaults to OTEL_EXPORTER_OTLP_ENDPOINT")
+	otelFile := flag.String("otel-file", "", "append OpenTelemetry spans as OTLP JSON lines to this file; defaults to TSPLAY_OTEL_TRACES_FILE")
+	logFormat := flag.String("log-format", "text", "log format on stderr: text or json")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
 	isheadless := flag.Bool("headless", false, "is hide browser")
 
 	// 解析命令行参数
 	flag.Parse()
 
+	// Logs always go to stderr: stdout carries flow results, CLI output and
+	// the MCP stdio protocol.
+	if err := tsplay_core.ConfigureLogging(*logFormat, *logLevel, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+
 	browserCDPEndpointSet := false
 	browserCDPPortSet := false
 	browserCDPExecutableSet := false
@@ -138,19 +206,55 @@ func main() {
 	g_browserCDPPort = *browserCDPPort
 	g_browserCDPExecutable = strings.TrimSpace(*browserCDPExecutable)
 	g_browserCDPUserDataDir = strings.TrimSpace(*browserCDPUserDataDir)
+	g_resumeRunID = strings.TrimSpace(*resumeRunID)
+	g_browserTrace = strings.TrimSpace(*browserTrace)
+	g_browserEngine = strings.TrimSpace(*browserEngine)
 	if g_browserCDPExecutable != "" || g_browserCDPUserDataDir != "" {
 		g_browserCDPLaunch = true
 	}
+	retention, err := retentionPolicyFromFlags(*keepLast, *maxAge, *maxBytes, *keepFailed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *gcAfterRun {
+		if !retention.Enabled() {
+			log.Fatal("-gc-after-run needs at least one of -keep-last, -max-age or -max-bytes")
+		}
+		g_retention = &retention
+	}
+	for _, value := range reports {
+		target, err := tsplay_core.ParseFlowReportTarget(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g_reports = append(g_reports, target)
+	}
+	stopTracing, err := startTracingFromFlags(*otelEndpoint, *otelFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g_stopTracing = stopTracing
+	defer g_stopTracing()
 	if err := validateBrowserCDPFlagOptions(g_browserCDPEndpoint, browserCDPEndpointSet, g_browserCDPPort, browserCDPPortSet, g_browserCDPLaunch, g_browserCDPExecutable, browserCDPExecutableSet, g_browserCDPUserDataDir, browserCDPUserDataDirSet, g_browserVideoOutput); err != nil {
 		log.Fatal(err)
 	}
+	if *action != "install-playwright" {
+		usesCDP := g_browserCDPLaunch || g_browserCDPEndpoint != "" || g_browserCDPPort != 0
+		if err := validateBrowserEngineFlag(g_browserEngine, usesCDP); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	if len(*flowfile) != 0 {
 		flow, err := loadFlowDefinition(*flowfile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		run_flow(flow)
+		g_flowParams, err = loadFlowParams(*flowVarsFile, flowVars)
+		if err != nil {
+			log.Fatal(err)
+		}
+		run_flow(flow, *flowfile)
 	} else if len(*tsfile) != 0 {
 		content, err := loadScriptSource(*tsfile)
 		if err != nil {
@@ -163,22 +267,26 @@ func main() {
 			//fmt.Println("Start As Cli.")
 			cli_mode()
 		case "gpt":
-			fmt.Println("Start As GPT.")
+			slog.Info("start as gpt")
 		case "srv":
-			fmt.Println("Start As Web.")
+			slog.Info("start as web", "addr", *addr)
 			tsplay_core.McpServerMCP(*addr, tsplay_core.TSPlayMCPServerOptions{
-				FlowPathRoot: *flowRoot,
-				ArtifactRoot: *artifactRoot,
+				FlowPathRoot:        *flowRoot,
+				ArtifactRoot:        *artifactRoot,
+				Retention:           g_retention,
+				AllowAllSessionRuns: *mcpAllRuns,
 			})
 		case "workbench-api":
-			fmt.Println("Start As Workbench.")
+			slog.Info("start as workbench", "addr", *addr)
 			if err := serveWorkbenchApp(*addr, *serveRoot, *artifactRoot); err != nil {
 				log.Fatal(err)
 			}
 		case "mcp-stdio":
 			tsplay_core.McpServerStdio(tsplay_core.TSPlayMCPServerOptions{
-				FlowPathRoot: *flowRoot,
-				ArtifactRoot: *artifactRoot,
+				FlowPathRoot:        *flowRoot,
+				ArtifactRoot:        *artifactRoot,
+				Retention:           g_retention,
+				AllowAllSessionRuns: *mcpAllRuns,
 			})
 		case "mcp-tool":
 			if err := runMCPToolAction(*toolName, *argsJSON, *argsFile, *flowRoot, *artifactRoot); err != nil {
@@ -211,7 +319,11 @@ func main() {
 				log.Fatal(err)
 			}
 		case "install-playwright":
-			result, err := tsplay_core.InstallPlaywrightRuntime()
+			engines, err := tsplay_core.ParseFlowBrowserEngines(g_browserEngine)
+			if err != nil {
+				log.Fatal(err)
+			}
+			result, err := tsplay_core.InstallPlaywrightRuntime(engines...)
 			printJSON(result)
 			if err != nil {
 				log.Fatal(err)
@@ -249,6 +361,14 @@ func main() {
 			if strings.TrimSpace(*sessionName) == "" {
 				log.Fatal("-session-name is required for -action save-session")
 			}
+			fingerprint, err := tsplay_core.ParseFlowBrowserFingerprint(*fingerprintJSON)
+			if err != nil {
+				log.Fatal(err)
+			}
+			proxy, err := tsplay_core.ParseFlowBrowserProxy(*proxyJSON)
+			if err != nil {
+				log.Fatal(err)
+			}
 			session, err := tsplay_core.SaveFlowSavedSession(tsplay_core.FlowSavedSessionSaveOptions{
 				Name:             *sessionName,
 				ArtifactRoot:     *artifactRoot,
@@ -256,6 +376,9 @@ func main() {
 				StorageStatePath: *storageStatePath,
 				Profile:          *profileName,
 				Session:          *profileSession,
+				Fingerprint:      fingerprint,
+				Proxy:            proxy,
+				Engine:           g_browserEngine,
 			})
 			if err != nil {
 				log.Fatal(err)
@@ -305,8 +428,289 @@ func main() {
 				log.Fatal(err)
 			}
 			printJSON(deleted)
+		case "test":
+			var err error
+			g_flowParams, err = loadFlowParams(*flowVarsFile, flowVars)
+			if err != nil {
+				log.Fatal(err)
+			}
+			ok, err := runTestAction(tsplay_core.FlowSuiteOptions{
+				Root:       *testDir,
+				Setup:      *testSetup,
+				Teardown:   *testTeardown,
+				Workers:    *testWorkers,
+				Retries:    *testRetries,
+				Tags:       splitCommaList(*testTags),
+				Filter:     *testFilter,
+				RunOptions: flowRunOptionsFromFlags(),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !ok {
+				g_stopTracing()
+				os.Exit(1)
+			}
+		case "scheduler":
+			if err := runSchedulerAction(*scheduleFile, *artifactRoot, *isheadless); err != nil {
+				log.Fatal(err)
+			}
+		case "scheduler-history":
+			historyPath := tsplay_core.DefaultFlowScheduleHistoryPath(*artifactRoot)
+			if strings.TrimSpace(*scheduleFile) != "" {
+				scheduler, err := loadFlowScheduler(context.Background(), *scheduleFile, *artifactRoot, false)
+				if err != nil {
+					log.Fatal(err)
+				}
+				historyPath = scheduler.HistoryPath()
+			}
+			records, err := tsplay_core.QueryFlowScheduleHistory(historyPath, tsplay_core.FlowScheduleHistoryQuery{
+				Schedule: strings.TrimSpace(*scheduleJob),
+				Status:   strings.TrimSpace(*runStatus),
+				Limit:    *limit,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(map[string]any{"history_path": historyPath, "runs": records})
+		case "list-runs":
+			since, err := tsplay_core.ParseFlowRunTimeFilter(*runSince, time.Now())
+			if err != nil {
+				log.Fatalf("-since: %v", err)
+			}
+			until, err := tsplay_core.ParseFlowRunTimeFilter(*runUntil, time.Now())
+			if err != nil {
+				log.Fatalf("-until: %v", err)
+			}
+			runs, err := tsplay_core.ListFlowRuns(*artifactRoot, tsplay_core.FlowRunQuery{
+				Flow:    strings.TrimSpace(*runFlowName),
+				Status:  strings.TrimSpace(*runStatus),
+				Session: strings.TrimSpace(*runSession),
+				Since:   since,
+				Until:   until,
+				Limit:   *limit,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(map[string]any{"index_path": tsplay_core.FlowRunIndexPath(*artifactRoot), "runs": runs})
+		case "get-run":
+			if strings.TrimSpace(*runID) == "" {
+				log.Fatal("-run-id is required for -action get-run")
+			}
+			run, err := tsplay_core.GetFlowRun(*artifactRoot, *runID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(run)
+		case "reindex-runs":
+			count, err := tsplay_core.RebuildFlowRunIndex(*artifactRoot)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(map[string]any{"index_path": tsplay_core.FlowRunIndexPath(*artifactRoot), "runs": count})
+		case "gc-artifacts":
+			if !retention.Enabled() {
+				log.Fatal("-action gc-artifacts needs at least one of -keep-last, -max-age or -max-bytes")
+			}
+			result, err := tsplay_core.CollectFlowArtifacts(*artifactRoot, retention, *dryRun)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(result)
+		case "set-secret":
+			if strings.TrimSpace(*secretName) == "" {
+				log.Fatal("-secret-name is required for -action set-secret")
+			}
+			value, err := readSecretValue(os.Stdin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			path, err := tsplay_core.SetFlowSecret(*secretName, value)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(map[string]any{"secrets_file": path, "name": *secretName, "stored": true})
+		case "delete-secret":
+			if strings.TrimSpace(*secretName) == "" {
+				log.Fatal("-secret-name is required for -action delete-secret")
+			}
+			path, err := tsplay_core.DeleteFlowSecret(*secretName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(map[string]any{"secrets_file": path, "name": *secretName, "deleted": true})
+		case "list-secrets":
+			names, err := tsplay_core.ListFlowSecretNames()
+			if err != nil {
+				log.Fatal(err)
+			}
+			printJSON(map[string]any{
+				"secrets_file": tsplay_core.DefaultFlowSecretsFile(),
+				"names":        names,
+			})
+		}
+	}
+}
+
+func loadFlowScheduler(ctx context.Context, scheduleFile string, artifactRoot string, headless bool) (*tsplay_core.FlowScheduler, error) {
+	if strings.TrimSpace(scheduleFile) == "" {
+		return nil, fmt.Errorf("-schedule is required for -action scheduler")
+	}
+	schedule, err := tsplay_core.LoadFlowSchedule(scheduleFile)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Retention == nil {
+		schedule.Retention = g_retention
+	}
+	return tsplay_core.NewFlowScheduler(schedule, tsplay_core.FlowSchedulerOptions{
+		Context:      ctx,
+		ArtifactRoot: artifactRoot,
+		Headless:     headless,
+	})
+}
+
+// retentionPolicyFromFlags builds the policy shared by -action gc-artifacts
+// and -gc-after-run.
+func retentionPolicyFromFlags(keepLast int, maxAge string, maxBytes string, keepFailed bool) (tsplay_core.FlowArtifactRetentionPolicy, error) {
+	size, err := tsplay_core.ParseFlowArtifactByteSize(maxBytes)
+	if err != nil {
+		return tsplay_core.FlowArtifactRetentionPolicy{}, fmt.Errorf("-max-bytes: %w", err)
+	}
+	policy := tsplay_core.FlowArtifactRetentionPolicy{
+		KeepLastPerFlow: keepLast,
+		MaxAge:          strings.TrimSpace(maxAge),
+		MaxTotalBytes:   size,
+		DeleteFailed:    !keepFailed,
+	}
+	if err := policy.Validate(); err != nil {
+		return tsplay_core.FlowArtifactRetentionPolicy{}, err
+	}
+	return policy, nil
+}
+
+// startTracingFromFlags starts span export when -otel-endpoint, -otel-file or
+// the OTEL_* environment asks for it. The returned func flushes pending spans
+// and may be called more than once.
+func startTracingFromFlags(endpoint string, file string) (func(), error) {
+	options := tsplay_core.OTelTracingOptionsFromEnv()
+	if strings.TrimSpace(endpoint) != "" {
+		options.Endpoint = strings.TrimSpace(endpoint)
+	}
+	if strings.TrimSpace(file) != "" {
+		options.File = strings.TrimSpace(file)
+	}
+	shutdown, err := tsplay_core.StartOTelTracing(options)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("flush otel spans failed", "err", err)
 		}
+	}, nil
+}
+
+// runSchedulerAction runs scheduled flows until SIGINT/SIGTERM, then waits for
+// in-flight runs before returning.
+func runSchedulerAction(scheduleFile string, artifactRoot string, headless bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scheduler, err := loadFlowScheduler(ctx, scheduleFile, artifactRoot, headless)
+	if err != nil {
+		return err
 	}
+	slog.Info("scheduler started", "history", scheduler.HistoryPath())
+	return scheduler.Run()
+}
+
+// runTestAction runs a directory of flows, prints a pass/fail summary and
+// writes the -report targets, or a JUnit report under the artifact root when
+// none is given. It reports whether every flow passed.
+func runTestAction(options tsplay_core.FlowSuiteOptions) (bool, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	options.RunOptions.Context = ctx
+	// Each test is a fresh run; one video path or resume id cannot be shared.
+	options.RunOptions.ResumeRunID = ""
+	options.RunOptions.BrowserVideoOutputPath = ""
+	result, err := tsplay_core.RunFlowSuite(options)
+	if err != nil {
+		return false, err
+	}
+	printFlowSuiteSummary(os.Stdout, result)
+	reports := g_reports
+	if len(reports) == 0 {
+		reports = []tsplay_core.FlowReportTarget{{
+			Format: tsplay_core.FlowReportFormatJUnit,
+			Path:   filepath.Join(options.RunOptions.ArtifactRoot, "test-results", "junit.xml"),
+		}}
+	}
+	if err := tsplay_core.WriteFlowReports(reports, result.ReportRuns()); err != nil {
+		return false, err
+	}
+	for _, report := range reports {
+		fmt.Printf("%s report: %s\n", report.Format, report.Path)
+	}
+	return result.Succeeded(), nil
+}
+
+func printFlowSuiteSummary(out io.Writer, result *tsplay_core.FlowSuiteResult) {
+	printCase := func(prefix string, suiteCase tsplay_core.FlowSuiteCase) {
+		label := map[string]string{
+			tsplay_core.FlowSuiteStatusPassed:  "PASS",
+			tsplay_core.FlowSuiteStatusFailed:  "FAIL",
+			tsplay_core.FlowSuiteStatusFlaky:   "FLAKY",
+			tsplay_core.FlowSuiteStatusSkipped: "SKIP",
+		}[suiteCase.Status]
+		line := fmt.Sprintf("%-5s %s%s (%s) %dms", label, prefix, suiteCase.Name, suiteCase.File, suiteCase.DurationMS)
+		if suiteCase.Attempts > 1 {
+			line += fmt.Sprintf(", %d attempts", suiteCase.Attempts)
+		}
+		fmt.Fprintln(out, line)
+		if suiteCase.Error != "" && suiteCase.Status != tsplay_core.FlowSuiteStatusSkipped {
+			fmt.Fprintf(out, "      %s\n", suiteCase.Error)
+		}
+	}
+	if result.Setup != nil {
+		printCase("setup: ", *result.Setup)
+	}
+	for _, suiteCase := range result.Cases {
+		printCase("", suiteCase)
+	}
+	if result.Teardown != nil {
+		printCase("teardown: ", *result.Teardown)
+	}
+	fmt.Fprintf(out, "\n%d flows: %d passed, %d flaky, %d failed, %d skipped in %.1fs\n",
+		len(result.Cases), result.Passed, result.Flaky, result.Failed, result.Skipped, float64(result.DurationMS)/1000)
+}
+
+// splitCommaList splits a comma-separated flag value and drops empty items.
+func splitCommaList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readSecretValue reads a secret from stdin so it never lands in shell history
+// or the process list. A single trailing newline is dropped.
+func readSecretValue(input io.Reader) (string, error) {
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return "", fmt.Errorf("read secret value from stdin: %w", err)
+	}
+	value := strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+	if value == "" {
+		return "", fmt.Errorf("secret value from stdin is empty")
+	}
+	return value, nil
 }
 
 func printJSON(value any) {
@@ -360,8 +764,52 @@ func validateBrowserCDPFlagOptions(endpoint string, endpointSet bool, port int,
 	return nil
 }
 
-func run_flow(flow *tsplay_core.Flow) {
-	result, err := tsplay_core.RunFlow(flow, tsplay_core.FlowRunOptions{
+// validateBrowserEngineFlag checks -browser-engine for everything but
+// install-playwright: one engine, and chromium when attaching over CDP.
+func validateBrowserEngineFlag(engine string, usesCDP bool) error {
+	if engine == "" {
+		return nil
+	}
+	engines, err := tsplay_core.ParseFlowBrowserEngines(engine)
+	if err != nil {
+		return err
+	}
+	if len(engines) != 1 {
+		return fmt.Errorf("-browser-engine takes a single engine; only -action install-playwright accepts a list")
+	}
+	if usesCDP && engines[0] != tsplay_core.FlowBrowserEngineChromium {
+		return fmt.Errorf("-browser-cdp-launch/-browser-cdp-endpoint/-browser-cdp-port only work with -browser-engine %s", tsplay_core.FlowBrowserEngineChromium)
+	}
+	return nil
+}
+
+// loadFlowParams merges parameter values from -vars-file with repeated -var
+// flags. Flags win so a shared file can be overridden per run.
+func loadFlowParams(varsFile string, assignments []string) (map[string]any, error) {
+	params := map[string]any{}
+	if strings.TrimSpace(varsFile) != "" {
+		content, err := os.ReadFile(varsFile)
+		if err != nil {
+			return nil, fmt.Errorf("read -vars-file: %w", err)
+		}
+		if err := json.Unmarshal(content, &params); err != nil {
+			return nil, fmt.Errorf("parse -vars-file %s: must be a JSON object: %w", varsFile, err)
+		}
+	}
+	overrides, err := tsplay_core.ParseFlowParameterAssignments(assignments)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range overrides {
+		params[name] = value
+	}
+	return params, nil
+}
+
+// flowRunOptionsFromFlags collects the run options shared by -flow and
+// -action test.
+func flowRunOptionsFromFlags() tsplay_core.FlowRunOptions {
+	return tsplay_core.FlowRunOptions{
 		Headless:               g_headless,
 		ArtifactRoot:           g_artifactRoot,
 		BrowserVideoOutputPath: g_browserVideoOutput,
@@ -373,16 +821,33 @@ func run_flow(flow *tsplay_core.Flow) {
 		BrowserCDPPort:         g_browserCDPPort,
 		BrowserCDPExecutable:   g_browserCDPExecutable,
 		BrowserCDPUserDataDir:  g_browserCDPUserDataDir,
-	})
+		BrowserTrace:           g_browserTrace,
+		BrowserEngine:          g_browserEngine,
+		Params:                 g_flowParams,
+		ResumeRunID:            g_resumeRunID,
+		Retention:              g_retention,
+	}
+}
+
+func run_flow(flow *tsplay_core.Flow, flowFile string) {
+	result, err := tsplay_core.RunFlow(flow, flowRunOptionsFromFlags())
 	if result != nil {
 		encoded, marshalErr := json.MarshalIndent(result, "", "  ")
 		if marshalErr != nil {
-			log.Printf("could not encode flow result: %v", marshalErr)
+			slog.Error("could not encode flow result", "err", marshalErr)
 		} else {
 			fmt.Println(string(encoded))
 		}
 	}
+	if len(g_reports) > 0 {
+		run := tsplay_core.NewFlowReportRun(flow.Name, flowFile, result, err)
+		if reportErr := tsplay_core.WriteFlowReports(g_reports, []tsplay_core.FlowReportRun{run}); reportErr != nil {
+			slog.Error("could not write flow report", "err", reportErr)
+		}
+	}
 	if err != nil {
+		// log.Fatalf skips deferred calls, so flush the run's spans first.
+		g_stopTracing()
 		log.Fatalf("error running flow: %v", err)
 	}
 }
@@ -440,7 +905,7 @@ func cli_mode() {
 		if page != nil {
 			if !connectedOverCDP {
 				if err := page.Close(); err != nil {
-					log.Printf("failed to close page: %v", err)
+					slog.Warn("failed to close page", "err", err)
 				}
 			}
 			page = nil
@@ -448,20 +913,20 @@ func cli_mode() {
 		if browser != nil {
 			if !connectedOverCDP {
 				if err := browser.Close(); err != nil {
-					log.Printf("failed to close browser: %v", err)
+					slog.Warn("failed to close browser", "err", err)
 				}
 			}
 			browser = nil
 		}
 		if pw != nil {
 			if err := pw.Stop(); err != nil {
-				log.Printf("failed to stop Playwright runtime: %v", err)
+				slog.Warn("failed to stop Playwright runtime", "err", err)
 			}
 			pw = nil
 		}
 		if closeBrowserRuntime != nil {
 			if err := closeBrowserRuntime(); err != nil {
-				log.Printf("failed to close browser runtime: %v", err)
+				slog.Warn("failed to close browser runtime", "err", err)
 			}
 			closeBrowserRuntime = nil
 		}
@@ -473,12 +938,8 @@ func cli_mode() {
 		if pw != nil {
 			return nil
 		}
-		if strings.TrimSpace(reason) == "" {
-			fmt.Println("Starting Playwright runtime...")
-		} else {
-			fmt.Printf("Starting Playwright runtime because %s...\n", reason)
-		}
-		runtimeHandle, err := tsplay_core.StartPlaywright()
+		slog.Info("starting Playwright runtime", "reason", reason)
+		runtimeHandle, err := tsplay_core.StartPlaywright(g_browserEngine)
 		if err != nil {
 			return err
 		}
@@ -494,12 +955,9 @@ func cli_mode() {
 		if err := ensurePlaywrightRuntime(reason); err != nil {
 			return err
 		}
-		if strings.TrimSpace(reason) == "" {
-			fmt.Println("Launching Playwright browser and page...")
-		} else {
-			fmt.Printf("Launching Playwright browser and page because %s...\n", reason)
-		}
+		slog.Info("launching Playwright browser and page", "reason", reason)
 		browserConfig := tsplay_core.FlowBrowserConfig{
+			Engine:         g_browserEngine,
 			Headless:       playwright.Bool(g_headless),
 			CDPLaunch:      g_browserCDPLaunch,
 			CDPEndpoint:    g_browserCDPEndpoint,
@@ -516,7 +974,7 @@ func cli_mode() {
 		connectedOverCDP = browserRuntime.ConnectedOverCDP
 		closeBrowserRuntime = browserRuntime.Close
 		setPlaywrightGlobals()
-		fmt.Println("Playwright initialized. Browser, context, and page are ready.")
+		slog.Info("Playwright initialized; browser, context and page are ready")
 		return nil
 	}
 	fmt.Println("Please input the 'start' command to run and launch tsplay")
@@ -529,7 +987,7 @@ func cli_mode() {
 			AutoComplete: createReadlineCompleter(),
 		})
 		if err != nil {
-			log.Printf("failed to initialize readline: %v", err)
+			slog.Warn("failed to initialize readline", "err", err)
 		}
 	}
 
@@ -684,7 +1142,7 @@ func run_script(script string) {
 
 	if usage.NeedsRuntime {
 		var err error
-		pw, err = tsplay_core.StartPlaywright()
+		pw, err = tsplay_core.StartPlaywright(g_browserEngine)
 		if err != nil {
 			fatalWithCleanup("%v", err)
 		}
@@ -692,7 +1150,7 @@ func run_script(script string) {
 	if usage.NeedsBrowser() {
 		if pw == nil {
 			var err error
-			pw, err = tsplay_core.StartPlaywright()
+			pw, err = tsplay_core.StartPlaywright(g_browserEngine)
 			if err != nil {
 				fatalWithCleanup("%v", err)
 			}
@@ -703,6 +1161,7 @@ func run_script(script string) {
 			fatalWithCleanup("could not prepare browser video: %v", err)
 		}
 		browserConfig := tsplay_core.FlowBrowserConfig{
+			Engine:         g_browserEngine,
 			Headless:       playwright.Bool(g_headless),
 			CDPLaunch:      g_browserCDPLaunch,
 			CDPEndpoint:    g_browserCDPEndpoint,
@@ -737,7 +1196,7 @@ func run_script(script string) {
 		if err != nil {
 			fatalWithCleanup("could not save browser video: %v", err)
 		}
-		fmt.Printf("saved browser video: %s\n", savedPath)
+		slog.Info("saved browser video", "path", savedPath)
 		page = nil
 		return
 	}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -161,3 +163,22 @@ func TestValidateBrowserCDPFlagOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadFlowParamsMergesFileAndFlags(t *testing.T) {
+	varsFile := filepath.Join(t.TempDir(), "vars.json")
+	if err := os.WriteFile(varsFile, []byte(`{"region":"eu","limit":5}`), 0o644); err != nil {
+		t.Fatalf("write vars file: %v", err)
+	}
+
+	params, err := loadFlowParams(varsFile, []string{"region=us", "note=a=b"})
+	if err != nil {
+		t.Fatalf("load params: %v", err)
+	}
+	if params["region"] != "us" || params["limit"] != float64(5) || params["note"] != "a=b" {
+		t.Fatalf("unexpected params: %#v", params)
+	}
+
+	if _, err := loadFlowParams("", []string{"missing_equals"}); err == nil || !strings.Contains(err.Error(), "name=value") {
+		t.Fatalf("expected assignment error, got %v", err)
+	}
+}
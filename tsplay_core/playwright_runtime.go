@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 
@@ -22,25 +23,27 @@ const (
 )
 
 type PlaywrightRuntimeInfo struct {
-	Browser        string `json:"browser"`
-	BundlePath     string `json:"bundle_path,omitempty"`
-	BundleSource   string `json:"bundle_source,omitempty"`
-	DriverPath     string `json:"driver_path,omitempty"`
-	DriverSource   string `json:"driver_source"`
-	BrowsersPath   string `json:"browsers_path,omitempty"`
-	BrowsersSource string `json:"browsers_source"`
+	Browser string `json:"browser"`
+	// Browsers lists the engines InstallPlaywrightRuntime installed.
+	Browsers       []string `json:"browsers,omitempty"`
+	BundlePath     string   `json:"bundle_path,omitempty"`
+	BundleSource   string   `json:"bundle_source,omitempty"`
+	DriverPath     string   `json:"driver_path,omitempty"`
+	DriverSource   string   `json:"driver_source"`
+	BrowsersPath   string   `json:"browsers_path,omitempty"`
+	BrowsersSource string   `json:"browsers_source"`
 }
 
 var (
-	playwrightInstallFunc = func() error {
-		return playwright.Install(newPlaywrightRunOptions())
+	playwrightInstallFunc = func(browsers []string) error {
+		return playwright.Install(newPlaywrightRunOptions(browsers...))
 	}
 	playwrightRunFunc = func() (*playwright.Playwright, error) {
 		return playwright.Run(newPlaywrightRunOptions())
 	}
 
 	playwrightInstallMu            sync.Mutex
-	playwrightInstallDone          bool
+	playwrightInstalledBrowsers    = map[string]bool{}
 	playwrightBundleCandidatesFunc = defaultPlaywrightBundleCandidates
 )
 
@@ -48,31 +51,46 @@ func DescribePlaywrightRuntime() PlaywrightRuntimeInfo {
 	return resolvePlaywrightRuntimeInfo()
 }
 
-func InstallPlaywrightRuntime() (PlaywrightRuntimeInfo, error) {
+// InstallPlaywrightRuntime installs the Playwright driver and the given
+// browser engines, chromium when none are given.
+func InstallPlaywrightRuntime(engines ...string) (PlaywrightRuntimeInfo, error) {
 	info := configurePlaywrightRuntime()
-	if err := EnsurePlaywrightInstalled(); err != nil {
+	info.Browsers = playwrightBrowserNames(engines)
+	if err := EnsurePlaywrightInstalled(info.Browsers...); err != nil {
 		return info, err
 	}
 	return info, nil
 }
 
-func EnsurePlaywrightInstalled() error {
+// EnsurePlaywrightInstalled installs the given browser engines once per
+// process, chromium when none are given.
+func EnsurePlaywrightInstalled(engines ...string) error {
 	playwrightInstallMu.Lock()
 	defer playwrightInstallMu.Unlock()
 
-	if playwrightInstallDone {
+	missing := []string{}
+	for _, name := range playwrightBrowserNames(engines) {
+		if !playwrightInstalledBrowsers[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
 		return nil
 	}
-	if err := playwrightInstallFunc(); err != nil {
+	if err := playwrightInstallFunc(missing); err != nil {
 		return fmt.Errorf("could not install Playwright browsers: %w", err)
 	}
-	playwrightInstallDone = true
+	for _, name := range missing {
+		playwrightInstalledBrowsers[name] = true
+	}
 	return nil
 }
 
-func StartPlaywright() (*playwright.Playwright, error) {
+// StartPlaywright starts the Playwright driver after making sure the given
+// browser engines are installed, chromium when none are given.
+func StartPlaywright(engines ...string) (*playwright.Playwright, error) {
 	configurePlaywrightRuntime()
-	if err := EnsurePlaywrightInstalled(); err != nil {
+	if err := EnsurePlaywrightInstalled(engines...); err != nil {
 		return nil, err
 	}
 	pw, err := playwrightRunFunc()
@@ -82,10 +100,10 @@ func StartPlaywright() (*playwright.Playwright, error) {
 	return pw, nil
 }
 
-func newPlaywrightRunOptions() *playwright.RunOptions {
+func newPlaywrightRunOptions(browsers ...string) *playwright.RunOptions {
 	info := configurePlaywrightRuntime()
 	options := &playwright.RunOptions{
-		Browsers: []string{playwrightBrowserName},
+		Browsers: playwrightBrowserNames(browsers),
 	}
 	if info.DriverPath != "" {
 		options.DriverDirectory = info.DriverPath
@@ -93,6 +111,20 @@ func newPlaywrightRunOptions() *playwright.RunOptions {
 	return options
 }
 
+func playwrightBrowserNames(engines []string) []string {
+	names := []string{}
+	for _, engine := range engines {
+		name := normalizeFlowBrowserEngine(engine)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, playwrightBrowserName)
+	}
+	return names
+}
+
 func configurePlaywrightRuntime() PlaywrightRuntimeInfo {
 	info := resolvePlaywrightRuntimeInfo()
 	if info.DriverPath != "" && strings.TrimSpace(os.Getenv(envPlaywrightDriverPath)) == "" {
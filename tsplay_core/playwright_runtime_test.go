@@ -478,18 +478,18 @@ func stubPlaywrightRuntime(t *testing.T, install func() error, run func() (*play
 	oldRun := playwrightRunFunc
 
 	playwrightInstallMu.Lock()
-	oldDone := playwrightInstallDone
-	playwrightInstallDone = false
+	oldInstalled := playwrightInstalledBrowsers
+	playwrightInstalledBrowsers = map[string]bool{}
 	playwrightInstallMu.Unlock()
 
-	playwrightInstallFunc = install
+	playwrightInstallFunc = func([]string) error { return install() }
 	playwrightRunFunc = run
 
 	return func() {
 		playwrightInstallFunc = oldInstall
 		playwrightRunFunc = oldRun
 		playwrightInstallMu.Lock()
-		playwrightInstallDone = oldDone
+		playwrightInstalledBrowsers = oldInstalled
 		playwrightInstallMu.Unlock()
 	}
 }
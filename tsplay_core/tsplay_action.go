@@ -6,6 +6,7 @@ import (
 	"github.com/playwright-community/playwright-go"
 	lua "github.com/yuin/gopher-lua"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
@@ -300,11 +301,11 @@ func navigate(L *lua.LState) int {
 	// 调用 Playwright 页面导航功能
 	_, err := page.Goto(url)
 	if err != nil {
-		fmt.Printf("Failed to navigate to %s: %v", url, err)
+		slog.WarnContext(flowLogContext(L), "navigate failed", "url", url, "err", err)
 		return 0
 	}
 
-	fmt.Println("Successfully navigated to:", url)
+	slog.InfoContext(flowLogContext(L), "navigated", "url", url)
 	return 0
 }
 
@@ -313,13 +314,13 @@ func reload(L *lua.LState) int {
 	if page == nil {
 		return 0
 	}
-	response, err := page.Reload()
+	_, err := page.Reload()
 	if err != nil {
 		L.RaiseError("Failed to reload")
 		return 0
 	}
 
-	fmt.Println("Successfully reload", response)
+	slog.InfoContext(flowLogContext(L), "reloaded", "url", page.URL())
 	return 0
 }
 
@@ -333,15 +334,15 @@ func go_back(L *lua.LState) int {
 	// 调用 Page.GoBack() 方法
 	response, err := page.GoBack()
 	if err != nil {
-		fmt.Printf("Failed to go back: %v", err)
+		slog.WarnContext(flowLogContext(L), "go_back failed", "err", err)
 		return 0
 	}
 
 	// 检查是否成功返回上一页
 	if response != nil {
-		fmt.Println("Successfully went back, Response Status:", response.Status())
+		slog.InfoContext(flowLogContext(L), "went back", "status", response.Status())
 	} else {
-		fmt.Println("No history to go back to.")
+		slog.InfoContext(flowLogContext(L), "go_back skipped: no history")
 	}
 
 	return 0
@@ -355,14 +356,14 @@ func go_forward(L *lua.LState) int {
 
 	response, err := page.GoForward()
 	if err != nil {
-		fmt.Printf("Failed to go forward: %v", err)
+		slog.WarnContext(flowLogContext(L), "go_forward failed", "err", err)
 		return 0
 	}
 
 	if response != nil {
-		fmt.Println("Successfully went forward, Response Status:", response.Status())
+		slog.InfoContext(flowLogContext(L), "went forward", "status", response.Status())
 	} else {
-		fmt.Println("No history to go forward to.")
+		slog.InfoContext(flowLogContext(L), "go_forward skipped: no history")
 	}
 
 	return 0
@@ -384,7 +385,7 @@ func click(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -396,7 +397,7 @@ func click(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully clicked on selector: %s\n", selector)
+	slog.InfoContext(flowLogContext(L), "clicked", "selector", selector)
 	return 0
 }
 
@@ -406,7 +407,7 @@ func click_at(L *lua.LState) int {
 		return 0
 	}
 
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	x := float64(L.CheckNumber(2))
 	y := float64(L.CheckNumber(3))
 	if err := clickElementAtOffset(page, selector, x, y); err != nil {
@@ -414,7 +415,7 @@ func click_at(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully clicked selector: %s at %.1f, %.1f\n", selector, x, y)
+	slog.InfoContext(flowLogContext(L), "clicked at offset", "selector", selector, "x", x, "y", y)
 	return 0
 }
 
@@ -424,7 +425,7 @@ func click_box(L *lua.LState) int {
 		return 0
 	}
 
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	boxValue := luaValueToGo(L.CheckAny(2))
 	index := 0
 	if L.GetTop() >= 3 && L.Get(3) != lua.LNil {
@@ -450,7 +451,7 @@ func click_box(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully clicked selector: %s at box center %.1f, %.1f\n", selector, x, y)
+	slog.InfoContext(flowLogContext(L), "clicked box center", "selector", selector, "x", x, "y", y)
 	return 0
 }
 
@@ -477,7 +478,7 @@ func type_text(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 和 text 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	text := L.CheckString(2)
 
 	if selector == "" {
@@ -491,7 +492,7 @@ func type_text(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully typed text '%s' into selector: %s\n", text, selector)
+	slog.InfoContext(flowLogContext(L), "typed text", "selector", selector, "chars", len([]rune(text)))
 	return 0
 }
 
@@ -502,7 +503,7 @@ func get_text(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -595,7 +596,7 @@ func get_text(L *lua.LState) int {
 }
 
 func extract_text(L *lua.LState) int {
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	step := FlowStep{
 		Action:   "extract_text",
 		Selector: selector,
@@ -729,7 +730,7 @@ func set_value(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 和 value 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	value := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -742,7 +743,7 @@ func set_value(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully set value '%s' for selector: %s\n", value, selector)
+	slog.InfoContext(flowLogContext(L), "set value", "selector", selector, "chars", len([]rune(value)))
 	return 0
 }
 func select_option(L *lua.LState) int {
@@ -752,7 +753,7 @@ func select_option(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 和 value 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	value := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -768,7 +769,7 @@ func select_option(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully selected option '%s' for selector: %s\n", value, selector)
+	slog.InfoContext(flowLogContext(L), "selected option", "selector", selector, "value", value)
 	return 0
 }
 func hover(L *lua.LState) int {
@@ -778,7 +779,7 @@ func hover(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -790,7 +791,7 @@ func hover(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully hovered on selector: %s\n", selector)
+	slog.InfoContext(flowLogContext(L), "hovered", "selector", selector)
 	return 0
 }
 
@@ -800,7 +801,7 @@ func drag(L *lua.LState) int {
 		return 0
 	}
 
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	deltaX := float64(L.CheckNumber(2))
 	deltaY := 0.0
 	if L.GetTop() >= 3 {
@@ -816,7 +817,7 @@ func drag(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully dragged selector: %s by %.1f, %.1f\n", selector, deltaX, deltaY)
+	slog.InfoContext(flowLogContext(L), "dragged", "selector", selector, "dx", deltaX, "dy", deltaY)
 	return 0
 }
 
@@ -863,7 +864,7 @@ func scroll_to(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -875,7 +876,7 @@ func scroll_to(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully scrolled to selector: %s\n", selector)
+	slog.InfoContext(flowLogContext(L), "scrolled to", "selector", selector)
 	return 0
 }
 
@@ -916,7 +917,7 @@ func wait_for_selector(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 和 timeout 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	timeout := L.OptInt(2, 30000) // 默认超时时间为 30 秒（30000 毫秒）
 
 	if selector == "" {
@@ -933,7 +934,7 @@ func wait_for_selector(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Successfully waited for selector: %s\n", selector)
+	slog.InfoContext(flowLogContext(L), "waited for selector", "selector", selector)
 	return 0
 }
 
@@ -944,7 +945,7 @@ func wait_for_text(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector、text 和 timeout 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	expectedText := L.CheckString(2)
 	timeout := L.OptInt(3, 30000) // 默认超时时间为 30 秒（30000 毫秒）
 
@@ -954,23 +955,53 @@ func wait_for_text(L *lua.LState) int {
 	}
 
 	// 等待元素的文本变为指定值
-	err, _ := page.WaitForFunction(
-		fmt.Sprintf(`() => document.querySelector('%s')?.textContent.trim() === '%s'`, selector, expectedText),
-		playwright.PageWaitForFunctionOptions{Timeout: playwright.Float(float64(timeout))},
-	)
-	if err != nil {
+	if err := waitForLocatorText(page, selector, expectedText, timeout); err != nil {
 		L.RaiseError("Failed to wait for text '%s' in selector '%s': %v", expectedText, selector, err)
 		return 0
 	}
 
-	fmt.Printf("Successfully waited for text '%s' in selector: %s\n", expectedText, selector)
+	slog.InfoContext(flowLogContext(L), "waited for text", "selector", selector, "text", expectedText)
 	return 0
 }
 
+// waitForLocatorText polls the first element matching selector until its
+// trimmed text equals expected. It goes through a locator so frame-scoped and
+// semantic selectors work; a timeout of 0 waits without a limit.
+func waitForLocatorText(page playwright.Page, selector string, expected string, timeout int) error {
+	locator := page.Locator(selector).First()
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	remaining := func() *float64 {
+		if timeout <= 0 {
+			return playwright.Float(0)
+		}
+		return playwright.Float(float64(max(time.Until(deadline).Milliseconds(), 1)))
+	}
+	actual := ""
+	for {
+		if err := locator.WaitFor(playwright.LocatorWaitForOptions{State: playwright.WaitForSelectorStateAttached, Timeout: remaining()}); err != nil {
+			return err
+		}
+		text, err := locator.TextContent(playwright.LocatorTextContentOptions{Timeout: remaining()})
+		if err == nil {
+			actual = strings.TrimSpace(text)
+			if actual == expected {
+				return nil
+			}
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("timed out after %dms; last text %q", timeout, actual)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 func assert_visible(L *lua.LState) int {
 	step := FlowStep{
 		Action:   "assert_visible",
-		Selector: L.CheckString(1),
+		Selector: checkLuaSelector(L, 1),
 		Timeout:  L.OptInt(2, 0),
 	}
 
@@ -986,7 +1017,7 @@ func assert_visible(L *lua.LState) int {
 func assert_text(L *lua.LState) int {
 	step := FlowStep{
 		Action:   "assert_text",
-		Selector: L.CheckString(1),
+		Selector: checkLuaSelector(L, 1),
 		Text:     L.CheckString(2),
 		Timeout:  L.OptInt(3, 0),
 	}
@@ -1012,7 +1043,7 @@ func sleep(L *lua.LState) int {
 	duration := time.Duration(float64(seconds) * float64(time.Second))
 	time.Sleep(duration)
 
-	fmt.Printf("Slept for %.2f seconds\n", float64(seconds))
+	slog.InfoContext(flowLogContext(L), "slept", "seconds", float64(seconds))
 	return 0
 }
 
@@ -1047,7 +1078,7 @@ func screenshot(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Screenshot saved to: %s\n", path)
+	slog.InfoContext(flowLogContext(L), "screenshot saved", "path", path)
 	return 0
 }
 
@@ -1058,7 +1089,7 @@ func screenshot_element(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 和保存路径参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	path := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -1089,7 +1120,7 @@ func screenshot_element(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Screenshot of element '%s' saved to: %s\n", selector, path)
+	slog.InfoContext(flowLogContext(L), "element screenshot saved", "selector", selector, "path", path)
 	return 0
 }
 
@@ -1119,7 +1150,7 @@ func save_html(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("HTML saved to: %s\n", path)
+	slog.InfoContext(flowLogContext(L), "html saved", "path", path)
 	return 0
 }
 
@@ -1135,12 +1166,13 @@ func accept_alert(L *lua.LState) int {
 	}
 
 	// 监听弹窗事件并接受弹窗
+	logCtx := flowLogContext(L)
 	page.OnDialog(func(dialog playwright.Dialog) {
-		fmt.Printf("Alert detected: %s\n", dialog.Message())
+		slog.InfoContext(logCtx, "dialog accepted", "message", dialog.Message())
 		dialog.Accept()
 	})
 
-	fmt.Println("Listening for dialogs to accept...")
+	slog.DebugContext(logCtx, "listening for dialogs to accept")
 	return 0
 }
 
@@ -1151,12 +1183,13 @@ func dismiss_alert(L *lua.LState) int {
 	}
 
 	// 监听弹窗事件并关闭弹窗
+	logCtx := flowLogContext(L)
 	page.OnDialog(func(dialog playwright.Dialog) {
-		fmt.Printf("Alert detected: %s\n", dialog.Message())
+		slog.InfoContext(logCtx, "dialog dismissed", "message", dialog.Message())
 		dialog.Dismiss()
 	})
 
-	fmt.Println("Listening for dialogs to dismiss...")
+	slog.DebugContext(logCtx, "listening for dialogs to dismiss")
 	return 0
 }
 
@@ -1170,12 +1203,13 @@ func set_alert_text(L *lua.LState) int {
 	text := L.CheckString(1)
 
 	// 监听弹窗事件并设置文本
+	logCtx := flowLogContext(L)
 	page.OnDialog(func(dialog playwright.Dialog) {
-		fmt.Printf("Prompt detected: %s\n", dialog.Message())
+		slog.InfoContext(logCtx, "prompt answered", "message", dialog.Message())
 		dialog.Accept(text) // 使用 Accept(text) 方法设置文本
 	})
 
-	fmt.Printf("Listening for dialogs to set text: %s\n", text)
+	slog.DebugContext(logCtx, "listening for prompts to answer")
 	return 0
 }
 
@@ -1215,7 +1249,7 @@ func evaluate(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器和 JavaScript 脚本
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	script := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -1256,7 +1290,7 @@ func upload_file(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器和文件路径
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	filePath := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -1292,7 +1326,7 @@ func upload_file(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("File '%s' uploaded to element '%s'\n", filePath, selector)
+	slog.InfoContext(flowLogContext(L), "file uploaded", "selector", selector, "file", filePath)
 	return 0
 }
 func upload_multiple_files(L *lua.LState) int {
@@ -1302,7 +1336,7 @@ func upload_multiple_files(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器和多个文件路径
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	files := []string{}
 	for i := 2; i <= L.GetTop(); i++ {
 		files = append(files, L.CheckString(i))
@@ -1345,7 +1379,7 @@ func upload_multiple_files(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Files '%v' uploaded to element '%s'\n", files, selector)
+	slog.InfoContext(flowLogContext(L), "files uploaded", "selector", selector, "files", files)
 	return 0
 }
 
@@ -1391,8 +1425,12 @@ func download_url(L *lua.LState) int {
 		L.RaiseError("Failed to get User-Agent: %v", err)
 		return 0
 	}
-	// Create an HTTP client
-	client := &http.Client{}
+	// Create an HTTP client, going through browser.proxy when the flow sets one
+	client, err := flowProxyHTTPClient(flowContextFromState(L), &http.Client{})
+	if err != nil {
+		L.RaiseError("Failed to configure proxy: %v", err)
+		return 0
+	}
 
 	// Create a new HTTP GET request
 	req, err := http.NewRequest("GET", url, nil)
@@ -1436,7 +1474,7 @@ func download_url(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("File downloaded from '%s' to '%s'\n", url, savePath)
+	slog.InfoContext(flowLogContext(L), "file downloaded", "url", url, "path", savePath)
 	return 0
 }
 
@@ -1447,7 +1485,7 @@ func download_file(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取下载链接或按钮选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	savePath := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -1479,7 +1517,7 @@ func download_file(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("File downloaded to '%s'\n", savePath)
+	slog.InfoContext(flowLogContext(L), "file downloaded", "path", savePath)
 	return 0
 }
 
@@ -1496,7 +1534,7 @@ func get_attribute(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器和属性名
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	attribute := L.CheckString(2)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
@@ -1534,7 +1572,7 @@ func get_html(L *lua.LState) int {
 	}
 
 	// 检查是否传递了选择器参数
-	selector := L.OptString(1, "") // 若未传递参数，默认为空字符串
+	selector := optLuaSelector(L, 1, "") // 若未传递参数，默认为空字符串
 
 	var html string
 	var err error
@@ -1588,7 +1626,7 @@ func get_all_links(L *lua.LState) int {
 	}
 
 	// 检查是否传递了选择器参数
-	selector := L.OptString(1, "") // 若未传递参数，默认为空字符串
+	selector := optLuaSelector(L, 1, "") // 若未传递参数，默认为空字符串
 
 	//if selector == "" {
 	//	// 如果选择器为空，返回整个页面的 HTML 内容
@@ -1674,7 +1712,7 @@ func capture_table(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -1732,7 +1770,7 @@ func is_visible(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -1757,7 +1795,7 @@ func is_enabled(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -1782,7 +1820,7 @@ func is_checked(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -1806,7 +1844,7 @@ func is_selected(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -1835,7 +1873,7 @@ func is_aria_selected(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取选择器
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -1904,7 +1942,7 @@ func new_tab(L *lua.LState) int {
 	browser, _ := flowBrowserFromState(L)
 	setFlowBrowserGlobals(L, browser, context, page)
 
-	fmt.Printf("Opened new tab with URL: %s\n", url)
+	slog.InfoContext(flowLogContext(L), "opened new tab", "url", url)
 	return 0
 }
 func close_tab(L *lua.LState) int {
@@ -1931,7 +1969,7 @@ func close_tab(L *lua.LState) int {
 	}
 	setFlowBrowserGlobals(L, browser, context, nextPage)
 
-	fmt.Println("Current tab closed")
+	slog.InfoContext(flowLogContext(L), "closed current tab")
 	return 0
 }
 
@@ -1966,7 +2004,7 @@ func switch_to_tab(L *lua.LState) int {
 	browser, _ := flowBrowserFromState(L)
 	setFlowBrowserGlobals(L, browser, context, page)
 
-	fmt.Printf("Switched to tab at index: %d\n", index)
+	slog.InfoContext(flowLogContext(L), "switched tab", "index", index)
 	return 0
 }
 
@@ -1990,10 +2028,11 @@ func intercept_request(L *lua.LState) int {
 
 	route_url_match := L.OptString(2, "**/*") // 若未传递参数，默认为空字符串
 	// 设置请求拦截器
+	logCtx := flowLogContext(L)
 	err := page.Route(route_url_match, func(route playwright.Route) {
 		// 获取请求对象
 		request := route.Request()
-		fmt.Println(request.URL(), request.Method(), request.ResourceType(), callback)
+		slog.DebugContext(logCtx, "intercepted request", "url", request.URL(), "method", request.Method(), "resource_type", request.ResourceType())
 		// 将请求信息传递给 Lua 回调
 		//_callback := L.GetGlobal("__intercept_request_callback") // 已经将 callback 压入栈中
 		L.Push(callback)
@@ -2022,7 +2061,7 @@ func intercept_request(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Println("Request interceptor set")
+	slog.DebugContext(logCtx, "request interceptor set", "pattern", route_url_match)
 	return 0
 }
 
@@ -2040,8 +2079,9 @@ func block_request(L *lua.LState) int {
 	}
 
 	// 设置请求拦截器
+	logCtx := flowLogContext(L)
 	err := page.Route(pattern, func(route playwright.Route) {
-		fmt.Printf("Blocked request: %s\n", route.Request().URL())
+		slog.DebugContext(logCtx, "blocked request", "url", route.Request().URL())
 		route.Abort("blockedbyclient")
 	})
 	if err != nil {
@@ -2049,7 +2089,7 @@ func block_request(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Blocking requests matching pattern: %s\n", pattern)
+	slog.InfoContext(logCtx, "blocking requests", "pattern", pattern)
 	return 0
 }
 
@@ -2152,7 +2192,7 @@ func save_storage_state(L *lua.LState) int {
 		return 0
 	}
 
-	fmt.Printf("Storage state saved to: %s\n", path)
+	slog.InfoContext(flowLogContext(L), "storage state saved", "path", path)
 	L.Push(lua.LString(path))
 	return 1
 }
@@ -2190,7 +2230,7 @@ func load_storage_state(L *lua.LState) int {
 		_ = oldContext.Close()
 	}
 
-	fmt.Printf("Storage state loaded from: %s\n", path)
+	slog.InfoContext(flowLogContext(L), "storage state loaded", "path", path)
 	L.Push(lua.LString(path))
 	return 1
 }
@@ -2258,7 +2298,7 @@ func use_session(L *lua.LState) int {
 	}
 	_, _ = MarkFlowSavedSessionUsed(name, artifactRoot)
 
-	fmt.Printf("Saved session loaded: %s\n", name)
+	slog.InfoContext(flowLogContext(L), "saved session loaded", "name", name)
 	L.Push(lua.LString(name))
 	return 1
 }
@@ -2303,7 +2343,7 @@ func find_element(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
@@ -2333,7 +2373,7 @@ func find_elements(L *lua.LState) int {
 	}
 
 	// 从 Lua 获取 selector 参数
-	selector := L.CheckString(1)
+	selector := checkLuaSelector(L, 1)
 	if selector == "" {
 		L.RaiseError("Selector cannot be empty")
 		return 0
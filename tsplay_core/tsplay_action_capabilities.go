@@ -251,6 +251,9 @@ func buildFlowActionCapabilitiesRegistry() map[string]FlowActionCapabilities {
 		"close_tab",
 		"switch_to_tab",
 		"block_request",
+		"mock_route",
+		"modify_request",
+		"unroute",
 		"get_response",
 	)
 
@@ -260,8 +263,14 @@ func buildFlowActionCapabilitiesRegistry() map[string]FlowActionCapabilities {
 		NeedsBrowserState: true,
 	}, "get_storage_state", "get_cookies_string")
 
+	register(FlowActionCapabilities{
+		NeedsRuntime: true,
+		NeedsContext: true,
+	}, "wait_for_response", "capture_responses")
+
 	register(FlowActionCapabilities{}, "sleep",
 		"set_var",
+		"expr",
 		"append_var",
 		"assert_number",
 		"retry",
@@ -270,6 +279,12 @@ func buildFlowActionCapabilitiesRegistry() map[string]FlowActionCapabilities {
 		"on_error",
 		"wait_until",
 		"db_transaction",
+		"within_frame",
+		"call_flow",
+		"while",
+		"repeat_until",
+		"break",
+		"continue",
 		"read_json",
 		"read_csv",
 		"read_excel",
@@ -389,7 +404,7 @@ func analyzeFlowStepListPlaywrightUsage(steps []FlowStep, listPath string, ctx *
 
 func analyzeFlowStepPlaywrightUsage(step FlowStep, stepPath string, ctx *FlowContext) PlaywrightUsage {
 	switch step.Action {
-	case "retry", "foreach", "db_transaction":
+	case "retry", "foreach", "db_transaction", "within_frame":
 		return analyzeFlowStepListPlaywrightUsage(step.Steps, stepPath+".steps", ctx)
 	case "if":
 		usage := PlaywrightUsage{}
@@ -409,6 +424,22 @@ func analyzeFlowStepPlaywrightUsage(step FlowStep, stepPath string, ctx *FlowCon
 			return PlaywrightUsage{}
 		}
 		return analyzeFlowStepPlaywrightUsage(*step.Condition, stepPath+".condition", ctx)
+	case "while", "repeat_until":
+		usage := PlaywrightUsage{}
+		if step.Condition != nil {
+			usage.merge(analyzeFlowStepPlaywrightUsage(*step.Condition, stepPath+".condition", ctx))
+		}
+		usage.merge(analyzeFlowStepListPlaywrightUsage(step.Steps, stepPath+".steps", ctx))
+		return usage
+	case "call_flow":
+		return analyzeFlowCallFragmentPlaywrightUsage(step, stepPath, ctx)
+	case "wait_for_response", "capture_responses":
+		capabilities, _ := flowActionCapabilitiesFor(step.Action)
+		usage := PlaywrightUsage{}
+		usage.addCapabilityReason(capabilities, stepPath+"."+step.Action, step.Action, describeFixedPlaywrightRequirement(step.Action, capabilities))
+		usage.merge(analyzeFlowStepListPlaywrightUsage(step.Steps, stepPath+".steps", ctx))
+		usage.normalize()
+		return usage
 	}
 
 	switch step.Action {
@@ -428,6 +459,30 @@ func analyzeFlowStepPlaywrightUsage(step FlowStep, stepPath string, ctx *FlowCon
 	return usage
 }
 
+// analyzeFlowCallFragmentPlaywrightUsage follows call_flow into named
+// fragments. Called flow files are analyzed separately by RunFlow because
+// resolving them requires the caller's file input root.
+func analyzeFlowCallFragmentPlaywrightUsage(step FlowStep, stepPath string, ctx *FlowContext) PlaywrightUsage {
+	name, ok := staticCallFlowFragment(step)
+	if !ok || ctx == nil {
+		return PlaywrightUsage{}
+	}
+	fragment, exists := ctx.Fragments[name]
+	if !exists {
+		return PlaywrightUsage{}
+	}
+	key := "fragment:" + name
+	if _, recursive := flowCallChain(ctx.CallStack, key); recursive {
+		return PlaywrightUsage{}
+	}
+	fragmentCtx := &FlowContext{
+		Vars:      map[string]any{},
+		Fragments: ctx.Fragments,
+		CallStack: append(append([]string(nil), ctx.CallStack...), key),
+	}
+	return analyzeFlowStepListPlaywrightUsage(fragment.Steps, stepPath+".fragment."+name, fragmentCtx)
+}
+
 func analyzeFlowHTTPRequestPlaywrightUsage(step FlowStep, stepPath string, ctx *FlowContext) PlaywrightUsage {
 	usage := PlaywrightUsage{}
 
@@ -512,8 +567,14 @@ func newStaticFlowAnalysisContext(flow *Flow) *FlowContext {
 		for key, value := range flow.Vars {
 			vars[key] = value
 		}
+		for name, parameter := range flow.Parameters {
+			vars[name] = parameter.Default
+		}
 	}
 	ctx := &FlowContext{Vars: vars}
+	if flow != nil {
+		ctx.Fragments = flow.Fragments
+	}
 	if len(vars) == 0 {
 		return ctx
 	}
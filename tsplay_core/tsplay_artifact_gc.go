@@ -0,0 +1,393 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FlowArtifactGCReasonKeepLast = "keep_last_per_flow"
+	FlowArtifactGCReasonMaxAge   = "max_age"
+	FlowArtifactGCReasonMaxBytes = "max_total_bytes"
+)
+
+// flowArtifactReservedDirs are top-level directories of an artifact root that
+// hold long-lived state rather than run output. Garbage collection never
+// deletes anything inside them.
+var flowArtifactReservedDirs = []string{"sessions", "profiles", "workbench", "scheduler"}
+
+// flowArtifactGCMu keeps two collections from deleting the same run roots.
+var flowArtifactGCMu sync.Mutex
+
+// FlowArtifactRetentionPolicy decides which indexed run directories under an
+// artifact root are deleted. Zero fields disable their rule. Failed runs are
+// always kept unless DeleteFailed is set.
+type FlowArtifactRetentionPolicy struct {
+	// KeepLastPerFlow keeps the newest N runs of every flow (or MCP tool).
+	KeepLastPerFlow int `json:"keep_last_per_flow,omitempty" yaml:"keep_last_per_flow"`
+	// MaxAge deletes runs started before the bound, written as a lookback such
+	// as 72h or 30d, an RFC 3339 time or a YYYY-MM-DD date.
+	MaxAge string `json:"max_age,omitempty" yaml:"max_age"`
+	// MaxTotalBytes deletes the oldest remaining runs until the whole artifact
+	// root fits in the budget.
+	MaxTotalBytes int64 `json:"max_total_bytes,omitempty" yaml:"max_total_bytes"`
+	DeleteFailed  bool  `json:"delete_failed,omitempty" yaml:"delete_failed"`
+}
+
+// FlowArtifactGCRun is one run directory selected for deletion.
+type FlowArtifactGCRun struct {
+	RunID     string `json:"run_id"`
+	FlowName  string `json:"flow_name,omitempty"`
+	Tool      string `json:"tool,omitempty"`
+	Status    string `json:"status,omitempty"`
+	StartedAt string `json:"started_at,omitempty"`
+	RunRoot   string `json:"run_root"`
+	Bytes     int64  `json:"bytes"`
+	Reason    string `json:"reason"`
+}
+
+// FlowArtifactGCResult reports what a collection deleted, or would delete when
+// DryRun is set.
+type FlowArtifactGCResult struct {
+	ArtifactRoot     string                      `json:"artifact_root"`
+	DryRun           bool                        `json:"dry_run,omitempty"`
+	Policy           FlowArtifactRetentionPolicy `json:"policy"`
+	ScannedRuns      int                         `json:"scanned_runs"`
+	KeptRuns         int                         `json:"kept_runs"`
+	KeptFailedRuns   int                         `json:"kept_failed_runs,omitempty"`
+	ProtectedRuns    int                         `json:"protected_runs,omitempty"`
+	Deleted          []FlowArtifactGCRun         `json:"deleted"`
+	FreedBytes       int64                       `json:"freed_bytes"`
+	TotalBytesBefore int64                       `json:"total_bytes_before"`
+	TotalBytesAfter  int64                       `json:"total_bytes_after"`
+	Errors           []string                    `json:"errors,omitempty"`
+}
+
+type flowArtifactGCCandidate struct {
+	entry     FlowRunIndexEntry
+	root      string
+	startedAt time.Time
+	bytes     int64
+	reason    string
+	keep      bool
+}
+
+// Enabled reports whether the policy has any rule that can delete a run.
+func (policy FlowArtifactRetentionPolicy) Enabled() bool {
+	return policy.KeepLastPerFlow > 0 || strings.TrimSpace(policy.MaxAge) != "" || policy.MaxTotalBytes > 0
+}
+
+// Validate checks the policy fields without touching the file system.
+func (policy FlowArtifactRetentionPolicy) Validate() error {
+	if policy.KeepLastPerFlow < 0 {
+		return fmt.Errorf("keep_last_per_flow must not be negative")
+	}
+	if policy.MaxTotalBytes < 0 {
+		return fmt.Errorf("max_total_bytes must not be negative")
+	}
+	if _, err := ParseFlowRunTimeFilter(policy.MaxAge, time.Now()); err != nil {
+		return fmt.Errorf("max_age: %w", err)
+	}
+	return nil
+}
+
+// CollectFlowArtifacts deletes run directories recorded in the run index that
+// fall outside the retention policy. Saved sessions, persistent profiles,
+// workbench knowledge and scheduler history are never touched, and neither is
+// a run root holding the storage state of a saved session.
+func CollectFlowArtifacts(artifactRoot string, policy FlowArtifactRetentionPolicy, dryRun bool) (*FlowArtifactGCResult, error) {
+	flowArtifactGCMu.Lock()
+	defer flowArtifactGCMu.Unlock()
+	return collectFlowArtifacts(artifactRoot, policy, dryRun, time.Now(), nil)
+}
+
+// applyFlowArtifactRetention runs a collection after a run finished. Like the
+// run index it is best effort, and it skips its turn while another collection
+// is still running instead of queueing behind it.
+func applyFlowArtifactRetention(artifactRoot string, policy *FlowArtifactRetentionPolicy, currentRunID string) {
+	if policy == nil || !policy.Enabled() || strings.TrimSpace(artifactRoot) == "" {
+		return
+	}
+	if !flowArtifactGCMu.TryLock() {
+		return
+	}
+	defer flowArtifactGCMu.Unlock()
+	_, _ = collectFlowArtifacts(artifactRoot, *policy, false, time.Now(), map[string]bool{currentRunID: true})
+}
+
+func collectFlowArtifacts(artifactRoot string, policy FlowArtifactRetentionPolicy, dryRun bool, now time.Time, keepRunIDs map[string]bool) (*FlowArtifactGCResult, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(artifactRoot) == "" {
+		artifactRoot = DefaultFlowArtifactRoot
+	}
+	root, err := prepareRuntimeFileRoot(artifactRoot)
+	if err != nil {
+		return nil, err
+	}
+	cutoff, _ := ParseFlowRunTimeFilter(policy.MaxAge, now)
+	protectedPaths, err := flowArtifactProtectedPaths(root)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ListFlowRuns(root, FlowRunQuery{})
+	if err != nil {
+		return nil, err
+	}
+	totalBytes, err := flowArtifactDirSize(root)
+	if err != nil {
+		return nil, fmt.Errorf("measure artifact root %q: %w", root, err)
+	}
+	result := &FlowArtifactGCResult{
+		ArtifactRoot:     root,
+		DryRun:           dryRun,
+		Policy:           policy,
+		Deleted:          []FlowArtifactGCRun{},
+		TotalBytesBefore: totalBytes,
+	}
+
+	// entries are newest first, so the position inside each group is the
+	// run's rank for keep_last_per_flow.
+	candidates := []*flowArtifactGCCandidate{}
+	ranks := map[string]int{}
+	for _, entry := range entries {
+		runRoot, ok := flowArtifactRunRoot(root, entry.RunRoot)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(runRoot); err != nil {
+			continue
+		}
+		result.ScannedRuns++
+		group := firstNonEmpty(entry.FlowName, entry.Tool)
+		ranks[group]++
+		candidate := &flowArtifactGCCandidate{entry: entry, root: runRoot, startedAt: flowRunIndexTime(entry)}
+		candidates = append(candidates, candidate)
+
+		switch {
+		case keepRunIDs[entry.RunID] || entry.Status == "queued" || entry.Status == "running" || flowArtifactPathsWithin(protectedPaths, runRoot):
+			candidate.keep = true
+			result.ProtectedRuns++
+			continue
+		case entry.Status == FlowRunStatusFailed && !policy.DeleteFailed:
+			candidate.keep = true
+			result.KeptFailedRuns++
+			continue
+		}
+		if candidate.bytes, err = flowArtifactDirSize(runRoot); err != nil {
+			candidate.keep = true
+			result.Errors = append(result.Errors, fmt.Sprintf("measure %s: %v", runRoot, err))
+			continue
+		}
+		switch {
+		case policy.KeepLastPerFlow > 0 && ranks[group] > policy.KeepLastPerFlow:
+			candidate.reason = FlowArtifactGCReasonKeepLast
+		case !cutoff.IsZero() && !candidate.startedAt.IsZero() && candidate.startedAt.Before(cutoff):
+			candidate.reason = FlowArtifactGCReasonMaxAge
+		}
+	}
+
+	remaining := totalBytes
+	for _, candidate := range candidates {
+		if candidate.reason != "" {
+			remaining -= candidate.bytes
+		}
+	}
+	if policy.MaxTotalBytes > 0 {
+		for i := len(candidates) - 1; i >= 0 && remaining > policy.MaxTotalBytes; i-- {
+			candidate := candidates[i]
+			if candidate.keep || candidate.reason != "" {
+				continue
+			}
+			candidate.reason = FlowArtifactGCReasonMaxBytes
+			remaining -= candidate.bytes
+		}
+	}
+
+	deletedIDs := map[string]bool{}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		candidate := candidates[i]
+		if candidate.reason == "" {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(candidate.root); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("delete %s: %v", candidate.root, err))
+				continue
+			}
+			removeEmptyFlowArtifactParents(root, filepath.Dir(candidate.root))
+		}
+		deletedIDs[candidate.entry.RunID] = true
+		result.FreedBytes += candidate.bytes
+		result.Deleted = append(result.Deleted, FlowArtifactGCRun{
+			RunID:     candidate.entry.RunID,
+			FlowName:  candidate.entry.FlowName,
+			Tool:      candidate.entry.Tool,
+			Status:    candidate.entry.Status,
+			StartedAt: candidate.entry.StartedAt,
+			RunRoot:   candidate.root,
+			Bytes:     candidate.bytes,
+			Reason:    candidate.reason,
+		})
+	}
+	result.KeptRuns = result.ScannedRuns - len(result.Deleted)
+	result.TotalBytesAfter = result.TotalBytesBefore - result.FreedBytes
+	if !dryRun && len(deletedIDs) > 0 {
+		if err := pruneFlowRunIndex(root, deletedIDs); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+	return result, nil
+}
+
+// flowArtifactProtectedPaths lists the paths a collection must keep: the
+// reserved state directories and every saved session storage state file,
+// which may live inside a run root when it was saved from a run.
+func flowArtifactProtectedPaths(root string) ([]string, error) {
+	paths := []string{}
+	for _, name := range flowArtifactReservedDirs {
+		paths = append(paths, filepath.Join(root, name))
+	}
+	sessions, err := ListFlowSavedSessions(root)
+	if err != nil {
+		return nil, fmt.Errorf("list saved sessions: %w", err)
+	}
+	for _, session := range sessions {
+		path := strings.TrimSpace(session.StorageStatePath)
+		if path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, filepath.FromSlash(path))
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	return paths, nil
+}
+
+// flowArtifactRunRoot resolves an indexed run root and rejects anything that
+// is not strictly inside the artifact root or that lies in a reserved
+// directory.
+func flowArtifactRunRoot(root string, runRoot string) (string, bool) {
+	runRoot = strings.TrimSpace(runRoot)
+	if runRoot == "" {
+		return "", false
+	}
+	if !filepath.IsAbs(runRoot) {
+		runRoot = filepath.Join(root, runRoot)
+	}
+	runRoot = filepath.Clean(runRoot)
+	rel, err := filepath.Rel(root, runRoot)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	top, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	for _, name := range flowArtifactReservedDirs {
+		if top == name {
+			return "", false
+		}
+	}
+	return runRoot, true
+}
+
+// flowArtifactPathsWithin reports whether any path is dir itself or lies
+// below it.
+func flowArtifactPathsWithin(paths []string, dir string) bool {
+	for _, path := range paths {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func flowArtifactDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if os.IsNotExist(walkErr) {
+				return nil
+			}
+			return walkErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// removeEmptyFlowArtifactParents removes directories such as
+// mcp_runs/<session> once their last run is gone, stopping at the root.
+func removeEmptyFlowArtifactParents(root string, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// ParseFlowArtifactByteSize reads a size such as 500MB, 2GB or a plain byte
+// count. Units are binary: 1KB is 1024 bytes.
+func ParseFlowArtifactByteSize(text string) (int64, error) {
+	input := strings.TrimSpace(text)
+	text = strings.ToUpper(input)
+	if text == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	scale := int64(1)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(text, unit.suffix); ok {
+			text, scale = strings.TrimSpace(number), unit.scale
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("size %q must be a byte count or a number with a unit such as 500MB or 2GB", input)
+	}
+	return int64(value * float64(scale)), nil
+}
+
+// pruneFlowRunIndex drops deleted runs from the run index. It reloads the
+// index under the lock so runs recorded during the collection are kept.
+func pruneFlowRunIndex(root string, runIDs map[string]bool) error {
+	flowRunIndexMu.Lock()
+	defer flowRunIndexMu.Unlock()
+	entries, err := loadFlowRunIndex(root)
+	if err != nil {
+		return err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !runIDs[entry.RunID] {
+			kept = append(kept, entry)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return flowRunIndexTime(kept[i]).Before(flowRunIndexTime(kept[j]))
+	})
+	return writeFlowRunIndexLocked(root, kept)
+}
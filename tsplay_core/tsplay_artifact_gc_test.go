@@ -0,0 +1,188 @@
+package tsplay_core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectFlowArtifactsKeepLastPerFlow(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "gc_flow",
+		Parameters:    map[string]FlowParameter{"count": {Type: "integer", Default: 1}},
+		Steps: []FlowStep{
+			{Action: "assert_number", With: map[string]any{"value": "{{count}}", "op": "<=", "expected": 5}},
+		},
+	}
+	runIDs := []string{}
+	for i := 0; i < 4; i++ {
+		params := map[string]any{}
+		if i == 0 {
+			params["count"] = 10
+		}
+		result, _ := RunFlow(flow, FlowRunOptions{ArtifactRoot: artifactRoot, Params: params})
+		runIDs = append(runIDs, result.RunID)
+		time.Sleep(2 * time.Millisecond)
+	}
+	knowledge := filepath.Join(artifactRoot, "workbench", "knowledge", "example.com", "card.json")
+	if err := os.MkdirAll(filepath.Dir(knowledge), 0755); err != nil {
+		t.Fatalf("create knowledge dir: %v", err)
+	}
+	if err := os.WriteFile(knowledge, []byte(`{}`), 0644); err != nil {
+		t.Fatalf("write knowledge: %v", err)
+	}
+	if _, err := SaveFlowSavedSession(FlowSavedSessionSaveOptions{Name: "admin", ArtifactRoot: artifactRoot, StorageStateJSON: `{"cookies":[],"origins":[]}`}); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+
+	policy := FlowArtifactRetentionPolicy{KeepLastPerFlow: 1}
+	preview, err := CollectFlowArtifacts(artifactRoot, policy, true)
+	if err != nil {
+		t.Fatalf("CollectFlowArtifacts dry run returned error: %v", err)
+	}
+	// The newest run stays, the failed first run is kept, two runs in between go.
+	if len(preview.Deleted) != 2 || preview.KeptFailedRuns != 1 || preview.ScannedRuns != 4 {
+		t.Fatalf("unexpected dry run result %#v", preview)
+	}
+	if _, err := os.Stat(preview.Deleted[0].RunRoot); err != nil {
+		t.Fatalf("dry run must not delete %s: %v", preview.Deleted[0].RunRoot, err)
+	}
+
+	result, err := CollectFlowArtifacts(artifactRoot, policy, false)
+	if err != nil {
+		t.Fatalf("CollectFlowArtifacts returned error: %v", err)
+	}
+	if len(result.Deleted) != 2 || result.FreedBytes <= 0 || result.TotalBytesAfter >= result.TotalBytesBefore {
+		t.Fatalf("unexpected result %#v", result)
+	}
+	for _, deleted := range result.Deleted {
+		if deleted.Reason != FlowArtifactGCReasonKeepLast || (deleted.RunID != runIDs[1] && deleted.RunID != runIDs[2]) {
+			t.Fatalf("unexpected deleted run %#v", deleted)
+		}
+		if _, err := os.Stat(deleted.RunRoot); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted, got %v", deleted.RunRoot, err)
+		}
+	}
+	runs, _ := ListFlowRuns(artifactRoot, FlowRunQuery{})
+	if len(runs) != 2 || runs[0].RunID != runIDs[3] || runs[1].RunID != runIDs[0] {
+		t.Fatalf("expected index pruned to the kept runs, got %#v", runs)
+	}
+	if _, err := os.Stat(knowledge); err != nil {
+		t.Fatalf("workbench knowledge must survive gc: %v", err)
+	}
+	if _, err := LoadFlowSavedSession("admin", artifactRoot); err != nil {
+		t.Fatalf("saved session must survive gc: %v", err)
+	}
+}
+
+func TestCollectFlowArtifactsMaxAgeAndBytes(t *testing.T) {
+	artifactRoot := t.TempDir()
+	now := time.Now()
+	writeRun := func(runID string, status string, age time.Duration, size int) {
+		t.Helper()
+		runRoot := filepath.Join(artifactRoot, runID)
+		if err := os.MkdirAll(runRoot, 0755); err != nil {
+			t.Fatalf("create run root: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(runRoot, "page.html"), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatalf("write artifact: %v", err)
+		}
+		recordFlowRunIndexEntry(artifactRoot, FlowRunIndexEntry{
+			RunID:     runID,
+			FlowName:  "report",
+			Status:    status,
+			StartedAt: now.Add(-age).Format(time.RFC3339Nano),
+			RunRoot:   runRoot,
+		})
+	}
+	writeRun("ancient", FlowRunStatusSucceeded, 40*24*time.Hour, 100)
+	writeRun("old-failed", FlowRunStatusFailed, 35*24*time.Hour, 100)
+	writeRun("week", FlowRunStatusSucceeded, 7*24*time.Hour, 10000)
+	writeRun("day", FlowRunStatusSucceeded, 24*time.Hour, 10000)
+	writeRun("fresh", FlowRunStatusSucceeded, time.Hour, 10000)
+	// A run root outside the artifact root is never collected.
+	outside := t.TempDir()
+	recordFlowRunIndexEntry(artifactRoot, FlowRunIndexEntry{RunID: "outside", FlowName: "report", Status: FlowRunStatusSucceeded, StartedAt: now.Add(-90 * 24 * time.Hour).Format(time.RFC3339Nano), RunRoot: outside})
+
+	result, err := collectFlowArtifacts(artifactRoot, FlowArtifactRetentionPolicy{MaxAge: "30d", MaxTotalBytes: 25000}, false, now, nil)
+	if err != nil {
+		t.Fatalf("collectFlowArtifacts returned error: %v", err)
+	}
+	reasons := map[string]string{}
+	for _, deleted := range result.Deleted {
+		reasons[deleted.RunID] = deleted.Reason
+	}
+	if len(reasons) != 2 || reasons["ancient"] != FlowArtifactGCReasonMaxAge || reasons["week"] != FlowArtifactGCReasonMaxBytes {
+		t.Fatalf("unexpected deletions %#v", result.Deleted)
+	}
+	if _, err := os.Stat(filepath.Join(artifactRoot, "old-failed")); err != nil {
+		t.Fatalf("failed runs are kept by default: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Fatalf("run roots outside the artifact root must survive: %v", err)
+	}
+
+	withFailed, err := collectFlowArtifacts(artifactRoot, FlowArtifactRetentionPolicy{MaxAge: "30d", DeleteFailed: true}, false, now, nil)
+	if err != nil {
+		t.Fatalf("collectFlowArtifacts returned error: %v", err)
+	}
+	if len(withFailed.Deleted) != 1 || withFailed.Deleted[0].RunID != "old-failed" {
+		t.Fatalf("expected delete_failed to collect the old failed run, got %#v", withFailed.Deleted)
+	}
+
+	if _, err := CollectFlowArtifacts(artifactRoot, FlowArtifactRetentionPolicy{MaxAge: "last month"}, true); err == nil {
+		t.Fatalf("expected invalid max_age to fail")
+	}
+}
+
+func TestRunFlowAppliesRetentionAfterRun(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "retained_flow",
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "first", Value: "a"}},
+	}
+	retention := &FlowArtifactRetentionPolicy{KeepLastPerFlow: 2}
+	var last *FlowResult
+	for i := 0; i < 4; i++ {
+		result, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: artifactRoot, Retention: retention})
+		if err != nil {
+			t.Fatalf("RunFlow returned error: %v", err)
+		}
+		last = result
+		time.Sleep(2 * time.Millisecond)
+	}
+	runs, _ := ListFlowRuns(artifactRoot, FlowRunQuery{Flow: "retained_flow"})
+	if len(runs) != 2 || runs[0].RunID != last.RunID {
+		t.Fatalf("expected only the two newest runs to remain, got %#v", runs)
+	}
+	if _, err := os.Stat(last.RunRoot); err != nil {
+		t.Fatalf("the finished run must be kept: %v", err)
+	}
+	if options := normalizeTSPlayMCPServerOptions([]TSPlayMCPServerOptions{{Retention: retention}}); options.Retention != retention {
+		t.Fatalf("expected MCP server options to keep the retention policy, got %#v", options.Retention)
+	}
+}
+
+func TestParseFlowArtifactByteSize(t *testing.T) {
+	cases := map[string]int64{
+		"":      0,
+		"2048":  2048,
+		"1KB":   1024,
+		"500mb": 500 << 20,
+		"1.5G":  3 << 29,
+	}
+	for text, want := range cases {
+		got, err := ParseFlowArtifactByteSize(text)
+		if err != nil || got != want {
+			t.Fatalf("ParseFlowArtifactByteSize(%q) = %d, %v; want %d", text, got, err, want)
+		}
+	}
+	if _, err := ParseFlowArtifactByteSize("lots"); err == nil {
+		t.Fatalf("expected error for invalid size")
+	}
+}
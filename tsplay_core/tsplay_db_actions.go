@@ -74,8 +74,10 @@ type flowDatabase interface {
 }
 
 type flowDatabaseCacheEntry struct {
-	db  flowDatabase
-	err error
+	db     flowDatabase
+	err    error
+	name   string
+	driver string
 }
 
 var openFlowDatabase = func(driverName string, dsn string) (flowDatabase, error) {
@@ -373,7 +375,7 @@ func getFlowDatabase(config dbConnectionConfig) (flowDatabase, error) {
 		db.SetConnMaxLifetime(settings.ConnMaxLifetime)
 	}
 
-	entry := flowDatabaseCacheEntry{db: db, err: err}
+	entry := flowDatabaseCacheEntry{db: db, err: err, name: config.Name, driver: config.DriverName}
 	actual, _ := flowDatabaseCache.LoadOrStore(key, entry)
 	resolved := actual.(flowDatabaseCacheEntry)
 	return resolved.db, resolved.err
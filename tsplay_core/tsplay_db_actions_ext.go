@@ -492,6 +492,9 @@ func runFlowDBTransactionStep(L *lua.LState, ctx *FlowContext, step FlowStep, st
 	child := *ctx
 	child.Context = runCtx
 	child.DBTransaction = scope
+	// A failed transaction is rolled back, so its steps re-run as a whole on
+	// resume.
+	child.Checkpoint = nil
 
 	children, err := runFlowStepSequence(L, &child, step.Steps, stepPath, 0, 0)
 	if err != nil {
@@ -1253,10 +1256,10 @@ func resolveDBExecutor(flowCtx *FlowContext, fallbackCtx context.Context, connec
 			runCtx, cancel = context.WithTimeout(runCtx, settings.QueryTimeout)
 			if flowCtx != nil && flowCtx.DBTransaction != nil {
 				executor, err := flowCtx.DBTransaction.executor(runCtx, connection)
-				return runCtx, cancel, executor, err
+				return runCtx, cancel, traceFlowDBExecutor(executor, connection), err
 			}
 			db, err := getFlowDatabase(connection)
-			return runCtx, cancel, db, err
+			return runCtx, cancel, traceFlowDBExecutor(db, connection), err
 		}
 	}
 
@@ -1266,10 +1269,10 @@ func resolveDBExecutor(flowCtx *FlowContext, fallbackCtx context.Context, connec
 	}
 	if flowCtx != nil && flowCtx.DBTransaction != nil {
 		executor, err := flowCtx.DBTransaction.executor(runCtx, connection)
-		return runCtx, cancel, executor, err
+		return runCtx, cancel, traceFlowDBExecutor(executor, connection), err
 	}
 	db, err := getFlowDatabase(connection)
-	return runCtx, cancel, db, err
+	return runCtx, cancel, traceFlowDBExecutor(db, connection), err
 }
 
 func (scope *flowDBTransactionScope) executor(ctx context.Context, connection dbConnectionConfig) (flowDBExecutor, error) {
@@ -303,9 +303,8 @@ func normalizeSendEmailConfig(values map[string]any) (flowEmailConfig, error) {
 	return config, nil
 }
 
-func executeSendEmail(runCtx context.Context, config flowEmailConfig) (map[string]any, error) {
+func executeSendEmail(runCtx context.Context, config flowEmailConfig) (result map[string]any, err error) {
 	connectionConfig := emailConnectionConfig{}
-	var err error
 	switch {
 	case config.SMTP != nil && strings.TrimSpace(config.Connection) != "":
 		baseConfig, resolveErr := resolveEmailConnectionConfig(config.Connection)
@@ -352,6 +351,13 @@ func executeSendEmail(runCtx context.Context, config flowEmailConfig) (map[strin
 	if runCtx == nil {
 		runCtx = context.Background()
 	}
+	runCtx, span := startOTelSpan(runCtx, "smtp send", otelSpanKindClient,
+		"server.address", connectionConfig.Host,
+		"server.port", connectionConfig.Port,
+		"tsplay.email.connection", connectionConfig.Name,
+		"tsplay.email.recipient_count", len(envelopeRecipients),
+	)
+	defer func() { span.finish(err) }()
 
 	address := net.JoinHostPort(connectionConfig.Host, strconv.Itoa(connectionConfig.Port))
 	dialer := net.Dialer{Timeout: timeout}
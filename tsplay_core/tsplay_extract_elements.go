@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/playwright-community/playwright-go"
 	"log"
+	"log/slog"
 	"strings"
 )
 
@@ -46,7 +47,7 @@ func ExtractPageToJson(page playwright.Page) string {
 	if err != nil {
 		log.Fatalf("could not marshal DOM tree: %v", err)
 	}
-	log.Printf("DOM Tree:\n%s", domTreeJSON)
+	slog.Debug("DOM tree", "json", string(domTreeJSON))
 	return string(domTreeJSON)
 }
 
@@ -100,7 +101,7 @@ func ExtractElementWithXPath(page playwright.Page) string {
 	if err != nil {
 		log.Fatalf("could not marshal DOM tree with XPath: %v", err)
 	}
-	log.Printf("DOM Tree with XPath:\n%s", domTreeWithXPathJSON)
+	slog.Debug("DOM tree with XPath", "json", string(domTreeWithXPathJSON))
 	return string(domTreeWithXPathJSON)
 }
 
@@ -109,7 +110,7 @@ func ExtractSimplifiedElementWithXPath(page playwright.Page) string {
 	if err != nil {
 		log.Fatalf("could not evaluate simplified DOM tree with XPath: %v", err)
 	}
-	log.Printf("Simplified DOM Tree with XPath:\n%s", snapshot)
+	slog.Debug("simplified DOM tree with XPath", "snapshot", snapshot)
 	return snapshot
 }
 
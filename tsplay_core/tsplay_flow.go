@@ -9,6 +9,7 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"log/slog"
 	"math"
 	"os"
 	"path/filepath"
@@ -29,15 +30,31 @@ import (
 // It keeps most business logic declarative, while still allowing lua steps as
 // an escape hatch for advanced cases.
 type Flow struct {
-	SchemaVersion string             `json:"schema_version" yaml:"schema_version"`
-	Name          string             `json:"name" yaml:"name"`
-	Description   string             `json:"description,omitempty" yaml:"description,omitempty"`
-	Browser       *FlowBrowserConfig `json:"browser,omitempty" yaml:"browser,omitempty"`
-	Vars          map[string]any     `json:"vars,omitempty" yaml:"vars,omitempty"`
-	Steps         []FlowStep         `json:"steps" yaml:"steps"`
+	SchemaVersion string                   `json:"schema_version" yaml:"schema_version"`
+	Name          string                   `json:"name" yaml:"name"`
+	Description   string                   `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags          []string                 `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Browser       *FlowBrowserConfig       `json:"browser,omitempty" yaml:"browser,omitempty"`
+	Vars          map[string]any           `json:"vars,omitempty" yaml:"vars,omitempty"`
+	Parameters    map[string]FlowParameter `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Fragments     map[string]FlowFragment  `json:"fragments,omitempty" yaml:"fragments,omitempty"`
+	Steps         []FlowStep               `json:"steps" yaml:"steps"`
+}
+
+// FlowFragment is a named, reusable block of steps that call_flow can invoke.
+// It runs in its own variable scope: only the declared inputs are visible
+// inside the fragment, and only the declared outputs can be returned.
+type FlowFragment struct {
+	Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Inputs      []string   `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Outputs     []string   `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	Steps       []FlowStep `json:"steps" yaml:"steps"`
 }
 
 type FlowBrowserConfig struct {
+	// Engine is the browser engine to launch: chromium (default), firefox or
+	// webkit. CDP attach and launch only work with chromium.
+	Engine           string        `json:"engine,omitempty" yaml:"engine,omitempty"`
 	Headless         *bool         `json:"headless,omitempty" yaml:"headless,omitempty"`
 	UseSession       string        `json:"use_session,omitempty" yaml:"use_session,omitempty"`
 	StorageState     string        `json:"storage_state,omitempty" yaml:"storage_state,omitempty"`
@@ -55,6 +72,20 @@ type FlowBrowserConfig struct {
 	Timeout          int           `json:"timeout,omitempty" yaml:"timeout,omitempty"`
 	UserAgent        string        `json:"user_agent,omitempty" yaml:"user_agent,omitempty"`
 	Viewport         *FlowViewport `json:"viewport,omitempty" yaml:"viewport,omitempty"`
+	// FlowBrowserEmulation holds device, locale, timezone_id, geolocation,
+	// permissions, color_scheme, is_mobile, has_touch and extra_http_headers.
+	FlowBrowserEmulation `yaml:",inline"`
+	// Proxy routes the browser, http_request and download_url through an
+	// HTTP or SOCKS proxy.
+	Proxy *FlowBrowserProxy `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	// Trace records a Playwright trace of the run to <run root>/trace.zip:
+	// off (default), on, or retain-on-failure.
+	Trace string `json:"trace,omitempty" yaml:"trace,omitempty"`
+	// HAR records the run's network traffic or replays it from a HAR file.
+	HAR *FlowBrowserHAR `json:"har,omitempty" yaml:"har,omitempty"`
+
+	// device is the Playwright descriptor browser.device resolved to.
+	device *playwright.DeviceDescriptor
 }
 
 type FlowViewport struct {
@@ -113,6 +144,18 @@ type FlowStep struct {
 	Items              any      `json:"items,omitempty" yaml:"items,omitempty"`
 	ItemVar            string   `json:"item_var,omitempty" yaml:"item_var,omitempty"`
 	IndexVar           string   `json:"index_var,omitempty" yaml:"index_var,omitempty"`
+	Concurrency        int      `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	MaxIterations      int      `json:"max_iterations,omitempty" yaml:"max_iterations,omitempty"`
+
+	// call_flow parameters.
+	Fragment string         `json:"fragment,omitempty" yaml:"fragment,omitempty"`
+	Inputs   map[string]any `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Outputs  []string       `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+
+	// Frame scopes a selector action, or the steps of within_frame, to an
+	// iframe: name=<frame name>, url=<glob>, or iframe selectors joined by
+	// ">>".
+	Frame string `json:"frame,omitempty" yaml:"frame,omitempty"`
 }
 
 type FlowResult struct {
@@ -126,7 +169,24 @@ type FlowResult struct {
 	SessionID    string                  `json:"session_id,omitempty"`
 	ManualReview *FlowManualReviewResult `json:"manual_review,omitempty"`
 	BrowserVideo string                  `json:"browser_video,omitempty"`
+	BrowserTrace string                  `json:"browser_trace,omitempty"`
+	BrowserHAR   string                  `json:"browser_har,omitempty"`
+	Performance  *FlowPerformanceSummary `json:"performance,omitempty"`
 	Playwright   *PlaywrightUsage        `json:"playwright,omitempty"`
+	Checkpoint   string                  `json:"checkpoint,omitempty"`
+	ResumedFrom  *FlowRunResumeInfo      `json:"resumed_from,omitempty"`
+
+	// rawVars and secrets keep what masking Vars hid, so a suite can hand the
+	// vars of its setup flow to the test flows unmasked.
+	rawVars map[string]any
+	secrets []string
+}
+
+// FlowRunResumeInfo identifies the run and failed step a resumed run
+// continued from.
+type FlowRunResumeInfo struct {
+	RunID    string `json:"run_id"`
+	StepPath string `json:"step_path,omitempty"`
 }
 
 type FlowStepTrace struct {
@@ -146,6 +206,7 @@ type FlowStepTrace struct {
 	Output        any                `json:"output,omitempty"`
 	OutputSummary string             `json:"output_summary,omitempty"`
 	PageURL       string             `json:"page_url,omitempty"`
+	Metrics       *FlowStepMetrics   `json:"metrics,omitempty"`
 	Artifacts     *FlowStepArtifacts `json:"artifacts,omitempty"`
 	Condition     *FlowStepTrace     `json:"condition,omitempty"`
 	Children      []FlowStepTrace    `json:"children,omitempty"`
@@ -183,6 +244,26 @@ type FlowContext struct {
 	ClientName    string
 	ClientVersion string
 	OCRSidecars   map[string]*goddddocrSidecar
+	Fragments     map[string]FlowFragment
+	CallStack     []string
+	// Secrets holds run-time secret values that must never appear in traces
+	// or results. It is shared by every copy of the context within one run.
+	Secrets *flowSecretRegistry
+	// Checkpoint records completed steps so a failed run can be resumed. It is
+	// nil for steps that run in their own variable scope.
+	Checkpoint *flowRunCheckpointRecorder
+	// BrowserTrace groups Playwright trace actions by step path when
+	// browser.trace is enabled.
+	BrowserTrace *flowBrowserTracer
+	// Metrics counts requests and page errors per step. It is nil when the
+	// run has no browser context.
+	Metrics *flowStepMetricsRecorder
+	// Proxy is the resolved browser.proxy that http_request and download_url
+	// also use.
+	Proxy *FlowBrowserProxy
+	// Frames holds the frame values of the enclosing within_frame blocks,
+	// outermost first.
+	Frames []string
 }
 
 type FlowRunOptions struct {
@@ -204,6 +285,30 @@ type FlowRunOptions struct {
 	BrowserCDPPort         int
 	BrowserCDPExecutable   string
 	BrowserCDPUserDataDir  string
+	// BrowserTrace overrides browser.trace: off, on or retain-on-failure.
+	BrowserTrace string
+	// BrowserEngine overrides browser.engine: chromium, firefox or webkit.
+	BrowserEngine string
+	// Params supplies values for the flow's declared parameters. String values
+	// are converted to the declared parameter type.
+	Params map[string]any
+	// ResumeRunID continues a failed run from its checkpoint: variables are
+	// restored and steps that already completed are skipped.
+	ResumeRunID string
+	// ResumeRunRoot is where the checkpoint of ResumeRunID lives. It defaults
+	// to <artifact root>/<ResumeRunID>.
+	ResumeRunRoot string
+	// Retention garbage collects old runs under the artifact root once this
+	// run is indexed. The run itself is always kept.
+	Retention *FlowArtifactRetentionPolicy
+
+	resume       *FlowRunCheckpoint
+	browserTrace *flowBrowserTracer
+	proxy        *FlowBrowserProxy
+	secrets      []string
+	// tool names the MCP tool that started the run, so its run index entry
+	// belongs to the caller session from the first write.
+	tool string
 }
 
 type FlowSecurityPolicy struct {
@@ -216,6 +321,7 @@ type FlowSecurityPolicy struct {
 	AllowEmail        bool   `json:"allow_email"`
 	AllowRedis        bool   `json:"allow_redis"`
 	AllowDatabase     bool   `json:"allow_database"`
+	AllowSecrets      bool   `json:"allow_secrets"`
 	FileInputRoot     string `json:"file_input_root,omitempty"`
 	FileOutputRoot    string `json:"file_output_root,omitempty"`
 }
@@ -250,6 +356,7 @@ var flowActionSpecs = map[string]flowActionSpec{
 	"get_text":              {Args: []flowArgSpec{{Name: "selector", Required: true}}},
 	"extract_text":          {Args: []flowArgSpec{{Name: "selector", Required: true}, {Name: "timeout"}, {Name: "pattern"}}},
 	"set_var":               {Args: []flowArgSpec{{Name: "value", Required: true}}},
+	"expr":                  {Args: []flowArgSpec{{Name: "value", Required: true}}},
 	"append_var":            {Args: []flowArgSpec{{Name: "value", Required: true}}},
 	"set_value":             {Args: []flowArgSpec{{Name: "selector", Required: true}, {Name: "value", Required: true}}},
 	"select_option":         {Args: []flowArgSpec{{Name: "selector", Required: true}, {Name: "value", Required: true}}},
@@ -269,6 +376,12 @@ var flowActionSpecs = map[string]flowActionSpec{
 	"on_error":              {},
 	"wait_until":            {},
 	"db_transaction":        {},
+	"within_frame":          {},
+	"call_flow":             {},
+	"while":                 {},
+	"repeat_until":          {},
+	"break":                 {},
+	"continue":              {},
 	"screenshot":            {Args: []flowArgSpec{{Name: "path", Required: true}}},
 	"screenshot_element":    {Args: []flowArgSpec{{Name: "selector", Required: true}, {Name: "path", Required: true}}},
 	"save_html":             {Args: []flowArgSpec{{Name: "path", Required: true}}},
@@ -322,7 +435,12 @@ var flowActionSpecs = map[string]flowActionSpec{
 	"close_tab":             {},
 	"switch_to_tab":         {Args: []flowArgSpec{{Name: "index", Required: true}}},
 	"block_request":         {Args: []flowArgSpec{{Name: "pattern", Required: true}}},
+	"mock_route":            {Args: []flowArgSpec{{Name: "pattern", Required: true}, {Name: "status"}, {Name: "body"}, {Name: "json"}, {Name: "body_file"}, {Name: "headers"}, {Name: "content_type"}, {Name: "method"}, {Name: "times"}}},
+	"modify_request":        {Args: []flowArgSpec{{Name: "pattern", Required: true}, {Name: "headers"}, {Name: "query"}, {Name: "post_data"}, {Name: "json"}, {Name: "method"}, {Name: "times"}}},
+	"unroute":               {Args: []flowArgSpec{{Name: "pattern"}}},
 	"get_response":          {Args: []flowArgSpec{{Name: "url", Required: true}}},
+	"wait_for_response":     {Args: []flowArgSpec{{Name: "pattern", Required: true}, {Name: "method"}, {Name: "status"}, {Name: "timeout"}, {Name: "response_as"}}},
+	"capture_responses":     {Args: []flowArgSpec{{Name: "pattern", Required: true}, {Name: "method"}, {Name: "status"}, {Name: "count"}, {Name: "timeout"}, {Name: "response_as"}}},
 	"get_storage_state":     {Args: []flowArgSpec{{Name: "context_index"}}},
 	"get_cookies_string":    {Args: []flowArgSpec{{Name: "context_index"}}},
 	"lua":                   {Args: []flowArgSpec{{Name: "code", Required: true}}},
@@ -343,6 +461,7 @@ func TrustedFlowSecurityPolicy() FlowSecurityPolicy {
 		AllowEmail:        true,
 		AllowRedis:        true,
 		AllowDatabase:     true,
+		AllowSecrets:      true,
 	}
 }
 
@@ -384,12 +503,32 @@ func ValidateFlowStrict(flow *Flow) error {
 		}
 		knownVars[name] = value
 	}
+	if err := validateFlowParameters(flow); err != nil {
+		return err
+	}
+	for name, parameter := range flow.Parameters {
+		knownVars[name] = parameter.Default
+	}
 
 	if err := validateFlowBrowserConfig(flow.Browser); err != nil {
 		return err
 	}
 
-	return validateFlowStepSequence(flow.Steps, knownVars, "")
+	if err := validateFlowStepSequence(flow.Steps, knownVars, ""); err != nil {
+		return err
+	}
+	if err := validateFlowFragments(flow); err != nil {
+		return err
+	}
+	if err := validateFlowLoopControl(flow.Steps, "", ""); err != nil {
+		return err
+	}
+	for _, name := range sortedFlowFragmentNames(flow) {
+		if err := validateFlowLoopControl(flow.Fragments[name].Steps, flowFragmentPath(name), ""); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func validateFlowStepSequence(steps []FlowStep, knownVars map[string]any, parentPath string) error {
@@ -406,6 +545,9 @@ func validateFlowStepSequence(steps []FlowStep, knownVars map[string]any, parent
 		if step.SaveAs != "" && !flowIdentifierPattern.MatchString(step.SaveAs) {
 			return fmt.Errorf("step %s save_as %q is not a valid variable name", stepPath, step.SaveAs)
 		}
+		if err := validateFlowStepFrame(stepPath, step, knownVars); err != nil {
+			return err
+		}
 
 		if step.Action == "set_var" {
 			if err := validateSetVarFlowStep(stepPath, step, knownVars); err != nil {
@@ -414,6 +556,15 @@ func validateFlowStepSequence(steps []FlowStep, knownVars map[string]any, parent
 			knownVars[step.SaveAs] = nil
 			continue
 		}
+		if step.Action == "expr" {
+			if err := validateExprFlowStep(stepPath, step, knownVars); err != nil {
+				return err
+			}
+			if step.SaveAs != "" {
+				knownVars[step.SaveAs] = nil
+			}
+			continue
+		}
 		if step.Action == "append_var" {
 			if err := validateAppendVarFlowStep(stepPath, step, knownVars); err != nil {
 				return err
@@ -439,6 +590,15 @@ func validateFlowStepSequence(steps []FlowStep, knownVars map[string]any, parent
 			}
 			continue
 		}
+		if step.Action == "mock_route" || step.Action == "modify_request" || step.Action == "unroute" {
+			if err := validateNetworkRouteFlowStep(stepPath, step, spec, knownVars); err != nil {
+				return err
+			}
+			if step.SaveAs != "" {
+				knownVars[step.SaveAs] = nil
+			}
+			continue
+		}
 		if step.Action == "send_email" {
 			if err := validateSendEmailFlowStep(stepPath, step, knownVars); err != nil {
 				return err
@@ -525,6 +685,11 @@ func validateFlowStepSequence(steps []FlowStep, knownVars map[string]any, parent
 			if err := validateFlowControlStep(stepPath, step, knownVars); err != nil {
 				return err
 			}
+			if step.Action == "call_flow" {
+				for _, name := range callFlowOutputNames(step) {
+					knownVars[name] = nil
+				}
+			}
 			if step.SaveAs != "" {
 				knownVars[step.SaveAs] = nil
 			}
@@ -550,7 +715,7 @@ func validateFlowStepSequence(steps []FlowStep, knownVars map[string]any, parent
 
 func isFlowControlAction(action string) bool {
 	switch action {
-	case "retry", "if", "foreach", "on_error", "wait_until", "db_transaction":
+	case "retry", "if", "foreach", "on_error", "wait_until", "db_transaction", "call_flow", "while", "repeat_until", "break", "continue", "wait_for_response", "capture_responses", "within_frame":
 		return true
 	default:
 		return false
@@ -602,6 +767,15 @@ func validateFlowBrowserConfig(browser *FlowBrowserConfig) error {
 			return fmt.Errorf("browser.use_session cannot be combined with browser.persistent/profile/session")
 		}
 	}
+	if err := validateFlowBrowserEmulation(*browser); err != nil {
+		return err
+	}
+	if err := validateFlowBrowserProxy(*browser); err != nil {
+		return err
+	}
+	if err := validateFlowBrowserEngineConfig(*browser); err != nil {
+		return err
+	}
 	if cdpEndpoint != "" || browser.launchesCDP() {
 		if browser.UseSession != "" {
 			return fmt.Errorf("browser.cdp_launch/cdp_endpoint/cdp_port cannot be combined with browser.use_session")
@@ -622,6 +796,12 @@ func validateFlowBrowserConfig(browser *FlowBrowserConfig) error {
 	if browser.Timeout < 0 {
 		return fmt.Errorf("browser.timeout must be at least 0")
 	}
+	if err := validateFlowBrowserTraceMode(browser.Trace); err != nil {
+		return err
+	}
+	if err := validateFlowBrowserHAR(browser); err != nil {
+		return err
+	}
 	if browser.SaveStorageState != "" && strings.TrimSpace(browser.SaveStorageState) == "" {
 		return fmt.Errorf("browser.save_storage_state cannot be blank")
 	}
@@ -673,6 +853,16 @@ func validateFlowControlStep(stepPath string, step FlowStep, knownVars map[strin
 		return validateWaitUntilFlowStep(stepPath, step, knownVars)
 	case "db_transaction":
 		return validateDBTransactionFlowStep(stepPath, step, knownVars)
+	case "call_flow":
+		return validateCallFlowFlowStep(stepPath, step, knownVars)
+	case "while", "repeat_until":
+		return validateWhileFlowStep(stepPath, step, knownVars)
+	case "break", "continue":
+		return validateLoopControlFlowStep(stepPath, step)
+	case "wait_for_response", "capture_responses":
+		return validateResponseCaptureFlowStep(stepPath, step, knownVars)
+	case "within_frame":
+		return validateWithinFrameFlowStep(stepPath, step, knownVars)
 	default:
 		return fmt.Errorf("step %s action %q is not a control action", stepPath, step.Action)
 	}
@@ -762,6 +952,7 @@ func validateForeachFlowStep(stepPath string, step FlowStep, knownVars map[strin
 		"items":               true,
 		"item_var":            true,
 		"index_var":           true,
+		"concurrency":         true,
 		"steps":               true,
 		"progress_key":        true,
 		"progress_connection": true,
@@ -797,6 +988,15 @@ func validateForeachFlowStep(stepPath string, step FlowStep, knownVars map[strin
 	if indexVar, ok := step.param("index_var"); ok && !flowIdentifierPattern.MatchString(fmt.Sprint(indexVar)) {
 		return fmt.Errorf("step %s action %q index_var %q is not a valid variable name", stepPath, step.Action, indexVar)
 	}
+	if value, ok := step.param("concurrency"); ok && len(flowReferences(value)) == 0 {
+		concurrency, err := intParam(value)
+		if err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "concurrency", err)
+		}
+		if concurrency < 1 {
+			return fmt.Errorf("step %s action %q parameter %q must be at least 1", stepPath, step.Action, "concurrency")
+		}
+	}
 	if _, hasProgressConnection := step.param("progress_connection"); hasProgressConnection {
 		if _, hasProgressKey := step.param("progress_key"); !hasProgressKey {
 			return fmt.Errorf("step %s action %q progress_connection requires progress_key", stepPath, step.Action)
@@ -1852,17 +2052,39 @@ func validateFlowParamValue(stepIndex string, action string, name string, value
 	if err := validateFlowParamType(name, value, knownVars); err != nil {
 		return fmt.Errorf("step %s action %q parameter %q %w", stepIndex, action, name, err)
 	}
+	if selector, ok := value.(string); ok && name == "selector" && len(flowReferences(selector)) == 0 {
+		if err := validateSemanticSelector(selector); err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepIndex, action, name, err)
+		}
+	}
 	return nil
 }
 
 func validateFlowReferences(stepIndex string, action string, name string, value any, knownVars map[string]any) error {
 	for _, ref := range flowReferenceExpressions(value) {
-		base, _, err := parseFlowVariableReference(ref)
+		node, isPath, err := parseFlowPlaceholder(ref)
 		if err != nil {
 			return fmt.Errorf("step %s action %q parameter %q has invalid placeholder %q: %w", stepIndex, action, name, ref, err)
 		}
-		if _, ok := knownVars[base]; !ok {
-			return fmt.Errorf("step %s action %q parameter %q references unknown variable %q", stepIndex, action, name, base)
+		if _, shadowed := knownVars[flowSecretRefName]; !shadowed {
+			if _, err := flowPlaceholderSecretNames(ref); err != nil {
+				return fmt.Errorf("step %s action %q parameter %q has invalid secret reference %q: %w", stepIndex, action, name, ref, err)
+			}
+		}
+		if isPath {
+			base, _, _ := parseFlowVariableReference(ref)
+			if _, ok := knownVars[base]; !ok && base != flowSecretRefName {
+				return fmt.Errorf("step %s action %q parameter %q references unknown variable %q", stepIndex, action, name, base)
+			}
+			continue
+		}
+		for _, base := range flowExprRequiredVariables(node) {
+			if _, ok := knownVars[base]; !ok && base != flowSecretRefName {
+				return fmt.Errorf("step %s action %q parameter %q references unknown variable %q", stepIndex, action, name, base)
+			}
+		}
+		if _, err := checkFlowExpression(node); err != nil {
+			return fmt.Errorf("step %s action %q parameter %q has invalid expression %q: %w", stepIndex, action, name, ref, err)
 		}
 	}
 	return nil
@@ -1871,7 +2093,12 @@ func validateFlowReferences(stepIndex string, action string, name string, value
 func validateFlowParamType(name string, value any, knownVars map[string]any) error {
 	if resolved, ok := resolveKnownPlaceholderValue(value, knownVars); ok {
 		value = resolved
-	} else if _, ok := fullPlaceholderExpression(value); ok {
+	} else if expr, ok := fullPlaceholderExpression(value); ok {
+		if node, isPath, err := parseFlowPlaceholder(expr); err == nil && !isPath {
+			if exprType, err := checkFlowExpression(node); err == nil && !flowExprParamTypeMatches(exprType, flowParamType(name)) {
+				return fmt.Errorf("expression %q evaluates to %s", expr, exprType)
+			}
+		}
 		return nil
 	}
 
@@ -1933,17 +2160,17 @@ func validateFlowParamType(name string, value any, knownVars map[string]any) err
 
 func flowParamType(name string) string {
 	switch name {
-	case "url", "selector", "text", "value", "path", "range", "script", "code", "attribute", "sheet", "key", "connection", "file_path", "image_path", "source_path", "folder", "folder_path", "archive_path", "output_path", "save_path", "output_dir", "dest_dir", "destination", "password", "base_dir", "pattern", "item_var", "index_var", "method", "response_as", "body", "row_number_field", "progress_key", "progress_connection", "table", "driver", "sql", "subject", "html", "reply_to", "from_email", "field_name", "op", "label", "mode", "executable":
+	case "url", "selector", "text", "value", "path", "range", "script", "code", "attribute", "sheet", "key", "connection", "file_path", "image_path", "source_path", "folder", "folder_path", "archive_path", "output_path", "save_path", "output_dir", "dest_dir", "destination", "password", "base_dir", "pattern", "item_var", "index_var", "method", "response_as", "body", "row_number_field", "progress_key", "progress_connection", "table", "driver", "sql", "subject", "html", "reply_to", "from_email", "field_name", "op", "label", "mode", "executable", "fragment", "body_file", "content_type", "post_data", "frame":
 		return "string"
 	case "use_browser_cookies", "use_browser_referer", "use_browser_user_agent", "do_nothing", "overwrite", "confidence", "probability", "strict", "auto_scale", "det":
 		return "bool"
-	case "timeout", "index", "context_index", "delta", "ttl_seconds", "times", "interval_ms", "move_steps", "start_row", "limit", "timeout_ms", "timeout_seconds", "startup_timeout":
+	case "timeout", "index", "context_index", "delta", "ttl_seconds", "times", "interval_ms", "concurrency", "max_iterations", "move_steps", "start_row", "limit", "timeout_ms", "timeout_seconds", "startup_timeout", "status", "count":
 		return "int"
 	case "seconds", "x", "y", "delta_x", "delta_y", "scale_x", "scale_y", "expected":
 		return "number"
-	case "headers", "query", "form", "multipart_files", "multipart_fields", "row", "smtp":
+	case "headers", "query", "form", "multipart_files", "multipart_fields", "row", "smtp", "inputs":
 		return "object"
-	case "files", "folders", "paths", "sources", "columns", "returning", "key_columns", "update_columns", "server_args", "cli_args":
+	case "files", "folders", "paths", "sources", "columns", "returning", "key_columns", "update_columns", "server_args", "cli_args", "outputs":
 		return "string_list"
 	case "to", "cc", "bcc":
 		return "email_recipients"
@@ -1984,13 +2211,21 @@ func validateFlowSecurityWithBrowserConfig(flow *Flow, browser FlowBrowserConfig
 	if err := validateFlowStepSequenceSecurity(flow.Steps, policy, ""); err != nil {
 		return err
 	}
+	for _, name := range sortedFlowFragmentNames(flow) {
+		if err := validateFlowStepSequenceSecurity(flow.Fragments[name].Steps, policy, flowFragmentPath(name)); err != nil {
+			return err
+		}
+	}
+	if err := validateFlowSecretAccess(flow, policy); err != nil {
+		return err
+	}
 	if err := validateFlowFileAccessRoots(flow, policy); err != nil {
 		return err
 	}
 	if err := validateFlowBrowserConfigSecurity(&browser, policy); err != nil {
 		return err
 	}
-	return nil
+	return validateFlowCallFiles(flow, &policy, nil)
 }
 
 func validateFlowStepSequenceSecurity(steps []FlowStep, policy FlowSecurityPolicy, parentPath string) error {
@@ -2160,7 +2395,15 @@ func validateFlowFileAccessRoots(flow *Flow, policy FlowSecurityPolicy) error {
 	if !policy.AllowFileAccess {
 		return nil
 	}
-	return validateFlowFileAccessRootsForSteps(flow.Steps, policy, "")
+	if err := validateFlowFileAccessRootsForSteps(flow.Steps, policy, ""); err != nil {
+		return err
+	}
+	for _, name := range sortedFlowFragmentNames(flow) {
+		if err := validateFlowFileAccessRootsForSteps(flow.Fragments[name].Steps, policy, flowFragmentPath(name)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func validateFlowBrowserConfigSecurity(browser *FlowBrowserConfig, policy FlowSecurityPolicy) error {
@@ -2214,6 +2457,12 @@ func validateFlowBrowserConfigSecurity(browser *FlowBrowserConfig, policy FlowSe
 			return err
 		}
 	}
+	// HAR files carry cookies and auth headers, so they count as browser state.
+	if browser.HAR != nil && strings.TrimSpace(browser.HAR.Path) != "" {
+		if err := validateFlowFilePathValue("browser", "browser", "har.path", browser.HAR.pathRole(), browser.HAR.Path, policy); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -2413,10 +2662,12 @@ func flowFilePathParams(action string) map[string]flowFilePathRole {
 		return map[string]flowFilePathRole{"image_path": flowFileInputPath}
 	case "send_email":
 		return map[string]flowFilePathRole{"attachments": flowFileInputPath}
-	case "upload_file":
+	case "upload_file", "call_flow":
 		return map[string]flowFilePathRole{"file_path": flowFileInputPath}
 	case "upload_multiple_files":
 		return map[string]flowFilePathRole{"files": flowFileInputPath}
+	case "mock_route":
+		return map[string]flowFilePathRole{"body_file": flowFileInputPath}
 	default:
 		return nil
 	}
@@ -2545,6 +2796,36 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 	if err := ValidateFlow(flow); err != nil {
 		return nil, err
 	}
+	// The run id is fixed before the browser opens so browser.har can record
+	// into the run root, and before the run span starts so it carries the id.
+	if strings.TrimSpace(options.RunID) == "" {
+		options.RunID = newFlowRunID(flow)
+	}
+	var span *otelSpan
+	options.Context, span = startOTelSpan(options.Context, "flow "+flow.Name, otelSpanKindInternal,
+		"tsplay.flow.name", flow.Name,
+		"tsplay.run_id", options.RunID,
+		"tsplay.session_id", options.SessionID,
+		"tsplay.client_name", options.ClientName,
+	)
+	result, err := runFlowWithBrowser(flow, options)
+	if result != nil {
+		span.setAttributes("tsplay.flow.status", result.Status, "tsplay.step_count", len(result.Trace))
+	}
+	span.finish(err)
+	return result, err
+}
+
+func runFlowWithBrowser(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
+	if _, err := resolveFlowParameters(flow, options.Params); err != nil {
+		return nil, err
+	}
+	if err := loadFlowSecrets(newFlowSecretRegistry(), flowSecretReferences(flow.Vars, flow.Parameters, flow.Steps, flow.Fragments)); err != nil {
+		return nil, err
+	}
+	if err := prepareFlowResume(flow, &options); err != nil {
+		return nil, err
+	}
 	browserConfig, err := mergeFlowBrowserConfig(flow, options)
 	if err != nil {
 		return nil, err
@@ -2558,11 +2839,16 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 	if err != nil {
 		return nil, err
 	}
+	options.proxy, err = browserConfig.Proxy.resolve()
+	if err != nil {
+		return nil, err
+	}
 	if strings.TrimSpace(options.BrowserVideoOutputPath) != "" && browserConfig.connectsOverCDP() {
 		return nil, fmt.Errorf("browser video recording is not supported when attaching to a browser with browser.cdp_launch/cdp_endpoint/cdp_port")
 	}
 	playwrightUsage := AnalyzeFlowPlaywrightUsage(flow)
 	playwrightUsage.merge(analyzeFlowBrowserConfigPlaywrightUsage(&browserConfig))
+	playwrightUsage.merge(analyzeFlowCallFilesPlaywrightUsage(flow, options.Security, nil))
 	needsPlaywright := playwrightUsage.NeedsPlaywright
 
 	L := lua.NewState()
@@ -2579,6 +2865,7 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 	var browserVideo *BrowserVideoRecording
 	var browserVideoPath string
 	var connectedOverCDP bool
+	var browserHARPath string
 	var closeBrowserRuntime func() error
 	closePlaywright := sync.OnceValue(func() error {
 		var closeErr error
@@ -2635,7 +2922,7 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 		if err != nil {
 			return nil, err
 		}
-		pw, err = StartPlaywright()
+		pw, err = StartPlaywright(browserConfig.engine())
 		if err != nil {
 			summary := playwrightUsage.Summary(3)
 			if summary != "" {
@@ -2646,13 +2933,19 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 		browserRuntime, err := OpenFlowBrowser(pw, browserConfig, options, browserVideo)
 		if err != nil {
 			_ = closePlaywright()
-			return nil, err
+			return nil, options.proxy.maskError(err)
 		}
 		browser = browserRuntime.Browser
 		context = browserRuntime.Context
 		page = browserRuntime.Page
 		connectedOverCDP = browserRuntime.ConnectedOverCDP
+		browserHARPath = browserRuntime.HARPath
 		closeBrowserRuntime = browserRuntime.Close
+		options.browserTrace, err = startFlowBrowserTrace(context, browserConfig, flow.Name)
+		if err != nil {
+			_ = closePlaywright()
+			return nil, err
+		}
 		stopWatcher = watchContextCancel(options.Context, func() {
 			_ = closePlaywright()
 		})
@@ -2661,7 +2954,14 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 	defer stopWatcher()
 
 	result, runErr := RunFlowInStateWithOptions(L, flow, options)
+	var traceErr error
+	if result != nil {
+		result.BrowserTrace, traceErr = options.browserTrace.stop(result.RunRoot, runErr != nil)
+	}
 	closeErr := closePlaywright()
+	if closeErr == nil {
+		closeErr = traceErr
+	}
 	if result != nil && playwrightUsage.NeedsPlaywright {
 		usageCopy := playwrightUsage
 		result.Playwright = &usageCopy
@@ -2669,6 +2969,11 @@ func RunFlow(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
 	if result != nil && browserVideoPath != "" {
 		result.BrowserVideo = browserVideoPath
 	}
+	if result != nil && browserHARPath != "" {
+		if _, err := os.Stat(browserHARPath); err == nil {
+			result.BrowserHAR = browserHARPath
+		}
+	}
 	if runErr != nil {
 		FinalizeFlowResult(result, runErr)
 		return result, runErr
@@ -2719,6 +3024,13 @@ func resolveFlowBrowserConfig(flow *Flow, options FlowRunOptions) (FlowBrowserCo
 			if savedConfig.Session != "" {
 				config.Session = savedConfig.Session
 			}
+			if err := applyFlowSavedSessionEngine(&config, *savedConfig, config.UseSession); err != nil {
+				return FlowBrowserConfig{}, err
+			}
+			applyFlowBrowserFingerprint(&config, savedConfig.fingerprint())
+			if config.Proxy == nil {
+				config.Proxy = savedConfig.Proxy
+			}
 		}
 		resolvedConfig := config
 		resolvedConfig.UseSession = ""
@@ -2759,6 +3071,12 @@ func mergeFlowBrowserConfig(flow *Flow, options FlowRunOptions) (FlowBrowserConf
 		config.CDPLaunch = true
 		config.CDPUserDataDir = strings.TrimSpace(options.BrowserCDPUserDataDir)
 	}
+	if strings.TrimSpace(options.BrowserTrace) != "" {
+		config.Trace = strings.TrimSpace(options.BrowserTrace)
+	}
+	if strings.TrimSpace(options.BrowserEngine) != "" {
+		config.Engine = strings.TrimSpace(options.BrowserEngine)
+	}
 	if err := validateFlowBrowserConfig(&config); err != nil {
 		return FlowBrowserConfig{}, err
 	}
@@ -2771,43 +3089,73 @@ type FlowBrowserRuntime struct {
 	Page             playwright.Page
 	ConnectedOverCDP bool
 	CDPEndpoint      string
-	Close            func() error
+	// HARPath is where browser.har recording writes once the context closes.
+	HARPath string
+	Close   func() error
 }
 
 func OpenFlowBrowser(pw *playwright.Playwright, config FlowBrowserConfig, options FlowRunOptions, browserVideo *BrowserVideoRecording) (*FlowBrowserRuntime, error) {
 	if pw == nil {
 		return nil, fmt.Errorf("playwright runtime is nil")
 	}
-	if config.connectsOverCDP() {
-		return connectOverCDPFlowBrowser(pw, config, options, browserVideo)
+	config, err := resolveFlowBrowserHAR(config, options)
+	if err != nil {
+		return nil, err
 	}
-	if config.wantsPersistentContext() {
-		context, page, err := launchPersistentFlowBrowser(pw, config, flowBrowserStateRoot(options), browserVideo)
-		if err != nil {
-			return nil, err
-		}
-		return &FlowBrowserRuntime{
-			Context: context,
-			Page:    page,
-		}, nil
+	config, err = resolveFlowBrowserDevice(pw, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Proxy, err = config.Proxy.resolve(); err != nil {
+		return nil, err
+	}
+	var runtime *FlowBrowserRuntime
+	switch {
+	case config.connectsOverCDP():
+		runtime, err = connectOverCDPFlowBrowser(pw, config, options, browserVideo)
+	case config.wantsPersistentContext():
+		context, page, launchErr := launchPersistentFlowBrowser(pw, config, flowBrowserStateRoot(options), browserVideo)
+		runtime, err = &FlowBrowserRuntime{Context: context, Page: page}, launchErr
+	default:
+		browser, context, page, launchErr := launchFlowBrowser(pw, config, options, browserVideo)
+		runtime, err = &FlowBrowserRuntime{Browser: browser, Context: context, Page: page}, launchErr
 	}
-	browser, context, page, err := launchFlowBrowser(pw, config, options, browserVideo)
 	if err != nil {
 		return nil, err
 	}
-	return &FlowBrowserRuntime{
-		Browser: browser,
-		Context: context,
-		Page:    page,
-	}, nil
+	if err := routeFlowBrowserFromHAR(runtime.Context, config.HAR); err != nil {
+		runtime.closeLaunched()
+		return nil, err
+	}
+	if config.HAR.recording() {
+		runtime.HARPath = config.HAR.Path
+	}
+	return runtime, nil
+}
+
+// closeLaunched releases a runtime that failed to finish opening. Browsers
+// attached over CDP are left running.
+func (runtime *FlowBrowserRuntime) closeLaunched() {
+	if !runtime.ConnectedOverCDP {
+		if runtime.Context != nil {
+			_ = runtime.Context.Close()
+		}
+		if runtime.Browser != nil {
+			_ = runtime.Browser.Close()
+		}
+	}
+	if runtime.Close != nil {
+		_ = runtime.Close()
+	}
 }
 
 func launchFlowBrowser(pw *playwright.Playwright, config FlowBrowserConfig, options FlowRunOptions, browserVideo *BrowserVideoRecording) (playwright.Browser, playwright.BrowserContext, playwright.Page, error) {
-	browser, err := pw.Chromium.Launch(playwright.BrowserTypeLaunchOptions{
+	browser, err := flowBrowserType(pw, config.engine()).Launch(playwright.BrowserTypeLaunchOptions{
 		Headless: playwright.Bool(config.headlessValue()),
+		Proxy:    config.Proxy.playwrightProxy(),
 	})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("could not launch browser: %w", err)
+		return nil, nil, nil, fmt.Errorf("could not launch %s browser: %w", config.engine(), err)
 	}
 
 	contextOptions := playwright.BrowserNewContextOptions{}
@@ -2815,6 +3163,11 @@ func launchFlowBrowser(pw *playwright.Playwright, config FlowBrowserConfig, opti
 		browser.Close()
 		return nil, nil, nil, err
 	}
+	if contextOptions.StorageStatePath == nil {
+		if path := options.resume.storageStatePath(); path != "" {
+			contextOptions.StorageStatePath = playwright.String(path)
+		}
+	}
 	context, err := browser.NewContext(contextOptions)
 	if err != nil {
 		browser.Close()
@@ -2938,19 +3291,21 @@ func launchPersistentFlowBrowser(pw *playwright.Playwright, config FlowBrowserCo
 	contextOptions := playwright.BrowserTypeLaunchPersistentContextOptions{
 		Headless: playwright.Bool(config.headlessValue()),
 	}
-	if config.UserAgent != "" {
-		contextOptions.UserAgent = playwright.String(config.UserAgent)
-	}
-	if config.Viewport != nil {
-		contextOptions.Viewport = &playwright.Size{Width: config.Viewport.Width, Height: config.Viewport.Height}
-	}
+	applyFlowBrowserPersistentEmulation(&contextOptions, config)
+	contextOptions.Proxy = config.Proxy.playwrightProxy()
 	if config.Timeout > 0 {
 		contextOptions.Timeout = playwright.Float(float64(config.Timeout))
 	}
 	if browserVideo != nil {
 		contextOptions.RecordVideo = browserVideo.RecordVideo
 	}
-	context, err := pw.Chromium.LaunchPersistentContext(userDataDir, contextOptions)
+	if config.HAR.recording() {
+		contextOptions.RecordHarPath = playwright.String(config.HAR.Path)
+		contextOptions.RecordHarURLFilter = config.HAR.urlMatcher()
+		contextOptions.RecordHarContent = config.HAR.contentPolicy()
+		contextOptions.RecordHarMode = playwright.HarModeFull
+	}
+	context, err := flowBrowserType(pw, config.engine()).LaunchPersistentContext(userDataDir, contextOptions)
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not launch persistent browser context: %w", err)
 	}
@@ -2973,12 +3328,7 @@ func applyFlowBrowserContextOptions(options *playwright.BrowserNewContextOptions
 	if options == nil {
 		return nil
 	}
-	if config.UserAgent != "" {
-		options.UserAgent = playwright.String(config.UserAgent)
-	}
-	if config.Viewport != nil {
-		options.Viewport = &playwright.Size{Width: config.Viewport.Width, Height: config.Viewport.Height}
-	}
+	applyFlowBrowserEmulation(options, config)
 	loadPath, err := config.runtimeLoadStorageStatePath(security)
 	if err != nil {
 		return err
@@ -2989,6 +3339,12 @@ func applyFlowBrowserContextOptions(options *playwright.BrowserNewContextOptions
 	if browserVideo != nil {
 		options.RecordVideo = browserVideo.RecordVideo
 	}
+	if config.HAR.recording() {
+		options.RecordHarPath = playwright.String(config.HAR.Path)
+		options.RecordHarURLFilter = config.HAR.urlMatcher()
+		options.RecordHarContent = config.HAR.contentPolicy()
+		options.RecordHarMode = playwright.HarModeFull
+	}
 	return nil
 }
 
@@ -3023,24 +3379,31 @@ func runFlowInState(L *lua.LState, flow *Flow, options FlowRunOptions) (*FlowRes
 		if err := ValidateFlowSecurity(flow, *options.Security); err != nil {
 			return nil, err
 		}
+	} else if err := validateFlowCallFiles(flow, nil, nil); err != nil {
+		return nil, err
+	}
+	params, err := resolveFlowParameters(flow, options.Params)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareFlowResume(flow, &options); err != nil {
+		return nil, err
 	}
 	ensureFlowActionGlobals(L)
 
+	startedAt := time.Now()
 	artifactRoot := flowArtifactRoot(options)
 	runID := strings.TrimSpace(options.RunID)
 	if runID == "" {
 		runID = newFlowRunID(flow)
 	}
-	runRoot := strings.TrimSpace(options.RunRoot)
-	if runRoot == "" && strings.TrimSpace(artifactRoot) != "" {
-		if root, err := prepareRuntimeFileRoot(artifactRoot); err == nil {
-			runRoot = filepath.Join(root, runID)
-		}
-	}
-	runContext := options.Context
-	if runContext == nil {
-		runContext = context.Background()
-	}
+	options.RunID = runID
+	runRoot := flowRunRootForOptions(options)
+	runContext := withLogAttributes(options.Context,
+		"run_id", runID,
+		"session_id", options.SessionID,
+		"client_name", options.ClientName,
+	)
 	ctx := &FlowContext{
 		Vars:          map[string]any{},
 		Security:      options.Security,
@@ -3051,14 +3414,51 @@ func runFlowInState(L *lua.LState, flow *Flow, options FlowRunOptions) (*FlowRes
 		SessionID:     options.SessionID,
 		ClientName:    options.ClientName,
 		ClientVersion: options.ClientVersion,
+		Fragments:     flow.Fragments,
+		Secrets:       newFlowSecretRegistry(),
+		BrowserTrace:  options.browserTrace,
+		Proxy:         options.proxy,
+	}
+	ctx.Secrets.add(flowSecretParameterValues(flow.Parameters, params)...)
+	for _, name := range sortedFlowParameterNames(flow.Parameters) {
+		if flow.Parameters[name].Secret {
+			ctx.Secrets.markDerived(name, true)
+		}
+	}
+	ctx.Secrets.add(options.secrets...)
+	if ctx.Proxy != nil && ctx.Proxy.Password != "" {
+		ctx.Secrets.add(ctx.Proxy.Password)
+	}
+	defer activeFlowSecrets.track(ctx.Secrets)()
+	if err := loadFlowSecrets(ctx.Secrets, flowSecretReferences(flow.Vars, flow.Parameters, flow.Steps, flow.Fragments)); err != nil {
+		return nil, err
 	}
 	restoreFlowContext := setFlowContextState(L, ctx)
 	defer restoreFlowContext()
 	defer ctx.closeOCRSidecars()
+	if browserContext, ok := flowBrowserContextFromState(L); ok {
+		ctx.Metrics = startFlowStepMetrics(browserContext)
+		defer ctx.Metrics.stop()
+	}
 	for key, value := range flow.Vars {
 		ctx.Vars[key] = value
 		L.SetGlobal(key, goValueToLua(L, value))
 	}
+	for key, value := range params {
+		ctx.Vars[key] = value
+		L.SetGlobal(key, goValueToLua(L, value))
+	}
+	ctx.Checkpoint = newFlowRunCheckpointRecorder(ctx, flow, options.resume)
+	if options.resume != nil {
+		if err := ctx.Checkpoint.restoreVars(L, ctx, options.resume); err != nil {
+			return nil, err
+		}
+		// Parameters passed to the resumed run win over the restored snapshot,
+		// so a resume can correct the input that made the run fail.
+		for key := range options.Params {
+			setFlowVar(L, ctx, key, params[key])
+		}
+	}
 
 	result := &FlowResult{
 		Name:         flow.Name,
@@ -3068,28 +3468,49 @@ func runFlowInState(L *lua.LState, flow *Flow, options FlowRunOptions) (*FlowRes
 		RunRoot:      runRoot,
 		SessionID:    options.SessionID,
 	}
+	if options.resume != nil {
+		result.ResumedFrom = &FlowRunResumeInfo{RunID: options.resume.RunID, StepPath: options.resume.FailedStepPath}
+	}
+	defer func() {
+		if !ctx.Secrets.empty() {
+			result.rawVars = ctx.Vars
+			result.secrets = ctx.Secrets.snapshot()
+			result.Vars, _ = ctx.Secrets.mask(ctx.Vars).(map[string]any)
+		}
+	}()
 	playwrightUsage := AnalyzeFlowPlaywrightUsage(flow)
 	if playwrightUsage.NeedsPlaywright {
 		usageCopy := playwrightUsage
 		result.Playwright = &usageCopy
 	}
+	slog.InfoContext(ctx.Context, "flow run started", "flow", flow.Name, "run_root", runRoot)
 	traces, err := runFlowStepSequence(L, ctx, flow.Steps, "", 0, 0)
+	err = ctx.Secrets.maskError(err)
 	result.Trace = append(result.Trace, traces...)
+	result.Performance = buildFlowPerformanceSummary(result.Trace, time.Since(startedAt).Milliseconds())
 	saveErr := saveFlowBrowserStateFromConfig(L, flow, options)
-	if err != nil {
-		FinalizeFlowResult(result, err)
-		if saveErr != nil {
-			return result, fmt.Errorf("%w (also failed to save storage state: %v)", err, saveErr)
-		}
-		return result, err
+	checkpointErr := err
+	if checkpointErr == nil {
+		checkpointErr = saveErr
 	}
-	if saveErr != nil {
-		FinalizeFlowResult(result, saveErr)
-		return result, saveErr
+	ctx.Checkpoint.finish(L, result, checkpointErr)
+	result.Checkpoint = ctx.Checkpoint.checkpointPath()
+	FinalizeFlowResult(result, checkpointErr)
+	if err != nil && saveErr != nil {
+		err = fmt.Errorf("%w (also failed to save storage state: %v)", err, saveErr)
+	} else if err == nil {
+		err = saveErr
 	}
-
-	FinalizeFlowResult(result, nil)
-	return result, nil
+	if runRoot != "" {
+		recordFlowRunIndexEntry(artifactRoot, flowRunIndexEntryFromResult(result, options, startedAt, err))
+		applyFlowArtifactRetention(artifactRoot, options.Retention, runID)
+	}
+	if err != nil {
+		slog.WarnContext(ctx.Context, "flow run finished", "flow", flow.Name, "status", result.Status, "duration_ms", time.Since(startedAt).Milliseconds(), "err", err)
+	} else {
+		slog.InfoContext(ctx.Context, "flow run finished", "flow", flow.Name, "status", result.Status, "duration_ms", time.Since(startedAt).Milliseconds())
+	}
+	return result, err
 }
 
 func ensureFlowActionGlobals(L *lua.LState) {
@@ -3111,8 +3532,18 @@ func runFlowStepSequence(L *lua.LState, ctx *FlowContext, steps []FlowStep, pare
 			return traces, err
 		}
 		stepPath := flowStepPath(parentPath, i+1)
+		if ctx.Checkpoint.skipStep(stepPath) {
+			traces = append(traces, skippedFlowStepTrace(step, i+1, stepPath, attempt, iteration))
+			continue
+		}
 		trace, err := runFlowStepWithTrace(L, ctx, step, i+1, stepPath, attempt, iteration)
 		traces = append(traces, trace)
+		if isFlowLoopControl(err) {
+			return traces, err
+		}
+		if err == nil || step.ContinueOnError {
+			ctx.Checkpoint.completeStep(stepPath)
+		}
 		if err != nil && !step.ContinueOnError {
 			return traces, fmt.Errorf("step %s %q failed: %w", stepPath, step.Action, err)
 		}
@@ -3152,6 +3583,11 @@ func runFlowStepWithTrace(L *lua.LState, ctx *FlowContext, step FlowStep, index
 
 	var output any
 	var err error
+	metricsMark := ctx.Metrics.mark()
+	restoreStepLogContext := ctx.withStepLogContext(stepPath)
+	defer restoreStepLogContext()
+	endStepSpan := ctx.startStepSpan(step, stepPath, attempt, iteration)
+	endTraceGroup := ctx.BrowserTrace.group(trace)
 	switch step.Action {
 	case "retry":
 		output, trace.Attempts, err = runFlowRetryStep(L, ctx, step, stepPath)
@@ -3165,18 +3601,48 @@ func runFlowStepWithTrace(L *lua.LState, ctx *FlowContext, step FlowStep, index
 		output, trace.Attempts, err = runFlowWaitUntilStep(L, ctx, step, stepPath)
 	case "db_transaction":
 		output, trace.Children, err = runFlowDBTransactionStep(L, ctx, step, stepPath)
+	case "call_flow":
+		output, trace.Children, err = runFlowCallFlowStep(L, ctx, step, stepPath)
+	case "while", "repeat_until":
+		output, trace.Children, err = runFlowWhileStep(L, ctx, step, stepPath)
+	case "wait_for_response", "capture_responses":
+		output, trace.Children, err = runFlowResponseCaptureStep(L, ctx, step, stepPath)
+	case "within_frame":
+		output, trace.Children, err = runFlowWithinFrameStep(L, ctx, step, stepPath)
+	case "break", "continue":
+		err = &flowLoopControl{action: step.Action}
 	default:
 		output, err = runFlowStep(L, ctx, step)
 	}
+	endTraceGroup()
 	trace.FinishedAt = time.Now().Format(time.RFC3339Nano)
 	started, _ := time.Parse(time.RFC3339Nano, trace.StartedAt)
 	finished, _ := time.Parse(time.RFC3339Nano, trace.FinishedAt)
 	trace.DurationMS = finished.Sub(started).Milliseconds()
 	trace.PageURL = currentFlowPageURL(L)
+	trace.Metrics = ctx.Metrics.since(metricsMark)
+	_, isLoopControl := asFlowLoopControl(err)
+	recordFlowStepMetrics(step.Action, finished.Sub(started), err != nil && !isLoopControl)
+	if isLoopControl {
+		endStepSpan(nil)
+	} else {
+		endStepSpan(ctx.Secrets.maskError(err))
+	}
+	if err != nil && !isLoopControl {
+		slog.WarnContext(ctx.Context, "flow step failed", "action", step.Action, "duration_ms", trace.DurationMS, "err", ctx.Secrets.maskError(err))
+	} else {
+		slog.DebugContext(ctx.Context, "flow step finished", "action", step.Action, "duration_ms", trace.DurationMS)
+	}
 
+	if control, ok := asFlowLoopControl(err); ok {
+		trace.Status = "ok"
+		trace.Output = map[string]any{"control": control.action}
+		trace.OutputSummary = summarizeTraceValue(trace.Output)
+		return trace, err
+	}
 	if err != nil {
 		trace.Status = "error"
-		trace.Error = err.Error()
+		trace.Error = ctx.Secrets.maskText(err.Error())
 		trace.ErrorStack = string(debug.Stack())
 		artifacts := captureFlowFailureArtifacts(L, ctx, trace)
 		if !artifacts.empty() {
@@ -3186,9 +3652,11 @@ func runFlowStepWithTrace(L *lua.LState, ctx *FlowContext, step FlowStep, index
 	}
 
 	trace.Status = "ok"
-	trace.Output = compactTraceValue(output, 0)
-	trace.OutputSummary = summarizeTraceValue(output)
+	tracedOutput := ctx.Secrets.mask(output)
+	trace.Output = compactTraceValue(tracedOutput, 0)
+	trace.OutputSummary = summarizeTraceValue(tracedOutput)
 	if step.SaveAs != "" {
+		ctx.Secrets.markDerived(step.SaveAs, flowStepReadsSecrets(ctx, step))
 		ctx.Vars[step.SaveAs] = output
 		L.SetGlobal(step.SaveAs, goValueToLua(L, output))
 	}
@@ -3217,6 +3685,9 @@ func runFlowRetryStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath s
 		snapshot := snapshotFlowVars(ctx)
 		traces, err := runFlowStepSequence(L, ctx, step.Steps, stepPath, attempt, 0)
 		allAttempts = append(allAttempts, traces...)
+		if isFlowLoopControl(err) {
+			return nil, allAttempts, err
+		}
 		if err == nil {
 			return map[string]any{
 				"attempts": attempt,
@@ -3293,6 +3764,16 @@ func runFlowForeachStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath
 	if err != nil {
 		return nil, nil, err
 	}
+	concurrency, err := flowStepOptionalIntParam(ctx, step, "concurrency")
+	if err != nil {
+		return nil, nil, err
+	}
+	if concurrency < 0 {
+		return nil, nil, fmt.Errorf("foreach concurrency must be at least 1")
+	}
+	if concurrency > 1 && len(items) > 1 {
+		return runFlowForeachConcurrent(L, ctx, step, stepPath, items, itemVar, indexVar, concurrency, checkpoint)
+	}
 
 	itemSnapshot, hadItem := snapshotSingleFlowVar(ctx, itemVar)
 	indexSnapshot, hadIndex := snapshotSingleFlowVar(ctx, indexVar)
@@ -3300,10 +3781,14 @@ func runFlowForeachStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath
 	defer restoreSingleFlowVar(L, ctx, indexVar, indexSnapshot, hadIndex)
 
 	children := []FlowStepTrace{}
+	resumed := ctx.Checkpoint.resumeForeach(stepPath)
 	for index, item := range items {
 		if err := flowRunContextError(ctx); err != nil {
 			return nil, children, err
 		}
+		if index < resumed {
+			continue
+		}
 		setFlowVar(L, ctx, itemVar, item)
 		if indexVar != "" {
 			setFlowVar(L, ctx, indexVar, index+1)
@@ -3311,10 +3796,23 @@ func runFlowForeachStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath
 		iteration := index + 1
 		traces, err := runFlowStepSequence(L, ctx, step.Steps, fmt.Sprintf("%s[%d]", stepPath, iteration), 0, iteration)
 		children = append(children, traces...)
-		if err != nil {
+		control, isControl := asFlowLoopControl(err)
+		if err != nil && !isControl {
 			return nil, children, err
 		}
 		checkpoint.recordSuccess(L, ctx, item, iteration)
+		ctx.Checkpoint.completeIteration(stepPath, iteration)
+		if isControl && control.action == "break" {
+			result := map[string]any{
+				"iterations": iteration,
+				"status":     "completed",
+				"stopped_by": "break",
+			}
+			if summary := checkpoint.summary(); summary != nil {
+				result["checkpoint"] = summary
+			}
+			return result, children, nil
+		}
 	}
 	result := map[string]any{
 		"iterations": len(items),
@@ -3482,6 +3980,9 @@ func flowValueAsInt(value any) (int, bool) {
 
 func runFlowOnErrorStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath string) (any, []FlowStepTrace, string, error) {
 	children, err := runFlowStepSequence(L, ctx, step.Steps, stepPath+".try", 0, 0)
+	if isFlowLoopControl(err) {
+		return nil, children, "try", err
+	}
 	if err == nil {
 		return map[string]any{
 			"status": "succeeded",
@@ -3491,6 +3992,9 @@ func runFlowOnErrorStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath
 	setFlowVar(L, ctx, "last_error", err.Error())
 	handlerTraces, handlerErr := runFlowStepSequence(L, ctx, step.OnError, stepPath+".on_error", 0, 0)
 	children = append(children, handlerTraces...)
+	if isFlowLoopControl(handlerErr) {
+		return nil, children, "on_error", handlerErr
+	}
 	if handlerErr != nil {
 		return nil, children, "on_error", fmt.Errorf("on_error handler failed after original error %q: %w", err.Error(), handlerErr)
 	}
@@ -3740,7 +4244,7 @@ func (browser FlowBrowserConfig) runtimeSaveStorageStatePath(security *FlowSecur
 
 func (browser FlowBrowserConfig) usesBrowserState() bool {
 	loadPath, _ := browser.loadStorageStatePath()
-	return loadPath != "" || strings.TrimSpace(browser.SaveStorageState) != "" || browser.wantsPersistentContext() || browser.connectsOverCDP()
+	return loadPath != "" || strings.TrimSpace(browser.SaveStorageState) != "" || browser.wantsPersistentContext() || browser.connectsOverCDP() || browser.HAR != nil || browser.Proxy != nil
 }
 
 func (browser FlowBrowserConfig) wantsPersistentContext() bool {
@@ -3792,7 +4296,7 @@ func (browser FlowBrowserConfig) persistentContextDir(root string) (string, erro
 	if profile == "" {
 		profile = "default"
 	}
-	dir, err := flowSavedSessionProfileDir(rootReal, profile, browser.Session)
+	dir, err := flowSavedSessionProfileDir(rootReal, profile, browser.Session, browser.Engine)
 	if err != nil {
 		return "", err
 	}
@@ -3847,6 +4351,24 @@ func saveFlowBrowserStateFromConfig(L *lua.LState, flow *Flow, options FlowRunOp
 	return nil
 }
 
+// flowRunRootForOptions is the run root a run with these options writes to:
+// RunRoot when set, else <artifact root>/<run id>.
+func flowRunRootForOptions(options FlowRunOptions) string {
+	if runRoot := strings.TrimSpace(options.RunRoot); runRoot != "" {
+		return runRoot
+	}
+	artifactRoot := flowArtifactRoot(options)
+	runID := strings.TrimSpace(options.RunID)
+	if runID == "" || strings.TrimSpace(artifactRoot) == "" {
+		return ""
+	}
+	root, err := prepareRuntimeFileRoot(artifactRoot)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(root, runID)
+}
+
 func newFlowRunID(flow *Flow) string {
 	name := "flow"
 	if flow != nil && strings.TrimSpace(flow.Name) != "" {
@@ -3866,7 +4388,7 @@ func traceStepParams(step FlowStep, ctx *FlowContext) any {
 	if err != nil {
 		return value
 	}
-	return resolved
+	return ctx.Secrets.mask(resolved)
 }
 
 func summarizeTraceValue(value any) string {
@@ -4077,11 +4599,24 @@ func sanitizeArtifactSegment(value string) string {
 }
 
 func runFlowStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
+	step, err := scopeFlowStepToFrame(L, ctx, step)
+	if err != nil {
+		return nil, err
+	}
+	return runScopedFlowStep(L, ctx, step)
+}
+
+// runScopedFlowStep runs a step whose selector is already scoped to its frame.
+// Runners use it for the nested waits and reads they build from their own
+// selector, so the frame is not entered twice.
+func runScopedFlowStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
 	switch step.Action {
 	case "extract_text":
 		return runFlowExtractTextStep(L, ctx, step)
 	case "set_var":
 		return runFlowSetVarStep(ctx, step)
+	case "expr":
+		return runFlowExprStep(ctx, step)
 	case "append_var":
 		return runFlowAppendVarStep(ctx, step)
 	case "click_at":
@@ -4092,6 +4627,12 @@ func runFlowStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
 		return runFlowDragStep(L, ctx, step)
 	case "http_request":
 		return runFlowHTTPRequestStep(L, ctx, step)
+	case "mock_route":
+		return runFlowMockRouteStep(L, ctx, step)
+	case "modify_request":
+		return runFlowModifyRequestStep(L, ctx, step)
+	case "unroute":
+		return runFlowUnrouteStep(L, ctx, step)
 	case "ocr_ready":
 		return runFlowOCRReadyStep(L, ctx, step)
 	case "ocr_request":
@@ -4132,7 +4673,7 @@ func runFlowStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
 		return runFlowAssertTextStep(L, ctx, step)
 	case "assert_number":
 		return runFlowAssertNumberStep(ctx, step)
-	case "retry", "if", "foreach", "on_error", "wait_until", "db_transaction":
+	case "retry", "if", "foreach", "on_error", "wait_until", "db_transaction", "call_flow", "while", "repeat_until", "break", "continue", "wait_for_response", "capture_responses", "within_frame":
 		return nil, fmt.Errorf("control action %q can only be executed by the flow step runner", step.Action)
 	}
 
@@ -4171,7 +4712,7 @@ func runFlowStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
 }
 
 func runFlowClickAtStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
-	selector, err := flowStepStringParam(ctx, step, "selector")
+	selector, err := flowStepSelectorParam(ctx, step)
 	if err != nil {
 		return nil, err
 	}
@@ -4188,7 +4729,7 @@ func runFlowClickAtStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, er
 		return nil, err
 	}
 	if timeout > 0 {
-		if _, err := runFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
+		if _, err := runScopedFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
 			return nil, err
 		}
 	}
@@ -4208,7 +4749,7 @@ func runFlowClickAtStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, er
 }
 
 func runFlowClickBoxStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
-	selector, err := flowStepStringParam(ctx, step, "selector")
+	selector, err := flowStepSelectorParam(ctx, step)
 	if err != nil {
 		return nil, err
 	}
@@ -4261,7 +4802,7 @@ func runFlowClickBoxStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, e
 		return nil, err
 	}
 	if timeout > 0 {
-		if _, err := runFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
+		if _, err := runScopedFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
 			return nil, err
 		}
 	}
@@ -4540,7 +5081,7 @@ func imageDimensions(path string) (int, int, error) {
 }
 
 func runFlowDragStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
-	selector, err := flowStepStringParam(ctx, step, "selector")
+	selector, err := flowStepSelectorParam(ctx, step)
 	if err != nil {
 		return nil, err
 	}
@@ -4564,7 +5105,7 @@ func runFlowDragStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error
 		return nil, err
 	}
 	if timeout > 0 {
-		if _, err := runFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
+		if _, err := runScopedFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
 			return nil, err
 		}
 	}
@@ -4585,7 +5126,7 @@ func runFlowDragStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error
 }
 
 func runFlowAssertVisibleStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
-	selector, err := flowStepStringParam(ctx, step, "selector")
+	selector, err := flowStepSelectorParam(ctx, step)
 	if err != nil {
 		return nil, err
 	}
@@ -4594,12 +5135,12 @@ func runFlowAssertVisibleStep(L *lua.LState, ctx *FlowContext, step FlowStep) (a
 		return nil, err
 	}
 	if timeout > 0 {
-		if _, err := runFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
+		if _, err := runScopedFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
 			return nil, err
 		}
 	}
 
-	visible, err := runFlowStep(L, ctx, FlowStep{Action: "is_visible", Selector: selector})
+	visible, err := runScopedFlowStep(L, ctx, FlowStep{Action: "is_visible", Selector: selector})
 	if err != nil {
 		return nil, err
 	}
@@ -4610,7 +5151,7 @@ func runFlowAssertVisibleStep(L *lua.LState, ctx *FlowContext, step FlowStep) (a
 }
 
 func runFlowAssertTextStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
-	selector, err := flowStepStringParam(ctx, step, "selector")
+	selector, err := flowStepSelectorParam(ctx, step)
 	if err != nil {
 		return nil, err
 	}
@@ -4628,7 +5169,7 @@ func runFlowAssertTextStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any,
 		deadline = deadline.Add(time.Duration(timeout) * time.Millisecond)
 	}
 	for {
-		actual, err := runFlowStep(L, ctx, FlowStep{Action: "get_text", Selector: selector})
+		actual, err := runScopedFlowStep(L, ctx, FlowStep{Action: "get_text", Selector: selector})
 		if err == nil && flowTextContains(actual, expectedText) {
 			return map[string]any{
 				"selector": selector,
@@ -4714,7 +5255,7 @@ func compareFlowNumber(actual float64, op string, expected float64) (bool, strin
 }
 
 func runFlowExtractTextStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
-	selector, err := flowStepStringParam(ctx, step, "selector")
+	selector, err := flowStepSelectorParam(ctx, step)
 	if err != nil {
 		return nil, err
 	}
@@ -4727,12 +5268,12 @@ func runFlowExtractTextStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any
 		return nil, err
 	}
 	if timeout > 0 {
-		if _, err := runFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
+		if _, err := runScopedFlowStep(L, ctx, FlowStep{Action: "wait_for_selector", Selector: selector, Timeout: timeout}); err != nil {
 			return nil, err
 		}
 	}
 
-	actual, err := runFlowStep(L, ctx, FlowStep{Action: "get_text", Selector: selector})
+	actual, err := runScopedFlowStep(L, ctx, FlowStep{Action: "get_text", Selector: selector})
 	if err != nil {
 		return nil, err
 	}
@@ -5478,6 +6019,19 @@ func (step FlowStep) presentNamedParams() map[string]any {
 	}
 	addString("item_var", step.ItemVar)
 	addString("index_var", step.IndexVar)
+	if step.Concurrency != 0 {
+		params["concurrency"] = step.Concurrency
+	}
+	if step.MaxIterations != 0 {
+		params["max_iterations"] = step.MaxIterations
+	}
+	addString("fragment", step.Fragment)
+	if step.Inputs != nil {
+		params["inputs"] = step.Inputs
+	}
+	if len(step.Outputs) > 0 {
+		params["outputs"] = step.Outputs
+	}
 	for name, value := range step.With {
 		params[name] = value
 	}
@@ -5657,6 +6211,28 @@ func (step FlowStep) param(name string) (any, bool) {
 		return stringParam(step.ItemVar)
 	case "index_var":
 		return stringParam(step.IndexVar)
+	case "concurrency":
+		if step.Concurrency == 0 {
+			return nil, false
+		}
+		return step.Concurrency, true
+	case "max_iterations":
+		if step.MaxIterations == 0 {
+			return nil, false
+		}
+		return step.MaxIterations, true
+	case "fragment":
+		return stringParam(step.Fragment)
+	case "inputs":
+		if step.Inputs == nil {
+			return nil, false
+		}
+		return step.Inputs, true
+	case "outputs":
+		if len(step.Outputs) == 0 {
+			return nil, false
+		}
+		return step.Outputs, true
 	default:
 		return nil, false
 	}
@@ -5831,7 +6407,7 @@ func resolveValue(value any, ctx *FlowContext) (any, error) {
 	switch typed := value.(type) {
 	case string:
 		if matches := placeholderPattern.FindStringSubmatch(typed); len(matches) == 2 {
-			return resolveFlowVariableReference(matches[1], ctx.Vars)
+			return resolveFlowExpression(matches[1], flowPlaceholderVars(ctx, matches[1]))
 		}
 		var err error
 		resolved := replacePattern.ReplaceAllStringFunc(typed, func(token string) string {
@@ -5842,7 +6418,7 @@ func resolveValue(value any, ctx *FlowContext) (any, error) {
 			if len(matches) != 2 {
 				return token
 			}
-			value, resolveErr := resolveFlowVariableReference(matches[1], ctx.Vars)
+			value, resolveErr := resolveFlowExpression(matches[1], flowPlaceholderVars(ctx, matches[1]))
 			if resolveErr != nil {
 				err = resolveErr
 				return token
@@ -5999,12 +6575,23 @@ func listLen(value any) int {
 func flowReferences(value any) []string {
 	refs := []string{}
 	for _, ref := range flowReferenceExpressions(value) {
-		base, _, err := parseFlowVariableReference(ref)
+		node, isPath, err := parseFlowPlaceholder(ref)
 		if err != nil {
 			refs = append(refs, ref)
 			continue
 		}
-		refs = append(refs, base)
+		if isPath {
+			base, _, _ := parseFlowVariableReference(ref)
+			refs = append(refs, base)
+			continue
+		}
+		// Expressions without variables (such as now()) are still dynamic, so
+		// keep the raw text as a reference rather than reporting none.
+		names := flowExprVariables(node)
+		if len(names) == 0 {
+			names = []string{ref}
+		}
+		refs = append(refs, names...)
 	}
 	return refs
 }
@@ -6031,7 +6618,7 @@ func fullPlaceholderExpression(value any) (string, bool) {
 		return "", false
 	}
 	expr := strings.TrimSpace(matches[1])
-	if _, _, err := parseFlowVariableReference(expr); err != nil {
+	if _, _, err := parseFlowPlaceholder(expr); err != nil {
 		return "", false
 	}
 	return expr, true
@@ -6042,6 +6629,9 @@ func resolveKnownPlaceholderValue(value any, knownVars map[string]any) (any, boo
 	if !ok {
 		return nil, false
 	}
+	if _, isPath, _ := parseFlowPlaceholder(expr); !isPath {
+		return nil, false
+	}
 	resolved, err := resolveFlowVariableReference(expr, knownVars)
 	if err != nil || resolved == nil {
 		return nil, false
@@ -11,12 +11,13 @@ func BuildFlowJSONSchema() map[string]any {
 		"save_as":           map[string]any{"type": "string", "pattern": flowIdentifierPattern.String(), "description": "Save the action output as a flow variable."},
 		"continue_on_error": map[string]any{"type": "boolean", "description": "Continue to next step when this step fails."},
 		"steps":             map[string]any{"type": "array", "minItems": 1, "description": "Nested steps for control actions such as retry.", "items": map[string]any{"$ref": "#/$defs/step"}},
-		"condition":         map[string]any{"$ref": "#/$defs/step", "description": "Condition step for if and wait_until. Its output truthiness controls the branch."},
+		"condition":         map[string]any{"anyOf": []any{map[string]any{"$ref": "#/$defs/step"}, map[string]any{"type": "string"}}, "description": "Condition step for if, wait_until, while, and repeat_until. Its output truthiness controls the branch. A string such as \"{{ total > 10 }}\" is shorthand for an expr step."},
 		"then":              map[string]any{"type": "array", "minItems": 1, "items": map[string]any{"$ref": "#/$defs/step"}},
 		"else":              map[string]any{"type": "array", "minItems": 1, "items": map[string]any{"$ref": "#/$defs/step"}},
 		"on_error":          map[string]any{"type": "array", "minItems": 1, "items": map[string]any{"$ref": "#/$defs/step"}},
 		"url":               map[string]any{"type": "string"},
 		"selector":          map[string]any{"type": "string", "description": "Use selector candidates from tsplay.observe_page when possible."},
+		"frame":             map[string]any{"type": "string", "description": "Iframe for a selector action or within_frame: name=<frame name>, url=<glob>, or iframe selectors joined by >>. Copy the frame reported by tsplay.observe_page."},
 		"text":              map[string]any{"type": "string"},
 		"value":             map[string]any{"type": "string", "description": "String value. For set_var non-string literals, put the literal in with.value."},
 		"timeout":           map[string]any{"type": "integer", "minimum": 1},
@@ -25,6 +26,8 @@ func BuildFlowJSONSchema() map[string]any {
 		"items":             map[string]any{"description": "List value or variable placeholder for foreach."},
 		"item_var":          map[string]any{"type": "string", "pattern": flowIdentifierPattern.String()},
 		"index_var":         map[string]any{"type": "string", "pattern": flowIdentifierPattern.String()},
+		"max_iterations":    map[string]any{"type": "integer", "minimum": 1, "default": defaultFlowLoopMaxIterations, "description": "Safety cap for while and repeat_until loops."},
+		"concurrency":       map[string]any{"type": "integer", "minimum": 1, "description": "Optional foreach worker count. Each worker uses its own page; only append_var results flow back to the caller."},
 		"seconds":           map[string]any{"type": "number", "exclusiveMinimum": 0},
 		"path":              map[string]any{"type": "string"},
 		"range":             map[string]any{"type": "string", "description": "Optional Excel cell range such as A2:B20 for read_excel."},
@@ -49,6 +52,9 @@ func BuildFlowJSONSchema() map[string]any {
 		"ttl_seconds":       map[string]any{"type": "integer", "minimum": 1},
 		"index":             map[string]any{"type": "integer"},
 		"context_index":     map[string]any{"type": "integer"},
+		"fragment":          map[string]any{"type": "string", "pattern": flowIdentifierPattern.String(), "description": "Named fragment from the top-level fragments block for call_flow."},
+		"inputs":            map[string]any{"type": "object", "propertyNames": map[string]any{"pattern": flowIdentifierPattern.String()}, "description": "Input variables passed to the fragment or flow file called by call_flow."},
+		"outputs":           map[string]any{"type": "array", "items": map[string]any{"type": "string", "pattern": flowIdentifierPattern.String()}, "description": "Variables copied back from the called fragment or flow file into the caller."},
 	}
 	stepSchema := map[string]any{
 		"type":                 "object",
@@ -73,11 +79,13 @@ func BuildFlowJSONSchema() map[string]any {
 			},
 			"name":        map[string]any{"type": "string", "description": "Stable flow name, for example export_yesterday_orders."},
 			"description": map[string]any{"type": "string"},
+			"tags":        map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Labels that -action test -tags selects flows by, for example smoke or slow."},
 			"browser": map[string]any{
 				"type":                 "object",
 				"description":          "Optional browser launch/session config applied to the whole flow.",
 				"additionalProperties": false,
 				"properties": map[string]any{
+					"engine":             map[string]any{"type": "string", "enum": FlowBrowserEngines, "description": "Browser engine to launch: chromium (default), firefox or webkit. CDP options only work with chromium."},
 					"headless":           map[string]any{"type": "boolean"},
 					"use_session":        map[string]any{"type": "string", "description": "Reuse a named saved session created by tsplay.save_session."},
 					"storage_state":      map[string]any{"type": "string", "description": "Load browser storage state from a file relative to the artifact root."},
@@ -94,6 +102,52 @@ func BuildFlowJSONSchema() map[string]any {
 					"session":            map[string]any{"type": "string", "description": "Optional session name inside the profile."},
 					"timeout":            map[string]any{"type": "integer", "minimum": 0, "description": "Default browser/page timeout in milliseconds."},
 					"user_agent":         map[string]any{"type": "string"},
+					"device":             map[string]any{"type": "string", "description": "Playwright device preset such as \"iPhone 13\" or \"Pixel 7\". Sets user agent, viewport, scale factor, is_mobile and has_touch; explicit fields win."},
+					"locale":             map[string]any{"type": "string", "description": "Browser locale such as zh-CN or en-US."},
+					"timezone_id":        map[string]any{"type": "string", "description": "IANA timezone such as Asia/Shanghai."},
+					"geolocation": map[string]any{
+						"type":                 "object",
+						"description":          "Emulated position. The geolocation permission is granted automatically.",
+						"additionalProperties": false,
+						"required":             []string{"latitude", "longitude"},
+						"properties": map[string]any{
+							"latitude":  map[string]any{"type": "number", "minimum": -90, "maximum": 90},
+							"longitude": map[string]any{"type": "number", "minimum": -180, "maximum": 180},
+							"accuracy":  map[string]any{"type": "number", "minimum": 0},
+						},
+					},
+					"permissions":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Permissions granted to every origin, for example geolocation, notifications or clipboard-read."},
+					"color_scheme": map[string]any{"type": "string", "enum": []string{"light", "dark", "no-preference"}},
+					"is_mobile":    map[string]any{"type": "boolean", "description": "Emulate a mobile viewport meta tag. Not supported by firefox."},
+					"has_touch":    map[string]any{"type": "boolean"},
+					"proxy": map[string]any{
+						"type":                 "object",
+						"description":          "Send browser traffic, http_request and download_url through a proxy. Empty fields come from TSPLAY_PROXY_<CONNECTION>_SERVER/BYPASS/USERNAME/PASSWORD; keep credentials in env.",
+						"additionalProperties": false,
+						"properties": map[string]any{
+							"connection": map[string]any{"type": "string", "description": "Env name for the proxy settings. Defaults to default (TSPLAY_PROXY_DEFAULT_* or TSPLAY_PROXY_*)."},
+							"server":     map[string]any{"type": "string", "description": "Proxy URL such as http://proxy.example.com:3128 or socks5://127.0.0.1:1080."},
+							"bypass":     map[string]any{"type": "string", "description": "Comma-separated hosts that skip the proxy, such as localhost,.internal.example.com."},
+							"username":   map[string]any{"type": "string"},
+							"password":   map[string]any{"type": "string", "description": "Prefer TSPLAY_PROXY_<CONNECTION>_PASSWORD over writing it here."},
+						},
+					},
+					"extra_http_headers": map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}, "description": "Headers sent with every request of the browser context."},
+					"trace":              map[string]any{"type": "string", "enum": []string{FlowBrowserTraceOff, FlowBrowserTraceOn, FlowBrowserTraceRetainOnFailure}, "description": "Record a Playwright trace to <run root>/trace.zip with actions grouped by step path. retain-on-failure keeps it only for failed runs."},
+					"har": map[string]any{
+						"type":                 "object",
+						"description":          "Record the run's network traffic to a HAR file, or replay responses from one for offline, deterministic runs.",
+						"additionalProperties": false,
+						"required":             []string{"mode"},
+						"properties": map[string]any{
+							"mode":      map[string]any{"type": "string", "enum": []string{FlowBrowserHARRecord, FlowBrowserHARReplay}},
+							"path":      map[string]any{"type": "string", "description": "HAR file relative to the artifact root. Recording defaults to <run root>/network.har; replay requires it."},
+							"url":       map[string]any{"type": "string", "description": "Glob such as **/api/** limiting which requests are recorded or replayed."},
+							"url_regex": map[string]any{"type": "string", "description": "Regular expression alternative to url."},
+							"not_found": map[string]any{"type": "string", "enum": []string{FlowBrowserHARNotFoundAbort, FlowBrowserHARNotFoundFallback}, "description": "Replay only: abort requests missing from the HAR (default) or let them fall back to the network."},
+							"content":   map[string]any{"type": "string", "enum": []string{"embed", "attach", "omit"}, "description": "Record only: how response bodies are stored."},
+						},
+					},
 					"viewport": map[string]any{
 						"type":                 "object",
 						"additionalProperties": false,
@@ -111,6 +165,39 @@ func BuildFlowJSONSchema() map[string]any {
 				"propertyNames":        map[string]any{"pattern": flowIdentifierPattern.String()},
 				"additionalProperties": true,
 			},
+			"parameters": map[string]any{
+				"type":          "object",
+				"description":   "Typed inputs supplied at run time through CLI -var/-vars-file or MCP run_flow params. Each parameter becomes a flow variable with the same name.",
+				"propertyNames": map[string]any{"pattern": flowIdentifierPattern.String()},
+				"additionalProperties": map[string]any{
+					"type":                 "object",
+					"additionalProperties": false,
+					"properties": map[string]any{
+						"type":        map[string]any{"type": "string", "enum": flowParameterTypes, "default": "string"},
+						"description": map[string]any{"type": "string"},
+						"required":    map[string]any{"type": "boolean", "description": "Fail before the run starts when the caller omits this parameter. Cannot be combined with default."},
+						"default":     map[string]any{"description": "Value used when the caller omits the parameter."},
+						"enum":        map[string]any{"type": "array", "minItems": 1, "description": "Allowed values."},
+						"secret":      map[string]any{"type": "boolean", "description": "Mask the value in traces and results. Secret parameters cannot declare a default."},
+					},
+				},
+			},
+			"fragments": map[string]any{
+				"type":          "object",
+				"description":   "Named reusable step blocks invoked with call_flow. Each fragment runs in its own variable scope.",
+				"propertyNames": map[string]any{"pattern": flowIdentifierPattern.String()},
+				"additionalProperties": map[string]any{
+					"type":                 "object",
+					"additionalProperties": false,
+					"required":             []string{"steps"},
+					"properties": map[string]any{
+						"description": map[string]any{"type": "string"},
+						"inputs":      map[string]any{"type": "array", "items": map[string]any{"type": "string", "pattern": flowIdentifierPattern.String()}, "description": "Variables the caller must pass through call_flow inputs."},
+						"outputs":     map[string]any{"type": "array", "items": map[string]any{"type": "string", "pattern": flowIdentifierPattern.String()}, "description": "Variables the caller may request through call_flow outputs."},
+						"steps":       map[string]any{"type": "array", "minItems": 1, "items": map[string]any{"$ref": "#/$defs/step"}},
+					},
+				},
+			},
 			"steps": map[string]any{
 				"type":        "array",
 				"minItems":    1,
@@ -413,6 +500,22 @@ func flowSpecialActionSchemaConstraint(action string) map[string]any {
 			},
 		}
 	}
+	if action == "call_flow" {
+		return map[string]any{
+			"if": map[string]any{
+				"properties": map[string]any{"action": map[string]any{"const": action}},
+				"required":   []string{"action"},
+			},
+			"then": map[string]any{
+				"description": fmt.Sprintf("Constraints for action %q.", action),
+				"not":         map[string]any{"required": []string{"args"}},
+				"oneOf": []any{
+					map[string]any{"required": []string{"action", "fragment"}},
+					map[string]any{"required": []string{"action", "file_path"}},
+				},
+			},
+		}
+	}
 	if action == "read_excel" {
 		return map[string]any{
 			"if": map[string]any{
@@ -457,6 +560,16 @@ func flowSpecialActionSchemaConstraint(action string) map[string]any {
 		required = []string{"steps", "on_error"}
 	case "wait_until":
 		required = []string{"condition"}
+	case "while", "repeat_until":
+		required = []string{"condition", "steps"}
+	case "break", "continue":
+		required = []string{}
+	case "wait_for_response", "capture_responses":
+		required = []string{"pattern"}
+	case "within_frame":
+		required = []string{"frame", "steps"}
+	case "expr":
+		required = []string{"value"}
 	default:
 		return nil
 	}
@@ -1008,6 +1121,7 @@ func flowSchemaGenerationRules() []string {
 		"Use browser.cdp_launch/cdp_endpoint/cdp_port only when the user explicitly wants a trusted Chrome/Chromium/Edge session; do not combine CDP mode with use_session, storage_state, persistent profile, or user_agent.",
 		"Turn visible page facts into variables with extract_text + save_as, then use set_var when later steps need a stable derived value.",
 		"Use assert_visible/assert_text for business checks, retry for flaky page interactions, if for optional page states, foreach for lists, on_error for local recovery, and wait_until for polling conditions.",
+		"When the same login, pagination, or export block repeats, move it into a top-level fragment and invoke it with call_flow instead of copying steps.",
 		"Keep steps small and named so trace artifacts and repair context point to an exact failure location.",
 		"Do not use execute_script, evaluate, file actions, or browser state actions unless the request explicitly needs them and the MCP allow flags are set.",
 	}
@@ -1016,9 +1130,10 @@ func flowSchemaGenerationRules() []string {
 func flowSelectorStrategy() []string {
 	return []string{
 		"Prefer selectors from tsplay.observe_page selector_candidates when available.",
-		"Selector priority: data-testid, data-cy, id, placeholder, aria-label, role/text, stable class combinations, XPath only as a last resort.",
+		"Selector priority: semantic locators testid=, role=<role>[name=\"...\"], label=, text=, then data-testid/data-cy, id, placeholder, aria-label, stable class combinations, XPath only as a last resort.",
 		"Prefer selectors tied to user intent such as button text, input placeholder, or table/test ids over brittle DOM depth.",
 		"When a selector may appear late, pair it with wait_for_selector, retry, or wait_until instead of using sleep first.",
+		"When an observed element has a frame, copy it into the step's frame or wrap consecutive steps in within_frame; its selectors only match inside that iframe.",
 	}
 }
 
@@ -0,0 +1,337 @@
+package tsplay_core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// FlowBrowserEmulation describes the device a browser context pretends to be.
+// A device preset fills user_agent, viewport, is_mobile and has_touch unless
+// they are set explicitly.
+type FlowBrowserEmulation struct {
+	Device           string            `json:"device,omitempty" yaml:"device,omitempty"`
+	Locale           string            `json:"locale,omitempty" yaml:"locale,omitempty"`
+	TimezoneID       string            `json:"timezone_id,omitempty" yaml:"timezone_id,omitempty"`
+	Geolocation      *FlowGeolocation  `json:"geolocation,omitempty" yaml:"geolocation,omitempty"`
+	Permissions      []string          `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	ColorScheme      string            `json:"color_scheme,omitempty" yaml:"color_scheme,omitempty"`
+	IsMobile         *bool             `json:"is_mobile,omitempty" yaml:"is_mobile,omitempty"`
+	HasTouch         *bool             `json:"has_touch,omitempty" yaml:"has_touch,omitempty"`
+	ExtraHTTPHeaders map[string]string `json:"extra_http_headers,omitempty" yaml:"extra_http_headers,omitempty"`
+}
+
+type FlowGeolocation struct {
+	Latitude  float64 `json:"latitude" yaml:"latitude"`
+	Longitude float64 `json:"longitude" yaml:"longitude"`
+	Accuracy  float64 `json:"accuracy,omitempty" yaml:"accuracy,omitempty"`
+}
+
+// FlowBrowserFingerprint is the browser identity a saved session was created
+// with. Flows that use the session start from it so the site sees the same
+// device again.
+type FlowBrowserFingerprint struct {
+	UserAgent string        `json:"user_agent,omitempty"`
+	Viewport  *FlowViewport `json:"viewport,omitempty"`
+	FlowBrowserEmulation
+}
+
+func (emulation FlowBrowserEmulation) empty() bool {
+	return strings.TrimSpace(emulation.Device) == "" &&
+		strings.TrimSpace(emulation.Locale) == "" &&
+		strings.TrimSpace(emulation.TimezoneID) == "" &&
+		emulation.Geolocation == nil &&
+		len(emulation.Permissions) == 0 &&
+		strings.TrimSpace(emulation.ColorScheme) == "" &&
+		emulation.IsMobile == nil &&
+		emulation.HasTouch == nil &&
+		len(emulation.ExtraHTTPHeaders) == 0
+}
+
+func validateFlowBrowserEmulation(browser FlowBrowserConfig) error {
+	emulation := browser.FlowBrowserEmulation
+	for field, value := range map[string]string{"device": emulation.Device, "locale": emulation.Locale, "timezone_id": emulation.TimezoneID} {
+		if value != "" && strings.TrimSpace(value) == "" {
+			return fmt.Errorf("browser.%s cannot be blank", field)
+		}
+	}
+	if geolocation := emulation.Geolocation; geolocation != nil {
+		if geolocation.Latitude < -90 || geolocation.Latitude > 90 {
+			return fmt.Errorf("browser.geolocation.latitude must be between -90 and 90")
+		}
+		if geolocation.Longitude < -180 || geolocation.Longitude > 180 {
+			return fmt.Errorf("browser.geolocation.longitude must be between -180 and 180")
+		}
+		if geolocation.Accuracy < 0 {
+			return fmt.Errorf("browser.geolocation.accuracy must be at least 0")
+		}
+	}
+	for _, permission := range emulation.Permissions {
+		if strings.TrimSpace(permission) == "" {
+			return fmt.Errorf("browser.permissions cannot contain blank entries")
+		}
+	}
+	switch strings.TrimSpace(emulation.ColorScheme) {
+	case "", "light", "dark", "no-preference":
+	default:
+		return fmt.Errorf("browser.color_scheme must be \"light\", \"dark\" or \"no-preference\", got %q", emulation.ColorScheme)
+	}
+	for name := range emulation.ExtraHTTPHeaders {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("browser.extra_http_headers cannot contain a blank header name")
+		}
+	}
+	if emulation.IsMobile != nil && *emulation.IsMobile && browser.engine() == FlowBrowserEngineFirefox {
+		return fmt.Errorf("browser.is_mobile is not supported with browser.engine %q", FlowBrowserEngineFirefox)
+	}
+	if browser.connectsOverCDP() && !emulation.empty() {
+		return fmt.Errorf("browser.cdp_launch/cdp_endpoint/cdp_port cannot be combined with browser.device/locale/timezone_id/geolocation/permissions/color_scheme/is_mobile/has_touch/extra_http_headers")
+	}
+	return nil
+}
+
+// resolveFlowBrowserDevice looks up browser.device in Playwright's device
+// descriptors. Names match case-insensitively, so "iphone 13" finds
+// "iPhone 13".
+func resolveFlowBrowserDevice(pw *playwright.Playwright, config FlowBrowserConfig) (FlowBrowserConfig, error) {
+	name := strings.TrimSpace(config.Device)
+	if name == "" || pw == nil {
+		return config, nil
+	}
+	if device, ok := pw.Devices[name]; ok && device != nil {
+		config.device = device
+		return config, nil
+	}
+	known := make([]string, 0, len(pw.Devices))
+	for candidate, device := range pw.Devices {
+		if strings.EqualFold(candidate, name) && device != nil {
+			config.device = device
+			return config, nil
+		}
+		known = append(known, candidate)
+	}
+	sort.Strings(known)
+	suggestions := []string{}
+	for _, candidate := range known {
+		if strings.Contains(strings.ToLower(candidate), strings.ToLower(strings.Fields(name)[0])) {
+			suggestions = append(suggestions, candidate)
+		}
+		if len(suggestions) == 5 {
+			break
+		}
+	}
+	if len(suggestions) > 0 {
+		return config, fmt.Errorf("unknown browser.device %q; similar Playwright devices: %s", name, strings.Join(suggestions, ", "))
+	}
+	return config, fmt.Errorf("unknown browser.device %q; use a Playwright device name such as \"iPhone 13\" or \"Pixel 7\"", name)
+}
+
+// applyFlowBrowserEmulation sets the device preset first and then the
+// explicit browser fields, so user_agent or viewport in the flow win over
+// the preset.
+func applyFlowBrowserEmulation(options *playwright.BrowserNewContextOptions, config FlowBrowserConfig) {
+	if device := config.device; device != nil {
+		if device.UserAgent != "" {
+			options.UserAgent = playwright.String(device.UserAgent)
+		}
+		if device.Viewport != nil {
+			options.Viewport = &playwright.Size{Width: device.Viewport.Width, Height: device.Viewport.Height}
+		}
+		if device.Screen != nil {
+			options.Screen = &playwright.Size{Width: device.Screen.Width, Height: device.Screen.Height}
+		}
+		if device.DeviceScaleFactor > 0 {
+			options.DeviceScaleFactor = playwright.Float(device.DeviceScaleFactor)
+		}
+		// Firefox rejects isMobile, so presets only turn it on elsewhere.
+		if config.engine() != FlowBrowserEngineFirefox {
+			options.IsMobile = playwright.Bool(device.IsMobile)
+		}
+		options.HasTouch = playwright.Bool(device.HasTouch)
+	}
+	if config.UserAgent != "" {
+		options.UserAgent = playwright.String(config.UserAgent)
+	}
+	if config.Viewport != nil {
+		options.Viewport = &playwright.Size{Width: config.Viewport.Width, Height: config.Viewport.Height}
+	}
+	emulation := config.FlowBrowserEmulation
+	if locale := strings.TrimSpace(emulation.Locale); locale != "" {
+		options.Locale = playwright.String(locale)
+	}
+	if timezoneID := strings.TrimSpace(emulation.TimezoneID); timezoneID != "" {
+		options.TimezoneId = playwright.String(timezoneID)
+	}
+	permissions := []string{}
+	for _, permission := range emulation.Permissions {
+		permissions = append(permissions, strings.TrimSpace(permission))
+	}
+	if geolocation := emulation.Geolocation; geolocation != nil {
+		options.Geolocation = &playwright.Geolocation{
+			Latitude:  geolocation.Latitude,
+			Longitude: geolocation.Longitude,
+			Accuracy:  playwright.Float(geolocation.Accuracy),
+		}
+		// A position is only readable by the page once the permission is granted.
+		if !stringSliceContainsFold(permissions, "geolocation") {
+			permissions = append(permissions, "geolocation")
+		}
+	}
+	if len(permissions) > 0 {
+		options.Permissions = permissions
+	}
+	switch strings.TrimSpace(emulation.ColorScheme) {
+	case "light":
+		options.ColorScheme = playwright.ColorSchemeLight
+	case "dark":
+		options.ColorScheme = playwright.ColorSchemeDark
+	case "no-preference":
+		options.ColorScheme = playwright.ColorSchemeNoPreference
+	}
+	if emulation.IsMobile != nil {
+		options.IsMobile = playwright.Bool(*emulation.IsMobile)
+	}
+	if emulation.HasTouch != nil {
+		options.HasTouch = playwright.Bool(*emulation.HasTouch)
+	}
+	if len(emulation.ExtraHTTPHeaders) > 0 {
+		options.ExtraHttpHeaders = emulation.ExtraHTTPHeaders
+	}
+}
+
+// applyFlowBrowserPersistentEmulation applies the same emulation to a
+// persistent context launch.
+func applyFlowBrowserPersistentEmulation(options *playwright.BrowserTypeLaunchPersistentContextOptions, config FlowBrowserConfig) {
+	context := playwright.BrowserNewContextOptions{}
+	applyFlowBrowserEmulation(&context, config)
+	options.UserAgent = context.UserAgent
+	options.Viewport = context.Viewport
+	options.Screen = context.Screen
+	options.DeviceScaleFactor = context.DeviceScaleFactor
+	options.IsMobile = context.IsMobile
+	options.HasTouch = context.HasTouch
+	options.Locale = context.Locale
+	options.TimezoneId = context.TimezoneId
+	options.Geolocation = context.Geolocation
+	options.Permissions = context.Permissions
+	options.ColorScheme = context.ColorScheme
+	options.ExtraHttpHeaders = context.ExtraHttpHeaders
+}
+
+// fingerprint returns the identity fields of the config, or nil when none
+// are set.
+func (browser FlowBrowserConfig) fingerprint() *FlowBrowserFingerprint {
+	fingerprint := &FlowBrowserFingerprint{
+		UserAgent:            strings.TrimSpace(browser.UserAgent),
+		Viewport:             browser.Viewport,
+		FlowBrowserEmulation: browser.FlowBrowserEmulation,
+	}
+	if fingerprint.empty() {
+		return nil
+	}
+	return fingerprint
+}
+
+func (fingerprint *FlowBrowserFingerprint) empty() bool {
+	return fingerprint == nil || (fingerprint.UserAgent == "" && fingerprint.Viewport == nil && fingerprint.FlowBrowserEmulation.empty())
+}
+
+// validateFlowBrowserFingerprint checks a fingerprint before it is saved with
+// a session.
+func validateFlowBrowserFingerprint(fingerprint *FlowBrowserFingerprint) error {
+	if fingerprint == nil {
+		return nil
+	}
+	config := FlowBrowserConfig{
+		UserAgent:            fingerprint.UserAgent,
+		Viewport:             fingerprint.Viewport,
+		FlowBrowserEmulation: fingerprint.FlowBrowserEmulation,
+	}
+	return validateFlowBrowserConfig(&config)
+}
+
+// applyFlowBrowserFingerprint fills the identity fields the flow leaves empty
+// from a saved session's fingerprint.
+func applyFlowBrowserFingerprint(config *FlowBrowserConfig, fingerprint *FlowBrowserFingerprint) {
+	if config == nil || fingerprint == nil {
+		return
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = fingerprint.UserAgent
+	}
+	if config.Viewport == nil && fingerprint.Viewport != nil {
+		viewport := *fingerprint.Viewport
+		config.Viewport = &viewport
+	}
+	emulation := &config.FlowBrowserEmulation
+	saved := fingerprint.FlowBrowserEmulation
+	if emulation.Device == "" {
+		emulation.Device = saved.Device
+	}
+	if emulation.Locale == "" {
+		emulation.Locale = saved.Locale
+	}
+	if emulation.TimezoneID == "" {
+		emulation.TimezoneID = saved.TimezoneID
+	}
+	if emulation.Geolocation == nil && saved.Geolocation != nil {
+		geolocation := *saved.Geolocation
+		emulation.Geolocation = &geolocation
+	}
+	if len(emulation.Permissions) == 0 {
+		emulation.Permissions = append([]string(nil), saved.Permissions...)
+	}
+	if emulation.ColorScheme == "" {
+		emulation.ColorScheme = saved.ColorScheme
+	}
+	if emulation.IsMobile == nil {
+		emulation.IsMobile = saved.IsMobile
+	}
+	if emulation.HasTouch == nil {
+		emulation.HasTouch = saved.HasTouch
+	}
+	if len(saved.ExtraHTTPHeaders) > 0 {
+		headers := map[string]string{}
+		for name, value := range saved.ExtraHTTPHeaders {
+			headers[name] = value
+		}
+		for name, value := range emulation.ExtraHTTPHeaders {
+			headers[name] = value
+		}
+		emulation.ExtraHTTPHeaders = headers
+	}
+}
+
+// flowBrowserFingerprintView is the fingerprint shown in session views.
+// Header values may carry tokens, so they are only shown to the owner.
+func flowBrowserFingerprintView(fingerprint *FlowBrowserFingerprint, includeSensitive bool) *FlowBrowserFingerprint {
+	if fingerprint == nil {
+		return nil
+	}
+	view := *fingerprint
+	if !includeSensitive && len(fingerprint.ExtraHTTPHeaders) > 0 {
+		view.ExtraHTTPHeaders = map[string]string{}
+		for name := range fingerprint.ExtraHTTPHeaders {
+			view.ExtraHTTPHeaders[name] = flowSecretMask
+		}
+	}
+	return &view
+}
+
+// ParseFlowBrowserFingerprint decodes a fingerprint JSON object such as
+// {"device": "iPhone 13", "locale": "zh-CN"}. Blank text returns nil.
+func ParseFlowBrowserFingerprint(text string) (*FlowBrowserFingerprint, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.DisallowUnknownFields()
+	var fingerprint FlowBrowserFingerprint
+	if err := decoder.Decode(&fingerprint); err != nil {
+		return nil, fmt.Errorf("parse fingerprint: %w", err)
+	}
+	return &fingerprint, nil
+}
@@ -0,0 +1,129 @@
+package tsplay_core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestFlowBrowserEmulationParsesValidatesAndAppliesDevice(t *testing.T) {
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: mobile_checkout
+browser:
+  device: iphone 13
+  viewport:
+    width: 400
+    height: 800
+  locale: zh-CN
+  timezone_id: Asia/Shanghai
+  geolocation:
+    latitude: 31.23
+    longitude: 121.47
+  permissions: [notifications]
+  color_scheme: dark
+  extra_http_headers:
+    X-Test-Run: "1"
+steps:
+  - action: set_var
+    save_as: done
+    value: "yes"
+`), "yaml")
+	if err != nil {
+		t.Fatalf("ParseFlow returned error: %v", err)
+	}
+	if flow.Browser.Device != "iphone 13" || flow.Browser.Locale != "zh-CN" || flow.Browser.Geolocation == nil {
+		t.Fatalf("expected inline emulation fields, got %#v", flow.Browser)
+	}
+
+	pw := &playwright.Playwright{Devices: map[string]*playwright.DeviceDescriptor{
+		"iPhone 13": {
+			UserAgent:         "Mozilla/5.0 (iPhone)",
+			Viewport:          &playwright.Size{Width: 390, Height: 664},
+			Screen:            &playwright.Size{Width: 390, Height: 844},
+			DeviceScaleFactor: 3,
+			IsMobile:          true,
+			HasTouch:          true,
+		},
+	}}
+	config, err := resolveFlowBrowserDevice(pw, *flow.Browser)
+	if err != nil {
+		t.Fatalf("resolveFlowBrowserDevice returned error: %v", err)
+	}
+	options := playwright.BrowserNewContextOptions{}
+	applyFlowBrowserEmulation(&options, config)
+	if *options.UserAgent != "Mozilla/5.0 (iPhone)" || options.Viewport.Width != 400 || *options.DeviceScaleFactor != 3 || !*options.IsMobile {
+		t.Fatalf("expected device preset with explicit viewport, got %#v", options)
+	}
+	if *options.Locale != "zh-CN" || *options.TimezoneId != "Asia/Shanghai" || *options.ColorScheme != *playwright.ColorSchemeDark {
+		t.Fatalf("unexpected locale/timezone/color scheme %#v", options)
+	}
+	if !reflect.DeepEqual(options.Permissions, []string{"notifications", "geolocation"}) || options.ExtraHttpHeaders["X-Test-Run"] != "1" {
+		t.Fatalf("unexpected permissions or headers %#v", options)
+	}
+	if _, err := resolveFlowBrowserDevice(pw, FlowBrowserConfig{FlowBrowserEmulation: FlowBrowserEmulation{Device: "iPhone 99"}}); err == nil || !strings.Contains(err.Error(), "iPhone 13") {
+		t.Fatalf("expected an unknown device error with suggestions, got %v", err)
+	}
+
+	mobile := true
+	for _, config := range []FlowBrowserConfig{
+		{FlowBrowserEmulation: FlowBrowserEmulation{Geolocation: &FlowGeolocation{Latitude: 91}}},
+		{FlowBrowserEmulation: FlowBrowserEmulation{ColorScheme: "sepia"}},
+		{Engine: FlowBrowserEngineFirefox, FlowBrowserEmulation: FlowBrowserEmulation{IsMobile: &mobile}},
+		{CDPPort: 9222, FlowBrowserEmulation: FlowBrowserEmulation{Locale: "en-US"}},
+	} {
+		if err := validateFlowBrowserConfig(&config); err == nil {
+			t.Fatalf("expected %#v to be rejected", config)
+		}
+	}
+}
+
+func TestSavedSessionFingerprintIsReusedAndRedacted(t *testing.T) {
+	artifactRoot := t.TempDir()
+	fingerprint, err := ParseFlowBrowserFingerprint(`{"device": "Pixel 7", "locale": "en-GB", "timezone_id": "Europe/London", "extra_http_headers": {"X-Token": "secret"}}`)
+	if err != nil {
+		t.Fatalf("ParseFlowBrowserFingerprint returned error: %v", err)
+	}
+	if _, err := SaveFlowSavedSession(FlowSavedSessionSaveOptions{
+		Name:             "shop",
+		ArtifactRoot:     artifactRoot,
+		StorageStateJSON: `{"cookies":[],"origins":[]}`,
+		Fingerprint:      fingerprint,
+	}); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	// Saving again without a fingerprint keeps the stored one.
+	session, err := SaveFlowSavedSession(FlowSavedSessionSaveOptions{
+		Name:             "shop",
+		ArtifactRoot:     artifactRoot,
+		StorageStateJSON: `{"cookies":[{"name":"SESSION"}],"origins":[]}`,
+	})
+	if err != nil || session.Fingerprint == nil || session.Fingerprint.Device != "Pixel 7" {
+		t.Fatalf("expected the fingerprint to be kept, got %#v, %v", session, err)
+	}
+
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "reuse_shop",
+		Browser:       &FlowBrowserConfig{UseSession: "shop", FlowBrowserEmulation: FlowBrowserEmulation{Locale: "fr-FR"}},
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "done", Value: "yes"}},
+	}
+	config, err := resolveFlowBrowserConfig(flow, FlowRunOptions{ArtifactRoot: artifactRoot})
+	if err != nil {
+		t.Fatalf("resolveFlowBrowserConfig returned error: %v", err)
+	}
+	if config.Device != "Pixel 7" || config.TimezoneID != "Europe/London" || config.Locale != "fr-FR" || config.ExtraHTTPHeaders["X-Token"] != "secret" {
+		t.Fatalf("expected the fingerprint under the flow's own fields, got %#v", config.FlowBrowserEmulation)
+	}
+
+	view := buildFlowSavedSessionView(*session, artifactRoot, false)
+	viewFingerprint, ok := view["fingerprint"].(*FlowBrowserFingerprint)
+	if !ok || viewFingerprint.ExtraHTTPHeaders["X-Token"] != flowSecretMask || viewFingerprint.Device != "Pixel 7" {
+		t.Fatalf("expected redacted fingerprint in view, got %#v", view["fingerprint"])
+	}
+	if _, err := ParseFlowBrowserFingerprint(`{"device": "Pixel 7", "cookies": []}`); err == nil {
+		t.Fatalf("expected unknown fingerprint fields to be rejected")
+	}
+}
@@ -0,0 +1,86 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	FlowBrowserEngineChromium = "chromium"
+	FlowBrowserEngineFirefox  = "firefox"
+	FlowBrowserEngineWebKit   = "webkit"
+)
+
+// FlowBrowserEngines lists the browser engines browser.engine accepts.
+var FlowBrowserEngines = []string{FlowBrowserEngineChromium, FlowBrowserEngineFirefox, FlowBrowserEngineWebKit}
+
+func normalizeFlowBrowserEngine(engine string) string {
+	engine = strings.ToLower(strings.TrimSpace(engine))
+	if engine == "" {
+		return FlowBrowserEngineChromium
+	}
+	return engine
+}
+
+func validateFlowBrowserEngine(engine string) error {
+	switch normalizeFlowBrowserEngine(engine) {
+	case FlowBrowserEngineChromium, FlowBrowserEngineFirefox, FlowBrowserEngineWebKit:
+		return nil
+	default:
+		return fmt.Errorf("browser.engine must be %q, %q or %q, got %q", FlowBrowserEngineChromium, FlowBrowserEngineFirefox, FlowBrowserEngineWebKit, engine)
+	}
+}
+
+// ParseFlowBrowserEngines splits a comma-separated engine list such as
+// "chromium,firefox" and rejects unknown engines. An empty list means
+// chromium.
+func ParseFlowBrowserEngines(value string) ([]string, error) {
+	engines := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		if err := validateFlowBrowserEngine(part); err != nil {
+			return nil, err
+		}
+		engine := normalizeFlowBrowserEngine(part)
+		if !slices.Contains(engines, engine) {
+			engines = append(engines, engine)
+		}
+	}
+	if len(engines) == 0 {
+		engines = append(engines, FlowBrowserEngineChromium)
+	}
+	return engines, nil
+}
+
+// validateFlowBrowserEngineConfig rejects unknown engines and CDP options
+// combined with firefox or webkit, which Playwright cannot attach to.
+func validateFlowBrowserEngineConfig(browser FlowBrowserConfig) error {
+	if err := validateFlowBrowserEngine(browser.Engine); err != nil {
+		return err
+	}
+	if engine := browser.engine(); engine != FlowBrowserEngineChromium && browser.connectsOverCDP() {
+		return fmt.Errorf("browser.cdp_launch/cdp_endpoint/cdp_port only work with browser.engine %q, got %q", FlowBrowserEngineChromium, engine)
+	}
+	return nil
+}
+
+func (browser FlowBrowserConfig) engine() string {
+	return normalizeFlowBrowserEngine(browser.Engine)
+}
+
+// flowBrowserType returns the Playwright browser type that launches engine.
+func flowBrowserType(pw *playwright.Playwright, engine string) playwright.BrowserType {
+	switch normalizeFlowBrowserEngine(engine) {
+	case FlowBrowserEngineFirefox:
+		return pw.Firefox
+	case FlowBrowserEngineWebKit:
+		return pw.WebKit
+	default:
+		return pw.Chromium
+	}
+}
@@ -0,0 +1,120 @@
+package tsplay_core
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestValidateFlowBrowserEngine(t *testing.T) {
+	for _, engine := range []string{"", "chromium", "firefox", " WebKit "} {
+		if err := validateFlowBrowserConfig(&FlowBrowserConfig{Engine: engine}); err != nil {
+			t.Fatalf("browser.engine %q returned error: %v", engine, err)
+		}
+	}
+	err := validateFlowBrowserConfig(&FlowBrowserConfig{Engine: "safari"})
+	if err == nil || !strings.Contains(err.Error(), "browser.engine") {
+		t.Fatalf("expected browser.engine error, got %v", err)
+	}
+	for _, config := range []FlowBrowserConfig{
+		{Engine: FlowBrowserEngineFirefox, CDPPort: 9222},
+		{Engine: FlowBrowserEngineWebKit, CDPLaunch: true},
+	} {
+		err := validateFlowBrowserConfig(&config)
+		if err == nil || !strings.Contains(err.Error(), "only work with browser.engine") {
+			t.Fatalf("expected CDP to be rejected for %q, got %v", config.Engine, err)
+		}
+	}
+	if err := validateFlowBrowserConfig(&FlowBrowserConfig{Engine: FlowBrowserEngineChromium, CDPPort: 9222}); err != nil {
+		t.Fatalf("expected chromium CDP to be accepted, got %v", err)
+	}
+
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "engine_override",
+		Browser:       &FlowBrowserConfig{Engine: FlowBrowserEngineFirefox},
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "done", Value: "yes"}},
+	}
+	config, err := mergeFlowBrowserConfig(flow, FlowRunOptions{BrowserEngine: "webkit"})
+	if err != nil || config.engine() != FlowBrowserEngineWebKit {
+		t.Fatalf("expected run option to override browser.engine, got %#v, %v", config, err)
+	}
+	if _, err := mergeFlowBrowserConfig(flow, FlowRunOptions{BrowserCDPPort: 9222}); err == nil {
+		t.Fatalf("expected firefox with a CDP port to be rejected")
+	}
+
+	engines, err := ParseFlowBrowserEngines("firefox, chromium,firefox")
+	if err != nil || !reflect.DeepEqual(engines, []string{"firefox", "chromium"}) {
+		t.Fatalf("unexpected engines %v, %v", engines, err)
+	}
+	if _, err := ParseFlowBrowserEngines("chromium,edge"); err == nil {
+		t.Fatalf("expected an unknown engine to be rejected")
+	}
+}
+
+func TestRunFlowInstallsTheConfiguredEngine(t *testing.T) {
+	restore := stubPlaywrightRuntime(t,
+		func() error { return nil },
+		func() (*playwright.Playwright, error) { return nil, errors.New("boom") },
+	)
+	defer restore()
+	installed := [][]string{}
+	playwrightInstallFunc = func(browsers []string) error {
+		installed = append(installed, browsers)
+		return nil
+	}
+
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "webkit_flow",
+		Browser:       &FlowBrowserConfig{Engine: FlowBrowserEngineWebKit},
+		Steps:         []FlowStep{{Action: "navigate", URL: "https://example.com"}},
+	}
+	if _, err := RunFlow(flow, FlowRunOptions{}); err == nil {
+		t.Fatalf("expected RunFlow() to fail when Playwright startup fails")
+	}
+	if _, err := RunFlow(flow, FlowRunOptions{}); err == nil {
+		t.Fatalf("expected RunFlow() to fail when Playwright startup fails")
+	}
+	if _, err := InstallPlaywrightRuntime("chromium", "webkit"); err != nil {
+		t.Fatalf("InstallPlaywrightRuntime returned error: %v", err)
+	}
+	want := [][]string{{"webkit"}, {"chromium"}}
+	if !reflect.DeepEqual(installed, want) {
+		t.Fatalf("expected installs %v, got %v", want, installed)
+	}
+}
+
+func TestSavedProfileSessionKeepsItsEngine(t *testing.T) {
+	root := t.TempDir()
+	session, err := SaveFlowSavedSession(FlowSavedSessionSaveOptions{Name: "fx_admin", ArtifactRoot: root, Profile: "ops", Engine: "Firefox"})
+	if err != nil || session.Engine != FlowBrowserEngineFirefox {
+		t.Fatalf("expected the profile session to record firefox, got %#v, %v", session, err)
+	}
+	if _, err := SaveFlowSavedSession(FlowSavedSessionSaveOptions{Name: "bad", ArtifactRoot: root, Profile: "ops", Engine: "safari"}); err == nil || !strings.Contains(err.Error(), "browser.engine") {
+		t.Fatalf("expected an unknown engine to be rejected, got %v", err)
+	}
+
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "profile_engine",
+		Browser:       &FlowBrowserConfig{UseSession: "fx_admin"},
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "done", Value: "yes"}},
+	}
+	config, err := resolveFlowBrowserConfig(flow, FlowRunOptions{ArtifactRoot: root})
+	if err != nil || config.engine() != FlowBrowserEngineFirefox {
+		t.Fatalf("expected the session engine to apply, got %#v, %v", config, err)
+	}
+	dir, err := config.persistentContextDir(root)
+	if err != nil || !strings.HasSuffix(dir, "-"+FlowBrowserEngineFirefox) {
+		t.Fatalf("expected the firefox profile directory, got %q, %v", dir, err)
+	}
+
+	flow.Browser.Engine = FlowBrowserEngineChromium
+	if _, err := resolveFlowBrowserConfig(flow, FlowRunOptions{ArtifactRoot: root}); err == nil || !strings.Contains(err.Error(), "firefox profile") {
+		t.Fatalf("expected a chromium run of a firefox profile to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	FlowBrowserHARRecord = "record"
+	FlowBrowserHARReplay = "replay"
+
+	FlowBrowserHARNotFoundAbort    = "abort"
+	FlowBrowserHARNotFoundFallback = "fallback"
+
+	flowBrowserHARFileName = "network.har"
+)
+
+// FlowBrowserHAR records the network traffic of a run into a HAR file, or
+// replays responses from one so a flow runs without reaching the real
+// backend.
+type FlowBrowserHAR struct {
+	// Mode is record or replay.
+	Mode string `json:"mode" yaml:"mode"`
+	// Path is the HAR file. Recording defaults to <run root>/network.har;
+	// replay requires it. Relative paths resolve under the artifact root.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// URL is a glob such as **/api/** that limits which requests are recorded
+	// or served from the HAR. URLRegex is the regular expression form.
+	URL      string `json:"url,omitempty" yaml:"url,omitempty"`
+	URLRegex string `json:"url_regex,omitempty" yaml:"url_regex,omitempty"`
+	// NotFound decides what replay does with a matching request that is not
+	// in the HAR: abort (default) fails it, fallback sends it to the network.
+	NotFound string `json:"not_found,omitempty" yaml:"not_found,omitempty"`
+	// Content is how recording stores response bodies: embed, attach or omit.
+	// Playwright picks attach for .zip paths and embed otherwise.
+	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+func validateFlowBrowserHAR(browser *FlowBrowserConfig) error {
+	har := browser.HAR
+	if har == nil {
+		return nil
+	}
+	mode := strings.TrimSpace(har.Mode)
+	switch mode {
+	case FlowBrowserHARRecord:
+		if strings.TrimSpace(har.NotFound) != "" {
+			return fmt.Errorf("browser.har.not_found only applies to mode %q", FlowBrowserHARReplay)
+		}
+		switch strings.TrimSpace(har.Content) {
+		case "", "embed", "attach", "omit":
+		default:
+			return fmt.Errorf("browser.har.content must be embed, attach or omit, got %q", har.Content)
+		}
+		if browser.connectsOverCDP() {
+			return fmt.Errorf("browser.har mode %q cannot be combined with browser.cdp_launch/cdp_endpoint/cdp_port", FlowBrowserHARRecord)
+		}
+	case FlowBrowserHARReplay:
+		if strings.TrimSpace(har.Path) == "" {
+			return fmt.Errorf("browser.har.path is required for mode %q", FlowBrowserHARReplay)
+		}
+		if strings.TrimSpace(har.Content) != "" {
+			return fmt.Errorf("browser.har.content only applies to mode %q", FlowBrowserHARRecord)
+		}
+		switch strings.TrimSpace(har.NotFound) {
+		case "", FlowBrowserHARNotFoundAbort, FlowBrowserHARNotFoundFallback:
+		default:
+			return fmt.Errorf("browser.har.not_found must be %q or %q, got %q", FlowBrowserHARNotFoundAbort, FlowBrowserHARNotFoundFallback, har.NotFound)
+		}
+	default:
+		return fmt.Errorf("browser.har.mode must be %q or %q, got %q", FlowBrowserHARRecord, FlowBrowserHARReplay, har.Mode)
+	}
+	if har.Path != "" && strings.TrimSpace(har.Path) == "" {
+		return fmt.Errorf("browser.har.path cannot be blank")
+	}
+	if strings.TrimSpace(har.URL) != "" && strings.TrimSpace(har.URLRegex) != "" {
+		return fmt.Errorf("browser.har accepts either url or url_regex, not both")
+	}
+	if strings.TrimSpace(har.URLRegex) != "" {
+		if _, err := regexp.Compile(har.URLRegex); err != nil {
+			return fmt.Errorf("browser.har.url_regex: %w", err)
+		}
+	}
+	return nil
+}
+
+func (har *FlowBrowserHAR) recording() bool {
+	return har != nil && strings.TrimSpace(har.Mode) == FlowBrowserHARRecord
+}
+
+func (har *FlowBrowserHAR) replaying() bool {
+	return har != nil && strings.TrimSpace(har.Mode) == FlowBrowserHARReplay
+}
+
+func (har *FlowBrowserHAR) pathRole() flowFilePathRole {
+	if har.replaying() {
+		return flowFileInputPath
+	}
+	return flowFileOutputPath
+}
+
+// urlMatcher returns the Playwright URL matcher for url or url_regex, or nil
+// to match every request.
+func (har *FlowBrowserHAR) urlMatcher() any {
+	if pattern := strings.TrimSpace(har.URLRegex); pattern != "" {
+		return regexp.MustCompile(pattern)
+	}
+	if pattern := strings.TrimSpace(har.URL); pattern != "" {
+		return pattern
+	}
+	return nil
+}
+
+// resolveFlowBrowserHAR turns browser.har.path into an absolute path checked
+// against the file roots, defaulting recordings to <run root>/network.har.
+func resolveFlowBrowserHAR(config FlowBrowserConfig, options FlowRunOptions) (FlowBrowserConfig, error) {
+	if config.HAR == nil {
+		return config, nil
+	}
+	har := *config.HAR
+	path := strings.TrimSpace(har.Path)
+	if path == "" {
+		runRoot := flowRunRootForOptions(options)
+		if runRoot == "" {
+			return config, fmt.Errorf("browser.har.path is required when the run has no run root")
+		}
+		path = filepath.Join(runRoot, flowBrowserHARFileName)
+	} else {
+		resolved, err := resolveFlowBrowserStatePath(path, har.pathRole(), options.Security)
+		if err != nil {
+			return config, fmt.Errorf("browser.har.path: %w", err)
+		}
+		path = resolved
+	}
+	har.Path = path
+	config.HAR = &har
+	return config, nil
+}
+
+func (har *FlowBrowserHAR) contentPolicy() *playwright.HarContentPolicy {
+	switch strings.TrimSpace(har.Content) {
+	case "embed":
+		return playwright.HarContentPolicyEmbed
+	case "attach":
+		return playwright.HarContentPolicyAttach
+	case "omit":
+		return playwright.HarContentPolicyOmit
+	default:
+		return nil
+	}
+}
+
+// routeFlowBrowserFromHAR serves matching requests of the context from the
+// recorded HAR.
+func routeFlowBrowserFromHAR(context playwright.BrowserContext, har *FlowBrowserHAR) error {
+	if !har.replaying() || context == nil {
+		return nil
+	}
+	notFound := playwright.HarNotFoundAbort
+	if strings.TrimSpace(har.NotFound) == FlowBrowserHARNotFoundFallback {
+		notFound = playwright.HarNotFoundFallback
+	}
+	if err := context.RouteFromHAR(har.Path, playwright.BrowserContextRouteFromHAROptions{
+		NotFound: notFound,
+		URL:      har.urlMatcher(),
+	}); err != nil {
+		return fmt.Errorf("replay browser.har %q: %w", har.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package tsplay_core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateFlowBrowserHAR(t *testing.T) {
+	valid := []*FlowBrowserHAR{
+		{Mode: "record"},
+		{Mode: "record", Path: "captures/api.har", URL: "**/api/**", Content: "omit"},
+		{Mode: "replay", Path: "captures/api.har", NotFound: "fallback"},
+		{Mode: "replay", Path: "captures/api.har", URLRegex: `/api/v\d+/`},
+	}
+	for _, har := range valid {
+		if err := validateFlowBrowserConfig(&FlowBrowserConfig{HAR: har}); err != nil {
+			t.Fatalf("browser.har %#v returned error: %v", har, err)
+		}
+	}
+
+	invalid := map[string]*FlowBrowserHAR{
+		"browser.har.mode":         {Mode: "capture"},
+		"required for mode":        {Mode: "replay"},
+		"not_found only applies":   {Mode: "record", NotFound: "fallback"},
+		"content only applies":     {Mode: "replay", Path: "a.har", Content: "omit"},
+		"browser.har.not_found":    {Mode: "replay", Path: "a.har", NotFound: "skip"},
+		"either url or url_regex":  {Mode: "replay", Path: "a.har", URL: "**/api/**", URLRegex: "api"},
+		"browser.har.url_regex":    {Mode: "replay", Path: "a.har", URLRegex: "("},
+		"browser.har.content must": {Mode: "record", Content: "inline"},
+		"cannot be combined":       nil,
+	}
+	for want, har := range invalid {
+		config := &FlowBrowserConfig{HAR: har}
+		if har == nil {
+			config = &FlowBrowserConfig{CDPEndpoint: "http://127.0.0.1:9222", HAR: &FlowBrowserHAR{Mode: "record"}}
+		}
+		err := validateFlowBrowserConfig(config)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q for %#v, got %v", want, config.HAR, err)
+		}
+	}
+}
+
+func TestResolveFlowBrowserHARPaths(t *testing.T) {
+	artifactRoot := t.TempDir()
+	recorded, err := resolveFlowBrowserHAR(
+		FlowBrowserConfig{HAR: &FlowBrowserHAR{Mode: FlowBrowserHARRecord}},
+		FlowRunOptions{ArtifactRoot: artifactRoot, RunID: "run-1"},
+	)
+	if err != nil {
+		t.Fatalf("resolveFlowBrowserHAR returned error: %v", err)
+	}
+	root, _ := prepareRuntimeFileRoot(artifactRoot)
+	if want := filepath.Join(root, "run-1", flowBrowserHARFileName); recorded.HAR.Path != want {
+		t.Fatalf("expected default record path %q, got %q", want, recorded.HAR.Path)
+	}
+
+	inputRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(inputRoot, "fixtures"), 0755); err != nil {
+		t.Fatalf("create fixtures dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inputRoot, "fixtures", "api.har"), []byte(`{"log":{"entries":[]}}`), 0644); err != nil {
+		t.Fatalf("write har: %v", err)
+	}
+	replayed, err := resolveFlowBrowserHAR(
+		FlowBrowserConfig{HAR: &FlowBrowserHAR{Mode: FlowBrowserHARReplay, Path: "fixtures/api.har"}},
+		FlowRunOptions{ArtifactRoot: artifactRoot, RunID: "run-2", Security: &FlowSecurityPolicy{FileInputRoot: inputRoot}},
+	)
+	if err != nil {
+		t.Fatalf("resolveFlowBrowserHAR returned error: %v", err)
+	}
+	input, _ := prepareRuntimeFileRoot(inputRoot)
+	if want := filepath.Join(input, "fixtures", "api.har"); replayed.HAR.Path != want {
+		t.Fatalf("expected replay path under the input root %q, got %q", want, replayed.HAR.Path)
+	}
+
+	if _, err := resolveFlowBrowserHAR(
+		FlowBrowserConfig{HAR: &FlowBrowserHAR{Mode: FlowBrowserHARReplay, Path: "../outside.har"}},
+		FlowRunOptions{Security: &FlowSecurityPolicy{FileInputRoot: inputRoot}},
+	); err == nil {
+		t.Fatalf("expected replay path outside the input root to fail")
+	}
+}
+
+func TestValidateFlowSecurityRequiresBrowserStateForHAR(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "har_policy",
+		Browser: &FlowBrowserConfig{
+			HAR: &FlowBrowserHAR{Mode: FlowBrowserHARReplay, Path: "fixtures/api.har"},
+		},
+		Steps: []FlowStep{{Action: "navigate", URL: "https://example.com"}},
+	}
+	if err := ValidateFlow(flow); err != nil {
+		t.Fatalf("validate flow: %v", err)
+	}
+	if err := ValidateFlowSecurity(flow, FlowSecurityPolicy{}); err == nil || !strings.Contains(err.Error(), "allow_browser_state") {
+		t.Fatalf("expected browser state grant error, got %v", err)
+	}
+	if err := ValidateFlowSecurity(flow, FlowSecurityPolicy{AllowBrowserState: true, FileInputRoot: t.TempDir()}); err != nil {
+		t.Fatalf("expected granted HAR replay to pass, got %v", err)
+	}
+	flow.Browser.HAR.Path = "../escape.har"
+	if err := ValidateFlowSecurity(flow, FlowSecurityPolicy{AllowBrowserState: true, FileInputRoot: t.TempDir()}); err == nil {
+		t.Fatalf("expected HAR path outside the input root to fail")
+	}
+}
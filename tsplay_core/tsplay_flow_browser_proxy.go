@@ -0,0 +1,258 @@
+package tsplay_core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const proxyDefaultConnection = "default"
+
+// FlowBrowserProxy routes browser traffic, http_request and download_url
+// through an HTTP or SOCKS proxy. Without a server the whole proxy is read
+// from TSPLAY_PROXY_<CONNECTION>_SERVER/BYPASS/USERNAME/PASSWORD, so
+// credentials can stay out of the flow file. A flow that sets its own server
+// never receives the env credentials; a saved session's server was chosen by
+// whoever saved it and still does.
+type FlowBrowserProxy struct {
+	Connection string `json:"connection,omitempty" yaml:"connection,omitempty"`
+	Server     string `json:"server,omitempty" yaml:"server,omitempty"`
+	// Bypass is a comma-separated list of hosts that skip the proxy, such as
+	// "localhost,.internal.example.com".
+	Bypass   string `json:"bypass,omitempty" yaml:"bypass,omitempty"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	savedSession bool
+}
+
+func validateFlowBrowserProxy(browser FlowBrowserConfig) error {
+	proxy := browser.Proxy
+	if proxy == nil {
+		return nil
+	}
+	if proxy.Connection != "" && strings.TrimSpace(proxy.Connection) == "" {
+		return fmt.Errorf("browser.proxy.connection cannot be blank")
+	}
+	if server := strings.TrimSpace(proxy.Server); server != "" {
+		if _, err := parseFlowProxyServer(server); err != nil {
+			return fmt.Errorf("browser.proxy.server %w", err)
+		}
+	}
+	if browser.connectsOverCDP() {
+		return fmt.Errorf("browser.cdp_launch/cdp_endpoint/cdp_port cannot be combined with browser.proxy")
+	}
+	return nil
+}
+
+// resolve returns the proxy the run uses. The server and its credentials come
+// from the same place: the flow when it sets server, the connection's env vars
+// otherwise. A saved session keeps its server and reads the rest from env.
+func (proxy *FlowBrowserProxy) resolve() (*FlowBrowserProxy, error) {
+	if proxy == nil {
+		return nil, nil
+	}
+	name := strings.TrimSpace(proxy.Connection)
+	if name == "" {
+		name = proxyDefaultConnection
+	}
+	resolved := &FlowBrowserProxy{
+		Connection: name,
+		Server:     strings.TrimSpace(proxy.Server),
+		Bypass:     strings.TrimSpace(proxy.Bypass),
+		Username:   strings.TrimSpace(proxy.Username),
+		Password:   proxy.Password,
+	}
+	if resolved.Server == "" || proxy.savedSession {
+		resolved.Server = firstNonEmpty(resolved.Server, lookupProxyConfigValue(name, "SERVER"))
+		resolved.Bypass = firstNonEmpty(resolved.Bypass, lookupProxyConfigValue(name, "BYPASS"))
+		resolved.Username = firstNonEmpty(resolved.Username, lookupProxyConfigValue(name, "USERNAME"))
+		resolved.Password = firstNonEmpty(resolved.Password, lookupProxyConfigValue(name, "PASSWORD"))
+	}
+	if resolved.Server == "" {
+		return nil, fmt.Errorf("proxy connection %q is not configured; set browser.proxy.server or %s", name, strings.Join(proxyConfigEnvKeys(name, "SERVER"), " or "))
+	}
+	if _, err := parseFlowProxyServer(resolved.Server); err != nil {
+		return nil, fmt.Errorf("proxy connection %q server %w", name, err)
+	}
+	return resolved, nil
+}
+
+// maskError hides the proxy password in an error from the browser or the
+// HTTP transport.
+func (proxy *FlowBrowserProxy) maskError(err error) error {
+	if proxy == nil || proxy.Password == "" {
+		return err
+	}
+	secrets := newFlowSecretRegistry()
+	secrets.add(proxy.Password)
+	return secrets.maskError(err)
+}
+
+func (proxy *FlowBrowserProxy) playwrightProxy() *playwright.Proxy {
+	if proxy == nil {
+		return nil
+	}
+	result := &playwright.Proxy{Server: proxy.Server}
+	if proxy.Bypass != "" {
+		result.Bypass = playwright.String(proxy.Bypass)
+	}
+	if proxy.Username != "" {
+		result.Username = playwright.String(proxy.Username)
+		result.Password = playwright.String(proxy.Password)
+	}
+	return result
+}
+
+// httpTransport returns a transport that sends requests through the proxy,
+// or nil to use the default transport.
+func (proxy *FlowBrowserProxy) httpTransport() (*http.Transport, error) {
+	if proxy == nil {
+		return nil, nil
+	}
+	proxyURL, err := parseFlowProxyServer(proxy.Server)
+	if err != nil {
+		return nil, fmt.Errorf("proxy server %w", err)
+	}
+	if proxy.Username != "" {
+		proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+	}
+	bypass := splitFlowProxyBypass(proxy.Bypass)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(request *http.Request) (*url.URL, error) {
+		if flowProxyBypassed(request.URL.Hostname(), bypass) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+	return transport, nil
+}
+
+// flowProxyHTTPClient is the client http_request and download_url use in a
+// flow with browser.proxy.
+func flowProxyHTTPClient(ctx *FlowContext, client *http.Client) (*http.Client, error) {
+	if ctx == nil || ctx.Proxy == nil {
+		return client, nil
+	}
+	transport, err := ctx.Proxy.httpTransport()
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+func parseFlowProxyServer(server string) (*url.URL, error) {
+	server = strings.TrimSpace(server)
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	parsed, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("must be a proxy URL such as http://proxy.example.com:3128: %w", err)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("must use http, https or socks5, got %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("must include a host, got %q", server)
+	}
+	return parsed, nil
+}
+
+func splitFlowProxyBypass(bypass string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(bypass, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// flowProxyBypassed matches host against bypass entries the way Playwright
+// does: an exact host, or a ".example.com" / "*.example.com" suffix.
+func flowProxyBypassed(host string, bypass []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range bypass {
+		if entryHost, _, err := net.SplitHostPort(entry); err == nil {
+			entry = entryHost
+		}
+		switch {
+		case entry == "*":
+			return true
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, entry[1:]) {
+				return true
+			}
+		case strings.HasPrefix(entry, "."):
+			if host == entry[1:] || strings.HasSuffix(host, entry) {
+				return true
+			}
+		case host == entry:
+			return true
+		}
+	}
+	return false
+}
+
+// flowBrowserProxyView hides credentials. The username is only shown to the
+// session owner; the password never is.
+func flowBrowserProxyView(proxy *FlowBrowserProxy, includeSensitive bool) *FlowBrowserProxy {
+	if proxy == nil {
+		return nil
+	}
+	view := *proxy
+	if view.Username != "" && !includeSensitive {
+		view.Username = flowSecretMask
+	}
+	if view.Password != "" {
+		view.Password = flowSecretMask
+	}
+	return &view
+}
+
+func lookupProxyConfigValue(connection string, suffix string) string {
+	for _, key := range proxyConfigEnvKeys(connection, suffix) {
+		if value, ok := os.LookupEnv(key); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func proxyConfigEnvKeys(connection string, suffix string) []string {
+	normalized := normalizeDBConnectionName(connection)
+	if normalized == normalizeDBConnectionName(proxyDefaultConnection) {
+		return []string{
+			"TSPLAY_PROXY_DEFAULT_" + suffix,
+			"TSPLAY_PROXY_" + suffix,
+		}
+	}
+	return []string{"TSPLAY_PROXY_" + normalized + "_" + suffix}
+}
+
+// ParseFlowBrowserProxy decodes a proxy JSON object such as
+// {"server": "http://proxy:3128", "connection": "corp"}. Blank text returns
+// nil.
+func ParseFlowBrowserProxy(text string) (*FlowBrowserProxy, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.DisallowUnknownFields()
+	var proxy FlowBrowserProxy
+	if err := decoder.Decode(&proxy); err != nil {
+		return nil, fmt.Errorf("parse proxy: %w", err)
+	}
+	return &proxy, nil
+}
@@ -0,0 +1,110 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const (
+	FlowBrowserTraceOff             = "off"
+	FlowBrowserTraceOn              = "on"
+	FlowBrowserTraceRetainOnFailure = "retain-on-failure"
+
+	flowBrowserTraceFileName = "trace.zip"
+)
+
+// flowBrowserTracer records a Playwright trace for one run. Every flow step
+// opens a trace group named after its step path, so the trace viewer shows
+// the Playwright calls of step 3.2 nested under step 3.
+type flowBrowserTracer struct {
+	tracing playwright.Tracing
+	mode    string
+}
+
+func normalizeFlowBrowserTraceMode(mode string) string {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		return FlowBrowserTraceOff
+	}
+	return mode
+}
+
+func validateFlowBrowserTraceMode(mode string) error {
+	switch normalizeFlowBrowserTraceMode(mode) {
+	case FlowBrowserTraceOff, FlowBrowserTraceOn, FlowBrowserTraceRetainOnFailure:
+		return nil
+	default:
+		return fmt.Errorf("browser.trace must be %q, %q or %q, got %q", FlowBrowserTraceOff, FlowBrowserTraceOn, FlowBrowserTraceRetainOnFailure, mode)
+	}
+}
+
+// startFlowBrowserTrace starts tracing on the run's browser context. It
+// returns nil when tracing is off.
+func startFlowBrowserTrace(context playwright.BrowserContext, config FlowBrowserConfig, title string) (*flowBrowserTracer, error) {
+	mode := normalizeFlowBrowserTraceMode(config.Trace)
+	if mode == FlowBrowserTraceOff || context == nil {
+		return nil, nil
+	}
+	tracing := context.Tracing()
+	if err := tracing.Start(playwright.TracingStartOptions{
+		Title:       playwright.String(title),
+		Screenshots: playwright.Bool(true),
+		Snapshots:   playwright.Bool(true),
+	}); err != nil {
+		return nil, fmt.Errorf("start browser trace: %w", err)
+	}
+	return &flowBrowserTracer{tracing: tracing, mode: mode}, nil
+}
+
+// group opens the trace group of one step and returns the function that
+// closes it. Grouping is cosmetic, so errors are ignored.
+func (tracer *flowBrowserTracer) group(trace FlowStepTrace) func() {
+	if tracer == nil {
+		return func() {}
+	}
+	name := fmt.Sprintf("step %s %s", trace.Path, trace.Action)
+	if strings.TrimSpace(trace.Name) != "" {
+		name += ": " + trace.Name
+	}
+	if trace.Attempt > 0 {
+		name += fmt.Sprintf(" (attempt %d)", trace.Attempt)
+	}
+	if trace.Iteration > 0 {
+		name += fmt.Sprintf(" (iteration %d)", trace.Iteration)
+	}
+	if err := tracer.tracing.Group(name); err != nil {
+		return func() {}
+	}
+	return func() {
+		_ = tracer.tracing.GroupEnd()
+	}
+}
+
+// stop ends tracing and writes <runRoot>/trace.zip, unless the mode is
+// retain-on-failure and the run succeeded, in which case the trace is
+// discarded. It returns the saved path, if any.
+func (tracer *flowBrowserTracer) stop(runRoot string, failed bool) (string, error) {
+	if tracer == nil {
+		return "", nil
+	}
+	runRoot = strings.TrimSpace(runRoot)
+	if runRoot == "" || (tracer.mode == FlowBrowserTraceRetainOnFailure && !failed) {
+		if err := tracer.tracing.Stop(); err != nil {
+			return "", fmt.Errorf("stop browser trace: %w", err)
+		}
+		return "", nil
+	}
+	if err := os.MkdirAll(runRoot, 0755); err != nil {
+		_ = tracer.tracing.Stop()
+		return "", fmt.Errorf("create browser trace directory: %w", err)
+	}
+	path := filepath.Join(runRoot, flowBrowserTraceFileName)
+	if err := tracer.tracing.Stop(path); err != nil {
+		return "", fmt.Errorf("save browser trace %q: %w", path, err)
+	}
+	return path, nil
+}
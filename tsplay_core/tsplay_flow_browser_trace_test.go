@@ -0,0 +1,67 @@
+package tsplay_core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFlowBrowserTraceMode(t *testing.T) {
+	for _, mode := range []string{"", "off", "on", "retain-on-failure", " ON "} {
+		if err := validateFlowBrowserConfig(&FlowBrowserConfig{Trace: mode}); err != nil {
+			t.Fatalf("browser.trace %q returned error: %v", mode, err)
+		}
+	}
+	err := validateFlowBrowserConfig(&FlowBrowserConfig{Trace: "always"})
+	if err == nil || !strings.Contains(err.Error(), "browser.trace") {
+		t.Fatalf("expected browser.trace error, got %v", err)
+	}
+
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "trace_override",
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "done", Value: "yes"}},
+	}
+	config, err := mergeFlowBrowserConfig(flow, FlowRunOptions{BrowserTrace: "retain-on-failure"})
+	if err != nil || config.Trace != FlowBrowserTraceRetainOnFailure {
+		t.Fatalf("expected run option to set browser.trace, got %#v, %v", config, err)
+	}
+	if _, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: t.TempDir(), BrowserTrace: "sometimes"}); err == nil {
+		t.Fatalf("expected invalid trace option to fail")
+	}
+}
+
+func TestFlowBrowserTracerDisabledIsNoop(t *testing.T) {
+	tracer, err := startFlowBrowserTrace(nil, FlowBrowserConfig{Trace: FlowBrowserTraceOn}, "no_browser")
+	if err != nil || tracer != nil {
+		t.Fatalf("expected no tracer without a browser context, got %#v, %v", tracer, err)
+	}
+	tracer.group(FlowStepTrace{Path: "1", Action: "click"})()
+	if path, err := tracer.stop(t.TempDir(), true); path != "" || err != nil {
+		t.Fatalf("expected nil tracer stop to do nothing, got %q, %v", path, err)
+	}
+}
+
+func TestBuildFlowRepairContextLinksBrowserTrace(t *testing.T) {
+	context, err := BuildFlowRepairContext(FlowRepairContextOptions{
+		Flow: &Flow{
+			SchemaVersion: CurrentFlowSchemaVersion,
+			Name:          "traced_failure",
+			Steps:         []FlowStep{{Action: "click", Selector: "#save"}},
+		},
+		Result: &FlowResult{
+			BrowserTrace: "/tmp/artifacts/run-1/trace.zip",
+			Trace: []FlowStepTrace{
+				{Index: 1, Path: "1", Action: "click", Status: "error", Error: "timeout"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BuildFlowRepairContext returned error: %v", err)
+	}
+	if context.Artifacts == nil || context.Artifacts.TracePath != "/tmp/artifacts/run-1/trace.zip" {
+		t.Fatalf("expected trace path in repair artifacts, got %#v", context.Artifacts)
+	}
+	if len(context.Artifacts.ArtifactSummary) != 1 || !strings.Contains(context.Artifacts.ArtifactSummary[0], `group "step 1"`) {
+		t.Fatalf("unexpected artifact summary %#v", context.Artifacts.ArtifactSummary)
+	}
+}
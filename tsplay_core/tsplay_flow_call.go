@@ -0,0 +1,559 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+type flowCallTarget struct {
+	key        string
+	label      string
+	vars       map[string]any
+	parameters map[string]FlowParameter
+	fragments  map[string]FlowFragment
+	steps      []FlowStep
+}
+
+func validateCallFlowFlowStep(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if len(step.Args) > 0 {
+		return fmt.Errorf("step %s action %q does not support args; use fragment or file_path, inputs, and outputs", stepPath, step.Action)
+	}
+	present := step.presentNamedParams()
+	allowed := map[string]bool{"fragment": true, "file_path": true, "inputs": true, "outputs": true}
+	for name, value := range present {
+		if !allowed[name] {
+			return fmt.Errorf("step %s action %q does not accept parameter %q", stepPath, step.Action, name)
+		}
+		if name == "inputs" {
+			if err := validateFlowReferences(stepPath, step.Action, name, value, knownVars); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(flowReferences(value)) > 0 {
+			return fmt.Errorf("step %s action %q parameter %q must be static so the called flow can be validated before the run", stepPath, step.Action, name)
+		}
+		if err := validateFlowParamType(name, value, knownVars); err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, name, err)
+		}
+	}
+
+	fragment, hasFragment := present["fragment"]
+	filePath, hasFilePath := present["file_path"]
+	if !hasFragment && !hasFilePath {
+		return fmt.Errorf("step %s action %q requires fragment or file_path", stepPath, step.Action)
+	}
+	if hasFragment && hasFilePath {
+		return fmt.Errorf("step %s action %q accepts either fragment or file_path, not both", stepPath, step.Action)
+	}
+	if hasFragment && strings.TrimSpace(fmt.Sprint(fragment)) == "" {
+		return fmt.Errorf("step %s action %q fragment cannot be blank", stepPath, step.Action)
+	}
+	if hasFilePath && strings.TrimSpace(fmt.Sprint(filePath)) == "" {
+		return fmt.Errorf("step %s action %q file_path cannot be blank", stepPath, step.Action)
+	}
+
+	if value, ok := present["inputs"]; ok {
+		inputs, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("step %s action %q parameter %q must be an object", stepPath, step.Action, "inputs")
+		}
+		for name := range inputs {
+			if !flowIdentifierPattern.MatchString(name) {
+				return fmt.Errorf("step %s action %q input %q is not a valid variable name", stepPath, step.Action, name)
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range callFlowOutputNames(step) {
+		if !flowIdentifierPattern.MatchString(name) {
+			return fmt.Errorf("step %s action %q output %q is not a valid variable name", stepPath, step.Action, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("step %s action %q output %q is listed more than once", stepPath, step.Action, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// validateFlowFragments checks fragment definitions in their own variable
+// scope, then checks every fragment call against the declared inputs and
+// outputs and rejects fragments that call themselves directly or indirectly.
+func validateFlowFragments(flow *Flow) error {
+	for _, name := range sortedFlowFragmentNames(flow) {
+		fragment := flow.Fragments[name]
+		fragmentPath := flowFragmentPath(name)
+		if !flowIdentifierPattern.MatchString(name) {
+			return fmt.Errorf("fragment %q is not a valid fragment name", name)
+		}
+		if len(fragment.Steps) == 0 {
+			return fmt.Errorf("%s must contain at least one step", fragmentPath)
+		}
+		knownVars := map[string]any{}
+		for _, input := range fragment.Inputs {
+			if !flowIdentifierPattern.MatchString(input) {
+				return fmt.Errorf("%s input %q is not a valid variable name", fragmentPath, input)
+			}
+			if _, exists := knownVars[input]; exists {
+				return fmt.Errorf("%s input %q is declared more than once", fragmentPath, input)
+			}
+			knownVars[input] = nil
+		}
+		if err := validateFlowStepSequence(fragment.Steps, knownVars, fragmentPath); err != nil {
+			return err
+		}
+		declaredOutputs := map[string]bool{}
+		for _, output := range fragment.Outputs {
+			if !flowIdentifierPattern.MatchString(output) {
+				return fmt.Errorf("%s output %q is not a valid variable name", fragmentPath, output)
+			}
+			if declaredOutputs[output] {
+				return fmt.Errorf("%s output %q is declared more than once", fragmentPath, output)
+			}
+			declaredOutputs[output] = true
+			if _, ok := knownVars[output]; !ok {
+				return fmt.Errorf("%s output %q is never set by the fragment steps", fragmentPath, output)
+			}
+		}
+	}
+
+	validateCalls := func(steps []FlowStep, parentPath string) error {
+		return forEachFlowStep(steps, parentPath, func(step FlowStep, stepPath string) error {
+			name, ok := staticCallFlowFragment(step)
+			if !ok {
+				return nil
+			}
+			fragment, exists := flow.Fragments[name]
+			if !exists {
+				return fmt.Errorf("step %s action %q references unknown fragment %q", stepPath, step.Action, name)
+			}
+			return validateCallFlowContract(stepPath, step, fmt.Sprintf("fragment %q", name), fragment.Inputs, fragment.Inputs, fragment.Outputs)
+		})
+	}
+	if err := validateCalls(flow.Steps, ""); err != nil {
+		return err
+	}
+	for _, name := range sortedFlowFragmentNames(flow) {
+		if err := validateCalls(flow.Fragments[name].Steps, flowFragmentPath(name)); err != nil {
+			return err
+		}
+	}
+
+	visiting := map[string]bool{}
+	visited := map[string]bool{}
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		chain = append(chain, name)
+		if visiting[name] {
+			return fmt.Errorf("fragment %q calls itself recursively: %s", name, strings.Join(chain, " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		visiting[name] = true
+		for _, callee := range flowFragmentCallees(flow.Fragments[name].Steps) {
+			if err := visit(callee, chain); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		return nil
+	}
+	for _, name := range sortedFlowFragmentNames(flow) {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCallFlowContract checks the inputs and outputs of one call_flow step
+// against what the called fragment or flow declares. A nil declaredOutputs
+// list means any variable of the called flow can be returned.
+func validateCallFlowContract(stepPath string, step FlowStep, label string, declaredInputs []string, requiredInputs []string, declaredOutputs []string) error {
+	inputs := callFlowStaticInputs(step)
+	declared := map[string]bool{}
+	for _, name := range declaredInputs {
+		declared[name] = true
+	}
+	for _, name := range sortedMapKeys(inputs) {
+		if !declared[name] {
+			return fmt.Errorf("step %s action %q input %q is not declared by %s", stepPath, step.Action, name, label)
+		}
+	}
+	for _, name := range requiredInputs {
+		if _, ok := inputs[name]; !ok {
+			return fmt.Errorf("step %s action %q requires input %q for %s", stepPath, step.Action, name, label)
+		}
+	}
+	if declaredOutputs == nil {
+		return nil
+	}
+	allowedOutputs := map[string]bool{}
+	for _, name := range declaredOutputs {
+		allowedOutputs[name] = true
+	}
+	for _, name := range callFlowOutputNames(step) {
+		if !allowedOutputs[name] {
+			return fmt.Errorf("step %s action %q output %q is not declared by %s", stepPath, step.Action, name, label)
+		}
+	}
+	return nil
+}
+
+// validateFlowCallFiles loads every flow file referenced by call_flow, checks
+// it against the caller's security policy, and follows nested includes so a
+// file that eventually includes itself is rejected before the run starts.
+func validateFlowCallFiles(flow *Flow, policy *FlowSecurityPolicy, stack []string) error {
+	return forEachFlowCallFile(flow, func(step FlowStep, stepPath string, filePath string) error {
+		called, resolvedPath, err := loadCallFlowFile(filePath, policy)
+		if err != nil {
+			return fmt.Errorf("step %s action %q %w", stepPath, step.Action, err)
+		}
+		if chain, recursive := flowCallChain(stack, resolvedPath); recursive {
+			return fmt.Errorf("step %s action %q includes %s recursively: %s", stepPath, step.Action, filePath, chain)
+		}
+		if err := validateCalledFlowFile(stepPath, step, called, filePath, policy); err != nil {
+			return err
+		}
+		return validateFlowCallFiles(called, policy, append(append([]string(nil), stack...), resolvedPath))
+	})
+}
+
+func validateCalledFlowFile(stepPath string, step FlowStep, called *Flow, filePath string, policy *FlowSecurityPolicy) error {
+	label := fmt.Sprintf("flow %s", filePath)
+	if err := ValidateFlow(called); err != nil {
+		return fmt.Errorf("step %s action %q %s is invalid: %w", stepPath, step.Action, label, err)
+	}
+	if called.Browser != nil {
+		return fmt.Errorf("step %s action %q %s cannot declare a browser block; configure the browser in the calling flow", stepPath, step.Action, label)
+	}
+	declaredInputs := sortedMapKeys(called.Vars)
+	requiredInputs := []string{}
+	for _, name := range sortedFlowParameterNames(called.Parameters) {
+		declaredInputs = append(declaredInputs, name)
+		if called.Parameters[name].Required {
+			requiredInputs = append(requiredInputs, name)
+		}
+	}
+	if err := validateCallFlowContract(stepPath, step, label, declaredInputs, requiredInputs, nil); err != nil {
+		return err
+	}
+	if policy == nil {
+		return nil
+	}
+	if err := validateFlowStepSequenceSecurity(called.Steps, *policy, ""); err != nil {
+		return fmt.Errorf("step %s action %q %s: %w", stepPath, step.Action, label, err)
+	}
+	for _, name := range sortedFlowFragmentNames(called) {
+		if err := validateFlowStepSequenceSecurity(called.Fragments[name].Steps, *policy, flowFragmentPath(name)); err != nil {
+			return fmt.Errorf("step %s action %q %s: %w", stepPath, step.Action, label, err)
+		}
+	}
+	if err := validateFlowSecretAccess(called, *policy); err != nil {
+		return fmt.Errorf("step %s action %q %s: %w", stepPath, step.Action, label, err)
+	}
+	if err := validateFlowFileAccessRoots(called, *policy); err != nil {
+		return fmt.Errorf("step %s action %q %s: %w", stepPath, step.Action, label, err)
+	}
+	return nil
+}
+
+func runFlowCallFlowStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath string) (any, []FlowStepTrace, error) {
+	if ctx == nil {
+		return nil, nil, fmt.Errorf("call_flow requires flow context")
+	}
+	target, err := resolveFlowCallTarget(ctx, step, stepPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chain, recursive := flowCallChain(ctx.CallStack, target.key); recursive {
+		return nil, nil, fmt.Errorf("call_flow %s is called recursively: %s", target.label, chain)
+	}
+
+	inputs := map[string]any{}
+	if value, ok := step.param("inputs"); ok {
+		resolved, err := resolveValue(value, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		typed, ok := resolved.(map[string]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("call_flow inputs must be an object")
+		}
+		inputs = typed
+	}
+
+	child := *ctx
+	child.Vars = map[string]any{}
+	for key, value := range target.vars {
+		child.Vars[key] = value
+	}
+	params, err := resolveFlowParameterValues(target.parameters, inputs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("call_flow %s %w", target.label, err)
+	}
+	for key, value := range inputs {
+		child.Vars[key] = value
+	}
+	for key, value := range params {
+		child.Vars[key] = value
+	}
+	ctx.Secrets.add(flowSecretParameterValues(target.parameters, params)...)
+	if err := loadFlowSecrets(ctx.Secrets, flowSecretReferences(target.vars, target.parameters, target.steps, target.fragments)); err != nil {
+		return nil, nil, fmt.Errorf("call_flow %s %w", target.label, err)
+	}
+	child.Fragments = target.fragments
+	child.Checkpoint = nil
+	child.CallStack = append(append([]string(nil), ctx.CallStack...), target.key)
+
+	swapFlowVarGlobals(L, ctx.Vars, child.Vars)
+	restoreFlowContext := setFlowContextState(L, &child)
+	children, err := runFlowStepSequence(L, &child, target.steps, stepPath, 0, 0)
+	restoreFlowContext()
+	swapFlowVarGlobals(L, child.Vars, ctx.Vars)
+	if err != nil {
+		return nil, children, err
+	}
+
+	outputs := map[string]any{}
+	for _, name := range callFlowOutputNames(step) {
+		value, ok := child.Vars[name]
+		if !ok {
+			return nil, children, fmt.Errorf("call_flow %s did not set output %q", target.label, name)
+		}
+		outputs[name] = value
+	}
+	for _, name := range sortedMapKeys(outputs) {
+		setFlowVar(L, ctx, name, outputs[name])
+	}
+	return outputs, children, nil
+}
+
+func resolveFlowCallTarget(ctx *FlowContext, step FlowStep, stepPath string) (flowCallTarget, error) {
+	if name, ok := staticCallFlowFragment(step); ok {
+		fragment, exists := ctx.Fragments[name]
+		if !exists {
+			return flowCallTarget{}, fmt.Errorf("call_flow references unknown fragment %q", name)
+		}
+		return flowCallTarget{
+			key:       "fragment:" + name,
+			label:     fmt.Sprintf("fragment %q", name),
+			fragments: ctx.Fragments,
+			steps:     fragment.Steps,
+		}, nil
+	}
+
+	filePath, err := flowStepStringParam(ctx, step, "file_path")
+	if err != nil {
+		return flowCallTarget{}, err
+	}
+	called, resolvedPath, err := loadCallFlowFile(filePath, ctx.Security)
+	if err != nil {
+		return flowCallTarget{}, err
+	}
+	if err := validateCalledFlowFile(stepPath, step, called, filePath, ctx.Security); err != nil {
+		return flowCallTarget{}, err
+	}
+	return flowCallTarget{
+		key:        "file:" + resolvedPath,
+		label:      fmt.Sprintf("flow %s", filePath),
+		vars:       called.Vars,
+		parameters: called.Parameters,
+		fragments:  called.Fragments,
+		steps:      called.Steps,
+	}, nil
+}
+
+func loadCallFlowFile(filePath string, policy *FlowSecurityPolicy) (*Flow, string, error) {
+	resolvedPath := filePath
+	if policy != nil {
+		resolved, err := resolveRuntimeFilePath(filePath, flowFileInputPath, *policy)
+		if err != nil {
+			return nil, "", err
+		}
+		resolvedPath = resolved
+	}
+	absPath, err := filepath.Abs(resolvedPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("resolve flow %s: %w", filePath, err)
+	}
+	if realPath, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = realPath
+	}
+	called, err := LoadFlowFile(absPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return called, absPath, nil
+}
+
+func analyzeFlowCallFilesPlaywrightUsage(flow *Flow, policy *FlowSecurityPolicy, stack []string) PlaywrightUsage {
+	usage := PlaywrightUsage{}
+	_ = forEachFlowCallFile(flow, func(_ FlowStep, _ string, filePath string) error {
+		called, resolvedPath, err := loadCallFlowFile(filePath, policy)
+		if err != nil {
+			return nil
+		}
+		if _, recursive := flowCallChain(stack, resolvedPath); recursive {
+			return nil
+		}
+		usage.merge(AnalyzeFlowPlaywrightUsage(called))
+		usage.merge(analyzeFlowCallFilesPlaywrightUsage(called, policy, append(append([]string(nil), stack...), resolvedPath)))
+		return nil
+	})
+	usage.normalize()
+	return usage
+}
+
+func forEachFlowCallFile(flow *Flow, visit func(step FlowStep, stepPath string, filePath string) error) error {
+	if flow == nil {
+		return nil
+	}
+	visitSteps := func(steps []FlowStep, parentPath string) error {
+		return forEachFlowStep(steps, parentPath, func(step FlowStep, stepPath string) error {
+			if step.Action != "call_flow" {
+				return nil
+			}
+			value, ok := step.param("file_path")
+			if !ok || flowFilePathValueIsDynamic(value) {
+				return nil
+			}
+			filePath := strings.TrimSpace(fmt.Sprint(value))
+			if filePath == "" {
+				return nil
+			}
+			return visit(step, stepPath, filePath)
+		})
+	}
+	if err := visitSteps(flow.Steps, ""); err != nil {
+		return err
+	}
+	for _, name := range sortedFlowFragmentNames(flow) {
+		if err := visitSteps(flow.Fragments[name].Steps, flowFragmentPath(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachFlowStep(steps []FlowStep, parentPath string, visit func(step FlowStep, stepPath string) error) error {
+	for i, step := range steps {
+		stepPath := flowStepPath(parentPath, i+1)
+		if err := visit(step, stepPath); err != nil {
+			return err
+		}
+		if err := forEachNestedFlowStepSequence(step, stepPath, func(nestedSteps []FlowStep, nestedPath string) error {
+			return forEachFlowStep(nestedSteps, nestedPath, visit)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func flowFragmentCallees(steps []FlowStep) []string {
+	callees := []string{}
+	seen := map[string]bool{}
+	_ = forEachFlowStep(steps, "", func(step FlowStep, _ string) error {
+		if name, ok := staticCallFlowFragment(step); ok && !seen[name] {
+			seen[name] = true
+			callees = append(callees, name)
+		}
+		return nil
+	})
+	sort.Strings(callees)
+	return callees
+}
+
+func staticCallFlowFragment(step FlowStep) (string, bool) {
+	if step.Action != "call_flow" {
+		return "", false
+	}
+	value, ok := step.param("fragment")
+	if !ok {
+		return "", false
+	}
+	name, ok := value.(string)
+	if !ok || strings.TrimSpace(name) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(name), true
+}
+
+func callFlowStaticInputs(step FlowStep) map[string]any {
+	value, ok := step.param("inputs")
+	if !ok {
+		return map[string]any{}
+	}
+	inputs, ok := value.(map[string]any)
+	if !ok {
+		return map[string]any{}
+	}
+	return inputs
+}
+
+func callFlowOutputNames(step FlowStep) []string {
+	value, ok := step.param("outputs")
+	if !ok {
+		return nil
+	}
+	switch typed := value.(type) {
+	case []string:
+		return typed
+	case []any:
+		names := make([]string, 0, len(typed))
+		for _, item := range typed {
+			names = append(names, fmt.Sprint(item))
+		}
+		return names
+	default:
+		return nil
+	}
+}
+
+func flowCallChain(stack []string, key string) (string, bool) {
+	for i, existing := range stack {
+		if existing == key {
+			chain := append(append([]string(nil), stack[i:]...), key)
+			return strings.Join(chain, " -> "), true
+		}
+	}
+	return "", false
+}
+
+// swapFlowVarGlobals hides one variable scope from Lua and exposes another, so
+// lua steps inside a called flow only see the called flow's variables.
+func swapFlowVarGlobals(L *lua.LState, from map[string]any, to map[string]any) {
+	if L == nil {
+		return
+	}
+	for key := range from {
+		L.SetGlobal(key, lua.LNil)
+	}
+	for key, value := range to {
+		L.SetGlobal(key, goValueToLua(L, value))
+	}
+}
+
+func sortedFlowFragmentNames(flow *Flow) []string {
+	if flow == nil || len(flow.Fragments) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(flow.Fragments))
+	for name := range flow.Fragments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func flowFragmentPath(name string) string {
+	return "fragments." + name
+}
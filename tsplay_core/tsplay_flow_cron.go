@@ -0,0 +1,181 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flowCronSchedule is a parsed five-field cron expression:
+// minute hour day-of-month month day-of-week.
+type flowCronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// Like Vixie cron, when both day fields are restricted a time matches if
+	// either of them matches.
+	dayOfMonthAny bool
+	dayOfWeekAny  bool
+}
+
+type flowCronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	flowCronMinuteField     = flowCronField{name: "minute", min: 0, max: 59}
+	flowCronHourField       = flowCronField{name: "hour", min: 0, max: 23}
+	flowCronDayOfMonthField = flowCronField{name: "day-of-month", min: 1, max: 31}
+	flowCronMonthField      = flowCronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	flowCronDayOfWeekField = flowCronField{name: "day-of-week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var flowCronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// flowCronSearchLimit bounds how far ahead next looks for a matching time, so
+// expressions such as "0 0 31 2 *" fail instead of looping forever.
+const flowCronSearchLimit = 5 * 366 * 24 * time.Hour
+
+func parseFlowCron(expr string) (*flowCronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := flowCronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields (minute hour day-of-month month day-of-week) or be one of @hourly, @daily, @weekly, @monthly, @yearly", expr)
+	}
+	schedule := &flowCronSchedule{
+		dayOfMonthAny: strings.HasPrefix(fields[2], "*"),
+		dayOfWeekAny:  strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	if schedule.minute, err = flowCronMinuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+	}
+	if schedule.hour, err = flowCronHourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+	}
+	if schedule.dayOfMonth, err = flowCronDayOfMonthField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+	}
+	if schedule.month, err = flowCronMonthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+	}
+	if schedule.dayOfWeek, err = flowCronDayOfWeekField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+	}
+	// 7 is an alias for Sunday.
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	return schedule, nil
+}
+
+func (field flowCronField) parse(text string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(text, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepText)
+			if err != nil || parsed < 1 {
+				return 0, fmt.Errorf("%s step %q must be a positive integer", field.name, stepText)
+			}
+			step = parsed
+		}
+		low, high := field.min, field.max
+		switch {
+		case rangeText == "*":
+		case strings.Contains(rangeText, "-"):
+			lowText, highText, _ := strings.Cut(rangeText, "-")
+			var err error
+			if low, err = field.value(lowText); err != nil {
+				return 0, err
+			}
+			if high, err = field.value(highText); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("%s range %q starts after it ends", field.name, rangeText)
+			}
+		default:
+			value, err := field.value(rangeText)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			if !hasStep {
+				high = value
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func (field flowCronField) value(text string) (int, error) {
+	if value, ok := field.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("%s value %q is not a number", field.name, text)
+	}
+	if value < field.min || value > field.max {
+		return 0, fmt.Errorf("%s value %d must be between %d and %d", field.name, value, field.min, field.max)
+	}
+	return value, nil
+}
+
+// next returns the first matching minute strictly after the given time, or the
+// zero time when the expression never matches.
+func (schedule *flowCronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(flowCronSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case schedule.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case schedule.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case schedule.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (schedule *flowCronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := schedule.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := schedule.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if schedule.dayOfMonthAny || schedule.dayOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
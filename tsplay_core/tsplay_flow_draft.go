@@ -46,6 +46,7 @@ type FlowDraftMatch struct {
 	Source   string `json:"source"`
 	Index    int    `json:"index,omitempty"`
 	Selector string `json:"selector,omitempty"`
+	Frame    string `json:"frame,omitempty"`
 	Tag      string `json:"tag,omitempty"`
 	Text     string `json:"text,omitempty"`
 	Reason   string `json:"reason,omitempty"`
@@ -398,8 +399,8 @@ func (b *flowDraftBuilder) addSearchSteps() bool {
 	}
 
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "wait for search input", Action: "wait_for_selector", Selector: selector, Timeout: 10000},
-		FlowStep{Name: "fill search query", Action: "type_text", Selector: selector, Text: fmt.Sprintf("{{%s}}", queryVar)},
+		FlowStep{Name: "wait for search input", Action: "wait_for_selector", Selector: selector, Frame: input.Frame, Timeout: 10000},
+		FlowStep{Name: "fill search query", Action: "type_text", Selector: selector, Frame: input.Frame, Text: fmt.Sprintf("{{%s}}", queryVar)},
 	)
 	b.draft.PlannedActions = append(b.draft.PlannedActions, "search")
 	b.noteElementMatch("search_input", *input, selector, reason)
@@ -422,7 +423,7 @@ func (b *flowDraftBuilder) addSearchSteps() bool {
 		return true
 	}
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "submit search", Action: "click", Selector: buttonSelector},
+		FlowStep{Name: "submit search", Action: "click", Selector: buttonSelector, Frame: button.Frame},
 		FlowStep{Name: "wait for search result update", Action: "wait_for_network_idle"},
 	)
 	b.noteElementMatch("search_submit", *button, buttonSelector, buttonReason)
@@ -451,8 +452,8 @@ func (b *flowDraftBuilder) addExportSteps() bool {
 		return false
 	}
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "wait for export control", Action: "wait_for_selector", Selector: selector, Timeout: 10000},
-		FlowStep{Name: "click export", Action: "click", Selector: selector},
+		FlowStep{Name: "wait for export control", Action: "wait_for_selector", Selector: selector, Frame: button.Frame, Timeout: 10000},
+		FlowStep{Name: "click export", Action: "click", Selector: selector, Frame: button.Frame},
 	)
 	b.draft.PlannedActions = append(b.draft.PlannedActions, "export")
 	b.noteElementMatch("export", *button, selector, reason)
@@ -483,8 +484,8 @@ func (b *flowDraftBuilder) addUploadSteps() bool {
 	b.flow.Vars["upload_file_path"] = filePath
 	b.draft.SuggestedVars["upload_file_path"] = filePath
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "wait for upload input", Action: "wait_for_selector", Selector: selector, Timeout: 10000},
-		FlowStep{Name: "choose upload file", Action: "upload_file", Selector: selector, FilePath: "{{upload_file_path}}"},
+		FlowStep{Name: "wait for upload input", Action: "wait_for_selector", Selector: selector, Frame: fileInput.Frame, Timeout: 10000},
+		FlowStep{Name: "choose upload file", Action: "upload_file", Selector: selector, Frame: fileInput.Frame, FilePath: "{{upload_file_path}}"},
 	)
 	b.draft.PlannedActions = append(b.draft.PlannedActions, "upload")
 	b.noteElementMatch("upload_input", *fileInput, selector, reason)
@@ -508,7 +509,7 @@ func (b *flowDraftBuilder) addUploadSteps() bool {
 		return true
 	}
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "submit upload", Action: "click", Selector: buttonSelector},
+		FlowStep{Name: "submit upload", Action: "click", Selector: buttonSelector, Frame: button.Frame},
 		FlowStep{Name: "wait for upload request", Action: "wait_for_network_idle"},
 	)
 	b.noteElementMatch("upload_submit", *button, buttonSelector, buttonReason)
@@ -564,8 +565,8 @@ func (b *flowDraftBuilder) addLoginSteps() bool {
 		selector := bestObservedSelector(*usernameInput)
 		if selector != "" {
 			b.flow.Steps = append(b.flow.Steps,
-				FlowStep{Name: "wait for username input", Action: "wait_for_selector", Selector: selector, Timeout: 10000},
-				FlowStep{Name: "fill username", Action: "type_text", Selector: selector, Text: "{{username}}"},
+				FlowStep{Name: "wait for username input", Action: "wait_for_selector", Selector: selector, Frame: usernameInput.Frame, Timeout: 10000},
+				FlowStep{Name: "fill username", Action: "type_text", Selector: selector, Frame: usernameInput.Frame, Text: "{{username}}"},
 			)
 			b.noteElementMatch("login_username", *usernameInput, selector, usernameReason)
 		}
@@ -574,7 +575,7 @@ func (b *flowDraftBuilder) addLoginSteps() bool {
 		selector := bestObservedSelector(*passwordInput)
 		if selector != "" {
 			b.flow.Steps = append(b.flow.Steps,
-				FlowStep{Name: "fill password", Action: "type_text", Selector: selector, Text: "{{password}}"},
+				FlowStep{Name: "fill password", Action: "type_text", Selector: selector, Frame: passwordInput.Frame, Text: "{{password}}"},
 			)
 			b.noteElementMatch("login_password", *passwordInput, selector, passwordReason)
 		}
@@ -594,7 +595,7 @@ func (b *flowDraftBuilder) addLoginSteps() bool {
 		selector := bestObservedSelector(*button)
 		if selector != "" {
 			b.flow.Steps = append(b.flow.Steps,
-				FlowStep{Name: "submit login", Action: "click", Selector: selector},
+				FlowStep{Name: "submit login", Action: "click", Selector: selector, Frame: button.Frame},
 				FlowStep{Name: "wait for login result", Action: "wait_for_network_idle"},
 			)
 			b.noteElementMatch("login_submit", *button, selector, buttonReason)
@@ -628,8 +629,8 @@ func (b *flowDraftBuilder) addSelectSteps() bool {
 	}
 
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "wait for select control", Action: "wait_for_selector", Selector: selector, Timeout: 10000},
-		FlowStep{Name: "select option", Action: "select_option", Selector: selector, Value: "{{selected_value}}"},
+		FlowStep{Name: "wait for select control", Action: "wait_for_selector", Selector: selector, Frame: selectElement.Frame, Timeout: 10000},
+		FlowStep{Name: "select option", Action: "select_option", Selector: selector, Frame: selectElement.Frame, Value: "{{selected_value}}"},
 	)
 	b.draft.PlannedActions = append(b.draft.PlannedActions, "select")
 	b.noteElementMatch("select_control", *selectElement, selector, reason)
@@ -655,7 +656,7 @@ func (b *flowDraftBuilder) addSubmitSteps() bool {
 		return false
 	}
 	b.flow.Steps = append(b.flow.Steps,
-		FlowStep{Name: "click submit control", Action: "click", Selector: selector},
+		FlowStep{Name: "click submit control", Action: "click", Selector: selector, Frame: button.Frame},
 	)
 	b.draft.PlannedActions = append(b.draft.PlannedActions, "submit")
 	b.noteElementMatch("submit", *button, selector, reason)
@@ -1335,6 +1336,7 @@ func (b *flowDraftBuilder) noteElementMatch(purpose string, element PageObservat
 		Source:   "observed_element",
 		Index:    element.Index,
 		Selector: selector,
+		Frame:    element.Frame,
 		Tag:      element.Tag,
 		Text:     firstNonEmpty(element.Text, element.Label, element.Placeholder),
 		Reason:   reason,
@@ -1494,7 +1496,7 @@ func repairDraftFlowSelectors(flow *Flow, observation *PageObservation, matches
 		return nil
 	}
 	repairs := []FlowDraftSelectorRepair{}
-	repairDraftSelectorStepSequence(flow.Steps, "", targets, &repairs)
+	repairDraftSelectorStepSequence(flow.Steps, "", "", targets, &repairs)
 	return repairs
 }
 
@@ -1525,26 +1527,37 @@ func buildDraftSelectorRepairTargets(elements []PageObservationElement, matches
 			if selector == "" {
 				continue
 			}
-			targets[selector] = target
+			targets[draftSelectorRepairKey(element.Frame, selector)] = target
 		}
 	}
 	return targets
 }
 
-func repairDraftSelectorStepSequence(steps []FlowStep, parentPath string, targets map[string]draftSelectorRepairTarget, repairs *[]FlowDraftSelectorRepair) {
+// draftSelectorRepairKey keeps a selector inside an iframe from matching the
+// same selector on the main page.
+func draftSelectorRepairKey(frame string, selector string) string {
+	return strings.TrimSpace(frame) + "\x00" + selector
+}
+
+func repairDraftSelectorStepSequence(steps []FlowStep, parentPath string, frame string, targets map[string]draftSelectorRepairTarget, repairs *[]FlowDraftSelectorRepair) {
 	for index := range steps {
 		stepPath := flowStepPath(parentPath, index+1)
-		repairDraftSelectorStep(&steps[index], stepPath, targets, repairs)
+		repairDraftSelectorStep(&steps[index], stepPath, frame, targets, repairs)
 	}
 }
 
-func repairDraftSelectorStep(step *FlowStep, stepPath string, targets map[string]draftSelectorRepairTarget, repairs *[]FlowDraftSelectorRepair) {
+// repairDraftSelectorStep matches selectors within their frame: the step's
+// own frame, or the frame of the enclosing within_frame block.
+func repairDraftSelectorStep(step *FlowStep, stepPath string, frame string, targets map[string]draftSelectorRepairTarget, repairs *[]FlowDraftSelectorRepair) {
 	if step == nil {
 		return
 	}
+	if step.Frame != "" {
+		frame = step.Frame
+	}
 	current := strings.TrimSpace(step.Selector)
 	if current != "" && len(flowReferences(current)) == 0 {
-		if target, ok := targets[current]; ok && target.Preferred != "" && target.Preferred != current {
+		if target, ok := targets[draftSelectorRepairKey(frame, current)]; ok && target.Preferred != "" && target.Preferred != current {
 			*repairs = append(*repairs, FlowDraftSelectorRepair{
 				StepPath:      stepPath,
 				Action:        step.Action,
@@ -1559,23 +1572,23 @@ func repairDraftSelectorStep(step *FlowStep, stepPath string, targets map[string
 		}
 	}
 	if step.Condition != nil {
-		repairDraftSelectorStep(step.Condition, stepPath+".condition", targets, repairs)
+		repairDraftSelectorStep(step.Condition, stepPath+".condition", frame, targets, repairs)
 	}
 	if len(step.Steps) > 0 {
 		nestedPath := stepPath
 		if step.Action == "on_error" {
 			nestedPath = stepPath + ".try"
 		}
-		repairDraftSelectorStepSequence(step.Steps, nestedPath, targets, repairs)
+		repairDraftSelectorStepSequence(step.Steps, nestedPath, frame, targets, repairs)
 	}
 	if len(step.Then) > 0 {
-		repairDraftSelectorStepSequence(step.Then, stepPath+".then", targets, repairs)
+		repairDraftSelectorStepSequence(step.Then, stepPath+".then", frame, targets, repairs)
 	}
 	if len(step.Else) > 0 {
-		repairDraftSelectorStepSequence(step.Else, stepPath+".else", targets, repairs)
+		repairDraftSelectorStepSequence(step.Else, stepPath+".else", frame, targets, repairs)
 	}
 	if len(step.OnError) > 0 {
-		repairDraftSelectorStepSequence(step.OnError, stepPath+".on_error", targets, repairs)
+		repairDraftSelectorStepSequence(step.OnError, stepPath+".on_error", frame, targets, repairs)
 	}
 }
 
@@ -0,0 +1,1292 @@
+package tsplay_core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Flow expressions are the small, side-effect-free language accepted inside
+// {{ }} placeholders, set_var values, and expr condition steps. Plain variable
+// paths such as {{ row.name }} keep resolving through the JSONPath resolver;
+// anything else is parsed and evaluated here without touching Lua.
+
+type flowExprNode interface{}
+
+type flowExprLiteral struct {
+	value any
+}
+
+type flowExprVar struct {
+	name string
+}
+
+type flowExprField struct {
+	target flowExprNode
+	name   string
+}
+
+type flowExprIndex struct {
+	target flowExprNode
+	index  flowExprNode
+}
+
+type flowExprUnary struct {
+	op      string
+	operand flowExprNode
+}
+
+type flowExprBinary struct {
+	op    string
+	left  flowExprNode
+	right flowExprNode
+}
+
+type flowExprCall struct {
+	name string
+	args []flowExprNode
+}
+
+type flowExprTokenKind int
+
+const (
+	flowExprTokenEOF flowExprTokenKind = iota
+	flowExprTokenNumber
+	flowExprTokenString
+	flowExprTokenIdent
+	flowExprTokenField
+	flowExprTokenOp
+)
+
+type flowExprToken struct {
+	kind  flowExprTokenKind
+	text  string
+	value any
+	pos   int
+}
+
+var flowExprOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","}
+
+func tokenizeFlowExpression(source string) ([]flowExprToken, error) {
+	tokens := []flowExprToken{}
+	for i := 0; i < len(source); {
+		r, size := utf8.DecodeRuneInString(source[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case len(tokens) > 0 && tokens[len(tokens)-1].kind == flowExprTokenOp && tokens[len(tokens)-1].text == ".":
+			// Field names after a dot follow the JSONPath rules used by plain
+			// placeholders, so keys such as content-type or 姓名 keep working.
+			start := i
+			for i < len(source) {
+				r, size := utf8.DecodeRuneInString(source[i:])
+				if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-') {
+					break
+				}
+				i += size
+			}
+			if start == i {
+				return nil, fmt.Errorf("expected field name at offset %d", start)
+			}
+			tokens = append(tokens, flowExprToken{kind: flowExprTokenField, text: source[start:i], pos: start})
+		case r >= '0' && r <= '9':
+			start := i
+			isFloat := false
+			for i < len(source) && (source[i] >= '0' && source[i] <= '9' || source[i] == '.' && !isFloat && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9') {
+				if source[i] == '.' {
+					isFloat = true
+				}
+				i++
+			}
+			text := source[start:i]
+			if isFloat {
+				value, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %q", text)
+				}
+				tokens = append(tokens, flowExprToken{kind: flowExprTokenNumber, text: text, value: value, pos: start})
+				continue
+			}
+			value, err := strconv.Atoi(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", text)
+			}
+			tokens = append(tokens, flowExprToken{kind: flowExprTokenNumber, text: text, value: value, pos: start})
+		case r == '\'' || r == '"':
+			start := i
+			quote := source[i]
+			i++
+			var builder strings.Builder
+			closed := false
+			for i < len(source) {
+				c := source[i]
+				if c == quote {
+					i++
+					closed = true
+					break
+				}
+				if c == '\\' && i+1 < len(source) {
+					i++
+					switch source[i] {
+					case 'n':
+						builder.WriteByte('\n')
+					case 't':
+						builder.WriteByte('\t')
+					default:
+						builder.WriteByte(source[i])
+					}
+					i++
+					continue
+				}
+				builder.WriteByte(c)
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string starting at offset %d", start)
+			}
+			tokens = append(tokens, flowExprToken{kind: flowExprTokenString, text: source[start:i], value: builder.String(), pos: start})
+		case r == '_' || r < utf8.RuneSelf && unicode.IsLetter(r):
+			start := i
+			for i < len(source) && (source[i] == '_' || source[i] >= 'a' && source[i] <= 'z' || source[i] >= 'A' && source[i] <= 'Z' || source[i] >= '0' && source[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, flowExprToken{kind: flowExprTokenIdent, text: source[start:i], pos: start})
+		default:
+			matched := ""
+			for _, op := range flowExprOperators {
+				if strings.HasPrefix(source[i:], op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+			}
+			tokens = append(tokens, flowExprToken{kind: flowExprTokenOp, text: matched, pos: i})
+			i += len(matched)
+		}
+	}
+	return append(tokens, flowExprToken{kind: flowExprTokenEOF, pos: len(source)}), nil
+}
+
+type flowExprParser struct {
+	tokens []flowExprToken
+	pos    int
+}
+
+func parseFlowExpression(source string) (flowExprNode, error) {
+	tokens, err := tokenizeFlowExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &flowExprParser{tokens: tokens}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != flowExprTokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", token.text, token.pos)
+	}
+	return node, nil
+}
+
+func (parser *flowExprParser) peek() flowExprToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *flowExprParser) next() flowExprToken {
+	token := parser.tokens[parser.pos]
+	if token.kind != flowExprTokenEOF {
+		parser.pos++
+	}
+	return token
+}
+
+// acceptOp consumes the next token when it is one of ops. Word operators
+// (and, or, not) are accepted as aliases of their symbol forms.
+func (parser *flowExprParser) acceptOp(ops ...string) (string, bool) {
+	token := parser.peek()
+	for _, op := range ops {
+		if token.kind == flowExprTokenOp && token.text == op {
+			parser.next()
+			return op, true
+		}
+		if token.kind == flowExprTokenIdent && flowExprWordOperators[token.text] == op {
+			parser.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+var flowExprWordOperators = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+func (parser *flowExprParser) expectOp(op string) error {
+	if _, ok := parser.acceptOp(op); ok {
+		return nil
+	}
+	token := parser.peek()
+	if token.kind == flowExprTokenEOF {
+		return fmt.Errorf("expected %q at end of expression", op)
+	}
+	return fmt.Errorf("expected %q at offset %d, got %q", op, token.pos, token.text)
+}
+
+func (parser *flowExprParser) parseOr() (flowExprNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := parser.acceptOp("||"); !ok {
+			return left, nil
+		}
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = flowExprBinary{op: "||", left: left, right: right}
+	}
+}
+
+func (parser *flowExprParser) parseAnd() (flowExprNode, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := parser.acceptOp("&&"); !ok {
+			return left, nil
+		}
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = flowExprBinary{op: "&&", left: left, right: right}
+	}
+}
+
+func (parser *flowExprParser) parseNot() (flowExprNode, error) {
+	if _, ok := parser.acceptOp("!"); ok {
+		operand, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return flowExprUnary{op: "!", operand: operand}, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *flowExprParser) parseComparison() (flowExprNode, error) {
+	left, err := parser.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := parser.acceptOp("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := parser.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return flowExprBinary{op: op, left: left, right: right}, nil
+}
+
+func (parser *flowExprParser) parseAdditive() (flowExprNode, error) {
+	left, err := parser.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := parser.acceptOp("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := parser.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = flowExprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (parser *flowExprParser) parseMultiplicative() (flowExprNode, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := parser.acceptOp("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = flowExprBinary{op: op, left: left, right: right}
+	}
+}
+
+func (parser *flowExprParser) parseUnary() (flowExprNode, error) {
+	if _, ok := parser.acceptOp("-"); ok {
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return flowExprUnary{op: "-", operand: operand}, nil
+	}
+	return parser.parsePostfix()
+}
+
+func (parser *flowExprParser) parsePostfix() (flowExprNode, error) {
+	node, err := parser.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := parser.acceptOp("."); ok {
+			token := parser.next()
+			if token.kind != flowExprTokenField {
+				return nil, fmt.Errorf("expected field name at offset %d", token.pos)
+			}
+			node = flowExprField{target: node, name: token.text}
+			continue
+		}
+		if _, ok := parser.acceptOp("["); ok {
+			index, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expectOp("]"); err != nil {
+				return nil, err
+			}
+			node = flowExprIndex{target: node, index: index}
+			continue
+		}
+		return node, nil
+	}
+}
+
+func (parser *flowExprParser) parsePrimary() (flowExprNode, error) {
+	token := parser.next()
+	switch token.kind {
+	case flowExprTokenNumber, flowExprTokenString:
+		return flowExprLiteral{value: token.value}, nil
+	case flowExprTokenIdent:
+		switch token.text {
+		case "true":
+			return flowExprLiteral{value: true}, nil
+		case "false":
+			return flowExprLiteral{value: false}, nil
+		case "null", "nil":
+			return flowExprLiteral{value: nil}, nil
+		}
+		if _, isWord := flowExprWordOperators[token.text]; isWord {
+			return nil, fmt.Errorf("unexpected %q at offset %d", token.text, token.pos)
+		}
+		if _, ok := parser.acceptOp("("); !ok {
+			return flowExprVar{name: token.text}, nil
+		}
+		call := flowExprCall{name: token.text}
+		if _, ok := parser.acceptOp(")"); ok {
+			return call, nil
+		}
+		for {
+			arg, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := parser.acceptOp(","); ok {
+				continue
+			}
+			if err := parser.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return call, nil
+		}
+	case flowExprTokenOp:
+		if token.text == "(" {
+			node, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	case flowExprTokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", token.text, token.pos)
+}
+
+// parseFlowPlaceholder parses the text inside {{ }}. isPath reports a plain
+// variable path that the legacy JSONPath resolver handles; node is nil in that
+// case only when the path is not valid expression syntax (for example a field
+// name containing spaces).
+func parseFlowPlaceholder(expr string) (flowExprNode, bool, error) {
+	expr = strings.TrimSpace(expr)
+	node, err := parseFlowExpression(expr)
+	_, _, legacyErr := parseFlowVariableReference(expr)
+	if err != nil {
+		if legacyErr == nil {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	return node, legacyErr == nil && flowExprIsPath(node), nil
+}
+
+func flowExprIsPath(node flowExprNode) bool {
+	switch typed := node.(type) {
+	case flowExprVar:
+		return true
+	case flowExprField:
+		return flowExprIsPath(typed.target)
+	case flowExprIndex:
+		literal, ok := typed.index.(flowExprLiteral)
+		if !ok {
+			return false
+		}
+		switch literal.value.(type) {
+		case int, string:
+			return flowExprIsPath(typed.target)
+		}
+	}
+	return false
+}
+
+// flowExprVariables lists the flow variables an expression reads, in order of
+// first use.
+func flowExprVariables(node flowExprNode) []string {
+	return collectFlowExprVariables(node, false)
+}
+
+// flowExprRequiredVariables lists the variables an expression cannot run
+// without. The first argument of default() may name a var that was never set.
+func flowExprRequiredVariables(node flowExprNode) []string {
+	return collectFlowExprVariables(node, true)
+}
+
+func collectFlowExprVariables(node flowExprNode, skipDefaulted bool) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	var walk func(flowExprNode)
+	walk = func(node flowExprNode) {
+		switch typed := node.(type) {
+		case flowExprVar:
+			if !seen[typed.name] {
+				seen[typed.name] = true
+				names = append(names, typed.name)
+			}
+		case flowExprField:
+			walk(typed.target)
+		case flowExprIndex:
+			walk(typed.target)
+			walk(typed.index)
+		case flowExprUnary:
+			walk(typed.operand)
+		case flowExprBinary:
+			walk(typed.left)
+			walk(typed.right)
+		case flowExprCall:
+			for i, arg := range typed.args {
+				if skipDefaulted && typed.name == "default" && i == 0 {
+					continue
+				}
+				walk(arg)
+			}
+		}
+	}
+	walk(node)
+	return names
+}
+
+// resolveFlowExpression resolves the text of one placeholder against vars.
+func resolveFlowExpression(expr string, vars map[string]any) (any, error) {
+	node, isPath, err := parseFlowPlaceholder(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", strings.TrimSpace(expr), err)
+	}
+	if isPath {
+		return resolveFlowVariableReference(expr, vars)
+	}
+	value, err := evalFlowExpression(node, vars)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q %w", strings.TrimSpace(expr), err)
+	}
+	return value, nil
+}
+
+// flowExpressionSource accepts an expression either bare or wrapped in a single
+// {{ }} placeholder, as written in expr steps and condition shorthands.
+func flowExpressionSource(value any) (string, error) {
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("must be an expression string")
+	}
+	text = strings.TrimSpace(text)
+	if matches := placeholderPattern.FindStringSubmatch(text); len(matches) == 2 {
+		text = matches[1]
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("cannot be blank")
+	}
+	return text, nil
+}
+
+var errFlowExprUnknownVariable = errors.New("unknown flow variable")
+
+func evalFlowExpression(node flowExprNode, vars map[string]any) (any, error) {
+	switch typed := node.(type) {
+	case flowExprLiteral:
+		return typed.value, nil
+	case flowExprVar:
+		value, ok := vars[typed.name]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", errFlowExprUnknownVariable, typed.name)
+		}
+		return value, nil
+	case flowExprField:
+		target, err := evalFlowExpression(typed.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		return flowExprMember(target, typed.name)
+	case flowExprIndex:
+		target, err := evalFlowExpression(typed.target, vars)
+		if err != nil {
+			return nil, err
+		}
+		index, err := evalFlowExpression(typed.index, vars)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := index.(string); ok {
+			return flowExprMember(target, key)
+		}
+		position, ok := flowExprNumber(index)
+		if !ok || math.Trunc(position) != position {
+			return nil, fmt.Errorf("index must be an integer or string, got %s", flowExprTypeName(index))
+		}
+		if target == nil {
+			return nil, nil
+		}
+		items, err := toList(target)
+		if err != nil {
+			return nil, fmt.Errorf("cannot index %s with a number", flowExprTypeName(target))
+		}
+		if position < 0 || int(position) >= len(items) {
+			return nil, nil
+		}
+		return items[int(position)], nil
+	case flowExprUnary:
+		operand, err := evalFlowExpression(typed.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		if typed.op == "!" {
+			return !flowValueTruthy(operand), nil
+		}
+		number, ok := flowExprNumber(operand)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", flowExprTypeName(operand))
+		}
+		return flowExprNumberResult(-number), nil
+	case flowExprBinary:
+		return evalFlowExprBinary(typed, vars)
+	case flowExprCall:
+		fn, ok := flowExprFunctions[typed.name]
+		if !ok {
+			return nil, fmt.Errorf("calls unknown function %q", typed.name)
+		}
+		if err := fn.checkArity(typed.name, len(typed.args)); err != nil {
+			return nil, err
+		}
+		args := make([]any, 0, len(typed.args))
+		for i, arg := range typed.args {
+			value, err := evalFlowExpression(arg, vars)
+			// default() exists for vars a branch or loop may never have set,
+			// so a missing var in its first argument is nil.
+			if err != nil && !(typed.name == "default" && i == 0 && errors.Is(err, errFlowExprUnknownVariable)) {
+				return nil, err
+			}
+			args = append(args, value)
+		}
+		result, err := fn.call(args)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): %w", typed.name, err)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression node %T", node)
+	}
+}
+
+func evalFlowExprBinary(node flowExprBinary, vars map[string]any) (any, error) {
+	left, err := evalFlowExpression(node.left, vars)
+	if err != nil {
+		return nil, err
+	}
+	switch node.op {
+	case "&&":
+		if !flowValueTruthy(left) {
+			return false, nil
+		}
+		right, err := evalFlowExpression(node.right, vars)
+		if err != nil {
+			return nil, err
+		}
+		return flowValueTruthy(right), nil
+	case "||":
+		if flowValueTruthy(left) {
+			return true, nil
+		}
+		right, err := evalFlowExpression(node.right, vars)
+		if err != nil {
+			return nil, err
+		}
+		return flowValueTruthy(right), nil
+	}
+	right, err := evalFlowExpression(node.right, vars)
+	if err != nil {
+		return nil, err
+	}
+	switch node.op {
+	case "==":
+		return flowExprEqual(left, right), nil
+	case "!=":
+		return !flowExprEqual(left, right), nil
+	case "<", "<=", ">", ">=":
+		cmp, err := flowExprCompare(left, right)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compare %s %s %s", flowExprTypeName(left), node.op, flowExprTypeName(right))
+		}
+		switch node.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "+":
+		leftNumber, leftOK := flowExprNumber(left)
+		rightNumber, rightOK := flowExprNumber(right)
+		if leftOK && rightOK {
+			return flowExprNumberResult(leftNumber + rightNumber), nil
+		}
+		_, leftString := left.(string)
+		_, rightString := right.(string)
+		if leftString || rightString {
+			return flowExprString(left) + flowExprString(right), nil
+		}
+		return nil, fmt.Errorf("cannot add %s and %s", flowExprTypeName(left), flowExprTypeName(right))
+	default:
+		leftNumber, leftOK := flowExprNumber(left)
+		rightNumber, rightOK := flowExprNumber(right)
+		if !leftOK || !rightOK {
+			return nil, fmt.Errorf("operator %q needs numbers, got %s and %s", node.op, flowExprTypeName(left), flowExprTypeName(right))
+		}
+		switch node.op {
+		case "-":
+			return flowExprNumberResult(leftNumber - rightNumber), nil
+		case "*":
+			return flowExprNumberResult(leftNumber * rightNumber), nil
+		case "/":
+			if rightNumber == 0 {
+				return nil, fmt.Errorf("divides by zero")
+			}
+			return flowExprNumberResult(leftNumber / rightNumber), nil
+		case "%":
+			if rightNumber == 0 {
+				return nil, fmt.Errorf("divides by zero")
+			}
+			return flowExprNumberResult(math.Mod(leftNumber, rightNumber)), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported operator %q", node.op)
+}
+
+func flowExprMember(target any, name string) (any, error) {
+	switch typed := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return typed[name], nil
+	case map[string]string:
+		if value, ok := typed[name]; ok {
+			return value, nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot read field %q of %s", name, flowExprTypeName(target))
+	}
+}
+
+func flowExprNumber(value any) (float64, bool) {
+	switch typed := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		number, _ := flowValueAsInt(typed)
+		return float64(number), true
+	case float32:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	default:
+		return 0, false
+	}
+}
+
+// flowExprNumberResult keeps whole numbers as int so they print as 3 instead
+// of 3.0 when interpolated into strings.
+func flowExprNumberResult(value float64) any {
+	if math.Trunc(value) == value && math.Abs(value) < 1<<53 {
+		return int(value)
+	}
+	return value
+}
+
+func flowExprString(value any) string {
+	if value == nil {
+		return ""
+	}
+	if number, ok := flowExprNumber(value); ok {
+		return fmt.Sprint(flowExprNumberResult(number))
+	}
+	return fmt.Sprint(value)
+}
+
+func flowExprEqual(left any, right any) bool {
+	leftNumber, leftOK := flowExprNumber(left)
+	rightNumber, rightOK := flowExprNumber(right)
+	if leftOK && rightOK {
+		return leftNumber == rightNumber
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func flowExprCompare(left any, right any) (int, error) {
+	leftNumber, leftOK := flowExprNumber(left)
+	rightNumber, rightOK := flowExprNumber(right)
+	if leftOK && rightOK {
+		switch {
+		case leftNumber < rightNumber:
+			return -1, nil
+		case leftNumber > rightNumber:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	}
+	leftText, leftString := left.(string)
+	rightText, rightString := right.(string)
+	if leftString && rightString {
+		return strings.Compare(leftText, rightText), nil
+	}
+	return 0, fmt.Errorf("incomparable values")
+}
+
+func flowExprTypeName(value any) string {
+	return flowExprTypeOfValue(value).String()
+}
+
+type flowExprType int
+
+const (
+	flowExprTypeAny flowExprType = iota
+	flowExprTypeNull
+	flowExprTypeBool
+	flowExprTypeNumber
+	flowExprTypeString
+	flowExprTypeList
+	flowExprTypeObject
+)
+
+func (t flowExprType) String() string {
+	switch t {
+	case flowExprTypeNull:
+		return "null"
+	case flowExprTypeBool:
+		return "boolean"
+	case flowExprTypeNumber:
+		return "number"
+	case flowExprTypeString:
+		return "string"
+	case flowExprTypeList:
+		return "list"
+	case flowExprTypeObject:
+		return "object"
+	default:
+		return "any"
+	}
+}
+
+func flowExprTypeOfValue(value any) flowExprType {
+	if value == nil {
+		return flowExprTypeNull
+	}
+	if _, ok := flowExprNumber(value); ok {
+		return flowExprTypeNumber
+	}
+	switch value.(type) {
+	case bool:
+		return flowExprTypeBool
+	case string:
+		return flowExprTypeString
+	case []any, []string:
+		return flowExprTypeList
+	case map[string]any, map[string]string:
+		return flowExprTypeObject
+	}
+	return flowExprTypeAny
+}
+
+type flowExprFunction struct {
+	minArgs int
+	maxArgs int
+	// argTypes lists the accepted types per argument position; a missing or
+	// empty entry accepts anything.
+	argTypes [][]flowExprType
+	returns  flowExprType
+	call     func(args []any) (any, error)
+}
+
+func (fn flowExprFunction) checkArity(name string, count int) error {
+	if count < fn.minArgs || count > fn.maxArgs {
+		if fn.minArgs == fn.maxArgs {
+			return fmt.Errorf("%s() takes %d argument(s), got %d", name, fn.minArgs, count)
+		}
+		return fmt.Errorf("%s() takes %d to %d arguments, got %d", name, fn.minArgs, fn.maxArgs, count)
+	}
+	return nil
+}
+
+var flowExprFunctions map[string]flowExprFunction
+
+func init() {
+	stringArg := []flowExprType{flowExprTypeString, flowExprTypeNumber, flowExprTypeNull}
+	flowExprFunctions = map[string]flowExprFunction{
+		"len": {
+			minArgs: 1, maxArgs: 1,
+			argTypes: [][]flowExprType{{flowExprTypeString, flowExprTypeList, flowExprTypeObject, flowExprTypeNull}},
+			returns:  flowExprTypeNumber,
+			call: func(args []any) (any, error) {
+				switch typed := args[0].(type) {
+				case nil:
+					return 0, nil
+				case string:
+					return utf8.RuneCountInString(typed), nil
+				case map[string]any:
+					return len(typed), nil
+				case map[string]string:
+					return len(typed), nil
+				}
+				items, err := toList(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("expects a string, list, or object, got %s", flowExprTypeName(args[0]))
+				}
+				return len(items), nil
+			},
+		},
+		"lower": {
+			minArgs: 1, maxArgs: 1, argTypes: [][]flowExprType{stringArg}, returns: flowExprTypeString,
+			call: func(args []any) (any, error) { return strings.ToLower(flowExprString(args[0])), nil },
+		},
+		"upper": {
+			minArgs: 1, maxArgs: 1, argTypes: [][]flowExprType{stringArg}, returns: flowExprTypeString,
+			call: func(args []any) (any, error) { return strings.ToUpper(flowExprString(args[0])), nil },
+		},
+		"trim": {
+			minArgs: 1, maxArgs: 1, argTypes: [][]flowExprType{stringArg}, returns: flowExprTypeString,
+			call: func(args []any) (any, error) { return strings.TrimSpace(flowExprString(args[0])), nil },
+		},
+		"string": {
+			minArgs: 1, maxArgs: 1, returns: flowExprTypeString,
+			call: func(args []any) (any, error) { return flowExprString(args[0]), nil },
+		},
+		"number": {
+			minArgs: 1, maxArgs: 1, argTypes: [][]flowExprType{stringArg}, returns: flowExprTypeNumber,
+			call: func(args []any) (any, error) {
+				if number, ok := flowExprNumber(args[0]); ok {
+					return flowExprNumberResult(number), nil
+				}
+				text := strings.ReplaceAll(strings.TrimSpace(flowExprString(args[0])), ",", "")
+				number, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, fmt.Errorf("cannot convert %q to a number", flowExprString(args[0]))
+				}
+				return flowExprNumberResult(number), nil
+			},
+		},
+		"default": {
+			minArgs: 2, maxArgs: 2, returns: flowExprTypeAny,
+			call: func(args []any) (any, error) {
+				if args[0] == nil || args[0] == "" {
+					return args[1], nil
+				}
+				return args[0], nil
+			},
+		},
+		"contains": {
+			minArgs: 2, maxArgs: 2,
+			argTypes: [][]flowExprType{{flowExprTypeString, flowExprTypeList, flowExprTypeObject, flowExprTypeNull}},
+			returns:  flowExprTypeBool,
+			call: func(args []any) (any, error) {
+				switch typed := args[0].(type) {
+				case nil:
+					return false, nil
+				case string:
+					return strings.Contains(typed, flowExprString(args[1])), nil
+				case map[string]any:
+					_, ok := typed[flowExprString(args[1])]
+					return ok, nil
+				}
+				items, err := toList(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("expects a string, list, or object, got %s", flowExprTypeName(args[0]))
+				}
+				for _, item := range items {
+					if flowExprEqual(item, args[1]) {
+						return true, nil
+					}
+				}
+				return false, nil
+			},
+		},
+		"starts_with": {
+			minArgs: 2, maxArgs: 2, argTypes: [][]flowExprType{stringArg, stringArg}, returns: flowExprTypeBool,
+			call: func(args []any) (any, error) {
+				return strings.HasPrefix(flowExprString(args[0]), flowExprString(args[1])), nil
+			},
+		},
+		"ends_with": {
+			minArgs: 2, maxArgs: 2, argTypes: [][]flowExprType{stringArg, stringArg}, returns: flowExprTypeBool,
+			call: func(args []any) (any, error) {
+				return strings.HasSuffix(flowExprString(args[0]), flowExprString(args[1])), nil
+			},
+		},
+		"matches": {
+			minArgs: 2, maxArgs: 2, argTypes: [][]flowExprType{stringArg, {flowExprTypeString}}, returns: flowExprTypeBool,
+			call: func(args []any) (any, error) {
+				pattern, err := regexp.Compile(flowExprString(args[1]))
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern: %w", err)
+				}
+				return pattern.MatchString(flowExprString(args[0])), nil
+			},
+		},
+		"now": {
+			minArgs: 0, maxArgs: 0, returns: flowExprTypeString,
+			call: func(args []any) (any, error) { return time.Now().Format(time.RFC3339), nil },
+		},
+		"format_date": {
+			minArgs: 2, maxArgs: 2, argTypes: [][]flowExprType{stringArg, {flowExprTypeString}}, returns: flowExprTypeString,
+			call: func(args []any) (any, error) {
+				value, err := flowExprTime(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return value.Format(flowExprDateLayout(flowExprString(args[1]))), nil
+			},
+		},
+	}
+}
+
+var flowExprInputDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+}
+
+func flowExprTime(value any) (time.Time, error) {
+	if number, ok := flowExprNumber(value); ok {
+		return time.Unix(int64(number), 0), nil
+	}
+	text := strings.TrimSpace(flowExprString(value))
+	for _, layout := range flowExprInputDateLayouts {
+		if parsed, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a date", text)
+}
+
+var flowExprDateTokens = strings.NewReplacer(
+	"YYYY", "2006",
+	"YY", "06",
+	"MM", "01",
+	"DD", "02",
+	"HH", "15",
+	"mm", "04",
+	"ss", "05",
+	"SSS", "000",
+)
+
+// flowExprDateLayout turns a YYYY-MM-DD HH:mm:ss style pattern into a Go
+// time layout.
+func flowExprDateLayout(pattern string) string {
+	return flowExprDateTokens.Replace(pattern)
+}
+
+// checkFlowExpression type-checks an expression without evaluating it.
+// Variables are typed as any because steps may reassign them at runtime;
+// literals and function results carry static types.
+func checkFlowExpression(node flowExprNode) (flowExprType, error) {
+	switch typed := node.(type) {
+	case flowExprLiteral:
+		return flowExprTypeOfValue(typed.value), nil
+	case flowExprVar:
+		return flowExprTypeAny, nil
+	case flowExprField:
+		targetType, err := checkFlowExpression(typed.target)
+		if err != nil {
+			return flowExprTypeAny, err
+		}
+		if targetType != flowExprTypeAny && targetType != flowExprTypeObject && targetType != flowExprTypeNull {
+			return flowExprTypeAny, fmt.Errorf("cannot read field %q of %s", typed.name, targetType)
+		}
+		return flowExprTypeAny, nil
+	case flowExprIndex:
+		if _, err := checkFlowExpression(typed.target); err != nil {
+			return flowExprTypeAny, err
+		}
+		indexType, err := checkFlowExpression(typed.index)
+		if err != nil {
+			return flowExprTypeAny, err
+		}
+		if indexType != flowExprTypeAny && indexType != flowExprTypeNumber && indexType != flowExprTypeString {
+			return flowExprTypeAny, fmt.Errorf("index must be an integer or string, got %s", indexType)
+		}
+		return flowExprTypeAny, nil
+	case flowExprUnary:
+		operandType, err := checkFlowExpression(typed.operand)
+		if err != nil {
+			return flowExprTypeAny, err
+		}
+		if typed.op == "!" {
+			return flowExprTypeBool, nil
+		}
+		if operandType != flowExprTypeAny && operandType != flowExprTypeNumber {
+			return flowExprTypeAny, fmt.Errorf("cannot negate %s", operandType)
+		}
+		return flowExprTypeNumber, nil
+	case flowExprBinary:
+		leftType, err := checkFlowExpression(typed.left)
+		if err != nil {
+			return flowExprTypeAny, err
+		}
+		rightType, err := checkFlowExpression(typed.right)
+		if err != nil {
+			return flowExprTypeAny, err
+		}
+		known := leftType != flowExprTypeAny && rightType != flowExprTypeAny
+		switch typed.op {
+		case "&&", "||", "==", "!=":
+			return flowExprTypeBool, nil
+		case "<", "<=", ">", ">=":
+			if known && !(leftType == rightType && (leftType == flowExprTypeNumber || leftType == flowExprTypeString)) {
+				return flowExprTypeAny, fmt.Errorf("cannot compare %s %s %s", leftType, typed.op, rightType)
+			}
+			return flowExprTypeBool, nil
+		case "+":
+			if leftType == flowExprTypeString || rightType == flowExprTypeString {
+				return flowExprTypeString, nil
+			}
+			if known && !(leftType == flowExprTypeNumber && rightType == flowExprTypeNumber) {
+				return flowExprTypeAny, fmt.Errorf("cannot add %s and %s", leftType, rightType)
+			}
+			if known {
+				return flowExprTypeNumber, nil
+			}
+			return flowExprTypeAny, nil
+		default:
+			for _, operandType := range []flowExprType{leftType, rightType} {
+				if operandType != flowExprTypeAny && operandType != flowExprTypeNumber {
+					return flowExprTypeAny, fmt.Errorf("operator %q needs numbers, got %s", typed.op, operandType)
+				}
+			}
+			return flowExprTypeNumber, nil
+		}
+	case flowExprCall:
+		fn, ok := flowExprFunctions[typed.name]
+		if !ok {
+			return flowExprTypeAny, fmt.Errorf("calls unknown function %q", typed.name)
+		}
+		if err := fn.checkArity(typed.name, len(typed.args)); err != nil {
+			return flowExprTypeAny, err
+		}
+		for i, arg := range typed.args {
+			argType, err := checkFlowExpression(arg)
+			if err != nil {
+				return flowExprTypeAny, err
+			}
+			if i >= len(fn.argTypes) || len(fn.argTypes[i]) == 0 || argType == flowExprTypeAny {
+				continue
+			}
+			accepted := false
+			for _, allowed := range fn.argTypes[i] {
+				if argType == allowed {
+					accepted = true
+					break
+				}
+			}
+			if !accepted {
+				return flowExprTypeAny, fmt.Errorf("%s() argument %d must not be %s", typed.name, i+1, argType)
+			}
+		}
+		if typed.name == "matches" && len(typed.args) == 2 {
+			if literal, ok := typed.args[1].(flowExprLiteral); ok {
+				if pattern, ok := literal.value.(string); ok {
+					if _, err := regexp.Compile(pattern); err != nil {
+						return flowExprTypeAny, fmt.Errorf("matches() has invalid pattern %q: %v", pattern, err)
+					}
+				}
+			}
+		}
+		return fn.returns, nil
+	default:
+		return flowExprTypeAny, fmt.Errorf("unsupported expression node %T", node)
+	}
+}
+
+// flowExprParamTypeMatches reports whether an expression's static type can
+// satisfy a parameter type from flowParamType. Unknown types always pass.
+func flowExprParamTypeMatches(exprType flowExprType, paramType string) bool {
+	if exprType == flowExprTypeAny || exprType == flowExprTypeNull {
+		return true
+	}
+	switch paramType {
+	case "string":
+		return exprType == flowExprTypeString
+	case "bool":
+		return exprType == flowExprTypeBool
+	case "int", "number":
+		return exprType == flowExprTypeNumber
+	case "string_list":
+		return exprType == flowExprTypeList
+	default:
+		return true
+	}
+}
+
+type flowStepAlias FlowStep
+
+// UnmarshalJSON accepts a bare expression string as a step's condition, so
+// conditions can be written as "condition": "{{ total > 10 }}". Step lists
+// still need full steps.
+func (step *FlowStep) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		flowStepAlias
+		Condition json.RawMessage `json:"condition,omitempty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*step = FlowStep(decoded.flowStepAlias)
+	if len(decoded.Condition) == 0 || string(decoded.Condition) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(decoded.Condition, &text); err == nil {
+		step.Condition = &FlowStep{Action: "expr", Value: text}
+		return nil
+	}
+	var condition FlowStep
+	if err := json.Unmarshal(decoded.Condition, &condition); err != nil {
+		return err
+	}
+	step.Condition = &condition
+	return nil
+}
+
+// UnmarshalYAML is the YAML counterpart of UnmarshalJSON.
+func (step *FlowStep) UnmarshalYAML(value *yaml.Node) error {
+	var alias flowStepAlias
+	if err := expandFlowConditionShorthand(value).Decode(&alias); err != nil {
+		return err
+	}
+	*step = FlowStep(alias)
+	return nil
+}
+
+// expandFlowConditionShorthand returns a step mapping whose scalar condition
+// is written out as an expr step, leaving node itself unchanged.
+func expandFlowConditionShorthand(node *yaml.Node) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return node
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		condition := node.Content[i+1]
+		if node.Content[i].Value != "condition" || condition.Kind != yaml.ScalarNode || condition.Tag == "!!null" {
+			continue
+		}
+		scalar := func(value string) *yaml.Node {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Line: condition.Line, Column: condition.Column}
+		}
+		expanded := *node
+		expanded.Content = append([]*yaml.Node(nil), node.Content...)
+		expanded.Content[i+1] = &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Tag:     "!!map",
+			Content: []*yaml.Node{scalar("action"), scalar("expr"), scalar("value"), scalar(condition.Value)},
+			Line:    condition.Line,
+			Column:  condition.Column,
+		}
+		return &expanded
+	}
+	return node
+}
+
+func validateExprFlowStep(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if len(step.Args) > 0 {
+		return fmt.Errorf("step %s action %q does not support args; use value", stepPath, step.Action)
+	}
+	present := step.presentNamedParams()
+	for name := range present {
+		if name != "value" {
+			return fmt.Errorf("step %s action %q does not accept parameter %q", stepPath, step.Action, name)
+		}
+	}
+	value, ok := present["value"]
+	if !ok {
+		return fmt.Errorf("step %s action %q requires value", stepPath, step.Action)
+	}
+	source, err := flowExpressionSource(value)
+	if err != nil {
+		return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "value", err)
+	}
+	return validateFlowReferences(stepPath, step.Action, "value", "{{ "+source+" }}", knownVars)
+}
+
+func runFlowExprStep(ctx *FlowContext, step FlowStep) (any, error) {
+	value, ok := step.param("value")
+	if !ok {
+		return nil, fmt.Errorf("expr requires value")
+	}
+	source, err := flowExpressionSource(value)
+	if err != nil {
+		return nil, fmt.Errorf("expr value %w", err)
+	}
+	return resolveFlowExpression(source, flowPlaceholderVars(ctx, source))
+}
@@ -0,0 +1,266 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+type flowForeachIterationResult struct {
+	iteration int
+	item      any
+	vars      map[string]any
+	traces    []FlowStepTrace
+	err       error
+}
+
+// runFlowForeachConcurrent fans foreach iterations out to a bounded pool of
+// workers. Every worker drives its own page in the flow's browser context and
+// every iteration runs in a fresh Lua state with a private copy of the flow
+// variables, so iterations never see each other's writes. Only append_var
+// targets flow back to the caller: they are merged in iteration order once all
+// earlier iterations have finished, which is also when the progress
+// checkpoint advances.
+func runFlowForeachConcurrent(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath string, items []any, itemVar string, indexVar string, concurrency int, checkpoint *flowForeachCheckpoint) (any, []FlowStepTrace, error) {
+	if ctx.DBTransaction != nil {
+		return nil, nil, fmt.Errorf("foreach concurrency cannot be used inside db_transaction")
+	}
+	if concurrency > len(items) {
+		concurrency = len(items)
+	}
+	browser, _ := flowBrowserFromState(L)
+	browserContext, _ := flowBrowserContextFromState(L)
+	pages, err := openFlowForeachPages(browserContext, concurrency)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeFlowForeachPages(pages)
+
+	appendTargets := flowForeachAppendTargets(step.Steps)
+	base := snapshotFlowVars(ctx)
+	// Iterations merge in order, so a resumed run only has to skip a prefix.
+	resumed := ctx.Checkpoint.resumeForeach(stepPath)
+	jobs := make(chan int)
+	results := make(chan flowForeachIterationResult)
+	stop := make(chan struct{})
+	stopDispatch := sync.OnceFunc(func() { close(stop) })
+
+	var workers sync.WaitGroup
+	for _, page := range pages {
+		workers.Add(1)
+		go func(page playwright.Page) {
+			defer workers.Done()
+			workerCtx := *ctx
+			workerCtx.OCRSidecars = map[string]*goddddocrSidecar{}
+			workerCtx.Checkpoint = nil
+			// Trace groups form one stack per browser context, so parallel
+			// iterations record their actions ungrouped under the foreach step.
+			workerCtx.BrowserTrace = nil
+			// The metrics windows of concurrent iterations overlap, so only
+			// the foreach step itself reports metrics.
+			workerCtx.Metrics = nil
+			defer workerCtx.closeOCRSidecars()
+			for index := range jobs {
+				results <- runFlowForeachIteration(&workerCtx, browser, browserContext, page, step, stepPath, base, items[index], index+1, itemVar, indexVar)
+			}
+		}(page)
+	}
+	go func() {
+		defer close(jobs)
+		for index := resumed; index < len(items); index++ {
+			if flowRunContextError(ctx) != nil {
+				return
+			}
+			select {
+			case jobs <- index:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	iterationTraces := make([][]FlowStepTrace, len(items)+1)
+	pending := map[int]flowForeachIterationResult{}
+	next := resumed + 1
+	var firstErr error
+	firstErrIteration := 0
+	for result := range results {
+		iterationTraces[result.iteration] = result.traces
+		if result.err != nil {
+			stopDispatch()
+			if firstErrIteration == 0 || result.iteration < firstErrIteration {
+				firstErr = result.err
+				firstErrIteration = result.iteration
+			}
+			continue
+		}
+		pending[result.iteration] = result
+		for firstErrIteration == 0 || next < firstErrIteration {
+			completed, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if err := mergeFlowForeachAppends(L, ctx, appendTargets, base, completed.vars); err != nil {
+				stopDispatch()
+				firstErr = err
+				firstErrIteration = next
+				break
+			}
+			iterationCtx := *ctx
+			iterationCtx.Vars = completed.vars
+			checkpoint.recordSuccess(L, &iterationCtx, completed.item, next)
+			ctx.Checkpoint.completeIteration(stepPath, next)
+			next++
+		}
+	}
+
+	children := []FlowStepTrace{}
+	for _, traces := range iterationTraces {
+		children = append(children, traces...)
+	}
+	if firstErr != nil {
+		return nil, children, firstErr
+	}
+	if next <= len(items) {
+		if err := flowRunContextError(ctx); err != nil {
+			return nil, children, err
+		}
+		return nil, children, fmt.Errorf("foreach stopped after %d of %d iterations", next-1, len(items))
+	}
+	result := map[string]any{
+		"iterations":  len(items),
+		"concurrency": concurrency,
+		"status":      "completed",
+	}
+	if summary := checkpoint.summary(); summary != nil {
+		result["checkpoint"] = summary
+	}
+	return result, children, nil
+}
+
+func runFlowForeachIteration(ctx *FlowContext, browser playwright.Browser, browserContext playwright.BrowserContext, page playwright.Page, step FlowStep, stepPath string, base map[string]any, item any, iteration int, itemVar string, indexVar string) flowForeachIterationResult {
+	L := lua.NewState()
+	defer L.Close()
+	ensureFlowActionGlobals(L)
+	if page != nil {
+		setFlowBrowserGlobals(L, browser, browserContext, page)
+	}
+
+	iterationCtx := *ctx
+	iterationCtx.Vars = make(map[string]any, len(base)+2)
+	for key, value := range base {
+		setFlowVar(L, &iterationCtx, key, value)
+	}
+	setFlowVar(L, &iterationCtx, itemVar, item)
+	if indexVar != "" {
+		setFlowVar(L, &iterationCtx, indexVar, iteration)
+	}
+	setFlowContextState(L, &iterationCtx)
+
+	traces, err := runFlowStepSequence(L, &iterationCtx, step.Steps, fmt.Sprintf("%s[%d]", stepPath, iteration), 0, iteration)
+	if control, ok := asFlowLoopControl(err); ok {
+		err = nil
+		if control.action == "break" {
+			err = fmt.Errorf("break cannot be used inside foreach with concurrency")
+		}
+	}
+	return flowForeachIterationResult{
+		iteration: iteration,
+		item:      item,
+		vars:      iterationCtx.Vars,
+		traces:    traces,
+		err:       err,
+	}
+}
+
+// openFlowForeachPages opens one page per worker. Flows without a browser get
+// nil pages so browser-free loops can still run concurrently.
+func openFlowForeachPages(browserContext playwright.BrowserContext, count int) ([]playwright.Page, error) {
+	pages := make([]playwright.Page, count)
+	if browserContext == nil {
+		return pages, nil
+	}
+	for i := range pages {
+		page, err := browserContext.NewPage()
+		if err != nil {
+			closeFlowForeachPages(pages)
+			return nil, fmt.Errorf("foreach could not open page for worker %d: %w", i+1, err)
+		}
+		pages[i] = page
+	}
+	return pages, nil
+}
+
+func closeFlowForeachPages(pages []playwright.Page) {
+	for _, page := range pages {
+		if page != nil {
+			_ = page.Close()
+		}
+	}
+}
+
+func flowForeachAppendTargets(steps []FlowStep) []string {
+	targets := []string{}
+	seen := map[string]bool{}
+	_ = forEachFlowStep(steps, "", func(step FlowStep, _ string) error {
+		if step.Action == "append_var" && step.SaveAs != "" && !seen[step.SaveAs] {
+			seen[step.SaveAs] = true
+			targets = append(targets, step.SaveAs)
+		}
+		return nil
+	})
+	sort.Strings(targets)
+	return targets
+}
+
+func mergeFlowForeachAppends(L *lua.LState, ctx *FlowContext, targets []string, base map[string]any, vars map[string]any) error {
+	for _, name := range targets {
+		appended := flowForeachAppendedItems(base[name], vars[name])
+		if len(appended) == 0 {
+			continue
+		}
+		merged := []any{}
+		if current := ctx.Vars[name]; current != nil {
+			items, err := toList(current)
+			if err != nil {
+				return fmt.Errorf("append_var save_as %q must already be a list, got %T", name, current)
+			}
+			merged = append(merged, items...)
+		}
+		setFlowVar(L, ctx, name, append(merged, appended...))
+	}
+	return nil
+}
+
+// flowForeachAppendedItems returns what one iteration appended to a list it
+// started from. A list the iteration replaced outright is not treated as an
+// append and stays private to the iteration.
+func flowForeachAppendedItems(before any, after any) []any {
+	if after == nil {
+		return nil
+	}
+	afterItems, err := toList(after)
+	if err != nil {
+		return nil
+	}
+	if before == nil {
+		return afterItems
+	}
+	beforeItems, err := toList(before)
+	if err != nil || len(afterItems) < len(beforeItems) {
+		return nil
+	}
+	if !reflect.DeepEqual(beforeItems, afterItems[:len(beforeItems)]) {
+		return nil
+	}
+	return afterItems[len(beforeItems):]
+}
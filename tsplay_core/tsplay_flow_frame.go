@@ -0,0 +1,330 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// flowFrameEnterControl is the selector part Playwright's frameLocator uses
+// to continue a selector inside the iframe matched so far.
+const flowFrameEnterControl = "internal:control=enter-frame"
+
+const flowFrameLookupTimeout = 10 * time.Second
+
+// flowFrameSelectorScript returns a selector for an iframe element that is
+// stable across reloads where possible: id, name, src, then an XPath.
+const flowFrameSelectorScript = `(element) => {
+	const tag = element.tagName.toLowerCase();
+	const quote = (value) => JSON.stringify(String(value));
+	const id = element.getAttribute('id');
+	if (id) return tag + '[id=' + quote(id) + ']';
+	const name = element.getAttribute('name');
+	if (name) return tag + '[name=' + quote(name) + ']';
+	const src = element.getAttribute('src');
+	if (src && !/^(about|data|javascript):/i.test(src)) return tag + '[src=' + quote(src) + ']';
+	const xpath = (node) => {
+		if (node === node.ownerDocument.documentElement) return '/html';
+		let index = 1;
+		for (let sibling = node.previousElementSibling; sibling; sibling = sibling.previousElementSibling) {
+			if (sibling.tagName === node.tagName) index++;
+		}
+		return xpath(node.parentElement) + '/' + node.tagName.toLowerCase() + '[' + index + ']';
+	};
+	return 'xpath=' + xpath(element);
+}`
+
+// flowFrameTarget is a parsed frame value: name=<frame name>, url=<glob>, or
+// a chain of iframe selectors separated by ">>", outermost first.
+type flowFrameTarget struct {
+	name  string
+	url   string
+	glob  *regexp.Regexp
+	chain []string
+}
+
+func parseFlowFrameTarget(value string) (flowFrameTarget, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return flowFrameTarget{}, fmt.Errorf("cannot be blank")
+	}
+	if name, ok := strings.CutPrefix(value, "name="); ok {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return flowFrameTarget{}, fmt.Errorf("name= requires a frame name")
+		}
+		return flowFrameTarget{name: name}, nil
+	}
+	if pattern, ok := strings.CutPrefix(value, "url="); ok {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return flowFrameTarget{}, fmt.Errorf("url= requires a URL glob such as **/form.html")
+		}
+		glob, err := compileFlowURLGlob(pattern)
+		if err != nil {
+			return flowFrameTarget{}, fmt.Errorf("url= %w", err)
+		}
+		return flowFrameTarget{url: pattern, glob: glob}, nil
+	}
+	chain := []string{}
+	for _, part := range strings.Split(value, ">>") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return flowFrameTarget{}, fmt.Errorf("has an empty selector in frame chain %q", value)
+		}
+		if part == flowFrameEnterControl {
+			continue
+		}
+		chain = append(chain, part)
+	}
+	return flowFrameTarget{chain: chain}, nil
+}
+
+func (target flowFrameTarget) String() string {
+	switch {
+	case target.name != "":
+		return "name=" + target.name
+	case target.url != "":
+		return "url=" + target.url
+	default:
+		return strings.Join(target.chain, " >> ")
+	}
+}
+
+func (target flowFrameTarget) matches(frame playwright.Frame) bool {
+	if target.name != "" {
+		return frame.Name() == target.name
+	}
+	return target.glob.MatchString(frame.URL())
+}
+
+// flowActionAcceptsFrame reports whether the action takes a selector and
+// therefore a frame parameter.
+func flowActionAcceptsFrame(action string) bool {
+	spec, ok := flowActionSpecs[action]
+	if !ok {
+		return false
+	}
+	for _, arg := range spec.Args {
+		if arg.Name == "selector" {
+			return true
+		}
+	}
+	return false
+}
+
+func validateFlowStepFrame(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if step.Frame == "" || step.Action == "within_frame" {
+		return nil
+	}
+	if !flowActionAcceptsFrame(step.Action) {
+		return fmt.Errorf("step %s action %q does not accept parameter %q; only selector actions can be scoped to a frame", stepPath, step.Action, "frame")
+	}
+	return validateFlowFrameValue(stepPath, step, knownVars)
+}
+
+func validateFlowFrameValue(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if err := validateFlowParamValue(stepPath, step.Action, "frame", step.Frame, knownVars); err != nil {
+		return err
+	}
+	if len(flowReferences(step.Frame)) > 0 {
+		return nil
+	}
+	if _, err := parseFlowFrameTarget(step.Frame); err != nil {
+		return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "frame", err)
+	}
+	return nil
+}
+
+func validateWithinFrameFlowStep(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if len(step.Args) > 0 {
+		return fmt.Errorf("step %s action %q does not support args; use named fields frame and steps", stepPath, step.Action)
+	}
+	for name := range step.presentNamedParams() {
+		if name != "steps" {
+			return fmt.Errorf("step %s action %q does not accept parameter %q", stepPath, step.Action, name)
+		}
+	}
+	if step.Frame == "" {
+		return fmt.Errorf("step %s action %q requires %q", stepPath, step.Action, "frame")
+	}
+	if err := validateFlowFrameValue(stepPath, step, knownVars); err != nil {
+		return err
+	}
+	if len(step.Steps) == 0 {
+		return fmt.Errorf("step %s action %q requires nested steps", stepPath, step.Action)
+	}
+	// Nested steps share the caller's variables, like retry.
+	return validateFlowStepSequence(step.Steps, knownVars, stepPath)
+}
+
+// runFlowWithinFrameStep runs the nested steps with every selector scoped to
+// the frame. Blocks nest: an inner frame chain continues inside the outer one.
+func runFlowWithinFrameStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath string) (any, []FlowStepTrace, error) {
+	frame, err := resolveFlowStepFrame(ctx, step.Frame)
+	if err != nil {
+		return nil, nil, fmt.Errorf("within_frame %w", err)
+	}
+	child := *ctx
+	child.Frames = append(append([]string(nil), ctx.Frames...), frame)
+	// The frame scope only exists inside the block, so a failed block re-runs
+	// as a whole on resume.
+	child.Checkpoint = nil
+	children, err := runFlowStepSequence(L, &child, step.Steps, stepPath, 0, 0)
+	if err != nil {
+		return nil, children, err
+	}
+	return map[string]any{"frame": frame}, children, nil
+}
+
+func resolveFlowStepFrame(ctx *FlowContext, frame string) (string, error) {
+	resolved, err := resolveValue(frame, ctx)
+	if err != nil {
+		return "", err
+	}
+	text, ok := resolved.(string)
+	if !ok {
+		return "", fmt.Errorf("frame must be a string")
+	}
+	if _, err := parseFlowFrameTarget(text); err != nil {
+		return "", fmt.Errorf("frame %w", err)
+	}
+	return text, nil
+}
+
+// scopeFlowStepToFrame rewrites the selector of a selector action inside
+// within_frame or with a frame parameter into a frame-piercing selector, so
+// every Lua action and Flow runner works inside iframes unchanged. A step
+// without a selector targets the frame's document. Steps a runner derives from
+// its own, already scoped selector go through runScopedFlowStep instead.
+func scopeFlowStepToFrame(L *lua.LState, ctx *FlowContext, step FlowStep) (FlowStep, error) {
+	if (step.Frame == "" && len(ctx.Frames) == 0) || !flowActionAcceptsFrame(step.Action) {
+		return step, nil
+	}
+	selector := "html"
+	if value, ok := step.param("selector"); ok {
+		selector = fmt.Sprint(value)
+	}
+	frames := append([]string(nil), ctx.Frames...)
+	if step.Frame != "" {
+		frame, err := resolveFlowStepFrame(ctx, step.Frame)
+		if err != nil {
+			return step, err
+		}
+		frames = append(frames, frame)
+	}
+	chain := []string{}
+	for _, frame := range frames {
+		target, err := parseFlowFrameTarget(frame)
+		if err != nil {
+			return step, fmt.Errorf("frame %w", err)
+		}
+		if target.chain != nil {
+			chain = append(chain, target.chain...)
+			continue
+		}
+		// name= and url= pick a frame anywhere on the page, so they replace
+		// the enclosing chain.
+		page, err := pageFromLuaState(L)
+		if err != nil {
+			return step, err
+		}
+		found, err := findFlowFrame(ctx, page, target)
+		if err != nil {
+			return step, err
+		}
+		if chain, err = flowFrameSelectorChain(found); err != nil {
+			return step, fmt.Errorf("frame %s: %w", target, err)
+		}
+	}
+	return step.withSelector(composeFlowFrameSelector(chain, selector)), nil
+}
+
+func composeFlowFrameSelector(chain []string, selector string) string {
+	parts := make([]string, 0, len(chain)*2+1)
+	for _, frame := range chain {
+		parts = append(parts, frame, flowFrameEnterControl)
+	}
+	return strings.Join(append(parts, selector), " >> ")
+}
+
+// withSelector replaces the selector wherever step.param reads it from.
+func (step FlowStep) withSelector(selector string) FlowStep {
+	if _, ok := step.With["selector"]; ok {
+		with := make(map[string]any, len(step.With))
+		for name, value := range step.With {
+			with[name] = value
+		}
+		with["selector"] = selector
+		step.With = with
+		return step
+	}
+	if len(step.Args) > 0 {
+		args := append([]any(nil), step.Args...)
+		args[0] = selector
+		step.Args = args
+		return step
+	}
+	step.Selector = selector
+	return step
+}
+
+// findFlowFrame waits for a child frame matching a name= or url= target; the
+// iframe may still be loading when the step starts.
+func findFlowFrame(ctx *FlowContext, page playwright.Page, target flowFrameTarget) (playwright.Frame, error) {
+	deadline := time.Now().Add(flowFrameLookupTimeout)
+	var cancelled <-chan struct{}
+	if ctx != nil && ctx.Context != nil {
+		cancelled = ctx.Context.Done()
+	}
+	for {
+		seen := []string{}
+		for _, frame := range page.Frames() {
+			if frame.ParentFrame() == nil || frame.IsDetached() {
+				continue
+			}
+			if target.matches(frame) {
+				return frame, nil
+			}
+			seen = append(seen, fmt.Sprintf("name=%q url=%q", frame.Name(), frame.URL()))
+		}
+		if time.Now().After(deadline) {
+			if len(seen) == 0 {
+				return nil, fmt.Errorf("no frame matches %s after %s; the page has no iframes", target, flowFrameLookupTimeout)
+			}
+			return nil, fmt.Errorf("no frame matches %s after %s; frames on the page: %s", target, flowFrameLookupTimeout, strings.Join(seen, ", "))
+		}
+		select {
+		case <-cancelled:
+			return nil, ctx.Context.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// flowFrameSelectorChain returns the iframe selectors leading from the top
+// page to frame, outermost first. The main frame has an empty chain.
+func flowFrameSelectorChain(frame playwright.Frame) ([]string, error) {
+	chain := []string{}
+	for ; frame != nil && frame.ParentFrame() != nil; frame = frame.ParentFrame() {
+		element, err := frame.FrameElement()
+		if err != nil {
+			return nil, fmt.Errorf("locate iframe element: %w", err)
+		}
+		value, err := element.Evaluate(flowFrameSelectorScript)
+		element.Dispose()
+		if err != nil {
+			return nil, fmt.Errorf("describe iframe element: %w", err)
+		}
+		selector, _ := value.(string)
+		if selector == "" {
+			return nil, fmt.Errorf("describe iframe element: empty selector")
+		}
+		chain = append([]string{selector}, chain...)
+	}
+	return chain, nil
+}
@@ -0,0 +1,175 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlowFrameValidationAndSelectorScoping(t *testing.T) {
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: erp_invoice
+vars:
+  form_frame: iframe[name="form"]
+steps:
+  - action: within_frame
+    frame: iframe#workspace
+    steps:
+      - action: type_text
+        frame: "{{form_frame}}"
+        selector: "#amount"
+        text: "42"
+      - action: click
+        args: ["#save"]
+  - action: click
+    frame: url=**/dialog.html
+    selector: text=OK
+`), "yaml")
+	if err != nil {
+		t.Fatalf("ParseFlow returned error: %v", err)
+	}
+	if err := ValidateFlow(flow); err != nil {
+		t.Fatalf("ValidateFlow returned error: %v", err)
+	}
+	if flow.Steps[0].Frame != "iframe#workspace" || flow.Steps[0].Steps[0].Frame != "{{form_frame}}" {
+		t.Fatalf("expected frame fields to be parsed, got %#v", flow.Steps[0])
+	}
+
+	for name, yaml := range map[string]string{
+		"non-selector action": "  - action: navigate\n    url: https://example.com\n    frame: iframe#main\n",
+		"missing steps":       "  - action: within_frame\n    frame: iframe#main\n",
+		"missing frame":       "  - action: within_frame\n    steps:\n      - action: click\n        selector: '#save'\n",
+		"empty chain part":    "  - action: click\n    selector: '#save'\n    frame: iframe#outer >> >> iframe#inner\n",
+		"blank name":          "  - action: click\n    selector: '#save'\n    frame: 'name= '\n",
+		"extra parameter":     "  - action: within_frame\n    frame: iframe#main\n    timeout: 5\n    steps:\n      - action: click\n        selector: '#save'\n",
+	} {
+		flow, err := ParseFlow([]byte("schema_version: \"1\"\nname: bad_frame\nsteps:\n"+yaml), "yaml")
+		if err != nil {
+			t.Fatalf("ParseFlow(%s) returned error: %v", name, err)
+		}
+		if err := ValidateFlow(flow); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+
+	ctx := &FlowContext{
+		Vars:   map[string]any{"form_frame": `iframe[name="form"]`},
+		Frames: []string{"iframe#workspace"},
+	}
+	scoped, err := scopeFlowStepToFrame(nil, ctx, flow.Steps[0].Steps[0])
+	if err != nil {
+		t.Fatalf("scopeFlowStepToFrame returned error: %v", err)
+	}
+	want := `iframe#workspace >> internal:control=enter-frame >> iframe[name="form"] >> internal:control=enter-frame >> #amount`
+	if scoped.Selector != want {
+		t.Fatalf("scoped selector = %q, want %q", scoped.Selector, want)
+	}
+	scoped, err = scopeFlowStepToFrame(nil, ctx, flow.Steps[0].Steps[1])
+	if err != nil || scoped.Args[0] != "iframe#workspace >> internal:control=enter-frame >> #save" || flow.Steps[0].Steps[1].Args[0] != "#save" {
+		t.Fatalf("expected the positional selector to be scoped on a copy, got %#v, %v", scoped.Args, err)
+	}
+	framed := FlowStep{Action: "click", Selector: "iframe#inner >> internal:control=enter-frame >> #save"}
+	if scoped, err = scopeFlowStepToFrame(nil, ctx, framed); err != nil || scoped.Selector != "iframe#workspace >> internal:control=enter-frame >> "+framed.Selector {
+		t.Fatalf("expected a frame-entering selector to stay inside the enclosing frame, got %q, %v", scoped.Selector, err)
+	}
+	scoped, err = scopeFlowStepToFrame(nil, ctx, FlowStep{Action: "get_html"})
+	if err != nil || scoped.Selector != "iframe#workspace >> internal:control=enter-frame >> html" {
+		t.Fatalf("expected a step without selector to target the frame document, got %q, %v", scoped.Selector, err)
+	}
+	unscoped := FlowStep{Action: "navigate", URL: "https://example.com"}
+	if scoped, err = scopeFlowStepToFrame(nil, ctx, unscoped); err != nil || scoped.Selector != "" {
+		t.Fatalf("expected non-selector actions to run unchanged, got %#v, %v", scoped, err)
+	}
+}
+
+func TestBuildDraftFlowScopesStepsToObservedFrame(t *testing.T) {
+	observation := &PageObservation{
+		URL:          "https://erp.example.com/",
+		Title:        "ERP",
+		ArtifactRoot: t.TempDir(),
+		Elements: []PageObservationElement{
+			{
+				Index:              1,
+				Tag:                "button",
+				Type:               "button",
+				ID:                 "save",
+				Text:               "Save draft",
+				Visible:            true,
+				Enabled:            true,
+				SelectorCandidates: []string{`#save`},
+			},
+			{
+				Index:              2,
+				Tag:                "button",
+				Type:               "button",
+				Text:               "Submit invoice",
+				Visible:            true,
+				Enabled:            true,
+				SelectorCandidates: []string{`#save`, `[data-testid="submit-invoice"]`},
+				Frame:              `iframe[id="workspace"] >> iframe[name="form"]`,
+				FrameURL:           "https://erp.example.com/invoice/form.html",
+			},
+		},
+	}
+
+	draft, err := BuildDraftFlow(FlowDraftOptions{
+		Intent:      "提交发票",
+		Observation: observation,
+	})
+	if err != nil {
+		t.Fatalf("build draft flow: %v", err)
+	}
+	if !strings.Contains(draft.FlowYAML, `frame: iframe[id="workspace"] >> iframe[name="form"]`) {
+		t.Fatalf("expected a frame-scoped submit step: %s", draft.FlowYAML)
+	}
+	flow, err := ParseFlow([]byte(draft.FlowYAML), "yaml")
+	if err != nil {
+		t.Fatalf("parse drafted flow: %v", err)
+	}
+	if err := ValidateFlow(flow); err != nil {
+		t.Fatalf("validate drafted flow: %v", err)
+	}
+
+	// The #save selector in the frame must not be repaired with the main-page
+	// element that shares it.
+	steps := []FlowStep{{Action: "click", Selector: "#save", Frame: observation.Elements[1].Frame}}
+	repairs := []FlowDraftSelectorRepair{}
+	repairDraftSelectorStepSequence(steps, "", "", buildDraftSelectorRepairTargets(observation.Elements, nil), &repairs)
+	if len(repairs) != 1 || repairs[0].ObservedIndex != 2 || steps[0].Selector != `[data-testid="submit-invoice"]` {
+		t.Fatalf("expected the frame element to drive the repair, got %#v and %#v", repairs, steps)
+	}
+}
+
+func TestRunFlowWaitForTextInsideFrame(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/workspace" {
+			fmt.Fprint(w, `<div id="status">pending</div><script>setTimeout(() => { document.getElementById("status").textContent = " Bob's order shipped " }, 300)</script>`)
+			return
+		}
+		fmt.Fprint(w, `<iframe id="workspace" src="/workspace"></iframe>`)
+	}))
+	defer server.Close()
+
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: frame_wait_for_text
+steps:
+  - action: navigate
+    url: `+server.URL+`
+  - action: wait_for_text
+    frame: iframe#workspace
+    args: ["#status", "Bob's order shipped", 5000]
+  - action: lua
+    code: wait_for_text("iframe#workspace >> internal:control=enter-frame >> #status", "Bob's order shipped", 1000)
+`), "yaml")
+	if err != nil {
+		t.Fatalf("ParseFlow returned error: %v", err)
+	}
+	if _, err := RunFlow(flow, FlowRunOptions{Headless: true}); err != nil {
+		t.Fatalf("expected wait_for_text to see the text inside the frame, got %v", err)
+	}
+}
@@ -52,10 +52,12 @@ var (
 type flowDocumentContext string
 
 const (
-	flowContextRoot     flowDocumentContext = "flow"
-	flowContextBrowser  flowDocumentContext = "browser"
-	flowContextViewport flowDocumentContext = "viewport"
-	flowContextStep     flowDocumentContext = "step"
+	flowContextRoot      flowDocumentContext = "flow"
+	flowContextBrowser   flowDocumentContext = "browser"
+	flowContextViewport  flowDocumentContext = "viewport"
+	flowContextStep      flowDocumentContext = "step"
+	flowContextFragment  flowDocumentContext = "fragment"
+	flowContextParameter flowDocumentContext = "parameter"
 )
 
 var flowFieldAliasHints = map[flowDocumentContext]map[string]FlowIssue{
@@ -314,6 +316,40 @@ func validateGenericObject(context flowDocumentContext, object map[string]any, p
 				if issue, err := validateGenericStepList(value, "", joinDocPath(path, key)); issue != nil || err != nil {
 					return issue, err
 				}
+			case "fragments":
+				fragments, ok := value.(map[string]any)
+				if !ok {
+					continue
+				}
+				for _, name := range sortedMapKeys(fragments) {
+					child, ok := fragments[name].(map[string]any)
+					if !ok {
+						continue
+					}
+					if issue, err := validateGenericObject(flowContextFragment, child, joinDocPath(joinDocPath(path, key), name), flowFragmentPath(name)); issue != nil || err != nil {
+						return issue, err
+					}
+				}
+			case "parameters":
+				parameters, ok := value.(map[string]any)
+				if !ok {
+					continue
+				}
+				for _, name := range sortedMapKeys(parameters) {
+					child, ok := parameters[name].(map[string]any)
+					if !ok {
+						continue
+					}
+					if issue, err := validateGenericObject(flowContextParameter, child, joinDocPath(joinDocPath(path, key), name), ""); issue != nil || err != nil {
+						return issue, err
+					}
+				}
+			}
+		case flowContextFragment:
+			if key == "steps" {
+				if issue, err := validateGenericStepList(value, stepPath, joinDocPath(path, key)); issue != nil || err != nil {
+					return issue, err
+				}
 			}
 		case flowContextBrowser:
 			if key == "viewport" {
@@ -403,6 +439,32 @@ func validateYAMLObject(context flowDocumentContext, node *yaml.Node, path strin
 				if issue, err := validateYAMLStepList(valueNode, "", joinDocPath(path, key)); issue != nil || err != nil {
 					return issue, err
 				}
+			case "fragments":
+				if valueNode == nil || valueNode.Kind != yaml.MappingNode {
+					continue
+				}
+				for j := 0; j+1 < len(valueNode.Content); j += 2 {
+					name := valueNode.Content[j].Value
+					if issue, err := validateYAMLObject(flowContextFragment, valueNode.Content[j+1], joinDocPath(joinDocPath(path, key), name), flowFragmentPath(name)); issue != nil || err != nil {
+						return issue, err
+					}
+				}
+			case "parameters":
+				if valueNode == nil || valueNode.Kind != yaml.MappingNode {
+					continue
+				}
+				for j := 0; j+1 < len(valueNode.Content); j += 2 {
+					name := valueNode.Content[j].Value
+					if issue, err := validateYAMLObject(flowContextParameter, valueNode.Content[j+1], joinDocPath(joinDocPath(path, key), name), ""); issue != nil || err != nil {
+						return issue, err
+					}
+				}
+			}
+		case flowContextFragment:
+			if key == "steps" {
+				if issue, err := validateYAMLStepList(valueNode, stepPath, joinDocPath(path, key)); issue != nil || err != nil {
+					return issue, err
+				}
 			}
 		case flowContextBrowser:
 			if key == "viewport" {
@@ -731,6 +793,10 @@ func buildUnknownFieldMessage(context flowDocumentContext, issue *FlowIssue) str
 		prefix = "flow.browser"
 	case flowContextViewport:
 		prefix = "flow.browser.viewport"
+	case flowContextFragment:
+		prefix = "flow." + issue.StepPath
+	case flowContextParameter:
+		prefix = "flow." + strings.TrimSuffix(issue.Path, "."+issue.Field)
 	case flowContextStep:
 		if issue.StepPath != "" {
 			prefix = fmt.Sprintf("step %s", issue.StepPath)
@@ -785,6 +851,10 @@ func flowAllowedFieldListForContext(context flowDocumentContext) []string {
 		return structFieldNames(reflect.TypeOf(FlowViewport{}))
 	case flowContextStep:
 		return structFieldNames(reflect.TypeOf(FlowStep{}))
+	case flowContextFragment:
+		return structFieldNames(reflect.TypeOf(FlowFragment{}))
+	case flowContextParameter:
+		return structFieldNames(reflect.TypeOf(FlowParameter{}))
 	default:
 		return nil
 	}
@@ -794,6 +864,10 @@ func structFieldNames(typ reflect.Type) []string {
 	fields := make([]string, 0, typ.NumField())
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, structFieldNames(field.Type)...)
+			continue
+		}
 		tag := field.Tag.Get("json")
 		name := strings.TrimSpace(strings.Split(tag, ",")[0])
 		if name == "" || name == "-" {
@@ -814,22 +888,37 @@ func flowAllowedParamsForAction(action string) []string {
 	for _, arg := range spec.Args {
 		params = append(params, arg.Name)
 	}
+	if flowActionAcceptsFrame(action) {
+		params = append(params, "frame")
+	}
 	if spec.VarArgName != "" {
 		params = append(params, spec.VarArgName)
 	}
 	switch action {
 	case "set_var", "append_var":
 		params = append(params, "save_as", "value", "with.value")
+	case "expr":
+		params = []string{"value", "save_as"}
 	case "retry":
 		params = []string{"times", "interval_ms", "steps"}
 	case "if":
 		params = []string{"condition", "then", "else"}
 	case "foreach":
-		params = []string{"items", "item_var", "index_var", "steps"}
+		params = []string{"items", "item_var", "index_var", "concurrency", "steps"}
 	case "on_error":
 		params = []string{"steps", "on_error"}
 	case "wait_until":
 		params = []string{"condition", "timeout", "interval_ms"}
+	case "while", "repeat_until":
+		params = []string{"condition", "steps", "max_iterations", "interval_ms"}
+	case "break", "continue":
+		params = []string{}
+	case "call_flow":
+		params = []string{"fragment", "file_path", "inputs", "outputs"}
+	case "wait_for_response", "capture_responses":
+		params = append(params, "steps")
+	case "within_frame":
+		params = []string{"frame", "steps"}
 	case "write_csv":
 		params = []string{"file_path", "value", "with.headers"}
 	case "write_excel":
@@ -0,0 +1,226 @@
+package tsplay_core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const defaultFlowLoopMaxIterations = 100
+
+// flowLoopControl is returned by break and continue steps. It unwinds the
+// enclosing step sequences like an error until the nearest loop consumes it,
+// but it is never reported as a step failure.
+type flowLoopControl struct {
+	action string
+}
+
+func (control *flowLoopControl) Error() string {
+	return fmt.Sprintf("%s used outside of a loop", control.action)
+}
+
+func asFlowLoopControl(err error) (*flowLoopControl, bool) {
+	var control *flowLoopControl
+	if errors.As(err, &control) {
+		return control, true
+	}
+	return nil, false
+}
+
+func isFlowLoopControl(err error) bool {
+	_, ok := asFlowLoopControl(err)
+	return ok
+}
+
+func validateWhileFlowStep(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if len(step.Args) > 0 {
+		return fmt.Errorf("step %s action %q does not support args; use condition, steps, max_iterations, and interval_ms", stepPath, step.Action)
+	}
+	present := step.presentNamedParams()
+	allowed := map[string]bool{"condition": true, "steps": true, "max_iterations": true, "interval_ms": true}
+	for name, value := range present {
+		if !allowed[name] {
+			return fmt.Errorf("step %s action %q does not accept parameter %q", stepPath, step.Action, name)
+		}
+		if name == "condition" || name == "steps" {
+			continue
+		}
+		if err := validateFlowParamValue(stepPath, step.Action, name, value, knownVars); err != nil {
+			return err
+		}
+	}
+	if step.Condition == nil {
+		return fmt.Errorf("step %s action %q requires condition", stepPath, step.Action)
+	}
+	if len(step.Steps) == 0 {
+		return fmt.Errorf("step %s action %q requires nested steps", stepPath, step.Action)
+	}
+	if value, ok := step.param("max_iterations"); ok && len(flowReferences(value)) == 0 {
+		maxIterations, err := intParam(value)
+		if err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "max_iterations", err)
+		}
+		if maxIterations < 1 {
+			return fmt.Errorf("step %s action %q parameter %q must be at least 1", stepPath, step.Action, "max_iterations")
+		}
+	}
+	if value, ok := step.param("interval_ms"); ok && len(flowReferences(value)) == 0 {
+		intervalMS, err := intParam(value)
+		if err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "interval_ms", err)
+		}
+		if intervalMS < 0 {
+			return fmt.Errorf("step %s action %q parameter %q must be at least 0", stepPath, step.Action, "interval_ms")
+		}
+	}
+
+	localVars := copyKnownVars(knownVars)
+	if step.Action == "while" {
+		if err := validateFlowStepSequence([]FlowStep{*step.Condition}, copyKnownVars(knownVars), stepPath+".condition"); err != nil {
+			return err
+		}
+		return validateFlowStepSequence(step.Steps, localVars, stepPath)
+	}
+	// repeat_until checks its condition after the body, so the condition may
+	// read variables the body saved.
+	if err := validateFlowStepSequence(step.Steps, localVars, stepPath); err != nil {
+		return err
+	}
+	return validateFlowStepSequence([]FlowStep{*step.Condition}, localVars, stepPath+".condition")
+}
+
+func validateLoopControlFlowStep(stepPath string, step FlowStep) error {
+	if len(step.Args) > 0 {
+		return fmt.Errorf("step %s action %q does not support args", stepPath, step.Action)
+	}
+	for name := range step.presentNamedParams() {
+		return fmt.Errorf("step %s action %q does not accept parameter %q", stepPath, step.Action, name)
+	}
+	if step.ContinueOnError {
+		return fmt.Errorf("step %s action %q does not accept continue_on_error", stepPath, step.Action)
+	}
+	return nil
+}
+
+// validateFlowLoopControl checks that break and continue only appear inside a
+// loop body. Conditions, db_transaction blocks, and called fragments start a
+// new scope, so loop control cannot escape them.
+func validateFlowLoopControl(steps []FlowStep, parentPath string, loopAction string) error {
+	for i, step := range steps {
+		stepPath := flowStepPath(parentPath, i+1)
+		if step.Action == "break" || step.Action == "continue" {
+			if loopAction == "" {
+				return fmt.Errorf("step %s action %q must be inside a while, repeat_until, or foreach loop", stepPath, step.Action)
+			}
+			if step.Action == "break" && loopAction == "concurrent foreach" {
+				return fmt.Errorf("step %s action %q cannot be used inside foreach with concurrency", stepPath, step.Action)
+			}
+		}
+		if err := forEachNestedFlowStepSequence(step, stepPath, func(nestedSteps []FlowStep, nestedPath string) error {
+			return validateFlowLoopControl(nestedSteps, nestedPath, nestedFlowLoopAction(step, nestedPath, loopAction))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nestedFlowLoopAction(step FlowStep, nestedPath string, loopAction string) string {
+	if strings.HasSuffix(nestedPath, ".condition") {
+		return ""
+	}
+	switch step.Action {
+	case "while", "repeat_until":
+		return step.Action
+	case "foreach":
+		if value, ok := step.param("concurrency"); ok {
+			if concurrency, err := intParam(value); err == nil && concurrency > 1 {
+				return "concurrent foreach"
+			}
+		}
+		return step.Action
+	case "db_transaction":
+		return ""
+	default:
+		return loopAction
+	}
+}
+
+func runFlowWhileStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath string) (any, []FlowStepTrace, error) {
+	if step.Condition == nil {
+		return nil, nil, fmt.Errorf("%s requires condition", step.Action)
+	}
+	maxIterations, err := flowStepOptionalIntParam(ctx, step, "max_iterations")
+	if err != nil {
+		return nil, nil, err
+	}
+	if maxIterations == 0 {
+		maxIterations = defaultFlowLoopMaxIterations
+	}
+	if maxIterations < 0 {
+		return nil, nil, fmt.Errorf("%s max_iterations must be at least 1", step.Action)
+	}
+	intervalMS, err := flowStepOptionalIntParam(ctx, step, "interval_ms")
+	if err != nil {
+		return nil, nil, err
+	}
+	if intervalMS < 0 {
+		return nil, nil, fmt.Errorf("%s interval_ms must be at least 0", step.Action)
+	}
+
+	checkCondition := func(iteration int) (bool, FlowStepTrace) {
+		conditionTrace, err := runFlowStepWithTrace(L, ctx, *step.Condition, 0, stepPath+".condition", 0, iteration)
+		return err == nil && flowValueTruthy(conditionTrace.Output), conditionTrace
+	}
+	result := func(iterations int, stoppedBy string) map[string]any {
+		return map[string]any{
+			"iterations": iterations,
+			"status":     "completed",
+			"stopped_by": stoppedBy,
+		}
+	}
+
+	children := []FlowStepTrace{}
+	for iteration := 1; ; iteration++ {
+		if err := flowRunContextError(ctx); err != nil {
+			return nil, children, err
+		}
+		if step.Action == "while" {
+			ok, conditionTrace := checkCondition(iteration)
+			children = append(children, conditionTrace)
+			if !ok {
+				return result(iteration-1, "condition"), children, nil
+			}
+		}
+		if iteration > maxIterations {
+			return nil, children, fmt.Errorf("%s exceeded max_iterations %d", step.Action, maxIterations)
+		}
+		traces, err := runFlowStepSequence(L, ctx, step.Steps, fmt.Sprintf("%s[%d]", stepPath, iteration), 0, iteration)
+		children = append(children, traces...)
+		if control, ok := asFlowLoopControl(err); ok {
+			if control.action == "break" {
+				return result(iteration, "break"), children, nil
+			}
+		} else if err != nil {
+			return nil, children, err
+		}
+		if step.Action == "repeat_until" {
+			ok, conditionTrace := checkCondition(iteration)
+			children = append(children, conditionTrace)
+			if ok {
+				return result(iteration, "condition"), children, nil
+			}
+			if iteration >= maxIterations {
+				return nil, children, fmt.Errorf("%s exceeded max_iterations %d", step.Action, maxIterations)
+			}
+		}
+		if intervalMS > 0 {
+			if err := sleepWithFlowContext(ctx, time.Duration(intervalMS)*time.Millisecond); err != nil {
+				return nil, children, err
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package tsplay_core
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+const flowPerformanceSlowestSteps = 5
+
+// FlowStepMetrics counts what the page did while one step ran. Control steps
+// include the activity of their nested steps. Bytes is the sum of the
+// Content-Length response headers, so chunked responses are not counted.
+type FlowStepMetrics struct {
+	Requests       int   `json:"requests"`
+	FailedRequests int   `json:"failed_requests"`
+	Bytes          int64 `json:"bytes"`
+	ConsoleErrors  int   `json:"console_errors"`
+	PageErrors     int   `json:"page_errors"`
+}
+
+func (metrics FlowStepMetrics) empty() bool {
+	return metrics == FlowStepMetrics{}
+}
+
+// FlowPerformanceSummary is the run-level view of the step metrics.
+type FlowPerformanceSummary struct {
+	DurationMS   int64                 `json:"duration_ms"`
+	Steps        int                   `json:"steps"`
+	Totals       FlowStepMetrics       `json:"totals"`
+	SlowestSteps []FlowStepPerformance `json:"slowest_steps,omitempty"`
+}
+
+type FlowStepPerformance struct {
+	Path       string           `json:"path"`
+	Action     string           `json:"action"`
+	Name       string           `json:"name,omitempty"`
+	DurationMS int64            `json:"duration_ms"`
+	Metrics    *FlowStepMetrics `json:"metrics,omitempty"`
+}
+
+// flowStepMetricsRecorder counts the page activity of the run's browser
+// context. It keeps running totals only, so a long run costs no memory; a step
+// reads the difference between its start and end. A nil recorder records
+// nothing, which is the case for flows that never open a browser.
+type flowStepMetricsRecorder struct {
+	mu     sync.Mutex
+	totals FlowStepMetrics
+	detach func()
+}
+
+type flowStepMetricsMark FlowStepMetrics
+
+func startFlowStepMetrics(context playwright.BrowserContext) *flowStepMetricsRecorder {
+	if context == nil {
+		return nil
+	}
+	recorder := &flowStepMetricsRecorder{}
+	onRequest := func(playwright.Request) {
+		recorder.record(func(totals *FlowStepMetrics) { totals.Requests++ })
+	}
+	onResponse := func(response playwright.Response) {
+		if response == nil {
+			return
+		}
+		recorder.recordResponse(response.Status(), response.Headers())
+	}
+	onRequestFailed := func(playwright.Request) {
+		recorder.record(func(totals *FlowStepMetrics) { totals.FailedRequests++ })
+	}
+	onConsole := func(message playwright.ConsoleMessage) {
+		if message != nil && workbenchConsoleLevel(message.Type()) == "error" {
+			recorder.record(func(totals *FlowStepMetrics) { totals.ConsoleErrors++ })
+		}
+	}
+	onWebError := func(playwright.WebError) {
+		recorder.record(func(totals *FlowStepMetrics) { totals.PageErrors++ })
+	}
+	context.OnRequest(onRequest)
+	context.OnResponse(onResponse)
+	context.OnRequestFailed(onRequestFailed)
+	context.OnConsole(onConsole)
+	context.OnWebError(onWebError)
+	recorder.detach = func() {
+		context.RemoveListener("request", onRequest)
+		context.RemoveListener("response", onResponse)
+		context.RemoveListener("requestfailed", onRequestFailed)
+		context.RemoveListener("console", onConsole)
+		context.RemoveListener("weberror", onWebError)
+	}
+	return recorder
+}
+
+func (recorder *flowStepMetricsRecorder) record(update func(totals *FlowStepMetrics)) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	update(&recorder.totals)
+}
+
+func (recorder *flowStepMetricsRecorder) recordResponse(status int, headers map[string]string) {
+	length, err := strconv.ParseInt(strings.TrimSpace(headers["content-length"]), 10, 64)
+	recorder.record(func(totals *FlowStepMetrics) {
+		if status >= 400 {
+			totals.FailedRequests++
+		}
+		if err == nil && length > 0 {
+			totals.Bytes += length
+		}
+	})
+}
+
+func (recorder *flowStepMetricsRecorder) mark() flowStepMetricsMark {
+	if recorder == nil {
+		return flowStepMetricsMark{}
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return flowStepMetricsMark(recorder.totals)
+}
+
+// since returns the metrics recorded after mark, or nil when the page was
+// idle or nothing is recorded for the run.
+func (recorder *flowStepMetricsRecorder) since(mark flowStepMetricsMark) *FlowStepMetrics {
+	if recorder == nil {
+		return nil
+	}
+	recorder.mu.Lock()
+	metrics := recorder.totals
+	recorder.mu.Unlock()
+	metrics.add(FlowStepMetrics{
+		Requests:       -mark.Requests,
+		FailedRequests: -mark.FailedRequests,
+		Bytes:          -mark.Bytes,
+		ConsoleErrors:  -mark.ConsoleErrors,
+		PageErrors:     -mark.PageErrors,
+	})
+	if metrics.empty() {
+		return nil
+	}
+	return &metrics
+}
+
+func (recorder *flowStepMetricsRecorder) stop() {
+	if recorder == nil || recorder.detach == nil {
+		return
+	}
+	recorder.detach()
+	recorder.detach = nil
+}
+
+// buildFlowPerformanceSummary totals the metrics of the top-level steps, which
+// already include their nested steps, and lists the slowest leaf steps.
+func buildFlowPerformanceSummary(traces []FlowStepTrace, durationMS int64) *FlowPerformanceSummary {
+	summary := &FlowPerformanceSummary{DurationMS: durationMS}
+	for _, trace := range traces {
+		if trace.Metrics != nil {
+			summary.Totals.add(*trace.Metrics)
+		}
+	}
+	leaves := []FlowStepPerformance{}
+	collectFlowLeafStepPerformance(traces, &leaves)
+	summary.Steps = len(leaves)
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return leaves[i].DurationMS > leaves[j].DurationMS
+	})
+	if len(leaves) > flowPerformanceSlowestSteps {
+		leaves = leaves[:flowPerformanceSlowestSteps]
+	}
+	summary.SlowestSteps = leaves
+	return summary
+}
+
+func collectFlowLeafStepPerformance(traces []FlowStepTrace, leaves *[]FlowStepPerformance) {
+	for _, trace := range traces {
+		nested := trace.Children
+		if len(trace.Attempts) > 0 {
+			nested = append(append([]FlowStepTrace(nil), trace.Attempts...), trace.Children...)
+		}
+		if trace.Condition != nil {
+			nested = append([]FlowStepTrace{*trace.Condition}, nested...)
+		}
+		if len(nested) > 0 {
+			collectFlowLeafStepPerformance(nested, leaves)
+			continue
+		}
+		*leaves = append(*leaves, FlowStepPerformance{
+			Path:       trace.Path,
+			Action:     trace.Action,
+			Name:       trace.Name,
+			DurationMS: trace.DurationMS,
+			Metrics:    trace.Metrics,
+		})
+	}
+}
+
+func (metrics *FlowStepMetrics) add(other FlowStepMetrics) {
+	metrics.Requests += other.Requests
+	metrics.FailedRequests += other.FailedRequests
+	metrics.Bytes += other.Bytes
+	metrics.ConsoleErrors += other.ConsoleErrors
+	metrics.PageErrors += other.PageErrors
+}
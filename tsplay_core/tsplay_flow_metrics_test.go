@@ -0,0 +1,75 @@
+package tsplay_core
+
+import "testing"
+
+func TestFlowStepMetricsRecorderCountsSinceMark(t *testing.T) {
+	recorder := &flowStepMetricsRecorder{}
+	recorder.record(func(totals *FlowStepMetrics) { totals.Requests++ })
+	recorder.recordResponse(200, map[string]string{"content-length": "80"})
+	mark := recorder.mark()
+
+	for i := 0; i < 4; i++ {
+		recorder.record(func(totals *FlowStepMetrics) { totals.Requests++ })
+	}
+	recorder.recordResponse(200, map[string]string{"content-length": "1200"})
+	recorder.recordResponse(500, map[string]string{"content-length": "34"})
+	recorder.record(func(totals *FlowStepMetrics) { totals.FailedRequests++ })
+	recorder.recordResponse(200, map[string]string{"transfer-encoding": "chunked"})
+	recorder.record(func(totals *FlowStepMetrics) { totals.ConsoleErrors++ })
+	recorder.record(func(totals *FlowStepMetrics) { totals.PageErrors++ })
+
+	want := FlowStepMetrics{Requests: 4, FailedRequests: 2, Bytes: 1234, ConsoleErrors: 1, PageErrors: 1}
+	if got := recorder.since(mark); got == nil || *got != want {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+	if got := recorder.since(recorder.mark()); got != nil {
+		t.Fatalf("expected nil metrics for an idle step, got %#v", got)
+	}
+
+	var missing *flowStepMetricsRecorder
+	if got := missing.since(missing.mark()); got != nil {
+		t.Fatalf("expected nil metrics without a recorder, got %#v", got)
+	}
+}
+
+func TestBuildFlowPerformanceSummary(t *testing.T) {
+	traces := []FlowStepTrace{
+		{Path: "1", Action: "navigate", DurationMS: 900, Metrics: &FlowStepMetrics{Requests: 12, Bytes: 4096}},
+		{
+			Path: "2", Action: "retry", DurationMS: 700,
+			Metrics: &FlowStepMetrics{Requests: 3, FailedRequests: 1, ConsoleErrors: 1},
+			Attempts: []FlowStepTrace{
+				{Path: "2.1", Action: "click", DurationMS: 200, Metrics: &FlowStepMetrics{Requests: 1, FailedRequests: 1}},
+				{Path: "2.1", Attempt: 2, Action: "click", DurationMS: 450, Metrics: &FlowStepMetrics{Requests: 2, ConsoleErrors: 1}},
+			},
+		},
+		{
+			Path: "3", Action: "if", DurationMS: 20,
+			Condition: &FlowStepTrace{Path: "3.condition", Action: "is_visible", DurationMS: 15},
+			Children:  []FlowStepTrace{{Path: "3.1", Action: "set_var", DurationMS: 1}},
+		},
+		{Path: "4", Action: "set_var", DurationMS: 0},
+		{Path: "5", Action: "extract_text", DurationMS: 30},
+	}
+	summary := buildFlowPerformanceSummary(traces, 1700)
+	if summary.DurationMS != 1700 || summary.Steps != 7 {
+		t.Fatalf("unexpected summary %#v", summary)
+	}
+	wantTotals := FlowStepMetrics{Requests: 15, FailedRequests: 1, Bytes: 4096, ConsoleErrors: 1}
+	if summary.Totals != wantTotals {
+		t.Fatalf("expected totals %#v, got %#v", wantTotals, summary.Totals)
+	}
+	if len(summary.SlowestSteps) != flowPerformanceSlowestSteps {
+		t.Fatalf("expected %d slowest steps, got %#v", flowPerformanceSlowestSteps, summary.SlowestSteps)
+	}
+	gotPaths := []string{}
+	for _, step := range summary.SlowestSteps {
+		gotPaths = append(gotPaths, step.Path+"/"+step.Action)
+	}
+	wantPaths := []string{"1/navigate", "2.1/click", "2.1/click", "5/extract_text", "3.condition/is_visible"}
+	for i := range wantPaths {
+		if gotPaths[i] != wantPaths[i] {
+			t.Fatalf("expected slowest steps %v, got %v", wantPaths, gotPaths)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package tsplay_core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// flowRouteFilter decides which intercepted requests a mock_route or
+// modify_request handler acts on. Requests it skips fall back to the handlers
+// registered before it, and finally to the network.
+type flowRouteFilter struct {
+	method    string
+	mu        sync.Mutex
+	remaining int
+}
+
+func newFlowRouteFilter(method string, times int) *flowRouteFilter {
+	remaining := -1
+	if times > 0 {
+		remaining = times
+	}
+	return &flowRouteFilter{method: strings.ToUpper(strings.TrimSpace(method)), remaining: remaining}
+}
+
+// take reports whether the handler should act on request, counting it
+// against times.
+func (filter *flowRouteFilter) take(request playwright.Request) bool {
+	if filter.method != "" && !strings.EqualFold(request.Method(), filter.method) {
+		return false
+	}
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	if filter.remaining == 0 {
+		return false
+	}
+	if filter.remaining > 0 {
+		filter.remaining--
+	}
+	return true
+}
+
+// validateFlowRoutePattern checks a Playwright URL glob such as
+// **/api/orders?* before it reaches page.Route.
+func validateFlowRoutePattern(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if strings.ContainsAny(pattern, " \t\r\n") {
+		return fmt.Errorf("%q must not contain whitespace", pattern)
+	}
+	depth := 0
+	for _, r := range pattern {
+		switch r {
+		case '{':
+			depth++
+			if depth > 1 {
+				return fmt.Errorf("%q cannot nest {} groups", pattern)
+			}
+		case '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("%q has an unmatched }", pattern)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("%q has an unmatched {", pattern)
+	}
+	if _, err := compileFlowURLGlob(pattern); err != nil {
+		return fmt.Errorf("%q is not a valid URL glob: %w", pattern, err)
+	}
+	return nil
+}
+
+// compileFlowURLGlob turns a URL glob into the regular expression Playwright
+// builds for page.Route, so response matching agrees with routing. Playwright
+// panics on globs that do not compile, so patterns are checked here first.
+func compileFlowURLGlob(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	inGroup := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			if strings.IndexByte(flowURLGlobEscapedChars, glob[i]) >= 0 {
+				expr.WriteByte('\\')
+			}
+			expr.WriteByte(glob[i])
+		case c == '*':
+			var before, after byte
+			if i > 0 {
+				before = glob[i-1]
+			}
+			stars := 1
+			for i+1 < len(glob) && glob[i+1] == '*' {
+				stars++
+				i++
+			}
+			if i+1 < len(glob) {
+				after = glob[i+1]
+			}
+			if stars > 1 && (before == '/' || before == 0) && (after == '/' || after == 0) {
+				expr.WriteString("((?:[^/]*(?:/|$))*)")
+				i++
+			} else {
+				expr.WriteString("([^/]*)")
+			}
+		case c == '?':
+			expr.WriteByte('.')
+		case c == '[' || c == ']':
+			expr.WriteByte(c)
+		case c == '{':
+			inGroup = true
+			expr.WriteByte('(')
+		case c == '}':
+			inGroup = false
+			expr.WriteByte(')')
+		case c == ',' && inGroup:
+			expr.WriteByte('|')
+		default:
+			if strings.IndexByte(flowURLGlobEscapedChars, c) >= 0 {
+				expr.WriteByte('\\')
+			}
+			expr.WriteByte(c)
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+const flowURLGlobEscapedChars = `$^+.*()|\?{}[]`
+
+func validateNetworkRouteFlowStep(stepPath string, step FlowStep, spec flowActionSpec, knownVars map[string]any) error {
+	if len(step.Args) > 0 {
+		if err := validateFlowStepArgs(stepPath, step, spec, knownVars); err != nil {
+			return err
+		}
+	} else {
+		if err := validateFlowStepNamedParams(stepPath, step, spec, knownVars); err != nil {
+			return err
+		}
+	}
+
+	if value, ok := step.param("pattern"); ok && len(flowReferences(value)) == 0 {
+		if err := validateFlowRoutePattern(fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "pattern", err)
+		}
+	}
+	if value, ok := step.param("times"); ok && len(flowReferences(value)) == 0 {
+		times, err := intParam(value)
+		if err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "times", err)
+		}
+		if times < 1 {
+			return fmt.Errorf("step %s action %q parameter %q must be at least 1", stepPath, step.Action, "times")
+		}
+	}
+
+	switch step.Action {
+	case "mock_route":
+		if countPresentFlowParams(step, "body", "json", "body_file") > 1 {
+			return fmt.Errorf("step %s action %q accepts only one of body, json, or body_file", stepPath, step.Action)
+		}
+		if value, ok := step.param("status"); ok && len(flowReferences(value)) == 0 {
+			status, err := intParam(value)
+			if err != nil {
+				return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "status", err)
+			}
+			if status < 100 || status > 599 {
+				return fmt.Errorf("step %s action %q parameter %q must be between 100 and 599", stepPath, step.Action, "status")
+			}
+		}
+	case "modify_request":
+		if countPresentFlowParams(step, "post_data", "json") > 1 {
+			return fmt.Errorf("step %s action %q accepts only one of post_data or json", stepPath, step.Action)
+		}
+		if countPresentFlowParams(step, "headers", "query", "post_data", "json") == 0 {
+			return fmt.Errorf("step %s action %q requires at least one of headers, query, post_data, or json", stepPath, step.Action)
+		}
+	}
+	return nil
+}
+
+func countPresentFlowParams(step FlowStep, names ...string) int {
+	count := 0
+	for _, name := range names {
+		if value, ok := step.param(name); ok && value != nil {
+			count++
+		}
+	}
+	return count
+}
+
+func flowRouteStepPattern(ctx *FlowContext, step FlowStep) (string, error) {
+	pattern, err := flowStepStringParam(ctx, step, "pattern")
+	if err != nil {
+		return "", err
+	}
+	if err := validateFlowRoutePattern(pattern); err != nil {
+		return "", fmt.Errorf("action %q parameter %q %w", step.Action, "pattern", err)
+	}
+	return pattern, nil
+}
+
+func flowRouteStepObjectParam(ctx *FlowContext, step FlowStep, name string) (map[string]any, error) {
+	value, ok, err := flowStepResolvedParam(ctx, step, name)
+	if err != nil || !ok || value == nil {
+		return nil, err
+	}
+	return objectMapValue(value, fmt.Sprintf("action %q parameter %q", step.Action, name))
+}
+
+func runFlowMockRouteStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
+	pattern, err := flowRouteStepPattern(ctx, step)
+	if err != nil {
+		return nil, err
+	}
+	method, err := flowStepOptionalStringParam(ctx, step, "method")
+	if err != nil {
+		return nil, err
+	}
+	times, err := flowStepOptionalIntParam(ctx, step, "times")
+	if err != nil {
+		return nil, err
+	}
+	status, err := flowStepOptionalIntParam(ctx, step, "status")
+	if err != nil {
+		return nil, err
+	}
+	if status == 0 {
+		status = 200
+	}
+	if status < 100 || status > 599 {
+		return nil, fmt.Errorf("action %q parameter %q must be between 100 and 599", step.Action, "status")
+	}
+	headerValues, err := flowRouteStepObjectParam(ctx, step, "headers")
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{}
+	if headerValues != nil {
+		if headers, err = stringMapValue(headerValues, "headers"); err != nil {
+			return nil, err
+		}
+	}
+	contentType, err := flowStepOptionalStringParam(ctx, step, "content_type")
+	if err != nil {
+		return nil, err
+	}
+
+	options := playwright.RouteFulfillOptions{Status: playwright.Int(status), Headers: headers}
+	result := map[string]any{"pattern": pattern, "status": status}
+	if body, ok, err := flowStepResolvedParam(ctx, step, "body"); err != nil {
+		return nil, err
+	} else if ok && body != nil {
+		text, isString := body.(string)
+		if !isString {
+			return nil, fmt.Errorf("action %q parameter %q must be a string; use json for objects", step.Action, "body")
+		}
+		options.Body = text
+		result["body_bytes"] = len(text)
+	}
+	if jsonValue, ok, err := flowStepResolvedParam(ctx, step, "json"); err != nil {
+		return nil, err
+	} else if ok && jsonValue != nil {
+		encoded, err := json.Marshal(jsonValue)
+		if err != nil {
+			return nil, fmt.Errorf("action %q encode json: %w", step.Action, err)
+		}
+		options.Body = encoded
+		result["body_bytes"] = len(encoded)
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+	bodyFile, err := flowStepOptionalStringParam(ctx, step, "body_file")
+	if err != nil {
+		return nil, err
+	}
+	if bodyFile != "" {
+		if ctx != nil && ctx.Security != nil {
+			bodyFile, err = resolveRuntimeFilePath(bodyFile, flowFileInputPath, *ctx.Security)
+			if err != nil {
+				return nil, fmt.Errorf("action %q parameter %q %w", step.Action, "body_file", err)
+			}
+		}
+		options.Path = playwright.String(bodyFile)
+		result["body_file"] = bodyFile
+	}
+	if contentType != "" {
+		options.ContentType = playwright.String(contentType)
+		result["content_type"] = contentType
+	}
+
+	page, err := pageFromLuaState(L)
+	if err != nil {
+		return nil, err
+	}
+	filter := newFlowRouteFilter(method, times)
+	if err := page.Route(pattern, func(route playwright.Route) {
+		if !filter.take(route.Request()) {
+			_ = route.Fallback()
+			return
+		}
+		_ = route.Fulfill(options)
+	}); err != nil {
+		return nil, fmt.Errorf("mock route %q: %w", pattern, err)
+	}
+	if filter.method != "" {
+		result["method"] = filter.method
+	}
+	if times > 0 {
+		result["times"] = times
+	}
+	return result, nil
+}
+
+func runFlowModifyRequestStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
+	pattern, err := flowRouteStepPattern(ctx, step)
+	if err != nil {
+		return nil, err
+	}
+	method, err := flowStepOptionalStringParam(ctx, step, "method")
+	if err != nil {
+		return nil, err
+	}
+	times, err := flowStepOptionalIntParam(ctx, step, "times")
+	if err != nil {
+		return nil, err
+	}
+	headers, err := flowRouteStepObjectParam(ctx, step, "headers")
+	if err != nil {
+		return nil, err
+	}
+	query, err := flowRouteStepObjectParam(ctx, step, "query")
+	if err != nil {
+		return nil, err
+	}
+	var postData []byte
+	postDataSet := false
+	if text, ok, err := flowStepResolvedParam(ctx, step, "post_data"); err != nil {
+		return nil, err
+	} else if ok && text != nil {
+		postData = []byte(fmt.Sprint(text))
+		postDataSet = true
+	}
+	if jsonValue, ok, err := flowStepResolvedParam(ctx, step, "json"); err != nil {
+		return nil, err
+	} else if ok && jsonValue != nil {
+		if postData, err = json.Marshal(jsonValue); err != nil {
+			return nil, fmt.Errorf("action %q encode json: %w", step.Action, err)
+		}
+		postDataSet = true
+		if headers == nil {
+			headers = map[string]any{}
+		}
+		if _, ok := headers["content-type"]; !ok {
+			headers["content-type"] = "application/json"
+		}
+	}
+
+	page, err := pageFromLuaState(L)
+	if err != nil {
+		return nil, err
+	}
+	filter := newFlowRouteFilter(method, times)
+	if err := page.Route(pattern, func(route playwright.Route) {
+		request := route.Request()
+		if !filter.take(request) {
+			_ = route.Fallback()
+			return
+		}
+		options := playwright.RouteFallbackOptions{}
+		if len(headers) > 0 {
+			options.Headers = mergeFlowRouteHeaders(request.Headers(), headers)
+		}
+		if len(query) > 0 {
+			if rewritten, err := rewriteFlowRouteQuery(request.URL(), query); err == nil {
+				options.URL = playwright.String(rewritten)
+			}
+		}
+		if postDataSet {
+			options.PostData = postData
+		}
+		_ = route.Fallback(options)
+	}); err != nil {
+		return nil, fmt.Errorf("modify request %q: %w", pattern, err)
+	}
+
+	result := map[string]any{"pattern": pattern}
+	if filter.method != "" {
+		result["method"] = filter.method
+	}
+	if times > 0 {
+		result["times"] = times
+	}
+	if len(headers) > 0 {
+		result["headers"] = sortedMapKeys(headers)
+	}
+	if len(query) > 0 {
+		result["query"] = sortedMapKeys(query)
+	}
+	if postDataSet {
+		result["post_data_bytes"] = len(postData)
+	}
+	return result, nil
+}
+
+// mergeFlowRouteHeaders overlays changes on the original request headers.
+// A null value removes the header.
+func mergeFlowRouteHeaders(original map[string]string, changes map[string]any) map[string]string {
+	merged := map[string]string{}
+	for key, value := range original {
+		merged[strings.ToLower(key)] = value
+	}
+	for key, value := range changes {
+		key = strings.ToLower(key)
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = fmt.Sprint(value)
+	}
+	return merged
+}
+
+// rewriteFlowRouteQuery replaces the given query parameters of rawURL. A null
+// value removes the parameter.
+func rewriteFlowRouteQuery(rawURL string, changes map[string]any) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	values := parsed.Query()
+	for key := range changes {
+		values.Del(key)
+	}
+	appendURLValues(values, changes)
+	parsed.RawQuery = values.Encode()
+	return parsed.String(), nil
+}
+
+func runFlowUnrouteStep(L *lua.LState, ctx *FlowContext, step FlowStep) (any, error) {
+	pattern, err := flowStepOptionalStringParam(ctx, step, "pattern")
+	if err != nil {
+		return nil, err
+	}
+	page, err := pageFromLuaState(L)
+	if err != nil {
+		return nil, err
+	}
+	if pattern == "" {
+		if err := page.UnrouteAll(); err != nil {
+			return nil, fmt.Errorf("unroute all: %w", err)
+		}
+		return map[string]any{"all": true}, nil
+	}
+	if err := validateFlowRoutePattern(pattern); err != nil {
+		return nil, fmt.Errorf("action %q parameter %q %w", step.Action, "pattern", err)
+	}
+	if err := page.Unroute(pattern); err != nil {
+		return nil, fmt.Errorf("unroute %q: %w", pattern, err)
+	}
+	return map[string]any{"pattern": pattern}, nil
+}
@@ -0,0 +1,83 @@
+package tsplay_core
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateFlowNetworkRouteSteps(t *testing.T) {
+	valid := []FlowStep{
+		{Action: "mock_route", Pattern: "**/api/orders*", With: map[string]any{"status": 500, "json": map[string]any{"error": "boom"}}},
+		{Action: "mock_route", Pattern: "**/*.{png,jpg}", With: map[string]any{"body_file": "fixtures/pixel.png", "times": 1}},
+		{Action: "modify_request", Pattern: "**/api/**", With: map[string]any{"headers": map[string]any{"x-debug": "1", "cookie": nil}, "method": "POST"}},
+		{Action: "unroute", Pattern: "**/api/orders*"},
+		{Action: "unroute"},
+	}
+	for _, step := range valid {
+		flow := &Flow{SchemaVersion: CurrentFlowSchemaVersion, Name: "routes", Steps: []FlowStep{step}}
+		if err := ValidateFlow(flow); err != nil {
+			t.Fatalf("step %#v returned error: %v", step, err)
+		}
+	}
+
+	invalid := map[string]FlowStep{
+		`requires "pattern"`:          {Action: "mock_route", With: map[string]any{"status": 200}},
+		"unmatched {":                 {Action: "mock_route", Pattern: "**/*.{png,jpg"},
+		"cannot nest":                 {Action: "unroute", Pattern: "**/{a,{b,c}}"},
+		"between 100 and 599":         {Action: "mock_route", Pattern: "**/api", With: map[string]any{"status": 42}},
+		"only one of body, json":      {Action: "mock_route", Pattern: "**/api", With: map[string]any{"body": "ok", "json": map[string]any{}}},
+		`"times" must be at least 1`:  {Action: "mock_route", Pattern: "**/api", With: map[string]any{"times": 0}},
+		"only one of post_data":       {Action: "modify_request", Pattern: "**/api", With: map[string]any{"post_data": "a=1", "json": map[string]any{}}},
+		"requires at least one of":    {Action: "modify_request", Pattern: "**/api"},
+		`"headers" must be an object`: {Action: "modify_request", Pattern: "**/api", With: map[string]any{"headers": "x-debug: 1"}},
+	}
+	for want, step := range invalid {
+		flow := &Flow{SchemaVersion: CurrentFlowSchemaVersion, Name: "routes", Steps: []FlowStep{step}}
+		err := ValidateFlow(flow)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q for %#v, got %v", want, step, err)
+		}
+	}
+}
+
+func TestValidateFlowSecurityChecksMockRouteBodyFile(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "mock_body_file",
+		Steps: []FlowStep{
+			{Action: "mock_route", Pattern: "**/api/report", With: map[string]any{"body_file": "../secrets.json"}},
+		},
+	}
+	if err := ValidateFlowSecurity(flow, FlowSecurityPolicy{}); err == nil || !strings.Contains(err.Error(), "allow_file_access") {
+		t.Fatalf("expected file access error, got %v", err)
+	}
+	if err := ValidateFlowSecurity(flow, FlowSecurityPolicy{AllowFileAccess: true, FileInputRoot: t.TempDir()}); err == nil {
+		t.Fatalf("expected body_file outside the input root to fail")
+	}
+
+	flow.Steps[0].With = map[string]any{"status": 503}
+	if err := ValidateFlowSecurity(flow, FlowSecurityPolicy{}); err != nil {
+		t.Fatalf("inline mocks need no grants, got %v", err)
+	}
+}
+
+func TestFlowRouteRewriteHelpers(t *testing.T) {
+	headers := mergeFlowRouteHeaders(
+		map[string]string{"Accept": "text/html", "Cookie": "sid=1"},
+		map[string]any{"X-Debug": 1, "cookie": nil},
+	)
+	if len(headers) != 2 || headers["accept"] != "text/html" || headers["x-debug"] != "1" {
+		t.Fatalf("unexpected merged headers %#v", headers)
+	}
+
+	rewritten, err := rewriteFlowRouteQuery("https://example.com/api?page=1&debug=0&q=a", map[string]any{"page": 2, "debug": nil, "tag": []any{"x", "y"}})
+	if err != nil {
+		t.Fatalf("rewriteFlowRouteQuery returned error: %v", err)
+	}
+	parsed, _ := url.Parse(rewritten)
+	query := parsed.Query()
+	if query.Get("page") != "2" || query.Has("debug") || query.Get("q") != "a" || len(query["tag"]) != 2 {
+		t.Fatalf("unexpected rewritten url %q", rewritten)
+	}
+}
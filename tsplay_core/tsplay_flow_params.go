@@ -0,0 +1,308 @@
+package tsplay_core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const flowSecretMask = "[redacted]"
+
+// FlowParameter declares one typed input that callers supply at run time.
+// Resolved parameters become ordinary flow variables, so steps reference them
+// with the usual {{name}} placeholders.
+type FlowParameter struct {
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     any    `json:"default,omitempty" yaml:"default,omitempty"`
+	Enum        []any  `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Secret      bool   `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+var flowParameterTypes = []string{"string", "number", "integer", "boolean", "list", "object"}
+
+func (parameter FlowParameter) normalizedType() string {
+	if typ := strings.ToLower(strings.TrimSpace(parameter.Type)); typ != "" {
+		return typ
+	}
+	return "string"
+}
+
+// enumValues returns the enum options converted to the declared type, so "1"
+// in a YAML enum matches the number 1. The flow itself is left as written.
+func (parameter FlowParameter) enumValues() []any {
+	if len(parameter.Enum) == 0 {
+		return nil
+	}
+	values := make([]any, 0, len(parameter.Enum))
+	for _, option := range parameter.Enum {
+		if coerced, err := coerceFlowParameterValue(parameter.normalizedType(), option); err == nil {
+			option = coerced
+		}
+		values = append(values, option)
+	}
+	return values
+}
+
+func validateFlowParameters(flow *Flow) error {
+	for _, name := range sortedFlowParameterNames(flow.Parameters) {
+		parameter := flow.Parameters[name]
+		if !flowIdentifierPattern.MatchString(name) {
+			return fmt.Errorf("parameters key %q is not a valid variable name", name)
+		}
+		if _, exists := flow.Vars[name]; exists {
+			return fmt.Errorf("parameter %q is also declared in vars; use the parameter default instead", name)
+		}
+		typ := parameter.normalizedType()
+		if !slices.Contains(flowParameterTypes, typ) {
+			return fmt.Errorf("parameter %q type %q is not supported; use one of %s", name, parameter.Type, strings.Join(flowParameterTypes, ", "))
+		}
+		for i, option := range parameter.Enum {
+			if _, err := coerceFlowParameterValue(typ, option); err != nil {
+				return fmt.Errorf("parameter %q enum value %d %w", name, i+1, err)
+			}
+		}
+		if parameter.Default == nil {
+			continue
+		}
+		if parameter.Required {
+			return fmt.Errorf("parameter %q cannot be required and declare a default", name)
+		}
+		if parameter.Secret {
+			return fmt.Errorf("parameter %q is secret and cannot declare a default; pass it at run time", name)
+		}
+		if _, err := checkFlowParameterValue(name, parameter, parameter.Default); err != nil {
+			return fmt.Errorf("%w (default)", err)
+		}
+	}
+	return nil
+}
+
+// resolveFlowParameters checks run-time inputs against the flow's declared
+// parameters and returns the values to seed as flow variables. Inputs that do
+// not match a declared parameter are rejected so typos fail fast.
+func resolveFlowParameters(flow *Flow, inputs map[string]any) (map[string]any, error) {
+	for _, name := range sortedMapKeys(inputs) {
+		if _, ok := flow.Parameters[name]; !ok {
+			if len(flow.Parameters) == 0 {
+				return nil, fmt.Errorf("flow %q does not declare parameters; cannot set %q", flow.Name, name)
+			}
+			return nil, fmt.Errorf("flow %q does not declare parameter %q; declared parameters: %s", flow.Name, name, strings.Join(sortedFlowParameterNames(flow.Parameters), ", "))
+		}
+	}
+	return resolveFlowParameterValues(flow.Parameters, inputs)
+}
+
+func resolveFlowParameterValues(parameters map[string]FlowParameter, inputs map[string]any) (map[string]any, error) {
+	values := map[string]any{}
+	for _, name := range sortedFlowParameterNames(parameters) {
+		parameter := parameters[name]
+		input, ok := inputs[name]
+		if !ok || input == nil {
+			if parameter.Required {
+				return nil, fmt.Errorf("parameter %q is required", name)
+			}
+			input = parameter.Default
+		}
+		if input == nil {
+			values[name] = nil
+			continue
+		}
+		value, err := checkFlowParameterValue(name, parameter, input)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+func checkFlowParameterValue(name string, parameter FlowParameter, value any) (any, error) {
+	coerced, err := coerceFlowParameterValue(parameter.normalizedType(), value)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %q %w", name, err)
+	}
+	enum := parameter.enumValues()
+	if len(enum) == 0 {
+		return coerced, nil
+	}
+	for _, option := range enum {
+		if flowExprEqual(coerced, option) {
+			return coerced, nil
+		}
+	}
+	options := make([]string, 0, len(enum))
+	for _, option := range enum {
+		options = append(options, fmt.Sprint(option))
+	}
+	if parameter.Secret {
+		return nil, fmt.Errorf("parameter %q must be one of %s", name, strings.Join(options, ", "))
+	}
+	return nil, fmt.Errorf("parameter %q value %v must be one of %s", name, coerced, strings.Join(options, ", "))
+}
+
+// coerceFlowParameterValue converts an input to the declared type. Strings are
+// parsed because -var flags and form inputs always arrive as text.
+func coerceFlowParameterValue(typ string, value any) (any, error) {
+	text, isText := value.(string)
+	switch typ {
+	case "string":
+		if isText {
+			return text, nil
+		}
+		return nil, fmt.Errorf("must be a string")
+	case "number":
+		if isText {
+			number, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be a number")
+			}
+			return flowExprNumberResult(number), nil
+		}
+		if number, ok := flowExprNumber(value); ok {
+			return flowExprNumberResult(number), nil
+		}
+		return nil, fmt.Errorf("must be a number")
+	case "integer":
+		if isText {
+			number, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			return number, nil
+		}
+		if number, ok := flowExprNumber(value); ok && math.Trunc(number) == number {
+			return int(number), nil
+		}
+		return nil, fmt.Errorf("must be an integer")
+	case "boolean":
+		if isText {
+			parsed, err := strconv.ParseBool(strings.TrimSpace(text))
+			if err != nil {
+				return nil, fmt.Errorf("must be a boolean")
+			}
+			return parsed, nil
+		}
+		if typed, ok := value.(bool); ok {
+			return typed, nil
+		}
+		return nil, fmt.Errorf("must be a boolean")
+	case "list":
+		if isText {
+			var items []any
+			if err := json.Unmarshal([]byte(text), &items); err != nil {
+				return nil, fmt.Errorf("must be a list or a JSON array")
+			}
+			return items, nil
+		}
+		items, err := toList(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be a list")
+		}
+		return items, nil
+	case "object":
+		if isText {
+			var object map[string]any
+			if err := json.Unmarshal([]byte(text), &object); err != nil {
+				return nil, fmt.Errorf("must be an object or a JSON object")
+			}
+			return object, nil
+		}
+		if typed, ok := value.(map[string]any); ok {
+			return typed, nil
+		}
+		return nil, fmt.Errorf("must be an object")
+	default:
+		return nil, fmt.Errorf("has unsupported type %q", typ)
+	}
+}
+
+// BuildFlowParametersJSONSchema describes a flow's declared parameters as a
+// JSON Schema object, suitable for MCP clients and form builders.
+func BuildFlowParametersJSONSchema(flow *Flow) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	if flow != nil {
+		for _, name := range sortedFlowParameterNames(flow.Parameters) {
+			parameter := flow.Parameters[name]
+			property := map[string]any{}
+			switch typ := parameter.normalizedType(); typ {
+			case "list":
+				property["type"] = "array"
+			default:
+				property["type"] = typ
+			}
+			if parameter.Description != "" {
+				property["description"] = parameter.Description
+			}
+			if parameter.Default != nil {
+				property["default"] = parameter.Default
+			}
+			if enum := parameter.enumValues(); len(enum) > 0 {
+				property["enum"] = enum
+			}
+			if parameter.Secret {
+				property["writeOnly"] = true
+			}
+			properties[name] = property
+			if parameter.Required {
+				required = append(required, name)
+			}
+		}
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// ParseFlowParameterAssignments turns repeated name=value CLI flags into raw
+// parameter inputs. Values stay strings; typing happens against the flow's
+// parameter declarations.
+func ParseFlowParameterAssignments(assignments []string) (map[string]any, error) {
+	inputs := map[string]any{}
+	for _, assignment := range assignments {
+		name, value, ok := strings.Cut(assignment, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("parameter assignment %q must look like name=value", assignment)
+		}
+		if !flowIdentifierPattern.MatchString(name) {
+			return nil, fmt.Errorf("parameter assignment %q uses invalid name %q", assignment, name)
+		}
+		inputs[name] = value
+	}
+	return inputs, nil
+}
+
+func sortedFlowParameterNames(parameters map[string]FlowParameter) []string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func flowSecretParameterValues(parameters map[string]FlowParameter, values map[string]any) []string {
+	secrets := []string{}
+	for _, name := range sortedFlowParameterNames(parameters) {
+		if !parameters[name].Secret {
+			continue
+		}
+		if text := flowExprString(values[name]); text != "" {
+			secrets = append(secrets, text)
+		}
+	}
+	return secrets
+}
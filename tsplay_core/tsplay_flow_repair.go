@@ -80,6 +80,7 @@ type FlowRepairTraceItem struct {
 
 type FlowRepairArtifacts struct {
 	Paths                FlowRepairArtifactPaths `json:"paths"`
+	TracePath            string                  `json:"trace_path,omitempty"`
 	ArtifactSummary      []string                `json:"artifact_summary,omitempty"`
 	DOMSnapshotExcerpt   string                  `json:"dom_snapshot_excerpt,omitempty"`
 	DOMSnapshotTruncated bool                    `json:"dom_snapshot_truncated,omitempty"`
@@ -165,7 +166,7 @@ func BuildFlowRepairContext(options FlowRepairContextOptions) (*FlowRepairContex
 		ValidationChecklist: flowRepairValidationChecklist(),
 	}
 	if options.Result != nil {
-		if vars, ok := compactTraceValue(options.Result.Vars, 0).(map[string]any); ok && len(vars) > 0 {
+		if vars, ok := redactSensitiveFlowKeys(compactTraceValue(options.Result.Vars, 0)).(map[string]any); ok && len(vars) > 0 {
 			context.Variables = vars
 		}
 		if focused := buildFlowRepairFocusedVariables(options.Flow, failedLocation, failedStepPath, options.Result.Vars); len(focused) > 0 {
@@ -189,11 +190,108 @@ func BuildFlowRepairContext(options FlowRepairContextOptions) (*FlowRepairContex
 	if failedTrace.Artifacts != nil {
 		context.Artifacts = buildFlowRepairArtifacts(*failedTrace.Artifacts, artifactRoot, excerptLimit, failedFlowStep, failedTrace)
 	}
+	if options.Result != nil && strings.TrimSpace(options.Result.BrowserTrace) != "" {
+		if context.Artifacts == nil {
+			context.Artifacts = &FlowRepairArtifacts{}
+		}
+		context.Artifacts.TracePath = options.Result.BrowserTrace
+		context.Artifacts.ArtifactSummary = append(context.Artifacts.ArtifactSummary, fmt.Sprintf("trace: %s (group \"step %s\")", filepath.Base(options.Result.BrowserTrace), failedStepPath))
+	}
+	secrets := activeFlowSecrets.registry()
+	if options.Result != nil {
+		secrets.add(options.Result.secrets...)
+	}
+	redactFlowRepairContext(context, secrets)
 	context.RepairHints = buildRuntimeFlowRepairHints(context, failedTrace, failedFlowStep)
 	context.Prompt = buildFlowRepairPrompt(context)
 	return context, nil
 }
 
+// redactFlowRepairContext hides credentials before the context is turned into
+// hints and a prompt for an AI model. Resolved secrets are masked wherever
+// they appear, and literal passwords written into the flow are replaced.
+func redactFlowRepairContext(context *FlowRepairContext, secrets *flowSecretRegistry) {
+	context.Error = secrets.maskText(context.Error)
+	for i := range context.TraceSummary {
+		redactFlowRepairTraceItem(&context.TraceSummary[i], secrets)
+	}
+	if context.FailedStep != nil {
+		redactFlowRepairStepContext(context.FailedStep, secrets)
+	}
+	for i := range context.NearbySteps {
+		redactFlowRepairStepContext(&context.NearbySteps[i], secrets)
+	}
+	if context.Variables != nil {
+		context.Variables, _ = secrets.mask(context.Variables).(map[string]any)
+	}
+	if context.FocusedVariables != nil {
+		context.FocusedVariables, _ = secrets.mask(context.FocusedVariables).(map[string]any)
+	}
+	if context.Artifacts != nil {
+		context.Artifacts.DOMSnapshotExcerpt = secrets.maskText(context.Artifacts.DOMSnapshotExcerpt)
+		for i, snippet := range context.Artifacts.RelevantDOM {
+			context.Artifacts.RelevantDOM[i] = secrets.maskText(snippet)
+		}
+	}
+}
+
+func redactFlowRepairStepContext(stepContext *FlowRepairStepContext, secrets *flowSecretRegistry) {
+	stepContext.Step = redactFlowRepairStep(stepContext.Step, secrets)
+	if stepContext.Trace != nil {
+		item := *stepContext.Trace
+		redactFlowRepairTraceItem(&item, secrets)
+		stepContext.Trace = &item
+	}
+}
+
+func redactFlowRepairStep(step FlowStep, secrets *flowSecretRegistry) FlowStep {
+	if step.Password != "" {
+		step.Password, _ = redactSensitiveFlowValue(step.Password).(string)
+	}
+	step.Text = secrets.maskText(step.Text)
+	step.Value = secrets.maskText(step.Value)
+	if len(step.Args) > 0 {
+		step.Args, _ = secrets.mask(step.Args).([]any)
+	}
+	step.With = redactFlowRepairStepMap(step.With, secrets)
+	step.Inputs = redactFlowRepairStepMap(step.Inputs, secrets)
+	return step
+}
+
+func redactFlowRepairStepMap(values map[string]any, secrets *flowSecretRegistry) map[string]any {
+	if len(values) == 0 {
+		return values
+	}
+	redacted := make(map[string]any, len(values))
+	for key, value := range values {
+		if isSensitiveFlowKey(key) {
+			redacted[key] = redactSensitiveFlowValue(value)
+			continue
+		}
+		redacted[key] = secrets.mask(value)
+	}
+	return redacted
+}
+
+func redactFlowRepairTraceItem(item *FlowRepairTraceItem, secrets *flowSecretRegistry) {
+	item.Text = secrets.maskText(item.Text)
+	item.URL = secrets.maskText(item.URL)
+	item.ArgsSummary = secrets.maskText(item.ArgsSummary)
+	item.OutputSummary = secrets.maskText(item.OutputSummary)
+	item.Error = secrets.maskText(item.Error)
+	if item.Condition != nil {
+		condition := *item.Condition
+		redactFlowRepairTraceItem(&condition, secrets)
+		item.Condition = &condition
+	}
+	for i := range item.Children {
+		redactFlowRepairTraceItem(&item.Children[i], secrets)
+	}
+	for i := range item.Attempts {
+		redactFlowRepairTraceItem(&item.Attempts[i], secrets)
+	}
+}
+
 func ParseFlowRunResultForRepair(runResultText string, traceText string) (*FlowResult, string, error) {
 	runResultText = strings.TrimSpace(runResultText)
 	traceText = strings.TrimSpace(traceText)
@@ -920,6 +1018,7 @@ func flowRepairInstructions() []string {
 		"Add wait_for_selector or wait_for_text before fragile click/type/extract steps when the page is dynamic.",
 		"Prefer extract_text + save_as and set_var over introducing lua just to move values between steps.",
 		"Preserve existing save_as variables and downstream variable references unless they are the actual bug.",
+		"Keep {{ secret.NAME }} references as they are; [redacted] marks a masked credential, never write a literal password in its place.",
 		"Return a valid TSPlay Flow YAML/JSON that passes tsplay.validate_flow.",
 	}
 }
@@ -1160,6 +1259,10 @@ func classifyFlowRepairFailure(trace FlowStepTrace, step *FlowStep) (string, str
 		return "extraction_pattern", "The extraction regex no longer matches the text returned by the page."
 	case action == "wait_until":
 		return "polling_timeout", "The condition never became truthy before timeout."
+	case (action == "while" || action == "repeat_until") && strings.Contains(errorText, "max_iterations"):
+		return "loop_limit", "The loop condition never ended the loop before max_iterations; the exit condition may no longer match the page."
+	case action == "call_flow":
+		return "called_flow", "A step inside the called fragment or flow file failed; the nested trace under children points at the exact step."
 	case action == "navigate":
 		return "navigation", "Navigation did not reach the expected page or failed before the next state appeared."
 	case strings.Contains(errorText, "timeout"),
@@ -1260,6 +1363,10 @@ func buildFlowRepairFocusedVariables(flow *Flow, failedLocation *flowRepairStepL
 		if !ok {
 			continue
 		}
+		if isSensitiveFlowKey(ref) {
+			focused[ref] = redactSensitiveFlowValue(value)
+			continue
+		}
 		focused[ref] = compactTraceValue(value, 0)
 	}
 	if len(focused) == 0 {
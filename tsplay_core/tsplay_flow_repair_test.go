@@ -1,6 +1,10 @@
 package tsplay_core
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
 
 func TestBuildFlowRepairContextUsesNestedFailurePath(t *testing.T) {
 	contextPayload, err := BuildFlowRepairContext(FlowRepairContextOptions{
@@ -89,3 +93,38 @@ func TestBuildFlowRepairContextUsesNestedFailurePath(t *testing.T) {
 		t.Fatalf("unexpected failed nearby step: %#v", contextPayload.NearbySteps[1])
 	}
 }
+
+func TestBuildFlowRepairContextRedactsSecrets(t *testing.T) {
+	secrets := newFlowSecretRegistry()
+	secrets.add("repair-secret-value")
+	t.Cleanup(activeFlowSecrets.track(secrets))
+	contextPayload, err := BuildFlowRepairContext(FlowRepairContextOptions{
+		Flow: &Flow{
+			SchemaVersion: CurrentFlowSchemaVersion,
+			Name:          "login",
+			Steps: []FlowStep{
+				{Action: "type_text", Selector: "#user", Text: "{{ secret.erp_user }}"},
+				{Action: "extract_zip", FilePath: "a.zip", Password: "literal-zip-pass"},
+			},
+		},
+		Result: &FlowResult{
+			Vars: map[string]any{"auth": "Bearer repair-secret-value", "api_token": "tok-literal"},
+			Trace: []FlowStepTrace{
+				{Index: 1, Path: "1", Action: "type_text", Status: "ok", ArgsSummary: `{"text":"repair-secret-value"}`},
+				{Index: 2, Path: "2", Action: "extract_zip", Status: "error", Error: "bad password repair-secret-value"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("build repair context: %v", err)
+	}
+	encoded, _ := json.Marshal(contextPayload)
+	for _, leaked := range []string{"repair-secret-value", "literal-zip-pass", "tok-literal"} {
+		if strings.Contains(string(encoded), leaked) {
+			t.Fatalf("repair context leaked %q: %s", leaked, encoded)
+		}
+	}
+	if contextPayload.NearbySteps[0].Step.Text != "{{ secret.erp_user }}" {
+		t.Fatalf("secret placeholder should stay visible: %#v", contextPayload.NearbySteps[0].Step)
+	}
+}
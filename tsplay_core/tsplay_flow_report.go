@@ -0,0 +1,573 @@
+package tsplay_core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	FlowReportFormatJUnit = "junit"
+	FlowReportFormatHTML  = "html"
+
+	// Screenshots up to this size are inlined into the HTML report so it
+	// stays readable after the run root is cleaned up; larger ones are linked.
+	flowReportInlineScreenshotBytes = 4 << 20
+)
+
+// FlowReportTarget is one report output, written from "junit:path" or
+// "html:path".
+type FlowReportTarget struct {
+	Format string `json:"format"`
+	Path   string `json:"path"`
+}
+
+// ParseFlowReportTarget parses a -report value such as junit:reports/flow.xml.
+func ParseFlowReportTarget(value string) (FlowReportTarget, error) {
+	format, path, ok := strings.Cut(strings.TrimSpace(value), ":")
+	format = strings.ToLower(strings.TrimSpace(format))
+	path = strings.TrimSpace(path)
+	if !ok || path == "" {
+		return FlowReportTarget{}, fmt.Errorf("report %q must be format:path, for example junit:reports/flow.xml", value)
+	}
+	switch format {
+	case FlowReportFormatJUnit, FlowReportFormatHTML:
+		return FlowReportTarget{Format: format, Path: path}, nil
+	default:
+		return FlowReportTarget{}, fmt.Errorf("unsupported report format %q: use junit or html", format)
+	}
+}
+
+// FlowReportRun is one flow run in a report. Result is nil when the flow
+// failed before it started, for example on a validation error; Error then
+// explains why. Skipped is the reason a flow did not run at all.
+type FlowReportRun struct {
+	Name     string      `json:"name"`
+	File     string      `json:"file,omitempty"`
+	Result   *FlowResult `json:"result,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Skipped  string      `json:"skipped,omitempty"`
+	Attempts int         `json:"attempts,omitempty"`
+}
+
+// NewFlowReportRun pairs a RunFlow result with its error.
+func NewFlowReportRun(name string, file string, result *FlowResult, err error) FlowReportRun {
+	run := FlowReportRun{Name: name, File: file, Result: result}
+	if run.Name == "" && result != nil {
+		run.Name = result.Name
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	return run
+}
+
+func (run FlowReportRun) failed() bool {
+	if run.Error != "" {
+		return true
+	}
+	return run.Result != nil && run.Result.Status == FlowRunStatusFailed
+}
+
+func (run FlowReportRun) durationMS() int64 {
+	if run.Result == nil {
+		return 0
+	}
+	if run.Result.Performance != nil {
+		return run.Result.Performance.DurationMS
+	}
+	var total int64
+	for _, trace := range run.Result.Trace {
+		total += trace.DurationMS
+	}
+	return total
+}
+
+// WriteFlowReports writes every target. Paths are created as needed.
+func WriteFlowReports(targets []FlowReportTarget, runs []FlowReportRun) error {
+	for _, target := range targets {
+		var content []byte
+		var err error
+		switch target.Format {
+		case FlowReportFormatJUnit:
+			content, err = BuildFlowJUnitReport(runs)
+		case FlowReportFormatHTML:
+			content, err = BuildFlowHTMLReport(runs, filepath.Dir(target.Path))
+		default:
+			err = fmt.Errorf("unsupported report format %q", target.Format)
+		}
+		if err != nil {
+			return fmt.Errorf("build %s report: %w", target.Format, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(target.Path), 0755); err != nil {
+			return fmt.Errorf("create report directory: %w", err)
+		}
+		if err := os.WriteFile(target.Path, content, 0644); err != nil {
+			return fmt.Errorf("write %s report: %w", target.Format, err)
+		}
+	}
+	return nil
+}
+
+// flowReportCase is one test case: a top-level step, a nested assert_* step,
+// or the run itself when it failed outside of any step or never ran.
+type flowReportCase struct {
+	Name       string
+	Path       string
+	Action     string
+	Status     string
+	DurationMS int64
+	Error      string
+	ErrorStack string
+	Artifacts  []string
+}
+
+func (testCase flowReportCase) failed() bool {
+	return testCase.Status == "error"
+}
+
+func flowReportCases(run FlowReportRun) []flowReportCase {
+	cases := []flowReportCase{}
+	stepFailed := false
+	if run.Result != nil {
+		for _, trace := range run.Result.Trace {
+			cases = append(cases, newFlowReportCase(trace, true))
+			stepFailed = stepFailed || trace.Status == "error"
+			cases = append(cases, flowReportNestedAsserts(trace)...)
+		}
+	}
+	if run.Skipped != "" && run.Result == nil {
+		cases = append(cases, flowReportCase{Name: "flow", Status: "skipped", Error: run.Skipped})
+	}
+	if run.Error != "" && !stepFailed {
+		cases = append(cases, flowReportCase{
+			Name:       "flow",
+			Status:     "error",
+			DurationMS: run.durationMS(),
+			Error:      run.Error,
+		})
+	}
+	return cases
+}
+
+// flowReportNestedAsserts lists the assert_* steps nested in a control step.
+// Only the last retry attempt counts and conditions are skipped, and a failed
+// assert is only reported when the step around it failed as well, so an
+// assertion that a retry or on_error recovered from does not fail the report.
+func flowReportNestedAsserts(trace FlowStepTrace) []flowReportCase {
+	cases := []flowReportCase{}
+	for _, nested := range flowReportFinalNested(trace) {
+		if strings.HasPrefix(nested.Action, "assert_") && (nested.Status != "error" || trace.Status == "error") {
+			cases = append(cases, newFlowReportCase(nested, false))
+		}
+		cases = append(cases, flowReportNestedAsserts(nested)...)
+	}
+	return cases
+}
+
+// flowReportFinalNested returns the last attempt and the children of a control
+// step.
+func flowReportFinalNested(trace FlowStepTrace) []FlowStepTrace {
+	nested := []FlowStepTrace{}
+	last := 0
+	for _, attempt := range trace.Attempts {
+		last = max(last, attempt.Attempt)
+	}
+	for _, attempt := range trace.Attempts {
+		if attempt.Attempt == last {
+			nested = append(nested, attempt)
+		}
+	}
+	return append(nested, trace.Children...)
+}
+
+func newFlowReportCase(trace FlowStepTrace, includeNestedArtifacts bool) flowReportCase {
+	name := trace.Path + " " + trace.Action
+	if trace.Attempt > 0 {
+		name += fmt.Sprintf(" (attempt %d)", trace.Attempt)
+	}
+	if trace.Name != "" {
+		name += ": " + trace.Name
+	}
+	testCase := flowReportCase{
+		Name:       name,
+		Path:       trace.Path,
+		Action:     trace.Action,
+		Status:     trace.Status,
+		DurationMS: trace.DurationMS,
+		Error:      trace.Error,
+		ErrorStack: trace.ErrorStack,
+	}
+	seen := map[string]bool{}
+	var collect func(trace FlowStepTrace)
+	collect = func(trace FlowStepTrace) {
+		if trace.Artifacts != nil {
+			for _, path := range []string{trace.Artifacts.ScreenshotPath, trace.Artifacts.HTMLPath, trace.Artifacts.DOMSnapshotPath} {
+				if path != "" && !seen[path] {
+					seen[path] = true
+					testCase.Artifacts = append(testCase.Artifacts, path)
+				}
+			}
+		}
+		if includeNestedArtifacts {
+			for _, nested := range flowStepTraceNested(trace) {
+				collect(nested)
+			}
+		}
+	}
+	collect(trace)
+	return testCase
+}
+
+// flowStepTraceNested returns the condition, attempts and children of a
+// control step in the order they ran.
+func flowStepTraceNested(trace FlowStepTrace) []FlowStepTrace {
+	nested := []FlowStepTrace{}
+	if trace.Condition != nil {
+		nested = append(nested, *trace.Condition)
+	}
+	nested = append(nested, trace.Attempts...)
+	return append(nested, trace.Children...)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	File       string          `xml:"file,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// BuildFlowJUnitReport renders one <testsuite> per run. Failure artifacts are
+// listed in <system-out> with the [[ATTACHMENT|path]] marker that Jenkins and
+// GitLab pick up.
+func BuildFlowJUnitReport(runs []FlowReportRun) ([]byte, error) {
+	report := junitTestSuites{Name: "tsplay"}
+	var totalMS int64
+	for _, run := range runs {
+		suite := junitTestSuite{
+			Name: run.Name,
+			Time: junitSeconds(run.durationMS()),
+			File: run.File,
+		}
+		if run.Result != nil {
+			suite.Properties = append(suite.Properties,
+				junitProperty{Name: "run_id", Value: run.Result.RunID},
+				junitProperty{Name: "status", Value: run.Result.Status},
+			)
+			if run.Result.RunRoot != "" {
+				suite.Properties = append(suite.Properties, junitProperty{Name: "run_root", Value: run.Result.RunRoot})
+			}
+			if len(run.Result.Trace) > 0 {
+				suite.Timestamp = run.Result.Trace[0].StartedAt
+			}
+		}
+		if run.Attempts > 1 {
+			suite.Properties = append(suite.Properties, junitProperty{Name: "attempts", Value: fmt.Sprint(run.Attempts)})
+		}
+		for _, testCase := range flowReportCases(run) {
+			junitCase := junitTestCase{
+				Name:      testCase.Name,
+				ClassName: run.Name,
+				Time:      junitSeconds(testCase.DurationMS),
+				File:      run.File,
+			}
+			switch {
+			case testCase.failed():
+				junitCase.Failure = &junitFailure{
+					Message: testCase.Error,
+					Type:    firstNonEmpty(testCase.Action, "flow"),
+					Body:    strings.TrimSpace(testCase.Error + "\n\n" + testCase.ErrorStack),
+				}
+				suite.Failures++
+			case testCase.Status == "skipped":
+				junitCase.Skipped = &junitSkipped{Message: testCase.Error}
+				suite.Skipped++
+			}
+			if len(testCase.Artifacts) > 0 {
+				lines := make([]string, 0, len(testCase.Artifacts))
+				for _, path := range testCase.Artifacts {
+					lines = append(lines, "[[ATTACHMENT|"+path+"]]")
+				}
+				junitCase.SystemOut = strings.Join(lines, "\n")
+			}
+			suite.Cases = append(suite.Cases, junitCase)
+		}
+		suite.Tests = len(suite.Cases)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		totalMS += run.durationMS()
+		report.Suites = append(report.Suites, suite)
+	}
+	report.Time = junitSeconds(totalMS)
+	content, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
+
+func junitSeconds(durationMS int64) string {
+	return fmt.Sprintf("%.3f", float64(durationMS)/1000)
+}
+
+type flowHTMLReport struct {
+	GeneratedAt string
+	Runs        []flowHTMLRun
+	Total       int
+	Failed      int
+}
+
+type flowHTMLRun struct {
+	Name       string
+	File       string
+	RunID      string
+	RunRoot    string
+	Status     string
+	Failed     bool
+	Attempts   int
+	DurationMS int64
+	Error      string
+	Steps      []flowHTMLStep
+	Artifacts  []flowHTMLArtifact
+}
+
+type flowHTMLStep struct {
+	Path       string
+	Action     string
+	Name       string
+	Status     string
+	Attempt    int
+	Iteration  int
+	Branch     string
+	DurationMS int64
+	Args       string
+	Output     string
+	Error      string
+	ErrorStack string
+	PageURL    string
+	Artifacts  []flowHTMLArtifact
+	Children   []flowHTMLStep
+}
+
+type flowHTMLArtifact struct {
+	Label  string
+	Href   string
+	Inline template.URL
+}
+
+// BuildFlowHTMLReport renders a self-contained page: styles are inline and
+// failure screenshots are embedded, so the file can be attached to a CI job
+// on its own. Artifact links are relative to reportDir.
+func BuildFlowHTMLReport(runs []FlowReportRun, reportDir string) ([]byte, error) {
+	report := flowHTMLReport{GeneratedAt: time.Now().Format(time.RFC3339), Total: len(runs)}
+	for _, run := range runs {
+		htmlRun := flowHTMLRun{
+			Name:       run.Name,
+			File:       run.File,
+			Failed:     run.failed(),
+			DurationMS: run.durationMS(),
+			Error:      firstNonEmpty(run.Error, run.Skipped),
+			Attempts:   run.Attempts,
+			Status:     FlowRunStatusFailed,
+		}
+		if run.Skipped != "" && run.Result == nil {
+			htmlRun.Status = "skipped"
+		}
+		if htmlRun.Failed {
+			report.Failed++
+		}
+		if run.Result != nil {
+			htmlRun.RunID = run.Result.RunID
+			htmlRun.RunRoot = run.Result.RunRoot
+			htmlRun.Status = firstNonEmpty(run.Result.Status, htmlRun.Status)
+			for _, trace := range run.Result.Trace {
+				htmlRun.Steps = append(htmlRun.Steps, newFlowHTMLStep(trace, reportDir))
+			}
+			for _, artifact := range []struct{ label, path string }{
+				{"browser video", run.Result.BrowserVideo},
+				{"browser trace", run.Result.BrowserTrace},
+				{"browser HAR", run.Result.BrowserHAR},
+				{"checkpoint", run.Result.Checkpoint},
+			} {
+				if artifact.path != "" {
+					htmlRun.Artifacts = append(htmlRun.Artifacts, flowHTMLArtifact{Label: artifact.label, Href: flowReportLink(artifact.path, reportDir)})
+				}
+			}
+		}
+		report.Runs = append(report.Runs, htmlRun)
+	}
+	var out bytes.Buffer
+	if err := flowHTMLReportTemplate.Execute(&out, report); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func newFlowHTMLStep(trace FlowStepTrace, reportDir string) flowHTMLStep {
+	step := flowHTMLStep{
+		Path:       trace.Path,
+		Action:     trace.Action,
+		Name:       trace.Name,
+		Status:     trace.Status,
+		Attempt:    trace.Attempt,
+		Iteration:  trace.Iteration,
+		Branch:     trace.Branch,
+		DurationMS: trace.DurationMS,
+		Args:       trace.ArgsSummary,
+		Output:     trace.OutputSummary,
+		Error:      trace.Error,
+		ErrorStack: trace.ErrorStack,
+		PageURL:    trace.PageURL,
+	}
+	if trace.Artifacts != nil {
+		if path := trace.Artifacts.ScreenshotPath; path != "" {
+			step.Artifacts = append(step.Artifacts, flowHTMLArtifact{Label: "screenshot", Href: flowReportLink(path, reportDir), Inline: flowReportInlineImage(path)})
+		}
+		if path := trace.Artifacts.HTMLPath; path != "" {
+			step.Artifacts = append(step.Artifacts, flowHTMLArtifact{Label: "page HTML", Href: flowReportLink(path, reportDir)})
+		}
+		if path := trace.Artifacts.DOMSnapshotPath; path != "" {
+			step.Artifacts = append(step.Artifacts, flowHTMLArtifact{Label: "DOM snapshot", Href: flowReportLink(path, reportDir)})
+		}
+		if trace.Artifacts.CaptureError != "" {
+			step.Artifacts = append(step.Artifacts, flowHTMLArtifact{Label: "capture error: " + trace.Artifacts.CaptureError})
+		}
+	}
+	for _, nested := range flowStepTraceNested(trace) {
+		step.Children = append(step.Children, newFlowHTMLStep(nested, reportDir))
+	}
+	return step
+}
+
+// flowReportLink makes path relative to the report directory so the report
+// and the artifact root can be archived together.
+func flowReportLink(path string, reportDir string) string {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	absoluteDir, err := filepath.Abs(reportDir)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	relative, err := filepath.Rel(absoluteDir, absolutePath)
+	if err != nil {
+		return filepath.ToSlash(absolutePath)
+	}
+	return filepath.ToSlash(relative)
+}
+
+func flowReportInlineImage(path string) template.URL {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > flowReportInlineScreenshotBytes {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(content))
+}
+
+var flowHTMLReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>TSPlay report</title>
+<style>
+body { font: 14px/1.5 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 24px; color: #1f2328; }
+h1 { font-size: 20px; margin: 0 0 4px; }
+.summary { color: #59636e; margin-bottom: 20px; }
+.run { border: 1px solid #d1d9e0; border-radius: 6px; margin-bottom: 16px; }
+.run > summary { padding: 10px 14px; cursor: pointer; font-weight: 600; }
+.run.failed > summary { background: #ffebe9; }
+.run.passed > summary { background: #dafbe1; }
+.run-body { padding: 8px 14px 14px; }
+.meta { color: #59636e; font-size: 12px; }
+.step { margin: 4px 0 4px 14px; border-left: 3px solid #d1d9e0; padding-left: 10px; }
+.step.error { border-color: #cf222e; }
+.step.ok { border-color: #1a7f37; }
+.step.skipped { border-color: #9a6700; }
+.step > summary { cursor: pointer; }
+.action { font-family: ui-monospace, SFMono-Regular, Menlo, monospace; font-weight: 600; }
+.duration { color: #59636e; font-size: 12px; margin-left: 6px; }
+.badge { font-size: 11px; padding: 0 6px; border-radius: 10px; background: #eff2f5; margin-left: 6px; }
+.error-text { color: #cf222e; white-space: pre-wrap; }
+pre { background: #f6f8fa; padding: 8px; overflow-x: auto; font-size: 12px; }
+img { max-width: 640px; border: 1px solid #d1d9e0; display: block; margin: 6px 0; }
+</style>
+</head>
+<body>
+<h1>TSPlay report</h1>
+<div class="summary">{{.Total}} flow(s), {{.Failed}} failed &middot; generated {{.GeneratedAt}}</div>
+{{range .Runs}}
+<details class="run {{if .Failed}}failed{{else}}passed{{end}}"{{if .Failed}} open{{end}}>
+<summary>{{.Name}} <span class="badge">{{.Status}}</span>{{if gt .Attempts 1}}<span class="badge">{{.Attempts}} attempts</span>{{end}}<span class="duration">{{.DurationMS}} ms</span></summary>
+<div class="run-body">
+<div class="meta">{{if .File}}file {{.File}} &middot; {{end}}{{if .RunID}}run {{.RunID}}{{end}}{{if .RunRoot}} &middot; {{.RunRoot}}{{end}}</div>
+{{if .Error}}<p class="error-text">{{.Error}}</p>{{end}}
+{{if .Artifacts}}<p>{{range .Artifacts}}<a href="{{.Href}}">{{.Label}}</a> {{end}}</p>{{end}}
+{{range .Steps}}{{template "step" .}}{{end}}
+</div>
+</details>
+{{end}}
+</body>
+</html>
+{{define "step"}}<details class="step {{.Status}}"{{if eq .Status "error"}} open{{end}}>
+<summary><span class="action">{{.Path}} {{.Action}}</span>{{if .Name}} {{.Name}}{{end}}{{if .Attempt}}<span class="badge">attempt {{.Attempt}}</span>{{end}}{{if .Iteration}}<span class="badge">iteration {{.Iteration}}</span>{{end}}{{if .Branch}}<span class="badge">{{.Branch}}</span>{{end}}<span class="badge">{{.Status}}</span><span class="duration">{{.DurationMS}} ms</span></summary>
+{{if .Args}}<div class="meta">args {{.Args}}</div>{{end}}
+{{if .Output}}<div class="meta">output {{.Output}}</div>{{end}}
+{{if .PageURL}}<div class="meta">page {{.PageURL}}</div>{{end}}
+{{if .Error}}<p class="error-text">{{.Error}}</p>{{end}}
+{{if .ErrorStack}}<details><summary>stack</summary><pre>{{.ErrorStack}}</pre></details>{{end}}
+{{range .Artifacts}}{{if .Inline}}<a href="{{.Href}}"><img src="{{.Inline}}" alt="{{.Label}}"></a>{{else if .Href}}<a href="{{.Href}}">{{.Label}}</a> {{else}}<div class="meta">{{.Label}}</div>{{end}}{{end}}
+{{range .Children}}{{template "step" .}}{{end}}
+</details>
+{{end}}`))
@@ -0,0 +1,136 @@
+package tsplay_core
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFlowReportsFromFailedRun(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "report_check",
+		Steps: []FlowStep{
+			{Action: "set_var", SaveAs: "total", Value: "3"},
+			{
+				Action: "retry",
+				Times:  1,
+				Steps: []FlowStep{
+					{Action: "assert_number", Name: "total is large", With: map[string]any{"value": "{{total}}", "op": ">", "expected": 10}},
+				},
+			},
+		},
+	}
+	result, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: t.TempDir()})
+	if err == nil {
+		t.Fatalf("expected the assertion to fail")
+	}
+	screenshot := filepath.Join(t.TempDir(), "failure.png")
+	if err := os.WriteFile(screenshot, []byte("\x89PNG fake"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result.Trace[1].Attempts[0].Artifacts = &FlowStepArtifacts{ScreenshotPath: screenshot}
+
+	reportDir := t.TempDir()
+	targets := []FlowReportTarget{
+		{Format: FlowReportFormatJUnit, Path: filepath.Join(reportDir, "junit", "flow.xml")},
+		{Format: FlowReportFormatHTML, Path: filepath.Join(reportDir, "flow.html")},
+	}
+	runs := []FlowReportRun{
+		NewFlowReportRun("", "script/report_check.flow.yaml", result, err),
+		NewFlowReportRun("broken", "script/broken.flow.yaml", nil, os.ErrNotExist),
+	}
+	if err := WriteFlowReports(targets, runs); err != nil {
+		t.Fatalf("WriteFlowReports returned error: %v", err)
+	}
+
+	content, err := os.ReadFile(targets[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(content, &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, content)
+	}
+	if suites.Tests != 4 || suites.Failures != 3 || len(suites.Suites) != 2 {
+		t.Fatalf("unexpected totals tests=%d failures=%d suites=%d\n%s", suites.Tests, suites.Failures, len(suites.Suites), content)
+	}
+	suite := suites.Suites[0]
+	if suite.Name != "report_check" || suite.File != "script/report_check.flow.yaml" {
+		t.Fatalf("unexpected suite %#v", suite)
+	}
+	wantCases := []string{"1 set_var", "2 retry", "2.1 assert_number (attempt 1): total is large"}
+	for i, want := range wantCases {
+		if suite.Cases[i].Name != want {
+			t.Fatalf("expected case %d to be %q, got %q", i, want, suite.Cases[i].Name)
+		}
+	}
+	assertCase := suite.Cases[2]
+	if assertCase.Failure == nil || !strings.Contains(assertCase.Failure.Message, "assert_number failed") || assertCase.Failure.Type != "assert_number" {
+		t.Fatalf("expected the assertion failure, got %#v", assertCase.Failure)
+	}
+	if !strings.Contains(assertCase.SystemOut, "[[ATTACHMENT|"+screenshot+"]]") || !strings.Contains(suite.Cases[1].SystemOut, screenshot) {
+		t.Fatalf("expected screenshot attachments, got %q and %q", assertCase.SystemOut, suite.Cases[1].SystemOut)
+	}
+	if broken := suites.Suites[1]; len(broken.Cases) != 1 || broken.Cases[0].Name != "flow" || broken.Cases[0].Failure == nil {
+		t.Fatalf("expected a single failing case for the broken flow, got %#v", broken.Cases)
+	}
+
+	page, err := os.ReadFile(targets[1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(page)
+	for _, want := range []string{"2.1 assert_number", "total is large", "data:image/png;base64,", `href="` + flowReportLink(screenshot, reportDir) + `"`, "2 flow(s), 2 failed"} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("expected HTML report to contain %q", want)
+		}
+	}
+}
+
+func TestParseFlowReportTarget(t *testing.T) {
+	target, err := ParseFlowReportTarget("JUnit:reports/flow.xml")
+	if err != nil || target.Format != FlowReportFormatJUnit || target.Path != "reports/flow.xml" {
+		t.Fatalf("unexpected target %#v, err %v", target, err)
+	}
+	for _, value := range []string{"reports/flow.xml", "html:", "pdf:report.pdf"} {
+		if _, err := ParseFlowReportTarget(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestFlowReportCountsOnlyTheFinalRetryAttempt(t *testing.T) {
+	result := &FlowResult{
+		Name: "retry_check",
+		Trace: []FlowStepTrace{{
+			Index:  1,
+			Path:   "1",
+			Action: "retry",
+			Status: "ok",
+			Condition: &FlowStepTrace{
+				Path: "1.condition", Action: "assert_visible", Status: "error", Error: "assert_visible failed",
+			},
+			Attempts: []FlowStepTrace{
+				{Path: "1.1", Attempt: 1, Action: "assert_text", Status: "error", Error: "assert_text failed"},
+				{Path: "1.1", Attempt: 2, Action: "assert_text", Status: "ok"},
+			},
+		}},
+	}
+	content, err := BuildFlowJUnitReport([]FlowReportRun{NewFlowReportRun("", "script/retry_check.flow.yaml", result, nil)})
+	if err != nil {
+		t.Fatalf("BuildFlowJUnitReport returned error: %v", err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(content, &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, content)
+	}
+	if suites.Tests != 2 || suites.Failures != 0 || !strings.Contains(string(content), `failures="0"`) {
+		t.Fatalf("expected the retried assertion to pass, got tests=%d failures=%d\n%s", suites.Tests, suites.Failures, content)
+	}
+	if name := suites.Suites[0].Cases[1].Name; name != "1.1 assert_text (attempt 2)" {
+		t.Fatalf("expected the final attempt to be reported, got %q", name)
+	}
+}
@@ -0,0 +1,264 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const defaultFlowResponseCaptureTimeoutMS = 30000
+
+// flowResponseWatcher collects the responses of the browser context that
+// match a wait_for_response or capture_responses step. It is armed before the
+// nested steps run, so the XHR triggered by a click is not missed.
+type flowResponseWatcher struct {
+	matcher *regexp.Regexp
+	method  string
+	status  int
+	limit   int
+
+	mu      sync.Mutex
+	matched []playwright.Response
+	done    chan struct{}
+}
+
+func newFlowResponseWatcher(matcher *regexp.Regexp, method string, status int, limit int) *flowResponseWatcher {
+	return &flowResponseWatcher{
+		matcher: matcher,
+		method:  strings.ToUpper(strings.TrimSpace(method)),
+		status:  status,
+		limit:   limit,
+		done:    make(chan struct{}),
+	}
+}
+
+// observe runs on the Playwright event goroutine, so it only records the
+// response; bodies are read after the wait.
+func (watcher *flowResponseWatcher) observe(response playwright.Response) {
+	if !watcher.matcher.MatchString(response.URL()) {
+		return
+	}
+	if watcher.method != "" && !strings.EqualFold(response.Request().Method(), watcher.method) {
+		return
+	}
+	if watcher.status != 0 && response.Status() != watcher.status {
+		return
+	}
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if len(watcher.matched) >= watcher.limit {
+		return
+	}
+	watcher.matched = append(watcher.matched, response)
+	if len(watcher.matched) == watcher.limit {
+		close(watcher.done)
+	}
+}
+
+func (watcher *flowResponseWatcher) responses() []playwright.Response {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	return append([]playwright.Response(nil), watcher.matched...)
+}
+
+func validateResponseCaptureFlowStep(stepPath string, step FlowStep, knownVars map[string]any) error {
+	if len(step.Args) > 0 {
+		return fmt.Errorf("step %s action %q does not support args; use pattern, with, and steps", stepPath, step.Action)
+	}
+	spec := flowActionSpecs[step.Action]
+	allowed := allowedFlowParamNames(spec)
+	allowed["steps"] = true
+	for name, value := range step.presentNamedParams() {
+		if !allowed[name] {
+			return fmt.Errorf("step %s action %q does not accept parameter %q", stepPath, step.Action, name)
+		}
+		if name == "steps" {
+			continue
+		}
+		if err := validateFlowParamValue(stepPath, step.Action, name, value, knownVars); err != nil {
+			return err
+		}
+	}
+	value, ok := step.param("pattern")
+	if !ok {
+		return fmt.Errorf("step %s action %q requires %q", stepPath, step.Action, "pattern")
+	}
+	if len(flowReferences(value)) == 0 {
+		if err := validateFlowRoutePattern(fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "pattern", err)
+		}
+	}
+	for _, name := range []string{"count", "timeout"} {
+		value, ok := step.param(name)
+		if !ok || len(flowReferences(value)) > 0 {
+			continue
+		}
+		number, err := intParam(value)
+		if err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, name, err)
+		}
+		if number < 1 {
+			return fmt.Errorf("step %s action %q parameter %q must be at least 1", stepPath, step.Action, name)
+		}
+	}
+	if value, ok := step.param("status"); ok && len(flowReferences(value)) == 0 {
+		status, err := intParam(value)
+		if err != nil {
+			return fmt.Errorf("step %s action %q parameter %q %w", stepPath, step.Action, "status", err)
+		}
+		if status < 100 || status > 599 {
+			return fmt.Errorf("step %s action %q parameter %q must be between 100 and 599", stepPath, step.Action, "status")
+		}
+	}
+	if value, ok := step.param("response_as"); ok && len(flowReferences(value)) == 0 {
+		switch strings.ToLower(strings.TrimSpace(fmt.Sprint(value))) {
+		case "", "auto", "text", "json":
+		default:
+			return fmt.Errorf("step %s action %q parameter %q must be one of auto, text, or json", stepPath, step.Action, "response_as")
+		}
+	}
+	// Nested steps share the caller's variables, like retry.
+	return validateFlowStepSequence(step.Steps, knownVars, stepPath)
+}
+
+// runFlowResponseCaptureStep arms a response listener, runs the nested steps,
+// and waits until count matching responses arrived or the timeout, measured
+// from arming, expires. wait_for_response returns the first response;
+// capture_responses returns the list.
+func runFlowResponseCaptureStep(L *lua.LState, ctx *FlowContext, step FlowStep, stepPath string) (any, []FlowStepTrace, error) {
+	pattern, err := flowRouteStepPattern(ctx, step)
+	if err != nil {
+		return nil, nil, err
+	}
+	matcher, err := compileFlowURLGlob(pattern)
+	if err != nil {
+		return nil, nil, fmt.Errorf("action %q parameter %q %w", step.Action, "pattern", err)
+	}
+	method, err := flowStepOptionalStringParam(ctx, step, "method")
+	if err != nil {
+		return nil, nil, err
+	}
+	status, err := flowStepOptionalIntParam(ctx, step, "status")
+	if err != nil {
+		return nil, nil, err
+	}
+	count := 1
+	if step.Action == "capture_responses" {
+		if count, err = flowStepOptionalIntParam(ctx, step, "count"); err != nil {
+			return nil, nil, err
+		}
+		if count == 0 {
+			count = 1
+		}
+	}
+	if count < 1 {
+		return nil, nil, fmt.Errorf("%s count must be at least 1", step.Action)
+	}
+	timeoutMS, err := flowStepOptionalIntParam(ctx, step, "timeout")
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeoutMS == 0 {
+		timeoutMS = defaultFlowResponseCaptureTimeoutMS
+	}
+	if timeoutMS < 0 {
+		return nil, nil, fmt.Errorf("%s timeout must be at least 1", step.Action)
+	}
+	responseAs, err := flowStepOptionalStringParam(ctx, step, "response_as")
+	if err != nil {
+		return nil, nil, err
+	}
+	responseAs = strings.ToLower(strings.TrimSpace(responseAs))
+	switch responseAs {
+	case "", "auto":
+		responseAs = "auto"
+	case "text", "json":
+	default:
+		return nil, nil, fmt.Errorf("%s response_as must be one of auto, text, or json", step.Action)
+	}
+
+	browserContext, ok := flowBrowserContextFromState(L)
+	if !ok || browserContext == nil {
+		return nil, nil, fmt.Errorf("%s requires a browser context", step.Action)
+	}
+	watcher := newFlowResponseWatcher(matcher, method, status, count)
+	listener := func(response playwright.Response) {
+		watcher.observe(response)
+	}
+	browserContext.On("response", listener)
+	defer browserContext.RemoveListener("response", listener)
+	deadline := time.Now().Add(time.Duration(timeoutMS) * time.Millisecond)
+
+	// The responses only exist while the listener is armed, so a failed
+	// block re-runs as a whole on resume.
+	child := *ctx
+	child.Checkpoint = nil
+	children, err := runFlowStepSequence(L, &child, step.Steps, stepPath, 0, 0)
+	if err != nil {
+		return nil, children, err
+	}
+
+	var cancelled <-chan struct{}
+	if ctx.Context != nil {
+		cancelled = ctx.Context.Done()
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-watcher.done:
+	case <-timer.C:
+	case <-cancelled:
+		return nil, children, ctx.Context.Err()
+	}
+
+	responses := watcher.responses()
+	if len(responses) < count {
+		return nil, children, fmt.Errorf("%s timed out after %dms: got %d of %d responses matching %q", step.Action, timeoutMS, len(responses), count, pattern)
+	}
+	captured := make([]any, 0, len(responses))
+	for _, response := range responses {
+		value, err := flowCapturedResponseValue(response, responseAs)
+		if err != nil {
+			return nil, children, fmt.Errorf("%s %s: %w", step.Action, response.URL(), err)
+		}
+		captured = append(captured, value)
+	}
+	if step.Action == "wait_for_response" {
+		return captured[0], children, nil
+	}
+	return captured, children, nil
+}
+
+// flowCapturedResponseValue has the same shape as the http_request result.
+// Responses without a body, such as redirects, return a nil body.
+func flowCapturedResponseValue(response playwright.Response, responseAs string) (map[string]any, error) {
+	headers := map[string]any{}
+	for key, value := range response.Headers() {
+		headers[key] = value
+	}
+	contentType := response.Headers()["content-type"]
+	value := map[string]any{
+		"method":       response.Request().Method(),
+		"url":          response.URL(),
+		"status":       response.Status(),
+		"ok":           response.Ok(),
+		"headers":      headers,
+		"content_type": contentType,
+		"body":         nil,
+	}
+	bodyBytes, err := response.Body()
+	if err != nil {
+		return value, nil
+	}
+	body, err := decodeHTTPResponseBody(bodyBytes, contentType, responseAs)
+	if err != nil {
+		return nil, err
+	}
+	value["body"] = body
+	return value, nil
+}
@@ -0,0 +1,89 @@
+package tsplay_core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFlowResponseCaptureSteps(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "capture_orders",
+		Steps: []FlowStep{
+			{
+				Action:  "capture_responses",
+				Pattern: "**/api/orders?*",
+				With:    map[string]any{"method": "GET", "status": 200, "count": 2, "timeout": 5000},
+				Steps:   []FlowStep{{Action: "click", Selector: "#search"}},
+				SaveAs:  "orders",
+			},
+			{Action: "wait_for_response", Pattern: "**/api/profile", SaveAs: "profile"},
+			{Action: "set_var", SaveAs: "first", Value: "{{orders}}"},
+		},
+	}
+	if err := ValidateFlow(flow); err != nil {
+		t.Fatalf("ValidateFlow returned error: %v", err)
+	}
+
+	invalid := map[string]FlowStep{
+		`requires "pattern"`:                {Action: "wait_for_response", Steps: []FlowStep{{Action: "click", Selector: "#go"}}},
+		"not a valid URL glob":              {Action: "wait_for_response", Pattern: "**/api/[orders"},
+		`does not accept parameter "count"`: {Action: "wait_for_response", Pattern: "**/api", With: map[string]any{"count": 2}},
+		`"count" must be at least 1`:        {Action: "capture_responses", Pattern: "**/api", With: map[string]any{"count": 0}},
+		"between 100 and 599":               {Action: "capture_responses", Pattern: "**/api", With: map[string]any{"status": 1000}},
+		"must be one of auto, text":         {Action: "capture_responses", Pattern: "**/api", With: map[string]any{"response_as": "xml"}},
+		"does not support args":             {Action: "wait_for_response", Args: []any{"**/api"}},
+		"unsupported action":                {Action: "wait_for_response", Pattern: "**/api", Steps: []FlowStep{{Action: "tap"}}},
+	}
+	for want, step := range invalid {
+		flow := &Flow{SchemaVersion: CurrentFlowSchemaVersion, Name: "capture", Steps: []FlowStep{step}}
+		err := ValidateFlow(flow)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error containing %q for %#v, got %v", want, step, err)
+		}
+	}
+}
+
+func TestCompileFlowURLGlobMatchesPlaywrightSemantics(t *testing.T) {
+	cases := []struct {
+		glob  string
+		url   string
+		match bool
+	}{
+		{"**/api/orders*", "https://shop.example.com/api/orders?page=2", true},
+		{"**/api/orders*", "https://shop.example.com/api/orders/7", false},
+		{"**/api/**", "https://shop.example.com/api/orders/7", true},
+		{"**/*.{png,jpg}", "https://cdn.example.com/img/logo.png", true},
+		{"**/*.{png,jpg}", "https://cdn.example.com/img/logo.gif", false},
+		{"https://example.com/a?b", "https://example.com/a?b", true},
+	}
+	for _, tc := range cases {
+		matcher, err := compileFlowURLGlob(tc.glob)
+		if err != nil {
+			t.Fatalf("compileFlowURLGlob(%q) returned error: %v", tc.glob, err)
+		}
+		if got := matcher.MatchString(tc.url); got != tc.match {
+			t.Fatalf("glob %q on %q = %v, want %v", tc.glob, tc.url, got, tc.match)
+		}
+	}
+}
+
+func TestAnalyzeFlowPlaywrightUsageFollowsResponseCaptureSteps(t *testing.T) {
+	usage := AnalyzeFlowPlaywrightUsage(&Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "capture_usage",
+		Steps: []FlowStep{
+			{Action: "wait_for_response", Pattern: "**/api/profile", Steps: []FlowStep{{Action: "click", Selector: "#load"}}},
+		},
+	})
+	if !usage.NeedsContext {
+		t.Fatalf("expected wait_for_response to require Playwright, got %#v", usage)
+	}
+	found := map[string]bool{}
+	for _, reason := range usage.Reasons {
+		found[reason.Action] = true
+	}
+	if !found["wait_for_response"] || !found["click"] {
+		t.Fatalf("expected reasons for the capture and the nested click, got %#v", usage.Reasons)
+	}
+}
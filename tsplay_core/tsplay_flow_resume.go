@@ -0,0 +1,433 @@
+package tsplay_core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	flowRunCheckpointFile             = "checkpoint.json"
+	flowRunCheckpointStorageStateFile = "checkpoint-storage-state.json"
+	flowRunCheckpointVersion          = 1
+	// flowRunCheckpointWriteInterval throttles checkpoint writes in long
+	// loops. Progress is kept in memory and the final state is always written
+	// when the run ends.
+	flowRunCheckpointWriteInterval = time.Second
+)
+
+// FlowRunCheckpoint is the resumable state of one run, persisted under its
+// RunRoot as steps complete and when the run ends. A failed run can continue
+// from the failed step by passing its run id as FlowRunOptions.ResumeRunID.
+type FlowRunCheckpoint struct {
+	Version          int            `json:"version"`
+	RunID            string         `json:"run_id"`
+	FlowName         string         `json:"flow_name"`
+	FlowHash         string         `json:"flow_hash"`
+	Status           string         `json:"status"`
+	UpdatedAt        string         `json:"updated_at"`
+	Vars             map[string]any `json:"vars"`
+	CompletedSteps   []string       `json:"completed_steps"`
+	ForeachPositions map[string]int `json:"foreach_positions,omitempty"`
+	FailedStepPath   string         `json:"failed_step_path,omitempty"`
+	Error            string         `json:"error,omitempty"`
+	StorageState     string         `json:"storage_state,omitempty"`
+	ResumedFrom      string         `json:"resumed_from,omitempty"`
+
+	// SecretVars names the variables left out of Vars because they held or
+	// were derived from a secret.
+	SecretVars []string `json:"secret_vars,omitempty"`
+
+	root string
+}
+
+// LoadFlowRunCheckpoint reads the checkpoint stored in a run's RunRoot.
+func LoadFlowRunCheckpoint(runRoot string) (*FlowRunCheckpoint, error) {
+	content, err := os.ReadFile(filepath.Join(runRoot, flowRunCheckpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no run checkpoint found in %s", runRoot)
+		}
+		return nil, fmt.Errorf("read run checkpoint: %w", err)
+	}
+	var checkpoint FlowRunCheckpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return nil, fmt.Errorf("parse run checkpoint in %s: %w", runRoot, err)
+	}
+	if checkpoint.Version != flowRunCheckpointVersion {
+		return nil, fmt.Errorf("run checkpoint in %s has unsupported version %d", runRoot, checkpoint.Version)
+	}
+	checkpoint.root = runRoot
+	return &checkpoint, nil
+}
+
+// prepareFlowResume loads the checkpoint of options.ResumeRunID. The resumed
+// run keeps its own run id and RunRoot and writes a fresh checkpoint, so a run
+// that fails again can be resumed in turn.
+func prepareFlowResume(flow *Flow, options *FlowRunOptions) error {
+	resumeRunID := strings.TrimSpace(options.ResumeRunID)
+	if options.resume != nil || resumeRunID == "" {
+		return nil
+	}
+	if sanitizeArtifactSegment(resumeRunID) != resumeRunID {
+		return fmt.Errorf("resume run id %q is not a valid run id", resumeRunID)
+	}
+	runRoot := strings.TrimSpace(options.ResumeRunRoot)
+	if runRoot == "" {
+		artifactRoot := flowArtifactRoot(*options)
+		if strings.TrimSpace(artifactRoot) == "" {
+			return fmt.Errorf("resuming run %q requires an artifact root", resumeRunID)
+		}
+		root, err := prepareRuntimeFileRoot(artifactRoot)
+		if err != nil {
+			return err
+		}
+		runRoot = filepath.Join(root, resumeRunID)
+	}
+	checkpoint, err := LoadFlowRunCheckpoint(runRoot)
+	if err != nil {
+		return fmt.Errorf("cannot resume run %q: %w", resumeRunID, err)
+	}
+	if checkpoint.FlowName != flow.Name {
+		return fmt.Errorf("run %q belongs to flow %q, not %q", resumeRunID, checkpoint.FlowName, flow.Name)
+	}
+	// Completed step paths and foreach positions only mean something for the
+	// steps they were recorded against.
+	if checkpoint.FlowHash != flowRunCheckpointHash(flow) {
+		return fmt.Errorf("run %q was recorded for a different version of flow %q; run the flow from the start", resumeRunID, flow.Name)
+	}
+	if checkpoint.Status == FlowRunStatusSucceeded {
+		return fmt.Errorf("run %q already succeeded; nothing to resume", resumeRunID)
+	}
+	options.resume = checkpoint
+	return nil
+}
+
+// flowRunCheckpointHash fingerprints the parts of a flow that decide which
+// step a recorded path refers to and which variables it starts with.
+func flowRunCheckpointHash(flow *Flow) string {
+	encoded, err := json.Marshal(struct {
+		Steps      []FlowStep               `json:"steps"`
+		Vars       map[string]any           `json:"vars"`
+		Parameters map[string]FlowParameter `json:"parameters"`
+		Fragments  map[string]FlowFragment  `json:"fragments"`
+	}{flow.Steps, flow.Vars, flow.Parameters, flow.Fragments})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+func (checkpoint *FlowRunCheckpoint) storageStatePath() string {
+	if checkpoint == nil || checkpoint.StorageState == "" {
+		return ""
+	}
+	path := filepath.Join(checkpoint.root, checkpoint.StorageState)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// flowRunCheckpointRecorder keeps the checkpoint of the running flow up to
+// date. Steps inside call_flow, db_transaction, and concurrent foreach
+// iterations run with their own variable scope, and steps inside
+// wait_for_response and capture_responses only make sense while the listener
+// is armed, and steps inside within_frame need the frame scope, so they are
+// not recorded one by one; the enclosing step is recorded when it completes
+// and re-runs as a whole on resume.
+type flowRunCheckpointRecorder struct {
+	mu         sync.Mutex
+	path       string
+	root       *FlowContext
+	checkpoint FlowRunCheckpoint
+	completed  map[string]bool
+	// pending holds the steps and foreach positions of the resumed run that
+	// have not been skipped yet. Each is skipped once so retries and loops run
+	// their steps again after the resume point.
+	pending        map[string]bool
+	pendingForeach map[string]int
+	written        bool
+	lastWrite      time.Time
+}
+
+func newFlowRunCheckpointRecorder(ctx *FlowContext, flow *Flow, resume *FlowRunCheckpoint) *flowRunCheckpointRecorder {
+	if strings.TrimSpace(ctx.RunRoot) == "" {
+		return nil
+	}
+	recorder := &flowRunCheckpointRecorder{
+		path: filepath.Join(ctx.RunRoot, flowRunCheckpointFile),
+		root: ctx,
+		checkpoint: FlowRunCheckpoint{
+			Version:          flowRunCheckpointVersion,
+			RunID:            ctx.RunID,
+			FlowName:         flow.Name,
+			FlowHash:         flowRunCheckpointHash(flow),
+			Status:           "running",
+			ForeachPositions: map[string]int{},
+		},
+		completed:      map[string]bool{},
+		pending:        map[string]bool{},
+		pendingForeach: map[string]int{},
+	}
+	if resume != nil {
+		recorder.checkpoint.ResumedFrom = resume.RunID
+		for _, path := range resume.CompletedSteps {
+			recorder.completed[path] = true
+			recorder.pending[path] = true
+		}
+		for path, position := range resume.ForeachPositions {
+			recorder.checkpoint.ForeachPositions[path] = position
+			recorder.pendingForeach[path] = position
+		}
+	}
+	return recorder
+}
+
+// restoreVars copies the checkpoint's variables into the run before the first
+// step. Secret variables are not stored and keep the value the resumed run
+// resolved at start, such as a secret parameter; one derived from a secret
+// during the run cannot be restored, so the resume is refused instead of
+// running on without it.
+func (recorder *flowRunCheckpointRecorder) restoreVars(L *lua.LState, ctx *FlowContext, resume *FlowRunCheckpoint) error {
+	if resume == nil {
+		return nil
+	}
+	secretVars := map[string]bool{}
+	unresolved := []string{}
+	for _, key := range resume.SecretVars {
+		secretVars[key] = true
+		if _, ok := ctx.Vars[key]; !ok {
+			unresolved = append(unresolved, key)
+		}
+	}
+	if len(unresolved) > 0 {
+		sort.Strings(unresolved)
+		return fmt.Errorf("cannot resume run %q: variables %s were derived from secrets and are not stored in the checkpoint; run the flow from the start", resume.RunID, strings.Join(unresolved, ", "))
+	}
+	for key, value := range resume.Vars {
+		if secretVars[key] {
+			continue
+		}
+		setFlowVar(L, ctx, key, value)
+	}
+	return nil
+}
+
+// skipStep reports whether stepPath already completed in the resumed run.
+func (recorder *flowRunCheckpointRecorder) skipStep(stepPath string) bool {
+	if recorder == nil {
+		return false
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if !recorder.pending[stepPath] {
+		return false
+	}
+	delete(recorder.pending, stepPath)
+	return true
+}
+
+// resumeForeach returns how many leading iterations of the foreach at
+// stepPath completed in the resumed run.
+func (recorder *flowRunCheckpointRecorder) resumeForeach(stepPath string) int {
+	if recorder == nil {
+		return 0
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	position := recorder.pendingForeach[stepPath]
+	delete(recorder.pendingForeach, stepPath)
+	return position
+}
+
+func (recorder *flowRunCheckpointRecorder) completeStep(stepPath string) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	// A completed step covers everything nested in it, which keeps the
+	// checkpoint small for long loops.
+	recorder.forgetDescendants(stepPath)
+	recorder.completed[stepPath] = true
+	recorder.writeThrottledLocked()
+}
+
+func (recorder *flowRunCheckpointRecorder) completeIteration(stepPath string, iteration int) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if iteration <= recorder.checkpoint.ForeachPositions[stepPath] {
+		return
+	}
+	recorder.forgetDescendants(fmt.Sprintf("%s[%d]", stepPath, iteration))
+	recorder.checkpoint.ForeachPositions[stepPath] = iteration
+	recorder.writeThrottledLocked()
+}
+
+func (recorder *flowRunCheckpointRecorder) forgetDescendants(stepPath string) {
+	for path := range recorder.completed {
+		if isFlowStepPathDescendant(path, stepPath) {
+			delete(recorder.completed, path)
+		}
+	}
+	for path := range recorder.checkpoint.ForeachPositions {
+		if path == stepPath || isFlowStepPathDescendant(path, stepPath) {
+			delete(recorder.checkpoint.ForeachPositions, path)
+		}
+	}
+}
+
+func isFlowStepPathDescendant(path string, ancestor string) bool {
+	return strings.HasPrefix(path, ancestor+".") || strings.HasPrefix(path, ancestor+"[")
+}
+
+// finish records the final status. On failure the browser storage state is
+// saved next to the checkpoint so a resumed run starts with the same cookies
+// and local storage.
+func (recorder *flowRunCheckpointRecorder) finish(L *lua.LState, result *FlowResult, runErr error) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if runErr == nil {
+		recorder.checkpoint.Status = FlowRunStatusSucceeded
+		recorder.checkpoint.FailedStepPath = ""
+		recorder.checkpoint.Error = ""
+		recorder.writeLocked()
+		return
+	}
+	recorder.checkpoint.Status = FlowRunStatusFailed
+	recorder.checkpoint.Error = recorder.root.Secrets.maskText(runErr.Error())
+	if result != nil && len(result.Trace) > 0 {
+		if failed, _ := findFailedFlowStepTrace(result.Trace); failed.Status == "error" {
+			recorder.checkpoint.FailedStepPath = failed.Path
+		}
+	}
+	if context, ok := flowBrowserContextFromState(L); ok && context != nil {
+		path := filepath.Join(filepath.Dir(recorder.path), flowRunCheckpointStorageStateFile)
+		if err := ensureOutputFileParent(path); err == nil {
+			// The storage state holds cookies, so only the owner may read it.
+			if _, err := context.StorageState(path); err == nil && os.Chmod(path, 0600) == nil {
+				recorder.checkpoint.StorageState = flowRunCheckpointStorageStateFile
+			}
+		}
+	}
+	recorder.writeLocked()
+}
+
+// writeThrottledLocked writes the checkpoint unless it was written within
+// flowRunCheckpointWriteInterval, so loops with many nested steps do not
+// rewrite the whole file after each one.
+func (recorder *flowRunCheckpointRecorder) writeThrottledLocked() {
+	if time.Since(recorder.lastWrite) < flowRunCheckpointWriteInterval {
+		return
+	}
+	recorder.writeLocked()
+}
+
+func (recorder *flowRunCheckpointRecorder) writeLocked() {
+	recorder.lastWrite = time.Now()
+	checkpoint := recorder.checkpoint
+	checkpoint.UpdatedAt = recorder.lastWrite.Format(time.RFC3339Nano)
+	checkpoint.Vars, checkpoint.SecretVars = flowCheckpointVars(recorder.root)
+	checkpoint.CompletedSteps = make([]string, 0, len(recorder.completed))
+	for path := range recorder.completed {
+		checkpoint.CompletedSteps = append(checkpoint.CompletedSteps, path)
+	}
+	sort.Strings(checkpoint.CompletedSteps)
+	encoded, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(recorder.path), 0755); err != nil {
+		return
+	}
+	temp := recorder.path + ".tmp"
+	// Variables can hold personal data, so only the owner may read them.
+	if err := os.WriteFile(temp, encoded, 0600); err != nil {
+		return
+	}
+	recorder.written = os.Rename(temp, recorder.path) == nil
+}
+
+// checkpointPath returns the checkpoint file once it has been written.
+func (recorder *flowRunCheckpointRecorder) checkpointPath() string {
+	if recorder == nil {
+		return ""
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if !recorder.written {
+		return ""
+	}
+	return recorder.path
+}
+
+// flowCheckpointVars snapshots the run variables for the checkpoint and names
+// the ones that held or were derived from a secret. Those are left out so a
+// secret never reaches disk, not even transformed beyond what masking
+// recognises; values that cannot be encoded as JSON (browser handles, for
+// example) are left out as well.
+func flowCheckpointVars(ctx *FlowContext) (map[string]any, []string) {
+	vars := map[string]any{}
+	secretVars := []string{}
+	for key, value := range ctx.Vars {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		if ctx.Secrets.isDerived(key) || flowCheckpointValueHasSecret(string(raw), ctx.Secrets.snapshot()) {
+			secretVars = append(secretVars, key)
+			continue
+		}
+		vars[key] = value
+	}
+	sort.Strings(secretVars)
+	return vars, secretVars
+}
+
+// flowCheckpointValueHasSecret matches secrets of any length, unlike the
+// trace masking, which only replaces very short secrets on an exact match.
+func flowCheckpointValueHasSecret(encoded string, secrets []string) bool {
+	for _, secret := range secrets {
+		escaped, err := json.Marshal(secret)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(encoded, string(escaped[1:len(escaped)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+func skippedFlowStepTrace(step FlowStep, index int, stepPath string, attempt int, iteration int) FlowStepTrace {
+	now := time.Now().Format(time.RFC3339Nano)
+	return FlowStepTrace{
+		Index:         index,
+		Path:          stepPath,
+		Attempt:       attempt,
+		Iteration:     iteration,
+		Name:          step.Name,
+		Action:        step.Action,
+		Status:        "skipped",
+		OutputSummary: "completed before resume",
+		StartedAt:     now,
+		FinishedAt:    now,
+	}
+}
@@ -0,0 +1,473 @@
+package tsplay_core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	flowRunIndexFileName     = "run_index.jsonl"
+	defaultFlowRunQueryLimit = 50
+)
+
+// flowRunIndexMu serializes appends so concurrent runs never interleave lines.
+var flowRunIndexMu sync.Mutex
+
+// FlowRunIndexEntry summarizes one run for the run index. Flow runs and MCP
+// browser-run audits sharing a run id are merged into a single entry.
+type FlowRunIndexEntry struct {
+	RunID          string `json:"run_id"`
+	FlowName       string `json:"flow_name,omitempty"`
+	Tool           string `json:"tool,omitempty"`
+	Status         string `json:"status,omitempty"`
+	RunStatus      string `json:"run_status,omitempty"`
+	SessionID      string `json:"session_id,omitempty"`
+	ClientName     string `json:"client_name,omitempty"`
+	StartedAt      string `json:"started_at,omitempty"`
+	FinishedAt     string `json:"finished_at,omitempty"`
+	DurationMS     int64  `json:"duration_ms,omitempty"`
+	RunRoot        string `json:"run_root,omitempty"`
+	AuditPath      string `json:"audit_path,omitempty"`
+	Checkpoint     string `json:"checkpoint,omitempty"`
+	StepCount      int    `json:"step_count,omitempty"`
+	FailedStepPath string `json:"failed_step_path,omitempty"`
+	ResumedFrom    string `json:"resumed_from,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// FlowRunQuery filters the run index. Zero fields match everything; Limit
+// keeps only the newest runs.
+type FlowRunQuery struct {
+	Flow    string
+	Status  string
+	Session string
+	Tool    string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+
+	// Owner, when set, limits the query to the runs one caller session may
+	// see; see flowRunOwnedBy.
+	Owner *string
+}
+
+// FlowRunDetails is one indexed run together with the audit and checkpoint
+// files it points to, when they still exist.
+type FlowRunDetails struct {
+	FlowRunIndexEntry
+	Audit     *TSPlayBrowserRun  `json:"audit,omitempty"`
+	Arguments any                `json:"arguments,omitempty"`
+	RunState  *FlowRunCheckpoint `json:"checkpoint_state,omitempty"`
+}
+
+// FlowRunIndexPath is the JSON lines run index kept under an artifact root.
+func FlowRunIndexPath(artifactRoot string) string {
+	if strings.TrimSpace(artifactRoot) == "" {
+		artifactRoot = DefaultFlowArtifactRoot
+	}
+	return filepath.Join(artifactRoot, flowRunIndexFileName)
+}
+
+func flowRunIndexEntryFromResult(result *FlowResult, options FlowRunOptions, startedAt time.Time, runErr error) FlowRunIndexEntry {
+	finishedAt := time.Now()
+	entry := FlowRunIndexEntry{
+		RunID:      result.RunID,
+		FlowName:   result.Name,
+		Tool:       options.tool,
+		Status:     firstNonEmpty(result.Status, FlowResultCompletionStatus(result, runErr)),
+		SessionID:  result.SessionID,
+		ClientName: options.ClientName,
+		StartedAt:  startedAt.Format(time.RFC3339Nano),
+		FinishedAt: finishedAt.Format(time.RFC3339Nano),
+		DurationMS: finishedAt.Sub(startedAt).Milliseconds(),
+		RunRoot:    result.RunRoot,
+		Checkpoint: result.Checkpoint,
+		StepCount:  len(result.Trace),
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+		if len(result.Trace) > 0 {
+			failed, _ := findFailedFlowStepTrace(result.Trace)
+			entry.FailedStepPath = failed.Path
+		}
+	}
+	if result.ResumedFrom != nil {
+		entry.ResumedFrom = result.ResumedFrom.RunID
+	}
+	return entry
+}
+
+func flowRunIndexEntryFromBrowserRun(run TSPlayBrowserRun) FlowRunIndexEntry {
+	entry := FlowRunIndexEntry{
+		RunID:      run.ID,
+		Tool:       run.Tool,
+		RunStatus:  run.Status,
+		SessionID:  run.Caller.SessionID,
+		ClientName: run.Caller.ClientName,
+		StartedAt:  firstNonEmpty(run.StartedAt, run.QueuedAt),
+		FinishedAt: run.FinishedAt,
+		DurationMS: run.DurationMS,
+		RunRoot:    run.RunRoot,
+		AuditPath:  run.AuditPath,
+		Error:      run.Error,
+	}
+	switch run.Status {
+	case "ok":
+		entry.Status = FlowRunStatusSucceeded
+	case "queued", "running":
+		entry.Status = run.Status
+	default:
+		entry.Status = FlowRunStatusFailed
+	}
+	if name, ok := run.Details["flow_name"].(string); ok {
+		entry.FlowName = name
+	}
+	return entry
+}
+
+func flowRunIndexEntryFromCheckpoint(checkpoint *FlowRunCheckpoint) FlowRunIndexEntry {
+	return FlowRunIndexEntry{
+		RunID:          checkpoint.RunID,
+		FlowName:       checkpoint.FlowName,
+		Status:         checkpoint.Status,
+		FinishedAt:     checkpoint.UpdatedAt,
+		RunRoot:        checkpoint.root,
+		Checkpoint:     filepath.Join(checkpoint.root, flowRunCheckpointFile),
+		FailedStepPath: checkpoint.FailedStepPath,
+		ResumedFrom:    checkpoint.ResumedFrom,
+		Error:          checkpoint.Error,
+	}
+}
+
+// merge folds a later record of the same run into the entry. The flow's own
+// completion status wins over the MCP wrapper's status, which only fills the
+// gap when the flow never produced a result.
+func (entry *FlowRunIndexEntry) merge(update FlowRunIndexEntry) {
+	setString := func(target *string, value string) {
+		if value != "" {
+			*target = value
+		}
+	}
+	if update.Status != "" && (entry.Status == "" || update.Tool == "") {
+		entry.Status = update.Status
+	}
+	setString(&entry.FlowName, update.FlowName)
+	setString(&entry.Tool, update.Tool)
+	setString(&entry.RunStatus, update.RunStatus)
+	setString(&entry.SessionID, update.SessionID)
+	setString(&entry.ClientName, update.ClientName)
+	if entry.StartedAt == "" {
+		entry.StartedAt = update.StartedAt
+	}
+	setString(&entry.FinishedAt, update.FinishedAt)
+	if update.DurationMS > entry.DurationMS {
+		entry.DurationMS = update.DurationMS
+	}
+	setString(&entry.RunRoot, update.RunRoot)
+	setString(&entry.AuditPath, update.AuditPath)
+	setString(&entry.Checkpoint, update.Checkpoint)
+	if update.StepCount > 0 {
+		entry.StepCount = update.StepCount
+	}
+	setString(&entry.FailedStepPath, update.FailedStepPath)
+	setString(&entry.ResumedFrom, update.ResumedFrom)
+	setString(&entry.Error, update.Error)
+}
+
+// recordFlowRunIndexEntry appends to the run index. Indexing is best effort:
+// a run never fails because its index line could not be written.
+func recordFlowRunIndexEntry(artifactRoot string, entry FlowRunIndexEntry) {
+	if strings.TrimSpace(artifactRoot) == "" || strings.TrimSpace(entry.RunID) == "" {
+		return
+	}
+	root, err := prepareRuntimeFileRoot(artifactRoot)
+	if err != nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	flowRunIndexMu.Lock()
+	defer flowRunIndexMu.Unlock()
+	file, err := os.OpenFile(FlowRunIndexPath(root), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, _ = file.Write(append(line, '\n'))
+}
+
+func loadFlowRunIndex(artifactRoot string) ([]FlowRunIndexEntry, error) {
+	path := FlowRunIndexPath(artifactRoot)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []FlowRunIndexEntry{}, nil
+		}
+		return nil, fmt.Errorf("open run index %q: %w", path, err)
+	}
+	defer file.Close()
+
+	entries := []FlowRunIndexEntry{}
+	positions := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var entry FlowRunIndexEntry
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return nil, fmt.Errorf("parse run index %q line %d: %w", path, line, err)
+		}
+		if position, ok := positions[entry.RunID]; ok {
+			entries[position].merge(entry)
+			continue
+		}
+		positions[entry.RunID] = len(entries)
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read run index %q: %w", path, err)
+	}
+	return entries, nil
+}
+
+// ListFlowRuns returns indexed runs matching the query, newest first.
+func ListFlowRuns(artifactRoot string, query FlowRunQuery) ([]FlowRunIndexEntry, error) {
+	entries, err := loadFlowRunIndex(artifactRoot)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]FlowRunIndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		if query.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return flowRunIndexTime(matched[i]).After(flowRunIndexTime(matched[j]))
+	})
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+	}
+	return matched, nil
+}
+
+// GetFlowRun loads one indexed run with its MCP audit and checkpoint.
+func GetFlowRun(artifactRoot string, runID string) (*FlowRunDetails, error) {
+	return getFlowRun(artifactRoot, runID, nil)
+}
+
+// GetFlowRunForSession is GetFlowRun for one caller session. A run of another
+// session is reported as not found.
+func GetFlowRunForSession(artifactRoot string, runID string, sessionID string) (*FlowRunDetails, error) {
+	return getFlowRun(artifactRoot, runID, &sessionID)
+}
+
+func getFlowRun(artifactRoot string, runID string, owner *string) (*FlowRunDetails, error) {
+	runID = strings.TrimSpace(runID)
+	if runID == "" {
+		return nil, fmt.Errorf("run id is required")
+	}
+	entries, err := loadFlowRunIndex(artifactRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.RunID != runID || (owner != nil && !flowRunOwnedBy(entry, *owner)) {
+			continue
+		}
+		details := &FlowRunDetails{FlowRunIndexEntry: entry}
+		if entry.AuditPath != "" {
+			if content, err := os.ReadFile(entry.AuditPath); err == nil {
+				var audit tsplayBrowserRunAudit
+				if json.Unmarshal(content, &audit) == nil {
+					details.Audit = &audit.Run
+					details.Arguments = audit.Arguments
+				}
+			}
+		}
+		if entry.RunRoot != "" {
+			if checkpoint, err := LoadFlowRunCheckpoint(entry.RunRoot); err == nil {
+				details.RunState = checkpoint
+			}
+		}
+		return details, nil
+	}
+	return nil, fmt.Errorf("run %q is not in the run index %s; use -action reindex-runs for runs recorded before the index existed", runID, FlowRunIndexPath(artifactRoot))
+}
+
+// RebuildFlowRunIndex rewrites the run index from the checkpoints and MCP run
+// audits found under the artifact root, for runs recorded before the index
+// existed or after the index file was removed.
+func RebuildFlowRunIndex(artifactRoot string) (int, error) {
+	if strings.TrimSpace(artifactRoot) == "" {
+		artifactRoot = DefaultFlowArtifactRoot
+	}
+	root, err := prepareRuntimeFileRoot(artifactRoot)
+	if err != nil {
+		return 0, err
+	}
+	entries := []FlowRunIndexEntry{}
+	positions := map[string]int{}
+	add := func(entry FlowRunIndexEntry) {
+		if entry.RunID == "" {
+			return
+		}
+		if position, ok := positions[entry.RunID]; ok {
+			entries[position].merge(entry)
+			return
+		}
+		positions[entry.RunID] = len(entries)
+		entries = append(entries, entry)
+	}
+	audits := []FlowRunIndexEntry{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil || d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case flowRunCheckpointFile:
+			if checkpoint, err := LoadFlowRunCheckpoint(filepath.Dir(path)); err == nil {
+				add(flowRunIndexEntryFromCheckpoint(checkpoint))
+			}
+		case "run.json":
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			var audit tsplayBrowserRunAudit
+			if json.Unmarshal(content, &audit) == nil && audit.Run.ID != "" && audit.Run.Tool != "" {
+				audits = append(audits, flowRunIndexEntryFromBrowserRun(audit.Run))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("scan artifact root %q: %w", root, err)
+	}
+	// Audits are merged after checkpoints so the flow status stays authoritative.
+	for _, audit := range audits {
+		add(audit)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return flowRunIndexTime(entries[i]).Before(flowRunIndexTime(entries[j]))
+	})
+
+	flowRunIndexMu.Lock()
+	defer flowRunIndexMu.Unlock()
+	if err := writeFlowRunIndexLocked(root, entries); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// writeFlowRunIndexLocked replaces the run index with entries. The caller
+// holds flowRunIndexMu.
+func writeFlowRunIndexLocked(root string, entries []FlowRunIndexEntry) error {
+	var content strings.Builder
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("marshal run index entry %q: %w", entry.RunID, err)
+		}
+		content.Write(line)
+		content.WriteByte('\n')
+	}
+	path := FlowRunIndexPath(root)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("write run index %q: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replace run index %q: %w", path, err)
+	}
+	return nil
+}
+
+func (query FlowRunQuery) matches(entry FlowRunIndexEntry) bool {
+	if query.Flow != "" && entry.FlowName != query.Flow {
+		return false
+	}
+	if query.Status != "" && entry.Status != query.Status && entry.RunStatus != query.Status {
+		return false
+	}
+	if query.Session != "" && entry.SessionID != query.Session {
+		return false
+	}
+	if query.Owner != nil && !flowRunOwnedBy(entry, *query.Owner) {
+		return false
+	}
+	if query.Tool != "" && entry.Tool != query.Tool {
+		return false
+	}
+	if !query.Since.IsZero() || !query.Until.IsZero() {
+		at := flowRunIndexTime(entry)
+		if at.IsZero() {
+			return false
+		}
+		if !query.Since.IsZero() && at.Before(query.Since) {
+			return false
+		}
+		if !query.Until.IsZero() && !at.Before(query.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// flowRunOwnedBy reports whether a caller session may see a run. MCP runs
+// hold their caller's arguments and variables, so they belong to the session
+// that started them. Runs started outside MCP, by the CLI, the scheduler or
+// the workbench, belong to the local operator, whose session id is empty.
+func flowRunOwnedBy(entry FlowRunIndexEntry, sessionID string) bool {
+	if entry.Tool != "" {
+		return entry.SessionID == sessionID
+	}
+	return sessionID == ""
+}
+
+func flowRunIndexTime(entry FlowRunIndexEntry) time.Time {
+	for _, text := range []string{entry.StartedAt, entry.FinishedAt} {
+		if at, err := time.Parse(time.RFC3339Nano, text); err == nil {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// ParseFlowRunTimeFilter reads a since/until bound: an RFC 3339 time, a
+// YYYY-MM-DD date in local time, or a lookback such as 90m, 24h or 7d.
+func ParseFlowRunTimeFilter(text string, now time.Time) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return time.Time{}, nil
+	}
+	if at, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return at, nil
+	}
+	if at, err := time.ParseInLocation("2006-01-02", text, time.Local); err == nil {
+		return at, nil
+	}
+	if days, ok := strings.CutSuffix(text, "d"); ok {
+		if count, err := strconv.Atoi(days); err == nil && count >= 0 {
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if lookback, err := time.ParseDuration(text); err == nil && lookback >= 0 {
+		return now.Add(-lookback), nil
+	}
+	return time.Time{}, fmt.Errorf("time filter %q must be an RFC 3339 time, a YYYY-MM-DD date, or a lookback such as 24h or 7d", text)
+}
@@ -0,0 +1,317 @@
+package tsplay_core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestRunFlowRecordsRunIndexEntries(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "indexed_flow",
+		Parameters:    map[string]FlowParameter{"count": {Type: "integer", Default: 1}},
+		Steps: []FlowStep{
+			{Action: "set_var", SaveAs: "first", Value: "a"},
+			{Action: "assert_number", With: map[string]any{"value": "{{count}}", "op": "<=", "expected": 5}},
+		},
+	}
+	if _, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: artifactRoot, SessionID: "alice"}); err != nil {
+		t.Fatalf("RunFlow returned error: %v", err)
+	}
+	failedResult, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: artifactRoot, SessionID: "bob", Params: map[string]any{"count": 10}})
+	if err == nil {
+		t.Fatalf("expected failing run")
+	}
+
+	runs, err := ListFlowRuns(artifactRoot, FlowRunQuery{Flow: "indexed_flow"})
+	if err != nil {
+		t.Fatalf("ListFlowRuns returned error: %v", err)
+	}
+	if len(runs) != 2 || runs[0].RunID != failedResult.RunID {
+		t.Fatalf("expected 2 runs newest first, got %#v", runs)
+	}
+	failed, _ := ListFlowRuns(artifactRoot, FlowRunQuery{Status: FlowRunStatusFailed})
+	if len(failed) != 1 || failed[0].SessionID != "bob" || failed[0].FailedStepPath != "2" || failed[0].Error == "" {
+		t.Fatalf("unexpected failed runs %#v", failed)
+	}
+	bySession, _ := ListFlowRuns(artifactRoot, FlowRunQuery{Session: "alice"})
+	if len(bySession) != 1 || bySession[0].Status != FlowRunStatusSucceeded || bySession[0].StepCount != 2 {
+		t.Fatalf("unexpected session runs %#v", bySession)
+	}
+	future, _ := ListFlowRuns(artifactRoot, FlowRunQuery{Since: time.Now().Add(time.Hour)})
+	if len(future) != 0 {
+		t.Fatalf("expected no runs after now, got %#v", future)
+	}
+
+	details, err := GetFlowRun(artifactRoot, failedResult.RunID)
+	if err != nil {
+		t.Fatalf("GetFlowRun returned error: %v", err)
+	}
+	if details.RunState == nil || details.RunState.FailedStepPath != "2" {
+		t.Fatalf("expected checkpoint state in run details, got %#v", details)
+	}
+	if _, err := GetFlowRun(artifactRoot, "missing"); err == nil {
+		t.Fatalf("expected error for unknown run")
+	}
+}
+
+func TestHandleListRunsToolMergesBrowserRunAudit(t *testing.T) {
+	artifactRoot := t.TempDir()
+	options := TSPlayMCPServerOptions{ArtifactRoot: artifactRoot}
+	result, err := handleRunFlowToolWithOptions(context.Background(), mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]any{"flow": `
+schema_version: "1"
+name: mcp_indexed
+steps:
+  - action: set_var
+    save_as: done
+    value: true
+`}},
+	}, options)
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	var runPayload map[string]any
+	decodeToolText(t, result, &runPayload)
+	run, _ := runPayload["run"].(map[string]any)
+	runID, _ := run["id"].(string)
+	if runPayload["ok"] != true || runID == "" {
+		t.Fatalf("unexpected run payload %#v", runPayload)
+	}
+	// The flow's own entry is written before the audit entry, so it must
+	// already carry the owner.
+	content, err := os.ReadFile(FlowRunIndexPath(artifactRoot))
+	if err != nil {
+		t.Fatalf("read run index: %v", err)
+	}
+	var first FlowRunIndexEntry
+	if err := json.Unmarshal([]byte(strings.SplitN(string(content), "\n", 2)[0]), &first); err != nil {
+		t.Fatalf("decode first index entry: %v", err)
+	}
+	if first.RunID != runID || first.Tool != "tsplay.run_flow" {
+		t.Fatalf("expected the first index entry to name the MCP tool, got %#v", first)
+	}
+
+	listed, err := InvokeTSPlayTool(context.Background(), "tsplay.list_runs", map[string]any{"flow": "mcp_indexed", "since": "1h"}, options)
+	if err != nil {
+		t.Fatalf("list runs: %v", err)
+	}
+	runs, _ := listed["runs"].([]any)
+	if listed["ok"] != true || len(runs) != 1 {
+		t.Fatalf("expected one indexed run, got %#v", listed)
+	}
+	entry, _ := runs[0].(map[string]any)
+	if entry["run_id"] != runID || entry["tool"] != "tsplay.run_flow" || entry["status"] != FlowRunStatusSucceeded || entry["run_status"] != "ok" || entry["audit_path"] == "" {
+		t.Fatalf("expected merged flow and audit entry, got %#v", entry)
+	}
+
+	got, err := InvokeTSPlayTool(context.Background(), "tsplay.get_run", map[string]any{"run_id": runID}, options)
+	if err != nil {
+		t.Fatalf("get run: %v", err)
+	}
+	detail, _ := got["run"].(map[string]any)
+	if got["ok"] != true || detail["audit"] == nil {
+		t.Fatalf("expected run audit in get_run, got %#v", got)
+	}
+
+	bad, err := InvokeTSPlayTool(context.Background(), "tsplay.list_runs", map[string]any{"since": "last week"}, options)
+	if err != nil {
+		t.Fatalf("list runs: %v", err)
+	}
+	if bad["ok"] != false {
+		t.Fatalf("expected invalid since to fail, got %#v", bad)
+	}
+}
+
+func TestRunIndexToolsOnlyShowTheCallersRuns(t *testing.T) {
+	artifactRoot := t.TempDir()
+	options := TSPlayMCPServerOptions{ArtifactRoot: artifactRoot}
+	makeCtx := func(id string) context.Context {
+		session := &runtimeTestSession{
+			id:          id,
+			initialized: true,
+			notify:      make(chan mcp.JSONRPCNotification, 1),
+		}
+		return server.NewMCPServer("test", "1.0.0").WithContext(context.Background(), session)
+	}
+	ownerCtx := makeCtx("session-owner")
+	result, err := handleRunFlowToolWithOptions(ownerCtx, mcp.CallToolRequest{
+		Params: mcp.CallToolParams{Arguments: map[string]any{"flow": `
+schema_version: "1"
+name: owned_run
+steps:
+  - action: set_var
+    save_as: done
+    value: true
+`}},
+	}, options)
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	var runPayload map[string]any
+	decodeToolText(t, result, &runPayload)
+	runID, _ := runPayload["run"].(map[string]any)["id"].(string)
+	if _, err := RunFlow(&Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "cli_run",
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "first", Value: "a"}},
+	}, FlowRunOptions{ArtifactRoot: artifactRoot}); err != nil {
+		t.Fatalf("RunFlow returned error: %v", err)
+	}
+
+	listRuns := func(ctx context.Context, options TSPlayMCPServerOptions) []any {
+		result, err := handleListRunsToolWithOptions(ctx, mcp.CallToolRequest{}, options)
+		if err != nil {
+			t.Fatalf("list runs: %v", err)
+		}
+		var payload map[string]any
+		decodeToolText(t, result, &payload)
+		runs, _ := payload["runs"].([]any)
+		return runs
+	}
+	getRun := func(ctx context.Context, options TSPlayMCPServerOptions) map[string]any {
+		result, err := handleGetRunToolWithOptions(ctx, mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: map[string]any{"run_id": runID}},
+		}, options)
+		if err != nil {
+			t.Fatalf("get run: %v", err)
+		}
+		var payload map[string]any
+		decodeToolText(t, result, &payload)
+		return payload
+	}
+
+	if runs := listRuns(ownerCtx, options); len(runs) != 1 || runs[0].(map[string]any)["run_id"] != runID {
+		t.Fatalf("expected only the owner's run, got %#v", runs)
+	}
+	if got := getRun(ownerCtx, options); got["ok"] != true {
+		t.Fatalf("expected the owner to get its run, got %#v", got)
+	}
+	otherCtx := makeCtx("session-other")
+	if runs := listRuns(otherCtx, options); len(runs) != 0 {
+		t.Fatalf("expected no runs for another session, got %#v", runs)
+	}
+	if got := getRun(otherCtx, options); got["ok"] != false || !strings.Contains(fmt.Sprint(got["error"]), "is not in the run index") {
+		t.Fatalf("expected another session's run to be not found, got %#v", got)
+	}
+	admin := TSPlayMCPServerOptions{ArtifactRoot: artifactRoot, AllowAllSessionRuns: true}
+	if runs := listRuns(otherCtx, admin); len(runs) != 2 {
+		t.Fatalf("expected every run for an admin server, got %#v", runs)
+	}
+	if got := getRun(otherCtx, admin); got["ok"] != true {
+		t.Fatalf("expected an admin server to get any run, got %#v", got)
+	}
+
+	// The workbench has no MCP session: it sees the CLI run only.
+	rec := httptest.NewRecorder()
+	NewWorkbenchAPIHandler(artifactRoot).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workbench/runs", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), runID) || !strings.Contains(rec.Body.String(), "cli_run") {
+		t.Fatalf("unexpected workbench runs %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	NewWorkbenchAPIHandler(artifactRoot).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workbench/runs/"+runID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected an MCP run to be hidden from the workbench, got %d", rec.Code)
+	}
+}
+
+func TestRebuildFlowRunIndexFromArtifacts(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "reindexed_flow",
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "first", Value: "a"}},
+	}
+	result, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: artifactRoot})
+	if err != nil {
+		t.Fatalf("RunFlow returned error: %v", err)
+	}
+	if err := os.Remove(FlowRunIndexPath(artifactRoot)); err != nil {
+		t.Fatalf("remove run index: %v", err)
+	}
+	if runs, _ := ListFlowRuns(artifactRoot, FlowRunQuery{}); len(runs) != 0 {
+		t.Fatalf("expected empty index after removal, got %#v", runs)
+	}
+
+	count, err := RebuildFlowRunIndex(artifactRoot)
+	if err != nil {
+		t.Fatalf("RebuildFlowRunIndex returned error: %v", err)
+	}
+	runs, _ := ListFlowRuns(artifactRoot, FlowRunQuery{Status: FlowRunStatusSucceeded})
+	if count != 1 || len(runs) != 1 || runs[0].RunID != result.RunID || runs[0].FlowName != "reindexed_flow" {
+		t.Fatalf("unexpected rebuilt index (%d) %#v", count, runs)
+	}
+}
+
+func TestWorkbenchRunsEndpoints(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "workbench_indexed",
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "first", Value: "a"}},
+	}
+	result, err := RunFlow(flow, FlowRunOptions{ArtifactRoot: artifactRoot})
+	if err != nil {
+		t.Fatalf("RunFlow returned error: %v", err)
+	}
+	handler := NewWorkbenchAPIHandler(artifactRoot)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workbench/runs?flow=workbench_indexed&status=succeeded", nil))
+	var listed struct {
+		Runs []FlowRunIndexEntry `json:"runs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected list response %d %s", rec.Code, rec.Body.String())
+	}
+	if len(listed.Runs) != 1 || listed.Runs[0].RunID != result.RunID {
+		t.Fatalf("unexpected runs %#v", listed.Runs)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workbench/runs/"+result.RunID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected get response %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/workbench/runs?limit=many", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request for invalid limit, got %d", rec.Code)
+	}
+}
+
+func TestParseFlowRunTimeFilter(t *testing.T) {
+	now := time.Date(2026, time.March, 6, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"2026-03-01T08:00:00Z": time.Date(2026, time.March, 1, 8, 0, 0, 0, time.UTC),
+		"24h":                  now.Add(-24 * time.Hour),
+		"7d":                   now.AddDate(0, 0, -7),
+	}
+	for text, want := range cases {
+		got, err := ParseFlowRunTimeFilter(text, now)
+		if err != nil || !got.Equal(want) {
+			t.Fatalf("ParseFlowRunTimeFilter(%q) = %s, %v; want %s", text, got, err, want)
+		}
+	}
+	if got, err := ParseFlowRunTimeFilter("2026-03-01", now); err != nil || got.Day() != 1 || got.Hour() != 0 {
+		t.Fatalf("unexpected date parse %s, %v", got, err)
+	}
+	if _, err := ParseFlowRunTimeFilter("yesterday", now); err == nil {
+		t.Fatalf("expected error for unsupported time filter")
+	}
+	if got, err := ParseFlowRunTimeFilter("", now); err != nil || !got.IsZero() {
+		t.Fatalf("expected zero time for empty filter, got %s, %v", got, err)
+	}
+}
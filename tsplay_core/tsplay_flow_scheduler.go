@@ -0,0 +1,532 @@
+package tsplay_core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FlowScheduleOverlapSkip  = "skip"
+	FlowScheduleOverlapQueue = "queue"
+	FlowScheduleOverlapAllow = "allow"
+
+	// FlowScheduleRunStatusSkipped marks a tick that did not start a run
+	// because the previous run of the same job was still going.
+	FlowScheduleRunStatusSkipped = "skipped"
+
+	flowSchedulerClientName      = "tsplay-scheduler"
+	flowSchedulerHistoryFolder   = "scheduler"
+	flowSchedulerHistoryFileName = "history.jsonl"
+)
+
+var flowScheduleOverlapPolicies = []string{FlowScheduleOverlapSkip, FlowScheduleOverlapQueue, FlowScheduleOverlapAllow}
+
+// FlowSchedule is the file read by -action scheduler. Each job runs one flow
+// file on a cron expression.
+type FlowSchedule struct {
+	// MaxConcurrentRuns caps how many scheduled runs drive a browser at once.
+	MaxConcurrentRuns int `json:"max_concurrent_runs,omitempty" yaml:"max_concurrent_runs,omitempty"`
+	// MaxRunsPerSession caps concurrent runs that share one saved session.
+	MaxRunsPerSession int `json:"max_runs_per_session,omitempty" yaml:"max_runs_per_session,omitempty"`
+	// HistoryPath overrides <artifact root>/scheduler/history.jsonl.
+	HistoryPath string `json:"history_path,omitempty" yaml:"history_path,omitempty"`
+	// Retention garbage collects old runs under the artifact root after each
+	// scheduled run.
+	Retention *FlowArtifactRetentionPolicy `json:"retention,omitempty" yaml:"retention,omitempty"`
+	Jobs      []FlowScheduleJob            `json:"jobs" yaml:"jobs"`
+
+	dir string
+}
+
+type FlowScheduleJob struct {
+	Name string `json:"name" yaml:"name"`
+	Cron string `json:"cron" yaml:"cron"`
+	// Flow is the flow file path, relative to the schedule file.
+	Flow   string         `json:"flow" yaml:"flow"`
+	Params map[string]any `json:"params,omitempty" yaml:"params,omitempty"`
+	// Session is a saved session name; it is applied as browser.use_session.
+	Session   string `json:"session,omitempty" yaml:"session,omitempty"`
+	TimeoutMS int    `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`
+	// Overlap decides what happens when a tick fires while the previous run
+	// of the job is still going: skip (default), queue one more run, or allow
+	// the runs to overlap.
+	Overlap  string `json:"overlap,omitempty" yaml:"overlap,omitempty"`
+	Disabled bool   `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// FlowScheduleRunRecord is one line of the scheduler history: the summary of
+// a scheduled run's FlowResult, or of a tick that was skipped.
+type FlowScheduleRunRecord struct {
+	Schedule       string `json:"schedule"`
+	FlowPath       string `json:"flow_path"`
+	FlowName       string `json:"flow_name,omitempty"`
+	RunID          string `json:"run_id,omitempty"`
+	Status         string `json:"status"`
+	Session        string `json:"session,omitempty"`
+	ScheduledAt    string `json:"scheduled_at"`
+	StartedAt      string `json:"started_at,omitempty"`
+	FinishedAt     string `json:"finished_at,omitempty"`
+	QueueWaitMS    int64  `json:"queue_wait_ms,omitempty"`
+	DurationMS     int64  `json:"duration_ms,omitempty"`
+	RunRoot        string `json:"run_root,omitempty"`
+	StepCount      int    `json:"step_count,omitempty"`
+	FailedStepPath string `json:"failed_step_path,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// FlowScheduleHistoryQuery filters scheduler history. Zero fields match
+// everything; Limit keeps only the newest records.
+type FlowScheduleHistoryQuery struct {
+	Schedule string
+	Flow     string
+	Status   string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+type FlowSchedulerOptions struct {
+	Context      context.Context
+	ArtifactRoot string
+	Headless     bool
+
+	runFlow func(*Flow, FlowRunOptions) (*FlowResult, error)
+	now     func() time.Time
+}
+
+type FlowScheduler struct {
+	schedule    *FlowSchedule
+	options     FlowSchedulerOptions
+	historyPath string
+	limiter     *tsplayBrowserRunLimiter
+	jobs        []*flowScheduledJob
+	historyMu   sync.Mutex
+	runs        sync.WaitGroup
+}
+
+type flowScheduledJob struct {
+	config   FlowScheduleJob
+	cron     *flowCronSchedule
+	flowPath string
+	next     time.Time
+
+	mu      sync.Mutex
+	running int
+	pending bool
+}
+
+func LoadFlowSchedule(path string) (*FlowSchedule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schedule FlowSchedule
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &schedule)
+	default:
+		err = yaml.Unmarshal(content, &schedule)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse schedule %s: %w", path, err)
+	}
+	schedule.dir = filepath.Dir(path)
+	return &schedule, nil
+}
+
+// NewFlowScheduler validates every job, including its flow file and params,
+// so a broken schedule fails at startup rather than at the first tick.
+func NewFlowScheduler(schedule *FlowSchedule, options FlowSchedulerOptions) (*FlowScheduler, error) {
+	if schedule == nil {
+		return nil, fmt.Errorf("schedule is nil")
+	}
+	if options.runFlow == nil {
+		options.runFlow = RunFlow
+	}
+	if options.now == nil {
+		options.now = time.Now
+	}
+	artifactRoot := strings.TrimSpace(options.ArtifactRoot)
+	if artifactRoot == "" {
+		artifactRoot = DefaultMCPArtifactRoot
+	}
+	artifactRoot, err := prepareRuntimeFileRoot(artifactRoot)
+	if err != nil {
+		return nil, err
+	}
+	options.ArtifactRoot = artifactRoot
+	if schedule.Retention != nil {
+		if err := schedule.Retention.Validate(); err != nil {
+			return nil, fmt.Errorf("schedule retention: %w", err)
+		}
+	}
+	historyPath := strings.TrimSpace(schedule.HistoryPath)
+	if historyPath == "" {
+		historyPath = DefaultFlowScheduleHistoryPath(artifactRoot)
+	} else if !filepath.IsAbs(historyPath) {
+		historyPath = filepath.Join(schedule.dir, historyPath)
+	}
+	scheduler := &FlowScheduler{
+		schedule:    schedule,
+		options:     options,
+		historyPath: historyPath,
+		limiter:     getTSPlayBrowserRunLimiter(schedule.MaxConcurrentRuns, schedule.MaxRunsPerSession),
+	}
+	names := map[string]bool{}
+	for i, config := range schedule.Jobs {
+		label := fmt.Sprintf("schedule job %d", i+1)
+		config.Name = strings.TrimSpace(config.Name)
+		if config.Name == "" {
+			return nil, fmt.Errorf("%s: name is required", label)
+		}
+		label = fmt.Sprintf("schedule job %q", config.Name)
+		if names[config.Name] {
+			return nil, fmt.Errorf("%s is declared more than once", label)
+		}
+		names[config.Name] = true
+		if config.Disabled {
+			continue
+		}
+		cron, err := parseFlowCron(config.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", label, err)
+		}
+		if cron.next(options.now()).IsZero() {
+			return nil, fmt.Errorf("%s: cron expression %q never matches", label, config.Cron)
+		}
+		config.Overlap = strings.ToLower(strings.TrimSpace(config.Overlap))
+		if config.Overlap == "" {
+			config.Overlap = FlowScheduleOverlapSkip
+		}
+		if !slices.Contains(flowScheduleOverlapPolicies, config.Overlap) {
+			return nil, fmt.Errorf("%s: overlap %q is not supported; use one of %s", label, config.Overlap, strings.Join(flowScheduleOverlapPolicies, ", "))
+		}
+		if config.TimeoutMS < 0 {
+			return nil, fmt.Errorf("%s: timeout_ms cannot be negative", label)
+		}
+		if strings.TrimSpace(config.Flow) == "" {
+			return nil, fmt.Errorf("%s: flow is required", label)
+		}
+		flowPath := config.Flow
+		if !filepath.IsAbs(flowPath) {
+			flowPath = filepath.Join(schedule.dir, flowPath)
+		}
+		job := &flowScheduledJob{config: config, cron: cron, flowPath: flowPath}
+		flow, err := job.loadFlow()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", label, err)
+		}
+		if err := ValidateFlow(flow); err != nil {
+			return nil, fmt.Errorf("%s: %w", label, err)
+		}
+		if _, err := resolveFlowParameters(flow, config.Params); err != nil {
+			return nil, fmt.Errorf("%s: %w", label, err)
+		}
+		scheduler.jobs = append(scheduler.jobs, job)
+	}
+	if len(scheduler.jobs) == 0 {
+		return nil, fmt.Errorf("schedule has no enabled jobs")
+	}
+	return scheduler, nil
+}
+
+// HistoryPath is the JSON lines file the scheduler appends run records to.
+func (scheduler *FlowScheduler) HistoryPath() string {
+	return scheduler.historyPath
+}
+
+// Run fires jobs on their cron expressions until the context is canceled,
+// then waits for in-flight runs to finish.
+func (scheduler *FlowScheduler) Run() error {
+	ctx := scheduler.options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	now := scheduler.options.now()
+	for _, job := range scheduler.jobs {
+		job.next = job.cron.next(now)
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		wake := time.Time{}
+		for _, job := range scheduler.jobs {
+			if wake.IsZero() || job.next.Before(wake) {
+				wake = job.next
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			scheduler.runs.Wait()
+			return nil
+		case <-timer.C:
+		}
+		now = scheduler.options.now()
+		for _, job := range scheduler.jobs {
+			if job.next.After(now) {
+				continue
+			}
+			scheduler.trigger(ctx, job, job.next)
+			job.next = job.cron.next(now)
+		}
+	}
+}
+
+// trigger starts a run for one tick, applying the job's overlap policy when a
+// previous run is still going.
+func (scheduler *FlowScheduler) trigger(ctx context.Context, job *flowScheduledJob, scheduledAt time.Time) {
+	job.mu.Lock()
+	if job.running > 0 {
+		switch job.config.Overlap {
+		case FlowScheduleOverlapQueue:
+			if !job.pending {
+				job.pending = true
+				job.mu.Unlock()
+				return
+			}
+			fallthrough
+		case FlowScheduleOverlapSkip:
+			job.mu.Unlock()
+			scheduler.record(FlowScheduleRunRecord{
+				Schedule:    job.config.Name,
+				FlowPath:    job.flowPath,
+				Status:      FlowScheduleRunStatusSkipped,
+				Session:     job.config.Session,
+				ScheduledAt: scheduledAt.Format(time.RFC3339Nano),
+				Error:       "previous run of this job is still running",
+			})
+			return
+		}
+	}
+	job.running++
+	job.mu.Unlock()
+
+	scheduler.runs.Add(1)
+	go func() {
+		defer scheduler.runs.Done()
+		for {
+			scheduler.runJob(ctx, job, scheduledAt)
+			job.mu.Lock()
+			if job.pending && ctx.Err() == nil {
+				job.pending = false
+				job.mu.Unlock()
+				scheduledAt = scheduler.options.now()
+				continue
+			}
+			job.pending = false
+			job.running--
+			job.mu.Unlock()
+			return
+		}
+	}()
+}
+
+func (scheduler *FlowScheduler) runJob(ctx context.Context, job *flowScheduledJob, scheduledAt time.Time) FlowScheduleRunRecord {
+	record := FlowScheduleRunRecord{
+		Schedule:    job.config.Name,
+		FlowPath:    job.flowPath,
+		Session:     job.config.Session,
+		ScheduledAt: scheduledAt.Format(time.RFC3339Nano),
+	}
+	defer func() { scheduler.record(record) }()
+
+	flow, err := job.loadFlow()
+	if err != nil {
+		record.Status = FlowRunStatusFailed
+		record.Error = err.Error()
+		return record
+	}
+	record.FlowName = flow.Name
+
+	timeoutMS := job.config.TimeoutMS
+	if timeoutMS <= 0 {
+		timeoutMS = defaultTSPlayFlowRunTimeoutMS
+	}
+	// The timeout covers the time spent waiting for a run slot, as it does for
+	// MCP runs.
+	runCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMS)*time.Millisecond)
+	defer cancel()
+	queuedAt := scheduler.options.now()
+	// Runs of a job without a session share no browser state, so only the
+	// global limit applies; the overlap policy alone decides whether they run
+	// side by side.
+	if job.config.Session == "" {
+		if err := scheduler.limiter.acquireGlobal(runCtx); err != nil {
+			record.Status = FlowRunStatusFailed
+			record.Error = fmt.Sprintf("waiting for a run slot: %v", err)
+			return record
+		}
+		defer scheduler.limiter.releaseGlobal()
+	} else {
+		limiterKey := "session:" + job.config.Session
+		if err := scheduler.limiter.acquire(runCtx, limiterKey); err != nil {
+			record.Status = FlowRunStatusFailed
+			record.Error = fmt.Sprintf("waiting for a run slot: %v", err)
+			return record
+		}
+		defer scheduler.limiter.releaseForSession(limiterKey)
+	}
+
+	startedAt := scheduler.options.now()
+	record.QueueWaitMS = startedAt.Sub(queuedAt).Milliseconds()
+	record.StartedAt = startedAt.Format(time.RFC3339Nano)
+	record.RunID = "schedule-" + sanitizeArtifactSegment(job.config.Name) + "-" + startedAt.Format("20060102-150405.000000000")
+	result, err := scheduler.options.runFlow(flow, FlowRunOptions{
+		Headless:     scheduler.options.Headless,
+		ArtifactRoot: scheduler.options.ArtifactRoot,
+		Context:      runCtx,
+		RunID:        record.RunID,
+		SessionID:    job.config.Session,
+		ClientName:   flowSchedulerClientName,
+		Params:       job.config.Params,
+		Retention:    scheduler.schedule.Retention,
+	})
+	finishedAt := scheduler.options.now()
+	record.FinishedAt = finishedAt.Format(time.RFC3339Nano)
+	record.DurationMS = finishedAt.Sub(startedAt).Milliseconds()
+	record.Status = FlowResultCompletionStatus(result, err)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if result != nil {
+		record.RunRoot = result.RunRoot
+		record.StepCount = len(result.Trace)
+		if err != nil && len(result.Trace) > 0 {
+			failed, _ := findFailedFlowStepTrace(result.Trace)
+			record.FailedStepPath = failed.Path
+		}
+	}
+	return record
+}
+
+// loadFlow reads the flow file on every run so edits apply at the next tick.
+func (job *flowScheduledJob) loadFlow() (*Flow, error) {
+	flow, err := LoadFlowFile(job.flowPath)
+	if err != nil {
+		return nil, err
+	}
+	if session := strings.TrimSpace(job.config.Session); session != "" {
+		if flow.Browser == nil {
+			flow.Browser = &FlowBrowserConfig{}
+		}
+		flow.Browser.UseSession = session
+	}
+	return flow, nil
+}
+
+func (scheduler *FlowScheduler) record(record FlowScheduleRunRecord) {
+	scheduler.historyMu.Lock()
+	defer scheduler.historyMu.Unlock()
+	if err := appendFlowScheduleRunRecord(scheduler.historyPath, record); err != nil {
+		fmt.Fprintf(os.Stderr, "scheduler: %v\n", err)
+	}
+}
+
+// DefaultFlowScheduleHistoryPath is where the scheduler keeps run history
+// unless the schedule sets history_path.
+func DefaultFlowScheduleHistoryPath(artifactRoot string) string {
+	if strings.TrimSpace(artifactRoot) == "" {
+		artifactRoot = DefaultMCPArtifactRoot
+	}
+	return filepath.Join(artifactRoot, flowSchedulerHistoryFolder, flowSchedulerHistoryFileName)
+}
+
+func appendFlowScheduleRunRecord(path string, record FlowScheduleRunRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create scheduler history directory: %w", err)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal scheduler history record: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open scheduler history %q: %w", path, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write scheduler history %q: %w", path, err)
+	}
+	return nil
+}
+
+// QueryFlowScheduleHistory reads scheduler history and returns matching
+// records, newest first. A missing history file yields no records.
+func QueryFlowScheduleHistory(path string, query FlowScheduleHistoryQuery) ([]FlowScheduleRunRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []FlowScheduleRunRecord{}, nil
+		}
+		return nil, fmt.Errorf("open scheduler history %q: %w", path, err)
+	}
+	defer file.Close()
+
+	records := []FlowScheduleRunRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record FlowScheduleRunRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("parse scheduler history %q line %d: %w", path, line, err)
+		}
+		if query.matches(record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read scheduler history %q: %w", path, err)
+	}
+	// Records are appended as runs finish, so file order is oldest first.
+	slices.Reverse(records)
+	if query.Limit > 0 && len(records) > query.Limit {
+		records = records[:query.Limit]
+	}
+	return records, nil
+}
+
+func (query FlowScheduleHistoryQuery) matches(record FlowScheduleRunRecord) bool {
+	if query.Schedule != "" && record.Schedule != query.Schedule {
+		return false
+	}
+	if query.Flow != "" && record.FlowName != query.Flow && record.FlowPath != query.Flow && filepath.Base(record.FlowPath) != query.Flow {
+		return false
+	}
+	if query.Status != "" && record.Status != query.Status {
+		return false
+	}
+	if !query.Since.IsZero() || !query.Until.IsZero() {
+		scheduledAt, err := time.Parse(time.RFC3339Nano, record.ScheduledAt)
+		if err != nil {
+			return false
+		}
+		if !query.Since.IsZero() && scheduledAt.Before(query.Since) {
+			return false
+		}
+		if !query.Until.IsZero() && !scheduledAt.Before(query.Until) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package tsplay_core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseFlowCronNext(t *testing.T) {
+	base := time.Date(2026, time.March, 6, 10, 17, 30, 0, time.UTC) // Friday
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, time.March, 6, 10, 30, 0, 0, time.UTC)},
+		{"0 8 * * mon-fri", time.Date(2026, time.March, 9, 8, 0, 0, 0, time.UTC)},
+		{"30 9 1,15 * *", time.Date(2026, time.March, 15, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * 5", time.Date(2026, time.March, 6, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.March, 8, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.March, 6, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		schedule, err := parseFlowCron(tc.expr)
+		if err != nil {
+			t.Fatalf("parseFlowCron(%q) returned error: %v", tc.expr, err)
+		}
+		if got := schedule.next(base); !got.Equal(tc.want) {
+			t.Fatalf("next(%q) = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 * foo *"} {
+		if _, err := parseFlowCron(expr); err == nil {
+			t.Fatalf("parseFlowCron(%q) expected error", expr)
+		}
+	}
+	never, err := parseFlowCron("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("parseFlowCron returned error: %v", err)
+	}
+	if got := never.next(base); !got.IsZero() {
+		t.Fatalf("expected no match for Feb 31, got %s", got)
+	}
+}
+
+func TestNewFlowSchedulerValidatesJobs(t *testing.T) {
+	dir := t.TempDir()
+	writeFlowSchedulerTestFlow(t, dir)
+
+	cases := []struct {
+		name string
+		job  FlowScheduleJob
+		want string
+	}{
+		{"missing name", FlowScheduleJob{Cron: "@daily", Flow: "report.flow.yaml"}, "name is required"},
+		{"bad cron", FlowScheduleJob{Name: "a", Cron: "daily", Flow: "report.flow.yaml"}, "5 fields"},
+		{"bad overlap", FlowScheduleJob{Name: "a", Cron: "@daily", Flow: "report.flow.yaml", Overlap: "replace", Params: map[string]any{"region": "east"}}, `overlap "replace"`},
+		{"missing flow", FlowScheduleJob{Name: "a", Cron: "@daily", Flow: "missing.flow.yaml"}, "no such file"},
+		{"missing param", FlowScheduleJob{Name: "a", Cron: "@daily", Flow: "report.flow.yaml"}, `parameter "region" is required`},
+	}
+	for _, tc := range cases {
+		_, err := NewFlowScheduler(&FlowSchedule{Jobs: []FlowScheduleJob{tc.job}, dir: dir}, FlowSchedulerOptions{ArtifactRoot: t.TempDir()})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestFlowSchedulerOverlapPolicies(t *testing.T) {
+	dir := t.TempDir()
+	writeFlowSchedulerTestFlow(t, dir)
+	schedulePath := filepath.Join(dir, "schedule.yaml")
+	if err := os.WriteFile(schedulePath, []byte(`
+max_concurrent_runs: 8
+history_path: history.jsonl
+jobs:
+  - name: skipper
+    cron: "@hourly"
+    flow: report.flow.yaml
+    params: {region: east}
+  - name: queuer
+    cron: "@hourly"
+    flow: report.flow.yaml
+    params: {region: west}
+    overlap: queue
+  - name: allower
+    cron: "@hourly"
+    flow: report.flow.yaml
+    params: {region: north}
+    overlap: allow
+`), 0644); err != nil {
+		t.Fatalf("write schedule: %v", err)
+	}
+	schedule, err := LoadFlowSchedule(schedulePath)
+	if err != nil {
+		t.Fatalf("LoadFlowSchedule returned error: %v", err)
+	}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	started := map[string]int{}
+	scheduler, err := NewFlowScheduler(schedule, FlowSchedulerOptions{
+		ArtifactRoot: t.TempDir(),
+		runFlow: func(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
+			mu.Lock()
+			started[flowExprString(options.Params["region"])]++
+			mu.Unlock()
+			<-release
+			return RunFlow(flow, options)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewFlowScheduler returned error: %v", err)
+	}
+	if scheduler.HistoryPath() != filepath.Join(dir, "history.jsonl") {
+		t.Fatalf("unexpected history path %q", scheduler.HistoryPath())
+	}
+
+	ctx := context.Background()
+	tick := time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC)
+	for _, job := range scheduler.jobs {
+		for i := 0; i < 3; i++ {
+			scheduler.trigger(ctx, job, tick.Add(time.Duration(i)*time.Hour))
+		}
+	}
+	// allower's three runs must all be in flight before any of them finishes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		north := started["north"]
+		mu.Unlock()
+		if north == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected allow to start 3 overlapping runs, got %d", north)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	scheduler.runs.Wait()
+
+	if started["east"] != 1 || started["west"] != 2 || started["north"] != 3 {
+		t.Fatalf("unexpected run counts %#v", started)
+	}
+	skipped, err := QueryFlowScheduleHistory(scheduler.HistoryPath(), FlowScheduleHistoryQuery{Status: FlowScheduleRunStatusSkipped})
+	if err != nil {
+		t.Fatalf("QueryFlowScheduleHistory returned error: %v", err)
+	}
+	// skipper drops two ticks; queuer keeps one pending run and drops the third tick.
+	if len(skipped) != 3 {
+		t.Fatalf("expected 3 skipped ticks, got %#v", skipped)
+	}
+	succeeded, err := QueryFlowScheduleHistory(scheduler.HistoryPath(), FlowScheduleHistoryQuery{Schedule: "queuer", Status: FlowRunStatusSucceeded})
+	if err != nil {
+		t.Fatalf("QueryFlowScheduleHistory returned error: %v", err)
+	}
+	if len(succeeded) != 2 {
+		t.Fatalf("expected 2 queuer runs, got %#v", succeeded)
+	}
+	record := succeeded[0]
+	if record.FlowName != "scheduled_report" || record.RunID == "" || record.StepCount != 1 || record.RunRoot == "" {
+		t.Fatalf("unexpected history record %#v", record)
+	}
+	if _, err := os.Stat(filepath.Join(record.RunRoot, flowRunCheckpointFile)); err != nil {
+		t.Fatalf("expected scheduled run to keep its run root: %v", err)
+	}
+}
+
+func TestQueryFlowScheduleHistoryFilters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	records := []FlowScheduleRunRecord{
+		{Schedule: "a", FlowName: "report", FlowPath: "/flows/report.flow.yaml", Status: FlowRunStatusSucceeded, ScheduledAt: "2026-03-01T08:00:00Z"},
+		{Schedule: "a", FlowName: "report", FlowPath: "/flows/report.flow.yaml", Status: FlowRunStatusFailed, ScheduledAt: "2026-03-02T08:00:00Z"},
+		{Schedule: "b", FlowName: "sync", FlowPath: "/flows/sync.flow.yaml", Status: FlowRunStatusFailed, ScheduledAt: "2026-03-03T08:00:00Z"},
+	}
+	for _, record := range records {
+		if err := appendFlowScheduleRunRecord(path, record); err != nil {
+			t.Fatalf("appendFlowScheduleRunRecord returned error: %v", err)
+		}
+	}
+
+	failed, err := QueryFlowScheduleHistory(path, FlowScheduleHistoryQuery{Status: FlowRunStatusFailed})
+	if err != nil {
+		t.Fatalf("QueryFlowScheduleHistory returned error: %v", err)
+	}
+	if len(failed) != 2 || failed[0].Schedule != "b" {
+		t.Fatalf("expected failed runs newest first, got %#v", failed)
+	}
+	byFlow, _ := QueryFlowScheduleHistory(path, FlowScheduleHistoryQuery{Flow: "report.flow.yaml"})
+	if len(byFlow) != 2 {
+		t.Fatalf("expected 2 runs of report.flow.yaml, got %#v", byFlow)
+	}
+	window, _ := QueryFlowScheduleHistory(path, FlowScheduleHistoryQuery{
+		Since: time.Date(2026, time.March, 2, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2026, time.March, 3, 0, 0, 0, 0, time.UTC),
+	})
+	if len(window) != 1 || window[0].Status != FlowRunStatusFailed || window[0].Schedule != "a" {
+		t.Fatalf("unexpected time window result %#v", window)
+	}
+	limited, _ := QueryFlowScheduleHistory(path, FlowScheduleHistoryQuery{Limit: 1})
+	if len(limited) != 1 || limited[0].Schedule != "b" {
+		t.Fatalf("unexpected limited result %#v", limited)
+	}
+	missing, err := QueryFlowScheduleHistory(filepath.Join(t.TempDir(), "none.jsonl"), FlowScheduleHistoryQuery{})
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected empty history for missing file, got %#v, %v", missing, err)
+	}
+}
+
+func writeFlowSchedulerTestFlow(t *testing.T, dir string) {
+	t.Helper()
+	content := `schema_version: "1"
+name: scheduled_report
+parameters:
+  region:
+    type: string
+    required: true
+steps:
+  - action: set_var
+    save_as: region_label
+    value: "{{ upper(region) }}"
+`
+	if err := os.WriteFile(filepath.Join(dir, "report.flow.yaml"), []byte(content), 0644); err != nil {
+		t.Fatalf("write flow: %v", err)
+	}
+}
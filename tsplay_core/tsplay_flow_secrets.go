@@ -0,0 +1,799 @@
+package tsplay_core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Flows read secrets with {{ secret.NAME }}. Values come from the
+// TSPLAY_SECRET_<NAME> environment variable first and then from an encrypted
+// local secrets file. Every resolved value is registered for masking so it
+// never reaches traces, results, repair prompts, or MCP responses.
+
+const (
+	flowSecretRefName          = "secret"
+	flowSecretEnvPrefix        = "TSPLAY_SECRET_"
+	envFlowSecretsFile         = "TSPLAY_SECRETS_FILE"
+	envFlowSecretsKey          = "TSPLAY_SECRETS_KEY"
+	flowSecretsFileVersion     = 1
+	flowSecretsKDFIterations   = 200000
+	flowSecretMinSubstringMask = 4
+)
+
+var flowSecretPathPattern = regexp.MustCompile(`^\$\.([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// activeFlowSecrets tracks the secret registries of the runs in progress. The
+// MCP response layer masks with it as a last line of defence; a run leaves the
+// set when it ends, so its values are not kept for the life of the process.
+var activeFlowSecrets = &flowActiveSecrets{registries: map[*flowSecretRegistry]int{}}
+
+type flowActiveSecrets struct {
+	mu         sync.Mutex
+	registries map[*flowSecretRegistry]int
+}
+
+// track adds a run's registry to the set and returns the func that removes it.
+func (active *flowActiveSecrets) track(registry *flowSecretRegistry) func() {
+	if registry == nil {
+		return func() {}
+	}
+	active.mu.Lock()
+	active.registries[registry]++
+	active.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			active.mu.Lock()
+			defer active.mu.Unlock()
+			if active.registries[registry]--; active.registries[registry] <= 0 {
+				delete(active.registries, registry)
+			}
+		})
+	}
+}
+
+// registry merges the secrets of every active run into one registry.
+func (active *flowActiveSecrets) registry() *flowSecretRegistry {
+	merged := newFlowSecretRegistry()
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	for registry := range active.registries {
+		merged.add(registry.snapshot()...)
+	}
+	return merged
+}
+
+// flowSecretRegistry tracks secret values for one run. Copies of a
+// FlowContext share the same registry, so secrets resolved inside nested or
+// concurrent steps are masked everywhere in the run.
+type flowSecretRegistry struct {
+	mu     sync.RWMutex
+	named  map[string]string
+	values []string
+
+	// derived names the run variables computed from a secret, for example by
+	// a Lua step. Their values cannot be masked, so they are never persisted.
+	derived map[string]bool
+}
+
+func newFlowSecretRegistry() *flowSecretRegistry {
+	return &flowSecretRegistry{named: map[string]string{}}
+}
+
+func (registry *flowSecretRegistry) add(values ...string) {
+	if registry == nil {
+		return
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, value := range values {
+		if value == "" || containsFlowSecretValue(registry.values, value) {
+			continue
+		}
+		registry.values = append(registry.values, value)
+	}
+	// Mask longer secrets first so a secret that contains another is not left
+	// partially visible.
+	sort.SliceStable(registry.values, func(i, j int) bool { return len(registry.values[i]) > len(registry.values[j]) })
+}
+
+func containsFlowSecretValue(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (registry *flowSecretRegistry) setNamed(name string, value string) {
+	if registry == nil {
+		return
+	}
+	registry.mu.Lock()
+	if registry.named == nil {
+		registry.named = map[string]string{}
+	}
+	registry.named[name] = value
+	registry.mu.Unlock()
+	registry.add(value)
+}
+
+func (registry *flowSecretRegistry) hasNamed(name string) bool {
+	if registry == nil {
+		return false
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	_, ok := registry.named[name]
+	return ok
+}
+
+func (registry *flowSecretRegistry) namedValues() map[string]any {
+	values := map[string]any{}
+	if registry == nil {
+		return values
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	for name, value := range registry.named {
+		values[name] = value
+	}
+	return values
+}
+
+// markDerived records whether the variable name now holds a value computed
+// from a secret.
+func (registry *flowSecretRegistry) markDerived(name string, derived bool) {
+	if registry == nil || name == "" {
+		return
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if !derived {
+		delete(registry.derived, name)
+		return
+	}
+	if registry.derived == nil {
+		registry.derived = map[string]bool{}
+	}
+	registry.derived[name] = true
+}
+
+func (registry *flowSecretRegistry) isDerived(name string) bool {
+	if registry == nil {
+		return false
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.derived[name]
+}
+
+func (registry *flowSecretRegistry) derivedNames() []string {
+	if registry == nil {
+		return nil
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	names := make([]string, 0, len(registry.derived))
+	for name := range registry.derived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (registry *flowSecretRegistry) snapshot() []string {
+	if registry == nil {
+		return nil
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return append([]string(nil), registry.values...)
+}
+
+func (registry *flowSecretRegistry) empty() bool {
+	if registry == nil {
+		return true
+	}
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return len(registry.values) == 0
+}
+
+// mask returns a copy of value with every registered secret replaced by
+// flowSecretMask.
+func (registry *flowSecretRegistry) mask(value any) any {
+	return maskFlowSecrets(value, registry.snapshot())
+}
+
+func (registry *flowSecretRegistry) maskText(text string) string {
+	return maskFlowSecretText(text, registry.snapshot())
+}
+
+// maskError keeps the error chain intact while hiding secrets in its message.
+func (registry *flowSecretRegistry) maskError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := registry.maskText(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &flowMaskedError{message: message, err: err}
+}
+
+// maskJSON masks secrets inside an encoded JSON document. Secrets are matched
+// in their JSON-escaped form so quotes and backslashes cannot defeat masking.
+func (registry *flowSecretRegistry) maskJSON(encoded []byte) []byte {
+	secrets := registry.snapshot()
+	if len(secrets) == 0 {
+		return encoded
+	}
+	text := string(encoded)
+	for _, secret := range secrets {
+		if len(secret) < flowSecretMinSubstringMask {
+			continue
+		}
+		escaped, err := json.Marshal(secret)
+		if err != nil {
+			continue
+		}
+		text = strings.ReplaceAll(text, string(escaped[1:len(escaped)-1]), flowSecretMask)
+	}
+	return []byte(text)
+}
+
+type flowMaskedError struct {
+	message string
+	err     error
+}
+
+func (err *flowMaskedError) Error() string {
+	return err.message
+}
+
+func (err *flowMaskedError) Unwrap() error {
+	return err.err
+}
+
+// maskFlowSecrets returns a copy of value with every occurrence of a secret
+// replaced by flowSecretMask. Very short secrets only mask exact matches so
+// that a one-character secret does not garble unrelated text.
+func maskFlowSecrets(value any, secrets []string) any {
+	if len(secrets) == 0 {
+		return value
+	}
+	switch typed := value.(type) {
+	case string:
+		return maskFlowSecretText(typed, secrets)
+	case []any:
+		items := make([]any, len(typed))
+		for i, item := range typed {
+			items[i] = maskFlowSecrets(item, secrets)
+		}
+		return items
+	case []string:
+		items := make([]any, len(typed))
+		for i, item := range typed {
+			items[i] = maskFlowSecrets(item, secrets)
+		}
+		return items
+	case map[string]any:
+		masked := make(map[string]any, len(typed))
+		for key, item := range typed {
+			masked[key] = maskFlowSecrets(item, secrets)
+		}
+		return masked
+	case map[string]string:
+		masked := make(map[string]any, len(typed))
+		for key, item := range typed {
+			masked[key] = maskFlowSecretText(item, secrets)
+		}
+		return masked
+	default:
+		if number, ok := flowExprNumber(value); ok {
+			for _, secret := range secrets {
+				if flowExprString(number) == secret {
+					return flowSecretMask
+				}
+			}
+		}
+		return value
+	}
+}
+
+func maskFlowSecretText(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if text == secret {
+			return flowSecretMask
+		}
+		if len(secret) >= flowSecretMinSubstringMask {
+			text = strings.ReplaceAll(text, secret, flowSecretMask)
+		}
+	}
+	return text
+}
+
+// isSensitiveFlowKey reports whether a map key conventionally holds a
+// credential, so trace and repair output can hide it even when the value was
+// written literally in the flow instead of coming from a secret reference.
+func isSensitiveFlowKey(key string) bool {
+	normalized := strings.ToLower(strings.TrimSpace(key))
+	switch normalized {
+	case "authorization", "proxy-authorization", "cookie", "set-cookie", "x-api-key", "api_key", "apikey":
+		return true
+	}
+	return strings.Contains(normalized, "password") || strings.Contains(normalized, "passwd") || strings.Contains(normalized, "secret") || strings.HasSuffix(normalized, "token")
+}
+
+// redactSensitiveFlowValue hides a literal credential but keeps placeholders,
+// which only name where the value comes from.
+func redactSensitiveFlowValue(value any) any {
+	text, ok := value.(string)
+	if !ok {
+		if value == nil {
+			return nil
+		}
+		return flowSecretMask
+	}
+	if text == "" || text == flowSecretMask || placeholderPattern.MatchString(strings.TrimSpace(text)) {
+		return text
+	}
+	return flowSecretMask
+}
+
+// redactSensitiveFlowKeys hides literal values under credential-like keys at
+// any depth. Traces only mask secret values; repair context and MCP run audits
+// hold raw tool input, so they also hide credentials written literally.
+func redactSensitiveFlowKeys(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		redacted := make(map[string]any, len(typed))
+		for key, item := range typed {
+			if isSensitiveFlowKey(key) {
+				redacted[key] = redactSensitiveFlowValue(item)
+				continue
+			}
+			redacted[key] = redactSensitiveFlowKeys(item)
+		}
+		return redacted
+	case []any:
+		items := make([]any, len(typed))
+		for i, item := range typed {
+			items[i] = redactSensitiveFlowKeys(item)
+		}
+		return items
+	default:
+		return value
+	}
+}
+
+// flowSecretReferenceName returns NAME for a plain {{ secret.NAME }} path.
+func flowSecretReferenceName(path string) (string, error) {
+	matches := flowSecretPathPattern.FindStringSubmatch(path)
+	if len(matches) != 2 {
+		return "", fmt.Errorf("secret references must look like secret.NAME")
+	}
+	return matches[1], nil
+}
+
+// errFlowSecretInExpression rejects secret.NAME inside a computed expression.
+// A transform such as upper(secret.NAME) would produce a value the registry
+// cannot mask, and len() or matches() would leak facts about the secret.
+var errFlowSecretInExpression = errors.New("secrets can only be used as a plain {{ secret.NAME }} placeholder, not inside an expression")
+
+// flowExprUsesSecrets reports whether a computed expression reads the secret
+// namespace.
+func flowExprUsesSecrets(node flowExprNode) bool {
+	for _, name := range flowExprVariables(node) {
+		if name == flowSecretRefName {
+			return true
+		}
+	}
+	return false
+}
+
+// flowPlaceholderSecretNames returns the secret names one placeholder reads.
+func flowPlaceholderSecretNames(expr string) ([]string, error) {
+	node, isPath, err := parseFlowPlaceholder(expr)
+	if err != nil {
+		return nil, err
+	}
+	if isPath {
+		base, path, _ := parseFlowVariableReference(expr)
+		if base != flowSecretRefName {
+			return nil, nil
+		}
+		name, err := flowSecretReferenceName(path)
+		if err != nil {
+			return nil, err
+		}
+		return []string{name}, nil
+	}
+	if flowExprUsesSecrets(node) {
+		return nil, errFlowSecretInExpression
+	}
+	return nil, nil
+}
+
+// flowSecretReferences lists every secret a flow's steps and fragments read.
+// Flows written before secret references existed may use a variable named
+// "secret"; that variable shadows the namespace and nothing is collected.
+func flowSecretReferences(vars map[string]any, parameters map[string]FlowParameter, steps []FlowStep, fragments map[string]FlowFragment) []string {
+	if _, ok := vars[flowSecretRefName]; ok {
+		return nil
+	}
+	if _, ok := parameters[flowSecretRefName]; ok {
+		return nil
+	}
+	seen := map[string]bool{}
+	names := []string{}
+	shadowed := false
+	collect := func(steps []FlowStep) {
+		_ = forEachFlowStep(steps, "", func(step FlowStep, _ string) error {
+			if step.SaveAs == flowSecretRefName || step.ItemVar == flowSecretRefName {
+				shadowed = true
+			}
+			values := []any{step.presentNamedParams()}
+			if len(step.Args) > 0 {
+				values = append(values, step.Args)
+			}
+			for _, value := range values {
+				for _, expr := range flowReferenceExpressions(value) {
+					refs, _ := flowPlaceholderSecretNames(expr)
+					for _, name := range refs {
+						if !seen[name] {
+							seen[name] = true
+							names = append(names, name)
+						}
+					}
+				}
+			}
+			return nil
+		})
+	}
+	collect(steps)
+	fragmentNames := make([]string, 0, len(fragments))
+	for name := range fragments {
+		fragmentNames = append(fragmentNames, name)
+	}
+	sort.Strings(fragmentNames)
+	for _, name := range fragmentNames {
+		collect(fragments[name].Steps)
+	}
+	if shadowed {
+		return nil
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateFlowSecretAccess rejects secret references unless the policy grants
+// allow_secrets; an untrusted flow could otherwise send a stored credential to
+// any page it navigates to.
+func validateFlowSecretAccess(flow *Flow, policy FlowSecurityPolicy) error {
+	if policy.AllowSecrets {
+		return nil
+	}
+	names := flowSecretReferences(flow.Vars, flow.Parameters, flow.Steps, flow.Fragments)
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("secret %q is disabled by security policy; set allow_secrets=true only for trusted flows", names[0])
+}
+
+// flowStepReadsSecrets reports whether a step's parameters read a secret, a
+// secret parameter or a variable derived from one, so its output is derived
+// from a secret as well. Lua and page scripts are checked for the names of
+// derived variables because they read flow variables directly.
+func flowStepReadsSecrets(ctx *FlowContext, step FlowStep) bool {
+	if ctx == nil || ctx.Secrets == nil {
+		return false
+	}
+	values := []any{step.presentNamedParams()}
+	if len(step.Args) > 0 {
+		values = append(values, step.Args)
+	}
+	_, shadowed := ctx.Vars[flowSecretRefName]
+	for _, value := range values {
+		for _, expr := range flowReferenceExpressions(value) {
+			node, isPath, err := parseFlowPlaceholder(expr)
+			if err != nil {
+				continue
+			}
+			names := []string{}
+			if isPath {
+				base, _, _ := parseFlowVariableReference(expr)
+				names = append(names, base)
+			} else {
+				names = flowExprVariables(node)
+			}
+			for _, name := range names {
+				if (name == flowSecretRefName && !shadowed) || ctx.Secrets.isDerived(name) {
+					return true
+				}
+			}
+		}
+	}
+	derived := ctx.Secrets.derivedNames()
+	if len(derived) == 0 {
+		return false
+	}
+	for _, name := range []string{"code", "script"} {
+		code, ok := step.param(name)
+		if !ok {
+			continue
+		}
+		for _, variable := range derived {
+			if regexp.MustCompile(`\b` + regexp.QuoteMeta(variable) + `\b`).MatchString(fmt.Sprint(code)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadFlowSecrets resolves every referenced secret into the registry before
+// any step runs, so a missing secret fails fast and masking is in place before
+// the first trace is written.
+func loadFlowSecrets(registry *flowSecretRegistry, names []string) error {
+	var fileSecrets map[string]string
+	fileLoaded := false
+	for _, name := range names {
+		if registry.hasNamed(name) {
+			continue
+		}
+		if value, ok := os.LookupEnv(flowSecretEnvName(name)); ok && value != "" {
+			registry.setNamed(name, value)
+			continue
+		}
+		if !fileLoaded {
+			fileLoaded = true
+			loaded, err := loadDefaultFlowSecretsFile()
+			if err != nil {
+				return err
+			}
+			fileSecrets = loaded
+		}
+		value, ok := fileSecrets[name]
+		if !ok || value == "" {
+			return fmt.Errorf("secret %q is not set; export %s or store it with -action set-secret -secret-name %s", name, flowSecretEnvName(name), name)
+		}
+		registry.setNamed(name, value)
+	}
+	return nil
+}
+
+func flowSecretEnvName(name string) string {
+	return flowSecretEnvPrefix + strings.ToUpper(name)
+}
+
+// flowPlaceholderVars returns the variables a placeholder is evaluated
+// against. The secret namespace is only attached to a plain path placeholder
+// that uses it, and only when no flow variable named "secret" shadows it.
+func flowPlaceholderVars(ctx *FlowContext, expr string) map[string]any {
+	if ctx == nil {
+		return nil
+	}
+	if ctx.Secrets == nil || !strings.Contains(expr, flowSecretRefName) {
+		return ctx.Vars
+	}
+	if _, isPath, err := parseFlowPlaceholder(expr); err != nil || !isPath {
+		return ctx.Vars
+	}
+	if _, shadowed := ctx.Vars[flowSecretRefName]; shadowed {
+		return ctx.Vars
+	}
+	vars := make(map[string]any, len(ctx.Vars)+1)
+	for key, value := range ctx.Vars {
+		vars[key] = value
+	}
+	vars[flowSecretRefName] = ctx.Secrets.namedValues()
+	return vars
+}
+
+// DefaultFlowSecretsFile returns the encrypted secrets file path, honouring
+// TSPLAY_SECRETS_FILE.
+func DefaultFlowSecretsFile() string {
+	if path := strings.TrimSpace(os.Getenv(envFlowSecretsFile)); path != "" {
+		return path
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "tsplay", "secrets.enc.json")
+	}
+	return filepath.Join(".tsplay", "secrets.enc.json")
+}
+
+func loadDefaultFlowSecretsFile() (map[string]string, error) {
+	path := DefaultFlowSecretsFile()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	passphrase := os.Getenv(envFlowSecretsKey)
+	if passphrase == "" {
+		return nil, fmt.Errorf("secrets file %s exists but %s is not set", path, envFlowSecretsKey)
+	}
+	return LoadFlowSecretsFile(path, passphrase)
+}
+
+type flowSecretsFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// LoadFlowSecretsFile decrypts a secrets file written by SaveFlowSecretsFile.
+func LoadFlowSecretsFile(path string, passphrase string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secrets file: %w", err)
+	}
+	var file flowSecretsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("parse secrets file %s: %w", path, err)
+	}
+	if file.Version != flowSecretsFileVersion || file.KDF != "pbkdf2-sha256" || file.Iterations < 1 {
+		return nil, fmt.Errorf("secrets file %s has unsupported format", path)
+	}
+	gcm, err := newFlowSecretsCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secrets file %s: wrong %s or corrupted file", path, envFlowSecretsKey)
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("parse secrets file %s payload: %w", path, err)
+	}
+	return secrets, nil
+}
+
+// SaveFlowSecretsFile encrypts secrets with AES-256-GCM under a key derived
+// from passphrase and writes them with owner-only permissions.
+func SaveFlowSecretsFile(path string, passphrase string, secrets map[string]string) error {
+	if passphrase == "" {
+		return fmt.Errorf("%s is required to encrypt the secrets file", envFlowSecretsKey)
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	file := flowSecretsFile{
+		Version:    flowSecretsFileVersion,
+		KDF:        "pbkdf2-sha256",
+		Iterations: flowSecretsKDFIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	gcm, err := newFlowSecretsCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+	encoded, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create secrets directory: %w", err)
+	}
+	if err := os.WriteFile(path, encoded, 0o600); err != nil {
+		return fmt.Errorf("write secrets file: %w", err)
+	}
+	return nil
+}
+
+// SetFlowSecret stores one secret in the default secrets file and returns the
+// file path.
+func SetFlowSecret(name string, value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("secret %q value is empty", name)
+	}
+	return updateFlowSecretsFile(name, func(secrets map[string]string) {
+		secrets[name] = value
+	})
+}
+
+// DeleteFlowSecret removes one secret from the default secrets file and
+// returns the file path.
+func DeleteFlowSecret(name string) (string, error) {
+	return updateFlowSecretsFile(name, func(secrets map[string]string) {
+		delete(secrets, name)
+	})
+}
+
+func updateFlowSecretsFile(name string, update func(map[string]string)) (string, error) {
+	if !flowIdentifierPattern.MatchString(name) {
+		return "", fmt.Errorf("secret name %q is not a valid identifier", name)
+	}
+	path := DefaultFlowSecretsFile()
+	passphrase := os.Getenv(envFlowSecretsKey)
+	secrets := map[string]string{}
+	if _, err := os.Stat(path); err == nil {
+		if passphrase == "" {
+			return "", fmt.Errorf("secrets file %s exists but %s is not set", path, envFlowSecretsKey)
+		}
+		loaded, err := LoadFlowSecretsFile(path, passphrase)
+		if err != nil {
+			return "", err
+		}
+		secrets = loaded
+	}
+	update(secrets)
+	return path, SaveFlowSecretsFile(path, passphrase, secrets)
+}
+
+// ListFlowSecretNames returns the names stored in the default secrets file.
+func ListFlowSecretNames() ([]string, error) {
+	secrets, err := loadDefaultFlowSecretsFile()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func newFlowSecretsCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2SHA256(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	key := make([]byte, 0, blocks*hashLen)
+	var counter [4]byte
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		key = prf.Sum(key)
+		t := key[len(key)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return key[:keyLen]
+}
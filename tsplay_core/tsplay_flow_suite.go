@@ -0,0 +1,417 @@
+package tsplay_core
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FlowSuiteStatusPassed  = "passed"
+	FlowSuiteStatusFailed  = "failed"
+	FlowSuiteStatusFlaky   = "flaky"
+	FlowSuiteStatusSkipped = "skipped"
+
+	flowSuiteClientName = "tsplay-test"
+)
+
+// flowSuiteFileSuffixes are the files -action test discovers. The setup and
+// teardown files of the suite root are not run as tests.
+var flowSuiteFileSuffixes = []string{".flow.yaml", ".flow.yml", ".flow.json"}
+
+// FlowSuiteOptions configures RunFlowSuite, the runner behind -action test.
+type FlowSuiteOptions struct {
+	// Root is the directory searched for flow files.
+	Root string
+	// Setup and Teardown are flow files run once before and after the tests.
+	// They default to setup.flow.* and teardown.flow.* in Root.
+	Setup    string
+	Teardown string
+	// Workers is how many test flows run at once; 0 means 1.
+	Workers int
+	// Retries reruns a failed test flow up to this many times. A flow that
+	// passes on a retry is reported as flaky.
+	Retries int
+	// Tags selects flows by their tags field. A flow runs when it has any of
+	// the plain tags, or when only exclusions are given, and never when it has
+	// a tag prefixed with "!".
+	Tags []string
+	// Filter keeps flows whose relative path or name contains it, or matches
+	// it as a glob such as checkout/*.
+	Filter string
+	// TimeoutMS bounds each attempt; 0 uses the MCP run_flow default.
+	TimeoutMS int
+	// RunOptions is the base of every run. Its Params, together with the
+	// variables the setup flow ends with, are passed to each flow that
+	// declares a parameter of the same name.
+	RunOptions FlowRunOptions
+
+	runFlow func(*Flow, FlowRunOptions) (*FlowResult, error)
+}
+
+// FlowSuiteCase is the outcome of one flow file of the suite.
+type FlowSuiteCase struct {
+	File       string      `json:"file"`
+	Name       string      `json:"name"`
+	Tags       []string    `json:"tags,omitempty"`
+	Status     string      `json:"status"`
+	Attempts   int         `json:"attempts,omitempty"`
+	RunID      string      `json:"run_id,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+	Error      string      `json:"error,omitempty"`
+	Result     *FlowResult `json:"-"`
+}
+
+type FlowSuiteResult struct {
+	Root       string          `json:"root"`
+	Setup      *FlowSuiteCase  `json:"setup,omitempty"`
+	Teardown   *FlowSuiteCase  `json:"teardown,omitempty"`
+	Cases      []FlowSuiteCase `json:"cases"`
+	Passed     int             `json:"passed"`
+	Failed     int             `json:"failed"`
+	Flaky      int             `json:"flaky"`
+	Skipped    int             `json:"skipped"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// Succeeded reports whether the setup, every test and the teardown passed.
+// Flaky tests count as passed.
+func (result *FlowSuiteResult) Succeeded() bool {
+	if result == nil || result.Failed > 0 || result.Skipped > 0 {
+		return false
+	}
+	for _, fixture := range []*FlowSuiteCase{result.Setup, result.Teardown} {
+		if fixture != nil && fixture.Status == FlowSuiteStatusFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// ReportRuns lists the setup, the tests and the teardown for WriteFlowReports.
+func (result *FlowSuiteResult) ReportRuns() []FlowReportRun {
+	runs := []FlowReportRun{}
+	if result == nil {
+		return runs
+	}
+	add := func(suiteCase FlowSuiteCase) {
+		run := FlowReportRun{
+			Name:     suiteCase.Name,
+			File:     suiteCase.File,
+			Result:   suiteCase.Result,
+			Attempts: suiteCase.Attempts,
+		}
+		switch suiteCase.Status {
+		case FlowSuiteStatusFailed:
+			run.Error = suiteCase.Error
+		case FlowSuiteStatusSkipped:
+			run.Skipped = suiteCase.Error
+		}
+		runs = append(runs, run)
+	}
+	if result.Setup != nil {
+		add(*result.Setup)
+	}
+	for _, suiteCase := range result.Cases {
+		add(suiteCase)
+	}
+	if result.Teardown != nil {
+		add(*result.Teardown)
+	}
+	return runs
+}
+
+type flowSuiteFile struct {
+	path     string
+	relative string
+	flow     *Flow
+	err      error
+}
+
+// RunFlowSuite discovers the flow files under options.Root, runs the setup
+// flow, the selected tests on options.Workers workers and the teardown flow.
+// When the setup fails the tests are skipped; the teardown always runs.
+func RunFlowSuite(options FlowSuiteOptions) (*FlowSuiteResult, error) {
+	if options.runFlow == nil {
+		options.runFlow = RunFlow
+	}
+	root := strings.TrimSpace(options.Root)
+	if root == "" {
+		return nil, fmt.Errorf("test root is required")
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("test root %s is not a directory", root)
+	}
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	if options.Retries < 0 {
+		return nil, fmt.Errorf("retries cannot be negative")
+	}
+	setupPath := resolveFlowSuiteFixture(root, options.Setup, "setup")
+	teardownPath := resolveFlowSuiteFixture(root, options.Teardown, "teardown")
+	files, err := discoverFlowSuiteFiles(root, setupPath, teardownPath)
+	if err != nil {
+		return nil, err
+	}
+	selected := []flowSuiteFile{}
+	for _, file := range files {
+		if !flowSuiteSelected(file, options.Tags, options.Filter) {
+			continue
+		}
+		selected = append(selected, file)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no flows under %s match the tags and filter", root)
+	}
+
+	startedAt := time.Now()
+	result := &FlowSuiteResult{Root: root, Cases: make([]FlowSuiteCase, len(selected))}
+	sharedVars := map[string]any{}
+	var sharedSecrets []string
+	setupFailed := false
+	if setupPath != "" {
+		setup := runFlowSuiteFile(options, loadFlowSuiteFile(setupPath, root), nil, nil, 0)
+		result.Setup = &setup
+		setupFailed = setup.Status == FlowSuiteStatusFailed
+		if setup.Result != nil {
+			// Hand the test flows the real values of setup vars, not the
+			// masked copy in the result, and keep masking them there.
+			vars := setup.Result.Vars
+			if setup.Result.rawVars != nil {
+				vars = setup.Result.rawVars
+			}
+			for key, value := range vars {
+				sharedVars[key] = value
+			}
+			sharedSecrets = setup.Result.secrets
+		}
+	}
+
+	if setupFailed {
+		for i, file := range selected {
+			result.Cases[i] = FlowSuiteCase{File: file.relative, Name: flowSuiteFileName(file), Status: FlowSuiteStatusSkipped, Error: "setup flow failed"}
+		}
+	} else {
+		slots := make(chan struct{}, options.Workers)
+		var wg sync.WaitGroup
+		for i, file := range selected {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(i int, file flowSuiteFile) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				result.Cases[i] = runFlowSuiteFile(options, file, sharedVars, sharedSecrets, options.Retries)
+			}(i, file)
+		}
+		wg.Wait()
+	}
+
+	if teardownPath != "" {
+		teardown := runFlowSuiteFile(options, loadFlowSuiteFile(teardownPath, root), sharedVars, sharedSecrets, 0)
+		result.Teardown = &teardown
+	}
+	for _, suiteCase := range result.Cases {
+		switch suiteCase.Status {
+		case FlowSuiteStatusPassed:
+			result.Passed++
+		case FlowSuiteStatusFlaky:
+			result.Flaky++
+		case FlowSuiteStatusSkipped:
+			result.Skipped++
+		default:
+			result.Failed++
+		}
+	}
+	result.DurationMS = time.Since(startedAt).Milliseconds()
+	return result, nil
+}
+
+// resolveFlowSuiteFixture returns the explicit setup or teardown path, or the
+// conventional <root>/<name>.flow.* file when it exists.
+func resolveFlowSuiteFixture(root string, explicit string, name string) string {
+	if explicit = strings.TrimSpace(explicit); explicit != "" {
+		return explicit
+	}
+	for _, suffix := range flowSuiteFileSuffixes {
+		candidate := filepath.Join(root, name+suffix)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func discoverFlowSuiteFiles(root string, fixtures ...string) ([]flowSuiteFile, error) {
+	skip := map[string]bool{}
+	for _, fixture := range fixtures {
+		if fixture == "" {
+			continue
+		}
+		if absolute, err := filepath.Abs(fixture); err == nil {
+			skip[absolute] = true
+		}
+	}
+	files := []flowSuiteFile{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isFlowSuiteFile(entry.Name()) {
+			return nil
+		}
+		if absolute, err := filepath.Abs(filePath); err == nil && skip[absolute] {
+			return nil
+		}
+		files = append(files, loadFlowSuiteFile(filePath, root))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].relative < files[j].relative
+	})
+	return files, nil
+}
+
+func isFlowSuiteFile(name string) bool {
+	lower := strings.ToLower(name)
+	for _, suffix := range flowSuiteFileSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFlowSuiteFile keeps load errors on the file so a broken flow is reported
+// as a failed test instead of aborting the suite.
+func loadFlowSuiteFile(filePath string, root string) flowSuiteFile {
+	relative, err := filepath.Rel(root, filePath)
+	if err != nil {
+		relative = filePath
+	}
+	file := flowSuiteFile{path: filePath, relative: filepath.ToSlash(relative)}
+	file.flow, file.err = LoadFlowFile(filePath)
+	if file.err == nil {
+		file.err = ValidateFlow(file.flow)
+	}
+	return file
+}
+
+func flowSuiteFileName(file flowSuiteFile) string {
+	if file.flow != nil && strings.TrimSpace(file.flow.Name) != "" {
+		return file.flow.Name
+	}
+	return file.relative
+}
+
+// flowSuiteSelected applies the tags and filter. A file that failed to load
+// has no tags, so only the filter can deselect it and a broken flow is not
+// silently dropped from a tagged run.
+func flowSuiteSelected(file flowSuiteFile, tags []string, filter string) bool {
+	if file.flow != nil {
+		include := []string{}
+		for _, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			if excluded, ok := strings.CutPrefix(tag, "!"); ok {
+				if slices.Contains(file.flow.Tags, excluded) {
+					return false
+				}
+			} else if tag != "" {
+				include = append(include, tag)
+			}
+		}
+		if len(include) > 0 && !slices.ContainsFunc(include, func(tag string) bool { return slices.Contains(file.flow.Tags, tag) }) {
+			return false
+		}
+	}
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return true
+	}
+	name := flowSuiteFileName(file)
+	if strings.Contains(file.relative, filter) || strings.Contains(name, filter) {
+		return true
+	}
+	for _, candidate := range []string{file.relative, path.Base(file.relative), name} {
+		if matched, err := path.Match(filter, candidate); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func runFlowSuiteFile(options FlowSuiteOptions, file flowSuiteFile, sharedVars map[string]any, sharedSecrets []string, retries int) FlowSuiteCase {
+	suiteCase := FlowSuiteCase{File: file.relative, Name: flowSuiteFileName(file)}
+	if file.err != nil {
+		suiteCase.Status = FlowSuiteStatusFailed
+		suiteCase.Error = file.err.Error()
+		return suiteCase
+	}
+	suiteCase.Tags = file.flow.Tags
+	params := map[string]any{}
+	for name := range file.flow.Parameters {
+		if value, ok := options.RunOptions.Params[name]; ok {
+			params[name] = value
+		} else if value, ok := sharedVars[name]; ok {
+			params[name] = value
+		}
+	}
+	timeoutMS := options.TimeoutMS
+	if timeoutMS <= 0 {
+		timeoutMS = defaultTSPlayFlowRunTimeoutMS
+	}
+	baseContext := options.RunOptions.Context
+	if baseContext == nil {
+		baseContext = context.Background()
+	}
+	var totalMS int64
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		runOptions := options.RunOptions
+		runOptions.Params = params
+		runOptions.secrets = sharedSecrets
+		runOptions.RunID = ""
+		runOptions.ClientName = firstNonEmpty(runOptions.ClientName, flowSuiteClientName)
+		runCtx, cancel := context.WithTimeout(baseContext, time.Duration(timeoutMS)*time.Millisecond)
+		runOptions.Context = runCtx
+		startedAt := time.Now()
+		result, err := options.runFlow(file.flow, runOptions)
+		cancel()
+		totalMS += time.Since(startedAt).Milliseconds()
+		suiteCase.Attempts = attempt
+		suiteCase.Result = result
+		suiteCase.Error = ""
+		if result != nil {
+			suiteCase.RunID = result.RunID
+		}
+		if err == nil {
+			suiteCase.Status = FlowSuiteStatusPassed
+			if attempt > 1 {
+				suiteCase.Status = FlowSuiteStatusFlaky
+			}
+			break
+		}
+		suiteCase.Status = FlowSuiteStatusFailed
+		suiteCase.Error = err.Error()
+		if attempt <= retries {
+			slog.Warn("test flow failed; retrying", "file", file.relative, "attempt", attempt, "err", err)
+		}
+	}
+	suiteCase.DurationMS = totalMS
+	return suiteCase
+}
@@ -0,0 +1,243 @@
+package tsplay_core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRunFlowSuiteSelectsRetriesAndSharesSetupVars(t *testing.T) {
+	root := t.TempDir()
+	writeFlowSuiteTestFile(t, root, "setup.flow.yaml", `
+schema_version: "1"
+name: setup
+steps:
+  - action: set_var
+    save_as: base_url
+    value: https://staging.example.com
+`)
+	writeFlowSuiteTestFile(t, root, "teardown.flow.yaml", `
+schema_version: "1"
+name: teardown
+steps:
+  - action: set_var
+    save_as: done
+    value: "yes"
+`)
+	writeFlowSuiteTestFile(t, root, "checkout/pay.flow.yaml", `
+schema_version: "1"
+name: pay
+tags: [smoke]
+parameters:
+  base_url:
+    type: string
+    required: true
+steps:
+  - action: set_var
+    save_as: url
+    value: "{{base_url}}/pay"
+`)
+	writeFlowSuiteTestFile(t, root, "search.flow.yaml", `
+schema_version: "1"
+name: search
+tags: [smoke, flaky]
+steps:
+  - action: set_var
+    save_as: query
+    value: shoes
+`)
+	writeFlowSuiteTestFile(t, root, "slow_report.flow.yaml", `
+schema_version: "1"
+name: slow_report
+tags: [smoke, slow]
+steps:
+  - action: set_var
+    save_as: report
+    value: monthly
+`)
+	writeFlowSuiteTestFile(t, root, "nightly.flow.yaml", `
+schema_version: "1"
+name: nightly
+steps:
+  - action: set_var
+    save_as: nightly
+    value: "yes"
+`)
+	writeFlowSuiteTestFile(t, root, "notes.yaml", "not a flow")
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	var payParams map[string]any
+	result, err := RunFlowSuite(FlowSuiteOptions{
+		Root:       root,
+		Workers:    2,
+		Retries:    1,
+		Tags:       []string{"smoke", "!slow"},
+		RunOptions: FlowRunOptions{ArtifactRoot: t.TempDir()},
+		runFlow: func(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
+			mu.Lock()
+			calls[flow.Name]++
+			attempt := calls[flow.Name]
+			if flow.Name == "pay" {
+				payParams = options.Params
+			}
+			mu.Unlock()
+			if options.ClientName != flowSuiteClientName {
+				t.Errorf("expected client name %q, got %q", flowSuiteClientName, options.ClientName)
+			}
+			if flow.Name == "search" && attempt == 1 {
+				return &FlowResult{Name: flow.Name, Status: FlowRunStatusFailed}, errors.New("element not found")
+			}
+			result, err := RunFlow(flow, options)
+			return result, err
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunFlowSuite returned error: %v", err)
+	}
+	if result.Setup == nil || result.Setup.Status != FlowSuiteStatusPassed || result.Teardown == nil || result.Teardown.Status != FlowSuiteStatusPassed {
+		t.Fatalf("expected setup and teardown to pass, got %#v %#v", result.Setup, result.Teardown)
+	}
+	if len(result.Cases) != 2 || result.Cases[0].File != "checkout/pay.flow.yaml" || result.Cases[1].File != "search.flow.yaml" {
+		t.Fatalf("expected pay and search to be selected, got %#v", result.Cases)
+	}
+	if result.Cases[0].Status != FlowSuiteStatusPassed || payParams["base_url"] != "https://staging.example.com" {
+		t.Fatalf("expected pay to pass with the setup's base_url, got %#v with params %#v", result.Cases[0], payParams)
+	}
+	if search := result.Cases[1]; search.Status != FlowSuiteStatusFlaky || search.Attempts != 2 {
+		t.Fatalf("expected search to be flaky after a retry, got %#v", search)
+	}
+	if result.Passed != 1 || result.Flaky != 1 || result.Failed != 0 || !result.Succeeded() {
+		t.Fatalf("unexpected totals %#v", result)
+	}
+	if runs := result.ReportRuns(); len(runs) != 4 || runs[2].Attempts != 2 || runs[0].Name != "setup" || runs[3].Name != "teardown" {
+		t.Fatalf("unexpected report runs %#v", runs)
+	}
+}
+
+func TestRunFlowSuiteSharesSecretSetupVarsUnmasked(t *testing.T) {
+	t.Setenv("TSPLAY_SECRETS_FILE", filepath.Join(t.TempDir(), "missing.enc.json"))
+	t.Setenv("TSPLAY_SECRET_API_KEY", "key-7f3a")
+	root := t.TempDir()
+	writeFlowSuiteTestFile(t, root, "setup.flow.yaml", `
+schema_version: "1"
+name: setup
+steps:
+  - action: set_var
+    save_as: auth_header
+    value: "Bearer {{ secret.api_key }}"
+`)
+	writeFlowSuiteTestFile(t, root, "api.flow.yaml", `
+schema_version: "1"
+name: api
+parameters:
+  auth_header:
+    type: string
+    required: true
+steps:
+  - action: set_var
+    save_as: header
+    value: "{{auth_header}}"
+`)
+
+	var apiParams map[string]any
+	result, err := RunFlowSuite(FlowSuiteOptions{
+		Root:       root,
+		RunOptions: FlowRunOptions{ArtifactRoot: t.TempDir(), Security: &FlowSecurityPolicy{AllowSecrets: true}},
+		runFlow: func(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
+			if flow.Name == "api" {
+				apiParams = options.Params
+			}
+			return RunFlow(flow, options)
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunFlowSuite returned error: %v", err)
+	}
+	if apiParams["auth_header"] != "Bearer key-7f3a" {
+		t.Fatalf("expected the test flow to get the real setup value, got %#v", apiParams)
+	}
+	if result.Setup.Result.Vars["auth_header"] != "Bearer "+flowSecretMask {
+		t.Fatalf("expected the setup result to stay masked, got %#v", result.Setup.Result.Vars)
+	}
+	if api := result.Cases[0]; api.Status != FlowSuiteStatusPassed || api.Result.Vars["header"] != "Bearer "+flowSecretMask {
+		t.Fatalf("expected the test flow to pass with masked vars, got %#v", api)
+	}
+}
+
+func TestRunFlowSuiteSkipsTestsWhenSetupFails(t *testing.T) {
+	root := t.TempDir()
+	writeFlowSuiteTestFile(t, root, "setup.flow.yaml", `
+schema_version: "1"
+name: setup
+steps:
+  - action: set_var
+    save_as: token
+    value: abc
+`)
+	writeFlowSuiteTestFile(t, root, "login.flow.yaml", `
+schema_version: "1"
+name: login
+steps:
+  - action: set_var
+    save_as: user
+    value: qa
+`)
+	writeFlowSuiteTestFile(t, root, "broken.flow.yaml", "schema_version: \"1\"\nname: broken\nsteps:\n  - action: no_such_action\n")
+	writeFlowSuiteTestFile(t, root, "teardown.flow.json", `{"schema_version": "1", "name": "teardown", "steps": [{"action": "set_var", "save_as": "done", "value": "yes"}]}`)
+
+	ran := []string{}
+	result, err := RunFlowSuite(FlowSuiteOptions{
+		Root:   root,
+		Filter: "*.flow.yaml",
+		runFlow: func(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
+			ran = append(ran, flow.Name)
+			if flow.Name == "setup" {
+				return nil, errors.New("login page is down")
+			}
+			return &FlowResult{Name: flow.Name, Status: FlowRunStatusSucceeded}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunFlowSuite returned error: %v", err)
+	}
+	if len(ran) != 2 || ran[0] != "setup" || ran[1] != "teardown" {
+		t.Fatalf("expected only setup and teardown to run, got %v", ran)
+	}
+	if result.Skipped != 2 || result.Succeeded() {
+		t.Fatalf("expected both tests to be skipped, got %#v", result)
+	}
+	if _, err := RunFlowSuite(FlowSuiteOptions{Root: root, Filter: "missing"}); err == nil {
+		t.Fatalf("expected an error when no flow matches")
+	}
+
+	if err := os.Remove(filepath.Join(root, "setup.flow.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = RunFlowSuite(FlowSuiteOptions{
+		Root:     root,
+		Teardown: filepath.Join(root, "teardown.flow.json"),
+		runFlow: func(flow *Flow, options FlowRunOptions) (*FlowResult, error) {
+			return &FlowResult{Name: flow.Name, Status: FlowRunStatusSucceeded}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunFlowSuite returned error: %v", err)
+	}
+	if len(result.Cases) != 2 || result.Cases[0].File != "broken.flow.yaml" || result.Cases[0].Status != FlowSuiteStatusFailed || result.Cases[0].Error == "" {
+		t.Fatalf("expected the broken flow to be reported as failed, got %#v", result.Cases)
+	}
+}
+
+func writeFlowSuiteTestFile(t *testing.T, root string, name string, content string) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
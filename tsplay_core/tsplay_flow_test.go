@@ -14,6 +14,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -1228,6 +1229,951 @@ func TestRunFlowForeachProgressCheckpointWritesNextSourceRow(t *testing.T) {
 	}
 }
 
+func TestRunFlowForeachConcurrencyIsolatesIterationsAndMergesAppends(t *testing.T) {
+	server := newRedisTestServer(t)
+	defer server.Close()
+
+	t.Setenv("TSPLAY_REDIS_ADDR", server.Addr())
+
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "foreach_concurrent",
+		Vars: map[string]any{
+			"rows": []any{
+				map[string]any{"source_row": 2, "delay": 0.08},
+				map[string]any{"source_row": 3, "delay": 0.01},
+				map[string]any{"source_row": 4, "delay": 0.05},
+				map[string]any{"source_row": 5, "delay": 0.01},
+			},
+			"processed_rows": []any{"header"},
+			"label":          "caller",
+		},
+		Steps: []FlowStep{
+			{
+				Action:      "foreach",
+				Items:       "{{rows}}",
+				ItemVar:     "row",
+				IndexVar:    "row_index",
+				Concurrency: 2,
+				With: map[string]any{
+					"progress_key": "imports:users:resume_row",
+				},
+				Steps: []FlowStep{
+					{Action: "sleep", With: map[string]any{"seconds": "{{row.delay}}"}},
+					{Action: "set_var", SaveAs: "label", Value: "row-{{row.source_row}}"},
+					{Action: "lua", Code: "seen_label = label; return row_index", SaveAs: "seen_index"},
+					{Action: "append_var", SaveAs: "processed_rows", Value: "{{label}}"},
+				},
+			},
+		},
+	}
+
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{
+		Security: &FlowSecurityPolicy{AllowLua: true, AllowRedis: true},
+	})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+
+	want := []any{"header", "row-2", "row-3", "row-4", "row-5"}
+	if !reflect.DeepEqual(result.Vars["processed_rows"], want) {
+		t.Fatalf("processed_rows = %#v", result.Vars["processed_rows"])
+	}
+	if got := result.Vars["label"]; got != "caller" {
+		t.Fatalf("iteration variable leaked into caller: label = %#v", got)
+	}
+	for _, name := range []string{"row", "row_index", "seen_index"} {
+		if _, ok := result.Vars[name]; ok {
+			t.Fatalf("iteration variable %q leaked into caller: %#v", name, result.Vars[name])
+		}
+	}
+	if got := L.GetGlobal("seen_label"); got != lua.LNil {
+		t.Fatalf("iteration lua global leaked into caller state: %v", got)
+	}
+
+	children := result.Trace[0].Children
+	if len(children) != 16 {
+		t.Fatalf("expected 16 child traces, got %d", len(children))
+	}
+	for i, child := range children {
+		if wantIteration := i/4 + 1; child.Iteration != wantIteration {
+			t.Fatalf("child %d iteration = %d, want %d", i, child.Iteration, wantIteration)
+		}
+	}
+
+	output, ok := result.Trace[0].Output.(map[string]any)
+	if !ok || output["concurrency"] != 2 {
+		t.Fatalf("foreach output = %#v", result.Trace[0].Output)
+	}
+	stored, err := redisGet("imports:users:resume_row", "")
+	if err != nil {
+		t.Fatalf("redis get checkpoint: %v", err)
+	}
+	if stored != "6" {
+		t.Fatalf("stored checkpoint = %#v", stored)
+	}
+	checkpoint, ok := output["checkpoint"].(map[string]any)
+	if !ok || checkpoint["writes"] != 4 || checkpoint["last_value"] != 6 {
+		t.Fatalf("checkpoint summary = %#v", output["checkpoint"])
+	}
+}
+
+func TestRunFlowForeachConcurrencyStopsAtFirstFailedIteration(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "foreach_concurrent_failure",
+		Vars: map[string]any{
+			"items": []any{1, 2, 3, 4, 5, 6},
+		},
+		Steps: []FlowStep{
+			{
+				Action:      "foreach",
+				Items:       "{{items}}",
+				ItemVar:     "item",
+				Concurrency: 3,
+				Steps: []FlowStep{
+					{Action: "lua", Code: "if item == 2 then error('boom') end; return item"},
+					{Action: "append_var", SaveAs: "done", Value: "{{item}}"},
+				},
+			},
+		},
+	}
+
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{
+		Security: &FlowSecurityPolicy{AllowLua: true},
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected iteration failure, got %v", err)
+	}
+	if !reflect.DeepEqual(result.Vars["done"], []any{1}) {
+		t.Fatalf("only iterations before the failure should be merged, got %#v", result.Vars["done"])
+	}
+}
+
+func TestValidateFlowRejectsInvalidForeachConcurrency(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "foreach_concurrency_invalid",
+		Steps: []FlowStep{
+			{
+				Action:  "foreach",
+				Items:   []any{1, 2},
+				ItemVar: "item",
+				With:    map[string]any{"concurrency": 0},
+				Steps:   []FlowStep{{Action: "set_var", SaveAs: "last", Value: "{{item}}"}},
+			},
+		},
+	}
+	if err := ValidateFlow(flow); err == nil || !strings.Contains(err.Error(), "concurrency") {
+		t.Fatalf("expected concurrency validation error, got %v", err)
+	}
+}
+
+func TestRunFlowWhileLoopsUntilConditionIsFalse(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "while_pagination",
+		Vars:          map[string]any{"page_number": 0},
+		Steps: []FlowStep{
+			{
+				Action:    "while",
+				Condition: &FlowStep{Action: "lua", Code: "return page_number < 3"},
+				Steps: []FlowStep{
+					{Action: "lua", Code: "return page_number + 1", SaveAs: "page_number"},
+					{Action: "append_var", SaveAs: "visited", Value: "{{page_number}}"},
+				},
+			},
+		},
+	}
+
+	result, err := RunFlowInState(L, flow)
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if got := fmt.Sprint(result.Vars["visited"]); got != "[1 2 3]" {
+		t.Fatalf("visited = %#v", result.Vars["visited"])
+	}
+	output, ok := result.Trace[0].Output.(map[string]any)
+	if !ok || output["iterations"] != 3 || output["stopped_by"] != "condition" {
+		t.Fatalf("while output = %#v", result.Trace[0].Output)
+	}
+	children := result.Trace[0].Children
+	if len(children) != 10 {
+		t.Fatalf("expected 4 condition traces and 6 body traces, got %d", len(children))
+	}
+	last := children[len(children)-1]
+	if last.Path != "1.condition" || last.Iteration != 4 {
+		t.Fatalf("unexpected final condition trace: %#v", last)
+	}
+	if children[8].Path != "1[3].2" || children[8].Iteration != 3 {
+		t.Fatalf("unexpected body trace: %#v", children[8])
+	}
+}
+
+func TestRunFlowRepeatUntilRunsBodyBeforeCondition(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "repeat_until_counter",
+		Steps: []FlowStep{
+			{
+				Action: "repeat_until",
+				Steps: []FlowStep{
+					{Action: "append_var", SaveAs: "attempts", Value: "x"},
+					{Action: "lua", Code: "return #attempts", SaveAs: "attempt_count"},
+				},
+				Condition: &FlowStep{Action: "lua", Code: "return attempt_count >= 2"},
+			},
+		},
+	}
+
+	result, err := RunFlowInState(L, flow)
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	output, ok := result.Trace[0].Output.(map[string]any)
+	if !ok || output["iterations"] != 2 {
+		t.Fatalf("repeat_until output = %#v", result.Trace[0].Output)
+	}
+}
+
+func TestRunFlowWhileFailsAfterMaxIterations(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "while_runaway",
+		Steps: []FlowStep{
+			{
+				Action:        "while",
+				MaxIterations: 3,
+				Condition:     &FlowStep{Action: "lua", Code: "return true"},
+				Steps: []FlowStep{
+					{Action: "append_var", SaveAs: "ticks", Value: "tick"},
+				},
+			},
+		},
+	}
+
+	result, err := RunFlowInState(L, flow)
+	if err == nil || !strings.Contains(err.Error(), "exceeded max_iterations 3") {
+		t.Fatalf("expected max_iterations error, got %v", err)
+	}
+	if got := len(result.Vars["ticks"].([]any)); got != 3 {
+		t.Fatalf("expected 3 iterations before the cap, got %d", got)
+	}
+}
+
+func TestRunFlowLoopBreakAndContinue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "loop_break_continue",
+		Vars:          map[string]any{"items": []any{1, 2, 3, 4, 5}},
+		Steps: []FlowStep{
+			{
+				Action:  "foreach",
+				Items:   "{{items}}",
+				ItemVar: "item",
+				Steps: []FlowStep{
+					{
+						Action:    "if",
+						Condition: &FlowStep{Action: "lua", Code: "return item == 2"},
+						Then:      []FlowStep{{Action: "continue"}},
+					},
+					{
+						Action: "on_error",
+						Steps: []FlowStep{
+							{
+								Action:    "if",
+								Condition: &FlowStep{Action: "lua", Code: "return item == 4"},
+								Then:      []FlowStep{{Action: "break"}},
+							},
+						},
+						OnError: []FlowStep{{Action: "set_var", SaveAs: "swallowed", Value: "yes"}},
+					},
+					{Action: "append_var", SaveAs: "seen", Value: "{{item}}"},
+				},
+			},
+		},
+	}
+
+	result, err := RunFlowInState(L, flow)
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if got := fmt.Sprint(result.Vars["seen"]); got != "[1 3]" {
+		t.Fatalf("seen = %#v", result.Vars["seen"])
+	}
+	if _, ok := result.Vars["swallowed"]; ok {
+		t.Fatalf("on_error must not catch break")
+	}
+	output, ok := result.Trace[0].Output.(map[string]any)
+	if !ok || output["iterations"] != 4 || output["stopped_by"] != "break" {
+		t.Fatalf("foreach output = %#v", result.Trace[0].Output)
+	}
+	for _, child := range result.Trace[0].Children {
+		if child.Status != "ok" {
+			t.Fatalf("loop control should not mark traces as failed: %#v", child)
+		}
+	}
+}
+
+func TestValidateFlowRejectsMisplacedLoopControl(t *testing.T) {
+	cases := map[string]*Flow{
+		"must be inside a while": {
+			SchemaVersion: "1",
+			Name:          "break_outside_loop",
+			Steps:         []FlowStep{{Action: "break"}},
+		},
+		"condition.1": {
+			SchemaVersion: "1",
+			Name:          "break_in_condition",
+			Steps: []FlowStep{
+				{
+					Action:    "while",
+					Condition: &FlowStep{Action: "if", Condition: &FlowStep{Action: "lua", Code: "return true"}, Then: []FlowStep{{Action: "break"}}},
+					Steps:     []FlowStep{{Action: "set_var", SaveAs: "x", Value: "1"}},
+				},
+			},
+		},
+		"cannot be used inside foreach with concurrency": {
+			SchemaVersion: "1",
+			Name:          "break_in_concurrent_foreach",
+			Steps: []FlowStep{
+				{Action: "foreach", Items: []any{1, 2}, ItemVar: "item", Concurrency: 2, Steps: []FlowStep{{Action: "break"}}},
+			},
+		},
+		"fragments.skip.1": {
+			SchemaVersion: "1",
+			Name:          "break_in_fragment",
+			Fragments: map[string]FlowFragment{
+				"skip": {Steps: []FlowStep{{Action: "continue"}}},
+			},
+			Steps: []FlowStep{
+				{Action: "foreach", Items: []any{1, 2}, ItemVar: "item", Steps: []FlowStep{{Action: "call_flow", Fragment: "skip"}}},
+			},
+		},
+		"does not accept parameter": {
+			SchemaVersion: "1",
+			Name:          "break_with_params",
+			Steps: []FlowStep{
+				{Action: "while", Condition: &FlowStep{Action: "lua", Code: "return true"}, Steps: []FlowStep{{Action: "break", Selector: "#next"}}},
+			},
+		},
+	}
+	for want, flow := range cases {
+		if err := ValidateFlow(flow); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: expected %q error, got %v", flow.Name, want, err)
+		}
+	}
+}
+
+func TestRunFlowEvaluatesExpressionsWithoutLua(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: expressions
+vars:
+  total: 12
+  row:
+    name: "  Alice  "
+    email: ""
+    created_at: "2024-03-05 08:09:10"
+steps:
+  - action: set_var
+    save_as: summary
+    value: "{{ upper(trim(row.name)) }} owes {{ total * 2 + 1 }}"
+  - action: set_var
+    save_as: email
+    value: "{{ default(row.email, 'n/a') }}"
+  - action: set_var
+    save_as: created
+    value: "{{ format_date(row.created_at, 'YYYY/MM/DD HH:mm') }}"
+  - action: set_var
+    save_as: is_big
+    value: "{{ total > 10 && matches(row.name, 'Ali') }}"
+  - action: if
+    condition: "{{ total >= 10 and not (len(row.name) == 0) }}"
+    then:
+      - action: set_var
+        save_as: branch
+        value: then
+    else:
+      - action: set_var
+        save_as: branch
+        value: else
+  - action: if
+    condition: "{{ total > 100 }}"
+    then:
+      - action: set_var
+        save_as: nickname
+        value: big spender
+  - action: set_var
+    save_as: greeting
+    value: "{{ default(nickname, 'guest') }}"
+`), "yaml")
+	if err != nil {
+		t.Fatalf("parse flow: %v", err)
+	}
+	if flow.Steps[4].Condition == nil || flow.Steps[4].Condition.Action != "expr" {
+		t.Fatalf("condition shorthand was not parsed as expr: %#v", flow.Steps[4].Condition)
+	}
+
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{Security: &FlowSecurityPolicy{}})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	want := map[string]any{
+		"summary":  "ALICE owes 25",
+		"email":    "n/a",
+		"created":  "2024/03/05 08:09",
+		"is_big":   true,
+		"branch":   "then",
+		"greeting": "guest",
+	}
+	for name, value := range want {
+		if !reflect.DeepEqual(result.Vars[name], value) {
+			t.Fatalf("%s = %#v, want %#v", name, result.Vars[name], value)
+		}
+	}
+}
+
+func TestRunFlowExpressionKeepsLegacyPlaceholderPaths(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "legacy_paths",
+		Vars: map[string]any{
+			"headers": map[string]any{"content-type": "text/html", "User Name": "bob"},
+			"rows":    []any{map[string]any{"name": "a"}, map[string]any{"name": "b"}},
+		},
+		Steps: []FlowStep{
+			{Action: "set_var", SaveAs: "content_type", Value: "{{headers.content-type}}"},
+			{Action: "set_var", SaveAs: "second", Value: "{{ rows[1].name }}"},
+			{Action: "set_var", SaveAs: "user", Value: "{{ headers['User Name'] }}"},
+			{Action: "set_var", SaveAs: "count", Value: "{{ len(rows) }}"},
+		},
+	}
+
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{Security: &FlowSecurityPolicy{}})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if result.Vars["content_type"] != "text/html" || result.Vars["second"] != "b" || result.Vars["user"] != "bob" || result.Vars["count"] != 2 {
+		t.Fatalf("unexpected vars: %#v", result.Vars)
+	}
+}
+
+func TestParseFlowAcceptsExpressionShorthandOnlyAsCondition(t *testing.T) {
+	flow, err := ParseFlow([]byte(`{"schema_version": "1", "name": "json_condition", "steps": [
+		{"action": "if", "condition": "{{ 1 < 2 }}", "then": [{"action": "set_var", "save_as": "ok", "value": "yes"}]}
+	]}`), "json")
+	if err != nil {
+		t.Fatalf("parse JSON flow: %v", err)
+	}
+	if condition := flow.Steps[0].Condition; condition == nil || condition.Action != "expr" || condition.Value != "{{ 1 < 2 }}" {
+		t.Fatalf("expected the JSON condition shorthand to become an expr step, got %#v", condition)
+	}
+	for format, content := range map[string]string{
+		"yaml": "schema_version: \"1\"\nname: bare_step\nsteps:\n  - action: if\n    condition: \"{{ true }}\"\n    then:\n      - \"{{ 1 + 1 }}\"\n",
+		"json": `{"schema_version": "1", "name": "bare_step", "steps": ["{{ 1 + 1 }}"]}`,
+	} {
+		if _, err := ParseFlow([]byte(content), format); err == nil {
+			t.Fatalf("expected a bare %s expression in a step list to be rejected", format)
+		}
+	}
+}
+
+func TestValidateFlowRejectsInvalidExpressions(t *testing.T) {
+	cases := map[string]struct {
+		step FlowStep
+		want string
+	}{
+		"unknown variable": {
+			step: FlowStep{Action: "set_var", SaveAs: "x", Value: "{{ missing + 1 }}"},
+			want: `references unknown variable "missing"`,
+		},
+		"unknown function": {
+			step: FlowStep{Action: "set_var", SaveAs: "x", Value: "{{ shout(total) }}"},
+			want: `calls unknown function "shout"`,
+		},
+		"wrong arity": {
+			step: FlowStep{Action: "set_var", SaveAs: "x", Value: "{{ default(total) }}"},
+			want: "default() takes 2 argument(s), got 1",
+		},
+		"literal type mismatch": {
+			step: FlowStep{Action: "set_var", SaveAs: "x", Value: "{{ 'a' < 3 }}"},
+			want: "cannot compare string < number",
+		},
+		"bad regex": {
+			step: FlowStep{Action: "set_var", SaveAs: "x", Value: "{{ matches(total, '[') }}"},
+			want: "matches() has invalid pattern",
+		},
+		"syntax error": {
+			step: FlowStep{Action: "if", Condition: &FlowStep{Action: "expr", Value: "total >"}, Then: []FlowStep{{Action: "sleep", Seconds: 0.01}}},
+			want: "has invalid placeholder",
+		},
+		"param type mismatch": {
+			step: FlowStep{Action: "sleep", With: map[string]any{"seconds": "{{ lower('x') }}"}},
+			want: "evaluates to string",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			flow := &Flow{
+				SchemaVersion: "1",
+				Name:          "invalid_expression",
+				Vars:          map[string]any{"total": 1},
+				Steps:         []FlowStep{tc.step},
+			}
+			err := ValidateFlow(flow)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestRunFlowResolvesDeclaredParameters(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: parameterized_export
+parameters:
+  region:
+    type: string
+    enum: [eu, us]
+    default: eu
+  limit:
+    type: integer
+    required: true
+  dry_run:
+    type: boolean
+    default: false
+  api_token:
+    type: string
+    required: true
+    secret: true
+steps:
+  - action: set_var
+    save_as: summary
+    value: "{{region}}:{{ limit * 2 }}:{{dry_run}}"
+  - action: set_var
+    save_as: auth_header
+    value: "Bearer {{api_token}}"
+`), "yaml")
+	if err != nil {
+		t.Fatalf("parse flow: %v", err)
+	}
+
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{
+		Security: &FlowSecurityPolicy{},
+		Params:   map[string]any{"limit": "21", "region": "us", "api_token": "tok-123"},
+	})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if result.Vars["summary"] != "us:42:false" {
+		t.Fatalf("summary = %#v", result.Vars["summary"])
+	}
+	if result.Vars["api_token"] != flowSecretMask || result.Vars["auth_header"] != "Bearer "+flowSecretMask {
+		t.Fatalf("secret leaked into vars: %#v", result.Vars)
+	}
+	for _, trace := range result.Trace {
+		if strings.Contains(trace.ArgsSummary+trace.OutputSummary, "tok-123") {
+			t.Fatalf("secret leaked into trace: %#v", trace)
+		}
+	}
+
+	schema := BuildFlowParametersJSONSchema(flow)
+	if !reflect.DeepEqual(schema["required"], []string{"api_token", "limit"}) {
+		t.Fatalf("unexpected schema: %#v", schema)
+	}
+}
+
+func TestValidateFlowLeavesParameterEnumUnchanged(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "parameter_enum",
+		Parameters:    map[string]FlowParameter{"page_size": {Type: "integer", Enum: []any{"10", "50"}, Default: 10}},
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "out", Value: "{{page_size}}"}},
+	}
+	for i := 0; i < 2; i++ {
+		if err := ValidateFlow(flow); err != nil {
+			t.Fatalf("validate flow: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(flow.Parameters["page_size"].Enum, []any{"10", "50"}) {
+		t.Fatalf("expected validation to leave the enum as written, got %#v", flow.Parameters["page_size"].Enum)
+	}
+	values, err := resolveFlowParameters(flow, map[string]any{"page_size": "50"})
+	if err != nil || values["page_size"] != 50 {
+		t.Fatalf("expected the typed enum option to match, got %#v, %v", values, err)
+	}
+}
+
+func TestRunFlowRejectsInvalidParameterInputs(t *testing.T) {
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "parameter_inputs",
+		Parameters: map[string]FlowParameter{
+			"limit":  {Type: "integer", Required: true},
+			"region": {Enum: []any{"eu", "us"}, Default: "eu"},
+		},
+		Steps: []FlowStep{{Action: "set_var", SaveAs: "out", Value: "{{region}}-{{limit}}"}},
+	}
+	cases := map[string]struct {
+		params map[string]any
+		want   string
+	}{
+		"missing required": {params: map[string]any{}, want: `parameter "limit" is required`},
+		"wrong type":       {params: map[string]any{"limit": "many"}, want: `parameter "limit" must be an integer`},
+		"not in enum":      {params: map[string]any{"limit": 1, "region": "apac"}, want: "must be one of eu, us"},
+		"undeclared":       {params: map[string]any{"limit": 1, "regoin": "us"}, want: `does not declare parameter "regoin"`},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			L := lua.NewState()
+			defer L.Close()
+			_, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{Params: tc.params})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlowRejectsInvalidParameterDeclarations(t *testing.T) {
+	cases := map[string]struct {
+		parameters map[string]FlowParameter
+		want       string
+	}{
+		"unknown type":       {parameters: map[string]FlowParameter{"x": {Type: "date"}}, want: `type "date" is not supported`},
+		"bad default":        {parameters: map[string]FlowParameter{"x": {Type: "number", Default: "lots"}}, want: `parameter "x" must be a number (default)`},
+		"default not enum":   {parameters: map[string]FlowParameter{"x": {Enum: []any{"a"}, Default: "b"}}, want: "must be one of a"},
+		"secret default":     {parameters: map[string]FlowParameter{"x": {Secret: true, Default: "pw"}}, want: "cannot declare a default"},
+		"required default":   {parameters: map[string]FlowParameter{"x": {Required: true, Default: "pw"}}, want: "cannot be required and declare a default"},
+		"invalid identifier": {parameters: map[string]FlowParameter{"bad-name": {}}, want: "is not a valid variable name"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			flow := &Flow{
+				SchemaVersion: "1",
+				Name:          "parameter_declarations",
+				Parameters:    tc.parameters,
+				Steps:         []FlowStep{{Action: "sleep", Seconds: 0.01}},
+			}
+			err := ValidateFlow(flow)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestRunFlowResolvesSecretReferencesAndMasksThem(t *testing.T) {
+	t.Setenv("TSPLAY_SECRETS_FILE", filepath.Join(t.TempDir(), "missing.enc.json"))
+	t.Setenv("TSPLAY_SECRET_SITE_PASSWORD", "hunter2-pass")
+	L := lua.NewState()
+	defer L.Close()
+
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: secret_login
+steps:
+  - action: set_var
+    save_as: login
+    value: "admin:{{ secret.site_password }}"
+  - action: set_var
+    save_as: form
+    with:
+      value:
+        user: admin
+        page_token: next-42
+`), "yaml")
+	if err != nil {
+		t.Fatalf("parse flow: %v", err)
+	}
+	if _, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{Security: &FlowSecurityPolicy{}}); err == nil || !strings.Contains(err.Error(), "allow_secrets") {
+		t.Fatalf("expected allow_secrets error, got %v", err)
+	}
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{Security: &FlowSecurityPolicy{AllowSecrets: true}})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if result.Vars["login"] != "admin:"+flowSecretMask {
+		t.Fatalf("unexpected vars: %#v", result.Vars)
+	}
+	encoded, _ := json.Marshal(result.Trace)
+	if strings.Contains(string(encoded), "hunter2-pass") {
+		t.Fatalf("secret leaked into trace: %s", encoded)
+	}
+	// Only secret values are masked; fields are not hidden by name.
+	if !strings.Contains(string(encoded), "next-42") {
+		t.Fatalf("expected non-secret page_token in trace: %s", encoded)
+	}
+
+	t.Setenv("TSPLAY_SECRET_SITE_PASSWORD", "")
+	_, err = RunFlowInStateWithOptions(L, flow, FlowRunOptions{Security: &FlowSecurityPolicy{AllowSecrets: true}})
+	if err == nil || !strings.Contains(err.Error(), "TSPLAY_SECRET_SITE_PASSWORD") {
+		t.Fatalf("expected missing secret error, got %v", err)
+	}
+}
+
+func TestFlowSecretsFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc.json")
+	t.Setenv("TSPLAY_SECRETS_FILE", path)
+	t.Setenv("TSPLAY_SECRETS_KEY", "correct horse")
+
+	if _, err := SetFlowSecret("erp_password", "from-file-pass"); err != nil {
+		t.Fatalf("set secret: %v", err)
+	}
+	if _, err := SetFlowSecret("unused", "other-value"); err != nil {
+		t.Fatalf("set secret: %v", err)
+	}
+	if _, err := DeleteFlowSecret("unused"); err != nil {
+		t.Fatalf("delete secret: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read secrets file: %v", err)
+	}
+	if strings.Contains(string(content), "from-file-pass") {
+		t.Fatalf("secrets file is not encrypted: %s", content)
+	}
+	names, err := ListFlowSecretNames()
+	if err != nil || !reflect.DeepEqual(names, []string{"erp_password"}) {
+		t.Fatalf("names = %#v, err = %v", names, err)
+	}
+	if _, err := LoadFlowSecretsFile(path, "wrong"); err == nil || !strings.Contains(err.Error(), "wrong TSPLAY_SECRETS_KEY") {
+		t.Fatalf("expected wrong key error, got %v", err)
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	result, err := RunFlowInStateWithOptions(L, &Flow{
+		SchemaVersion: "1",
+		Name:          "secret_file",
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "auth", Value: "{{ secret.erp_password }}"}},
+	}, FlowRunOptions{Security: &FlowSecurityPolicy{AllowSecrets: true}})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if result.Vars["auth"] != flowSecretMask {
+		t.Fatalf("auth = %#v", result.Vars["auth"])
+	}
+}
+
+func TestValidateFlowRejectsInvalidSecretReferences(t *testing.T) {
+	cases := map[string]struct {
+		flow Flow
+		want string
+	}{
+		"bare secret":   {flow: Flow{Steps: []FlowStep{{Action: "set_var", SaveAs: "x", Value: "{{secret}}"}}}, want: "must look like secret.NAME"},
+		"nested path":   {flow: Flow{Steps: []FlowStep{{Action: "set_var", SaveAs: "x", Value: "{{secret.a.b}}"}}}, want: "must look like secret.NAME"},
+		"dynamic index": {flow: Flow{Steps: []FlowStep{{Action: "set_var", SaveAs: "x", Value: "{{ secret[name] }}"}}, Vars: map[string]any{"name": "a"}}, want: "plain {{ secret.NAME }} placeholder"},
+		"transform":     {flow: Flow{Steps: []FlowStep{{Action: "set_var", SaveAs: "x", Value: "{{ upper(secret.a) }}"}}}, want: "plain {{ secret.NAME }} placeholder"},
+		"length oracle": {flow: Flow{Steps: []FlowStep{{Action: "expr", SaveAs: "x", Value: "{{ len(secret.a) > 8 }}"}}}, want: "plain {{ secret.NAME }} placeholder"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			flow := tc.flow
+			flow.SchemaVersion = "1"
+			flow.Name = "secret_refs"
+			err := ValidateFlow(&flow)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+		})
+	}
+	shadowed := &Flow{
+		SchemaVersion: "1",
+		Name:          "legacy_secret_var",
+		Vars:          map[string]any{"secret": map[string]any{"a": map[string]any{"b": "x"}}},
+		Steps:         []FlowStep{{Action: "set_var", SaveAs: "x", Value: "{{secret.a.b}}"}},
+	}
+	if err := ValidateFlow(shadowed); err != nil {
+		t.Fatalf("a declared secret variable should shadow the namespace: %v", err)
+	}
+}
+
+func TestRunFlowResumesFromFailedStep(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: resumable
+parameters:
+  ready:
+    type: boolean
+    default: false
+vars:
+  seen: []
+steps:
+  - action: set_var
+    save_as: first
+    value: a
+  - action: foreach
+    items: [1, 2, 3]
+    item_var: n
+    steps:
+      - action: append_var
+        save_as: seen
+        value: "{{n}}"
+      - action: lua
+        code: "if n == 3 and not ready then error('not ready') end"
+  - action: set_var
+    save_as: done
+    value: "yes"
+`), "yaml")
+	if err != nil {
+		t.Fatalf("parse flow: %v", err)
+	}
+	run := func(options FlowRunOptions) (*FlowResult, error) {
+		L := lua.NewState()
+		defer L.Close()
+		options.Security = &FlowSecurityPolicy{AllowLua: true}
+		options.ArtifactRoot = artifactRoot
+		return RunFlowInStateWithOptions(L, flow, options)
+	}
+
+	failed, err := run(FlowRunOptions{RunID: "first"})
+	if err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Fatalf("expected first run to fail, got %v", err)
+	}
+	checkpoint, err := LoadFlowRunCheckpoint(failed.RunRoot)
+	if err != nil {
+		t.Fatalf("load checkpoint: %v", err)
+	}
+	if checkpoint.Status != FlowRunStatusFailed || checkpoint.FailedStepPath != "2[3].2" || checkpoint.ForeachPositions["2"] != 2 {
+		t.Fatalf("unexpected checkpoint: %#v", checkpoint)
+	}
+
+	changed := *flow
+	changed.Steps = append([]FlowStep{{Action: "set_var", SaveAs: "extra", Value: "x"}}, flow.Steps...)
+	L := lua.NewState()
+	_, err = RunFlowInStateWithOptions(L, &changed, FlowRunOptions{RunID: "edited", ResumeRunID: "first", ArtifactRoot: artifactRoot, Security: &FlowSecurityPolicy{AllowLua: true}})
+	L.Close()
+	if err == nil || !strings.Contains(err.Error(), "different version") {
+		t.Fatalf("expected an edited flow to be refused, got %v", err)
+	}
+
+	resumed, err := run(FlowRunOptions{RunID: "second", ResumeRunID: "first", Params: map[string]any{"ready": "true"}})
+	if err != nil {
+		t.Fatalf("resume run: %v", err)
+	}
+	if resumed.ResumedFrom == nil || resumed.ResumedFrom.RunID != "first" || resumed.ResumedFrom.StepPath != "2[3].2" {
+		t.Fatalf("resumed_from = %#v", resumed.ResumedFrom)
+	}
+	if resumed.Trace[0].Status != "skipped" || resumed.Vars["done"] != "yes" || resumed.Vars["first"] != "a" {
+		t.Fatalf("unexpected resumed run: %#v", resumed)
+	}
+	if seen, _ := resumed.Vars["seen"].([]any); len(seen) != 3 {
+		t.Fatalf("completed steps ran again: seen = %#v", resumed.Vars["seen"])
+	}
+
+	if _, err := run(FlowRunOptions{RunID: "third", ResumeRunID: "second"}); err == nil || !strings.Contains(err.Error(), "already succeeded") {
+		t.Fatalf("expected succeeded run to be rejected, got %v", err)
+	}
+	if _, err := run(FlowRunOptions{ResumeRunID: "../first"}); err == nil || !strings.Contains(err.Error(), "not a valid run id") {
+		t.Fatalf("expected invalid run id error, got %v", err)
+	}
+}
+
+func TestRunFlowResumeRestoresSecretParametersAndRefusesDerivedSecrets(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: secret_resume
+parameters:
+  api_token:
+    type: string
+    required: true
+    secret: true
+  ready:
+    type: boolean
+    default: false
+steps:
+  - action: set_var
+    save_as: token_copy
+    value: "{{api_token}}"
+  - action: lua
+    save_as: token_reversed
+    code: "return string.reverse(api_token)"
+  - action: lua
+    code: "if not ready then error('not ready') end"
+  - action: lua
+    code: "if api_token ~= 'tok-123456' then error('token lost') end"
+`), "yaml")
+	if err != nil {
+		t.Fatalf("parse flow: %v", err)
+	}
+	run := func(options FlowRunOptions) (*FlowResult, error) {
+		L := lua.NewState()
+		defer L.Close()
+		options.Security = &FlowSecurityPolicy{AllowLua: true}
+		options.ArtifactRoot = artifactRoot
+		return RunFlowInStateWithOptions(L, flow, options)
+	}
+
+	failed, err := run(FlowRunOptions{RunID: "first", Params: map[string]any{"api_token": "tok-123456"}})
+	if err == nil {
+		t.Fatalf("expected first run to fail")
+	}
+	checkpoint, err := LoadFlowRunCheckpoint(failed.RunRoot)
+	if err != nil {
+		t.Fatalf("load checkpoint: %v", err)
+	}
+	if strings.Join(checkpoint.SecretVars, ",") != "api_token,token_copy,token_reversed" {
+		t.Fatalf("secret_vars = %v", checkpoint.SecretVars)
+	}
+	if _, stored := checkpoint.Vars["token_reversed"]; stored {
+		t.Fatalf("a value derived from a secret was written to the checkpoint: %#v", checkpoint.Vars)
+	}
+	info, err := os.Stat(filepath.Join(failed.RunRoot, flowRunCheckpointFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected an owner-only checkpoint, got %v, %v", info, err)
+	}
+	_, err = run(FlowRunOptions{RunID: "second", ResumeRunID: "first", Params: map[string]any{"api_token": "tok-123456", "ready": "true"}})
+	if err == nil || !strings.Contains(err.Error(), "token_copy") || strings.Contains(err.Error(), "api_token") {
+		t.Fatalf("expected the derived secret to block the resume, got %v", err)
+	}
+
+	// Without the derived variables, the secret parameter of the resumed run is
+	// used instead of a stored value.
+	flow.Steps = flow.Steps[2:]
+	if _, err := run(FlowRunOptions{RunID: "third", Params: map[string]any{"api_token": "tok-123456"}}); err == nil {
+		t.Fatalf("expected third run to fail")
+	}
+	resumed, err := run(FlowRunOptions{RunID: "fourth", ResumeRunID: "third", Params: map[string]any{"api_token": "tok-123456", "ready": "true"}})
+	if err != nil {
+		t.Fatalf("resume run: %v", err)
+	}
+	if len(resumed.Trace) != 2 || resumed.Trace[1].Status != "ok" {
+		t.Fatalf("unexpected resumed trace: %#v", resumed.Trace)
+	}
+}
+
 func TestRunFlowOnErrorHandlesFailure(t *testing.T) {
 	L := lua.NewState()
 	defer L.Close()
@@ -1292,6 +2238,219 @@ func TestRunFlowWaitUntilPollsCondition(t *testing.T) {
 	}
 }
 
+func TestRunFlowCallFlowFragmentScopesVarsAndReturnsOutputs(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "call_fragment",
+		Vars: map[string]any{
+			"user":   "alice",
+			"secret": "caller-only",
+		},
+		Fragments: map[string]FlowFragment{
+			"login": {
+				Inputs:  []string{"username"},
+				Outputs: []string{"token"},
+				Steps: []FlowStep{
+					{Action: "lua", Code: "return tostring(secret)", SaveAs: "seen_secret"},
+					{Action: "set_var", SaveAs: "token", Value: "token-{{username}}-{{seen_secret}}"},
+				},
+			},
+		},
+		Steps: []FlowStep{
+			{
+				Action:   "call_flow",
+				Name:     "login",
+				SaveAs:   "login_result",
+				Fragment: "login",
+				Inputs:   map[string]any{"username": "{{user}}"},
+				Outputs:  []string{"token"},
+			},
+		},
+	}
+
+	result, err := RunFlowInState(L, flow)
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if got := result.Vars["token"]; got != "token-alice-nil" {
+		t.Fatalf("token = %#v", got)
+	}
+	if _, ok := result.Vars["username"]; ok {
+		t.Fatalf("fragment input leaked into caller: %#v", result.Vars["username"])
+	}
+	if _, ok := result.Vars["seen_secret"]; ok {
+		t.Fatalf("fragment variable leaked into caller: %#v", result.Vars["seen_secret"])
+	}
+	if got := L.GetGlobal("secret").String(); got != "caller-only" {
+		t.Fatalf("caller lua global was not restored: %q", got)
+	}
+	if output, ok := result.Vars["login_result"].(map[string]any); !ok || output["token"] != "token-alice-nil" {
+		t.Fatalf("login_result = %#v", result.Vars["login_result"])
+	}
+	if len(result.Trace[0].Children) != 2 || result.Trace[0].Children[1].Path != "1.2" {
+		t.Fatalf("expected nested fragment traces: %#v", result.Trace[0].Children)
+	}
+}
+
+func TestRunFlowCallFlowFileUsesInputRootAndCallerPolicy(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "shared"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	child := `schema_version: "1"
+name: build_label
+vars:
+  prefix: order
+  order_id: ""
+steps:
+  - action: set_var
+    save_as: label
+    value: "{{prefix}}-{{order_id}}"
+`
+	if err := os.WriteFile(filepath.Join(root, "shared", "label.flow.yaml"), []byte(child), 0644); err != nil {
+		t.Fatalf("write child flow: %v", err)
+	}
+
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "call_file",
+		Steps: []FlowStep{
+			{
+				Action:   "call_flow",
+				FilePath: "shared/label.flow.yaml",
+				Inputs:   map[string]any{"order_id": "A1001"},
+				Outputs:  []string{"label"},
+			},
+		},
+	}
+	policy := &FlowSecurityPolicy{AllowFileAccess: true, FileInputRoot: root}
+
+	result, err := RunFlowInStateWithOptions(L, flow, FlowRunOptions{Security: policy})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	if got := result.Vars["label"]; got != "order-A1001" {
+		t.Fatalf("label = %#v", got)
+	}
+	if len(result.Trace[0].Children) != 1 || result.Trace[0].Children[0].Action != "set_var" {
+		t.Fatalf("expected nested file traces: %#v", result.Trace[0].Children)
+	}
+
+	luaChild := `schema_version: "1"
+name: lua_child
+steps:
+  - action: lua
+    code: return 1
+`
+	if err := os.WriteFile(filepath.Join(root, "shared", "lua.flow.yaml"), []byte(luaChild), 0644); err != nil {
+		t.Fatalf("write lua child flow: %v", err)
+	}
+	flow.Steps[0] = FlowStep{Action: "call_flow", FilePath: "shared/lua.flow.yaml"}
+	if err := ValidateFlowSecurity(flow, *policy); err == nil || !strings.Contains(err.Error(), "allow_lua") {
+		t.Fatalf("expected called flow to be checked against caller policy, got %v", err)
+	}
+
+	flow.Steps[0] = FlowStep{Action: "call_flow", FilePath: "../outside.flow.yaml"}
+	if err := ValidateFlowSecurity(flow, *policy); err == nil || !strings.Contains(err.Error(), "outside allowed file input root") {
+		t.Fatalf("expected file input root error, got %v", err)
+	}
+}
+
+func TestValidateFlowSecurityRejectsRecursiveCallFlowFiles(t *testing.T) {
+	root := t.TempDir()
+	first := `schema_version: "1"
+name: first
+steps:
+  - action: call_flow
+    file_path: second.flow.yaml
+`
+	second := `schema_version: "1"
+name: second
+steps:
+  - action: call_flow
+    file_path: first.flow.yaml
+`
+	if err := os.WriteFile(filepath.Join(root, "first.flow.yaml"), []byte(first), 0644); err != nil {
+		t.Fatalf("write first flow: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "second.flow.yaml"), []byte(second), 0644); err != nil {
+		t.Fatalf("write second flow: %v", err)
+	}
+	flow := &Flow{
+		SchemaVersion: "1",
+		Name:          "recursive_files",
+		Steps:         []FlowStep{{Action: "call_flow", FilePath: "first.flow.yaml"}},
+	}
+
+	err := ValidateFlowSecurity(flow, FlowSecurityPolicy{AllowFileAccess: true, FileInputRoot: root})
+	if err == nil || !strings.Contains(err.Error(), "recursively") {
+		t.Fatalf("expected recursive include error, got %v", err)
+	}
+}
+
+func TestValidateFlowStrictRejectsCallFlowContractErrors(t *testing.T) {
+	base := func() *Flow {
+		return &Flow{
+			SchemaVersion: "1",
+			Name:          "fragment_contract",
+			Fragments: map[string]FlowFragment{
+				"export": {
+					Inputs:  []string{"month"},
+					Outputs: []string{"report"},
+					Steps: []FlowStep{
+						{Action: "set_var", SaveAs: "report", Value: "report-{{month}}"},
+					},
+				},
+			},
+			Steps: []FlowStep{
+				{Action: "call_flow", Fragment: "export", Inputs: map[string]any{"month": "2024-01"}, Outputs: []string{"report"}},
+			},
+		}
+	}
+	if err := ValidateFlowStrict(base()); err != nil {
+		t.Fatalf("validate flow: %v", err)
+	}
+
+	cases := map[string]func(flow *Flow){
+		"unknown fragment": func(flow *Flow) {
+			flow.Steps[0].Fragment = "missing"
+		},
+		"requires input": func(flow *Flow) {
+			flow.Steps[0].Inputs = nil
+		},
+		"is not declared": func(flow *Flow) {
+			flow.Steps[0].Outputs = []string{"other"}
+		},
+		"either fragment or file_path": func(flow *Flow) {
+			flow.Steps[0].FilePath = "shared/export.flow.yaml"
+		},
+		"calls itself recursively": func(flow *Flow) {
+			fragment := flow.Fragments["export"]
+			fragment.Steps = append(fragment.Steps, FlowStep{Action: "call_flow", Fragment: "export", Inputs: map[string]any{"month": "{{month}}"}})
+			flow.Fragments["export"] = fragment
+		},
+		"references unknown variable": func(flow *Flow) {
+			fragment := flow.Fragments["export"]
+			fragment.Steps = append(fragment.Steps, FlowStep{Action: "set_var", SaveAs: "leak", Value: "{{caller_only}}"})
+			flow.Fragments["export"] = fragment
+			flow.Vars = map[string]any{"caller_only": "x"}
+		},
+	}
+	for want, mutate := range cases {
+		flow := base()
+		mutate(flow)
+		if err := ValidateFlowStrict(flow); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q error, got %v", want, err)
+		}
+	}
+}
+
 func TestRunFlowExtractTextAndSetVar(t *testing.T) {
 	L := lua.NewState()
 	defer L.Close()
@@ -2,6 +2,7 @@ package tsplay_core
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -353,7 +354,7 @@ func rewriteHTTPRequestRuntimePaths(config flowHTTPRequestConfig, policy FlowSec
 	return config, nil
 }
 
-func executeHTTPRequest(L *lua.LState, config flowHTTPRequestConfig) (map[string]any, error) {
+func executeHTTPRequest(L *lua.LState, config flowHTTPRequestConfig) (result map[string]any, err error) {
 	requestURL, err := addQueryParams(config.URL, config.Query)
 	if err != nil {
 		return nil, err
@@ -363,10 +364,20 @@ func executeHTTPRequest(L *lua.LState, config flowHTTPRequestConfig) (map[string
 	if err != nil {
 		return nil, err
 	}
-	request, err := http.NewRequest(config.Method, requestURL, bodyReader)
+	runCtx := context.Background()
+	if flowCtx := flowContextFromState(L); flowCtx != nil && flowCtx.Context != nil {
+		runCtx = flowCtx.Context
+	}
+	runCtx, span := startOTelSpan(runCtx, config.Method, otelSpanKindClient,
+		"http.request.method", config.Method,
+		"url.full", otelSpanURL(requestURL),
+	)
+	defer func() { span.finish(err) }()
+	request, err := http.NewRequestWithContext(runCtx, config.Method, requestURL, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("create http request: %w", err)
 	}
+	span.setAttributes("server.address", request.URL.Hostname())
 	for key, value := range config.Headers {
 		request.Header.Set(key, value)
 	}
@@ -376,13 +387,23 @@ func executeHTTPRequest(L *lua.LState, config flowHTTPRequestConfig) (map[string
 	if err := applyHTTPRequestBrowserHeaders(L, request, config); err != nil {
 		return nil, err
 	}
+	if traceparent := span.traceparent(); traceparent != "" && request.Header.Get("traceparent") == "" {
+		request.Header.Set("traceparent", traceparent)
+	}
 
-	client := &http.Client{Timeout: time.Duration(config.TimeoutMS) * time.Millisecond}
+	client, err := flowProxyHTTPClient(flowContextFromState(L), &http.Client{Timeout: time.Duration(config.TimeoutMS) * time.Millisecond})
+	if err != nil {
+		return nil, err
+	}
 	response, err := client.Do(request)
 	if err != nil {
 		return nil, fmt.Errorf("perform http request: %w", err)
 	}
 	defer response.Body.Close()
+	span.setAttributes("http.response.status_code", response.StatusCode)
+	if response.StatusCode >= 400 {
+		span.setError(response.Status)
+	}
 
 	bodyBytes, err := io.ReadAll(response.Body)
 	if err != nil {
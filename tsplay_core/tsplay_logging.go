@@ -0,0 +1,120 @@
+package tsplay_core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ConfigureLogging installs the process-wide slog logger. format is text or
+// json and level is debug, info, warn or error. The standard log package is
+// routed through the same handler, so every line shares one format. Servers
+// that speak MCP over stdio must pass os.Stderr, because stdout carries the
+// protocol.
+func ConfigureLogging(format string, level string, w io.Writer) error {
+	var logLevel slog.Level
+	if strings.TrimSpace(level) != "" {
+		if err := logLevel.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+			return fmt.Errorf("unsupported log level %q: use debug, info, warn or error", level)
+		}
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("unsupported log format %q: use text or json", format)
+	}
+	slog.SetDefault(slog.New(newLogContextHandler(handler)))
+	return nil
+}
+
+// logContextHandler adds the correlation attributes carried by the record's
+// context, so a flow step only has to log with its FlowContext.Context to be
+// tagged with run_id, session_id, client_name and step_path.
+type logContextHandler struct {
+	slog.Handler
+}
+
+func newLogContextHandler(handler slog.Handler) slog.Handler {
+	return logContextHandler{Handler: handler}
+}
+
+func (handler logContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attributes := logAttributesFromContext(ctx); len(attributes) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attributes...)
+	}
+	return handler.Handler.Handle(ctx, record)
+}
+
+func (handler logContextHandler) WithAttrs(attributes []slog.Attr) slog.Handler {
+	return logContextHandler{Handler: handler.Handler.WithAttrs(attributes)}
+}
+
+func (handler logContextHandler) WithGroup(name string) slog.Handler {
+	return logContextHandler{Handler: handler.Handler.WithGroup(name)}
+}
+
+type logAttributesContextKey struct{}
+
+func logAttributesFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attributes, _ := ctx.Value(logAttributesContextKey{}).([]slog.Attr)
+	return attributes
+}
+
+// withLogAttributes returns a context whose log lines carry the given key,
+// value pairs. A key that is already set, such as step_path in a nested step,
+// is replaced rather than repeated.
+func withLogAttributes(ctx context.Context, keyValues ...string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	existing := logAttributesFromContext(ctx)
+	attributes := make([]slog.Attr, 0, len(existing)+len(keyValues)/2)
+	for _, attribute := range existing {
+		replaced := false
+		for i := 0; i+1 < len(keyValues); i += 2 {
+			if keyValues[i] == attribute.Key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			attributes = append(attributes, attribute)
+		}
+	}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		attributes = append(attributes, slog.String(keyValues[i], keyValues[i+1]))
+	}
+	return context.WithValue(ctx, logAttributesContextKey{}, attributes)
+}
+
+// withStepLogContext tags ctx.Context with the step path until the returned
+// function restores the parent step's context.
+func (ctx *FlowContext) withStepLogContext(stepPath string) func() {
+	parent := ctx.Context
+	ctx.Context = withLogAttributes(parent, "step_path", stepPath)
+	return func() {
+		ctx.Context = parent
+	}
+}
+
+// flowLogContext is the context Lua actions log with: the running step's
+// context inside a flow, or a background context for plain scripts.
+func flowLogContext(L *lua.LState) context.Context {
+	if ctx := flowContextFromState(L); ctx != nil && ctx.Context != nil {
+		return ctx.Context
+	}
+	return context.Background()
+}
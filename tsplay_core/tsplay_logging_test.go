@@ -0,0 +1,105 @@
+package tsplay_core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestFlowLogLinesCarryRunCorrelation(t *testing.T) {
+	var output bytes.Buffer
+	useTestLogger(t, "json", "debug", &output)
+
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "logging_correlation",
+		Steps: []FlowStep{
+			{Action: "set_var", SaveAs: "greeting", Value: "hi"},
+			{Action: "retry", Times: 1, Steps: []FlowStep{{Action: "set_var", SaveAs: "nested", Value: "ok"}}},
+		},
+	}
+	result, err := RunFlow(flow, FlowRunOptions{
+		ArtifactRoot: t.TempDir(),
+		SessionID:    "session-1",
+		ClientName:   "qa-client",
+	})
+	if err != nil {
+		t.Fatalf("RunFlow returned error: %v", err)
+	}
+
+	stepPaths := []string{}
+	sawRunFinished := false
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected JSON log line, got %q: %v", scanner.Text(), err)
+		}
+		if line["run_id"] != result.RunID || line["session_id"] != "session-1" || line["client_name"] != "qa-client" {
+			t.Fatalf("expected run correlation on every line, got %v", line)
+		}
+		switch line["msg"] {
+		case "flow step finished":
+			stepPaths = append(stepPaths, line["step_path"].(string))
+		case "flow run finished":
+			sawRunFinished = true
+			if _, ok := line["step_path"]; ok {
+				t.Fatalf("expected no step_path outside of a step, got %v", line)
+			}
+		}
+	}
+	want := []string{"1", "2.1", "2"}
+	if len(stepPaths) != len(want) {
+		t.Fatalf("expected step lines %v, got %v", want, stepPaths)
+	}
+	for i := range want {
+		if stepPaths[i] != want[i] {
+			t.Fatalf("expected step lines %v, got %v", want, stepPaths)
+		}
+	}
+	if !sawRunFinished {
+		t.Fatalf("expected a flow run finished line, got %s", output.String())
+	}
+}
+
+func TestConfigureLoggingRejectsUnknownOptions(t *testing.T) {
+	var output bytes.Buffer
+	useTestLogger(t, "text", "warn", &output)
+	if err := ConfigureLogging("xml", "info", &output); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+	if err := ConfigureLogging("json", "verbose", &output); err == nil {
+		t.Fatalf("expected an error for an unknown level")
+	}
+	slog.Info("dropped below warn")
+	slog.Warn("kept at warn")
+	if err := ConfigureLogging("text", "info", &output); err != nil {
+		t.Fatalf("ConfigureLogging returned error: %v", err)
+	}
+	log.Printf("from the standard logger")
+	got := output.String()
+	if strings.Contains(got, "dropped") || !strings.Contains(got, `level=WARN msg="kept at warn"`) || !strings.Contains(got, `level=INFO msg="from the standard logger"`) {
+		t.Fatalf("unexpected log output %q", got)
+	}
+}
+
+// useTestLogger installs a logger for the test and restores the process-wide
+// slog and log settings afterwards.
+func useTestLogger(t *testing.T, format string, level string, w *bytes.Buffer) {
+	t.Helper()
+	previous := slog.Default()
+	previousWriter := log.Writer()
+	previousFlags := log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(previousWriter)
+		log.SetFlags(previousFlags)
+	})
+	if err := ConfigureLogging(format, level, w); err != nil {
+		t.Fatalf("ConfigureLogging returned error: %v", err)
+	}
+}
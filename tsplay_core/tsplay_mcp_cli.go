@@ -45,6 +45,10 @@ func InvokeTSPlayTool(
 		result, err = handleListSessionsToolWithOptions(ctx, request, normalized)
 	case "tsplay.get_session":
 		result, err = handleGetSessionToolWithOptions(ctx, request, normalized)
+	case "tsplay.list_runs":
+		result, err = handleListRunsToolWithOptions(ctx, request, normalized)
+	case "tsplay.get_run":
+		result, err = handleGetRunToolWithOptions(ctx, request, normalized)
 	case "tsplay.export_session_flow_snippet":
 		result, err = handleExportSessionFlowSnippetToolWithOptions(ctx, request, normalized)
 	case "tsplay.delete_session":
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -61,6 +62,7 @@ type tsplayBrowserRunHandle struct {
 	limiter   *tsplayBrowserRunLimiter
 	session   string
 	cancel    context.CancelFunc
+	retention *FlowArtifactRetentionPolicy
 	release   sync.Once
 }
 
@@ -68,6 +70,8 @@ type tsplayBrowserRunLimiter struct {
 	global       chan struct{}
 	sessionLimit int
 	sessions     sync.Map
+	// waiting counts runs blocked in acquire, for the queue depth metric.
+	waiting atomic.Int64
 }
 
 var tsplayBrowserRunLimiterCache sync.Map
@@ -92,6 +96,8 @@ func getTSPlayBrowserRunLimiter(globalLimit int, sessionLimit int) *tsplayBrowse
 }
 
 func (limiter *tsplayBrowserRunLimiter) acquire(ctx context.Context, session string) error {
+	limiter.waiting.Add(1)
+	defer limiter.waiting.Add(-1)
 	sessionChan := limiter.sessionSemaphore(session)
 	if err := acquireTSPlayBrowserRunToken(ctx, sessionChan); err != nil {
 		return err
@@ -103,6 +109,18 @@ func (limiter *tsplayBrowserRunLimiter) acquire(ctx context.Context, session str
 	return nil
 }
 
+// acquireGlobal takes only a global slot, for runs that are not tied to a
+// session and so have no per-session limit to respect.
+func (limiter *tsplayBrowserRunLimiter) acquireGlobal(ctx context.Context) error {
+	limiter.waiting.Add(1)
+	defer limiter.waiting.Add(-1)
+	return acquireTSPlayBrowserRunToken(ctx, limiter.global)
+}
+
+func (limiter *tsplayBrowserRunLimiter) releaseGlobal() {
+	releaseTSPlayBrowserRunToken(limiter.global)
+}
+
 func (limiter *tsplayBrowserRunLimiter) releaseForSession(session string) {
 	releaseTSPlayBrowserRunToken(limiter.global)
 	releaseTSPlayBrowserRunToken(limiter.sessionSemaphore(session))
@@ -177,7 +195,8 @@ func beginTSPlayBrowserRun(
 
 	handle := &tsplayBrowserRunHandle{
 		run:       run,
-		arguments: compactTraceValue(request.GetArguments(), 0),
+		arguments: redactSensitiveFlowKeys(compactTraceValue(request.GetArguments(), 0)),
+		retention: options.Retention,
 	}
 	if err := handle.writeAudit(); err != nil && rootErr == nil {
 		rootErr = err
@@ -206,6 +225,8 @@ func beginTSPlayBrowserRun(
 	if queuedAt, err := time.Parse(time.RFC3339Nano, handle.run.QueuedAt); err == nil {
 		handle.run.QueueWaitMS = time.Since(queuedAt).Milliseconds()
 	}
+	metricBrowserRunQueueWait.observe(float64(handle.run.QueueWaitMS)/1000, toolName)
+	otelSpanFromContext(ctx).setAttributes("tsplay.browser_run_id", handle.run.ID, "tsplay.queue_wait_ms", handle.run.QueueWaitMS)
 	remainingTimeoutMS := timeoutMS - int(handle.run.QueueWaitMS)
 	if remainingTimeoutMS < 1 {
 		remainingTimeoutMS = 1
@@ -255,6 +276,11 @@ func (handle *tsplayBrowserRunHandle) finish(err error, details map[string]any)
 			handle.run.Details = compactTraceValue(details, 0).(map[string]any)
 		}
 		_ = handle.writeAudit()
+		metricBrowserRuns.add(1, handle.run.Tool, handle.run.Status)
+		if handle.run.RunRoot != "" {
+			recordFlowRunIndexEntry(handle.run.ArtifactRoot, flowRunIndexEntryFromBrowserRun(handle.run))
+			applyFlowArtifactRetention(handle.run.ArtifactRoot, handle.retention, handle.run.ID)
+		}
 	})
 	return handle.snapshot()
 }
@@ -66,6 +66,9 @@ func flowSecurityPolicyResolutionFromToolRequest(
 	if _, present := request.GetArguments()["allow_database"]; present {
 		policy.AllowDatabase = request.GetBool("allow_database", false)
 	}
+	if _, present := request.GetArguments()["allow_secrets"]; present {
+		policy.AllowSecrets = request.GetBool("allow_secrets", false)
+	}
 
 	policy.FileInputRoot = options.ArtifactRoot
 	policy.FileOutputRoot = options.ArtifactRoot
@@ -96,6 +99,7 @@ func flowSecurityPolicyPreset(name string) (FlowSecurityPolicy, bool) {
 			AllowEmail:        true,
 			AllowRedis:        true,
 			AllowDatabase:     true,
+			AllowSecrets:      true,
 		}, true
 	default:
 		return FlowSecurityPolicy{}, false
@@ -203,6 +207,13 @@ func buildTSPlayToolSummary(tool string, payload map[string]any) string {
 			return fmt.Sprintf("Loaded browser session %q.", sessionName)
 		}
 		return "Loaded the requested browser session."
+	case "tsplay.list_runs":
+		return fmt.Sprintf("Returned %d recorded runs.", countTSPlayItems(payload["runs"]))
+	case "tsplay.get_run":
+		if run, ok := payload["run"].(*FlowRunDetails); ok && run != nil {
+			return fmt.Sprintf("Loaded run %q with status %s.", run.RunID, firstNonEmpty(run.Status, "unknown"))
+		}
+		return "Loaded the requested run."
 	case "tsplay.export_session_flow_snippet":
 		if format := tsplayNestedString(payload["export"], "format"); format != "" {
 			return fmt.Sprintf("Exported session snippets in %s format.", format)
@@ -511,6 +522,8 @@ func countTSPlayItems(value any) int {
 		return len(typed)
 	case []map[string]any:
 		return len(typed)
+	case []FlowRunIndexEntry:
+		return len(typed)
 	default:
 		return 0
 	}
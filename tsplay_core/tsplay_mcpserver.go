@@ -9,6 +9,7 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 	"log"
+	"log/slog"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -44,6 +45,13 @@ func withBrowserCDPPortInput(description string) mcp.ToolOption {
 	)
 }
 
+func withBrowserEngineInput(description string) mcp.ToolOption {
+	return mcp.WithString("browser_engine",
+		mcp.Description(description),
+		mcp.Enum(FlowBrowserEngines...),
+	)
+}
+
 type browserCDPToolConfig struct {
 	Launch      bool
 	Endpoint    string
@@ -173,8 +181,13 @@ func validateBrowserCDPToolRequest(request mcp.CallToolRequest) error {
 	if _, _, err := browserCDPPortFromToolRequest(request); err != nil {
 		return err
 	}
+	engine, _, err := browserCDPStringFromToolRequest(request, "browser_engine")
+	if err != nil {
+		return err
+	}
 	config := browserCDPConfigFromToolRequest(request)
 	browserConfig := FlowBrowserConfig{
+		Engine:         engine,
 		CDPEndpoint:    config.Endpoint,
 		CDPPort:        config.Port,
 		CDPLaunch:      config.Launch,
@@ -184,10 +197,16 @@ func validateBrowserCDPToolRequest(request mcp.CallToolRequest) error {
 	if _, err := validateFlowBrowserCDPLaunchEndpoint(browserConfig); err != nil {
 		return err
 	}
-	return nil
+	return validateFlowBrowserEngineConfig(browserConfig)
 }
 
 func applyBrowserCDPOverrideFromToolRequest(flow *Flow, request mcp.CallToolRequest) {
+	if engine, _, _ := browserCDPStringFromToolRequest(request, "browser_engine"); engine != "" {
+		if flow.Browser == nil {
+			flow.Browser = &FlowBrowserConfig{}
+		}
+		flow.Browser.Engine = engine
+	}
 	config := browserCDPConfigFromToolRequest(request)
 	if !config.usesCDP() {
 		return
@@ -211,7 +230,7 @@ func McpServerSSE() {
 
 	// Add a trivial tool for demonstration
 	mcpServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		fmt.Println(req.GetArguments())
+		slog.DebugContext(ctx, "echo", "arguments", req.GetArguments())
 		return mcp.NewToolResultText(fmt.Sprintf("Echo: %v", req.GetArguments()["message"])), nil
 	})
 
@@ -240,7 +259,7 @@ func McpServerSSE() {
 	mux.Handle("/api/{tenant}/sse", sseServer.SSEHandler())
 	mux.Handle("/api/{tenant}/message", sseServer.MessageHandler())
 
-	log.Printf("Dynamic SSE server listening on %s", addr)
+	slog.Info("dynamic SSE server listening", "addr", addr)
 	if err := http.ListenAndServe(addr, mux); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
@@ -274,6 +293,13 @@ type TSPlayMCPServerOptions struct {
 	DefaultRunTimeoutMS                int
 	MaxRunTimeoutMS                    int
 	QueueTimeoutMS                     int
+	// Retention garbage collects old runs under ArtifactRoot after each
+	// browser run finishes.
+	Retention *FlowArtifactRetentionPolicy
+	// AllowAllSessionRuns lets tsplay.list_runs and tsplay.get_run see the
+	// runs of every MCP session. Leave it off unless every client is trusted
+	// as an administrator; by default a session only sees its own runs.
+	AllowAllSessionRuns bool
 }
 
 func DefaultTSPlayMCPServerOptions() TSPlayMCPServerOptions {
@@ -314,6 +340,8 @@ func normalizeTSPlayMCPServerOptions(options []TSPlayMCPServerOptions) TSPlayMCP
 	if options[0].QueueTimeoutMS > 0 {
 		normalized.QueueTimeoutMS = options[0].QueueTimeoutMS
 	}
+	normalized.Retention = options[0].Retention
+	normalized.AllowAllSessionRuns = options[0].AllowAllSessionRuns
 	return normalized
 }
 
@@ -324,6 +352,7 @@ func NewTSPlayMCPServer(options ...TSPlayMCPServerOptions) *server.MCPServer {
 		"1.0.0",
 		server.WithToolCapabilities(true),
 		server.WithLogging(),
+		server.WithToolHandlerMiddleware(otelToolHandlerMiddleware),
 	)
 
 	registerTSPlayFlowTools(mcpServer, normalizedOptions)
@@ -336,30 +365,30 @@ func NewMCPServer() *server.MCPServer {
 	hooks := &server.Hooks{}
 
 	hooks.AddBeforeAny(func(ctx context.Context, id any, method mcp.MCPMethod, message any) {
-		fmt.Printf("beforeAny: %s, %v, %v\n", method, id, message)
+		slog.DebugContext(ctx, "mcp beforeAny", "method", method, "id", id, "message", message)
 	})
 	hooks.AddOnSuccess(func(ctx context.Context, id any, method mcp.MCPMethod, message any, result any) {
-		fmt.Printf("onSuccess: %s, %v, %v, %v\n", method, id, message, result)
+		slog.DebugContext(ctx, "mcp onSuccess", "method", method, "id", id, "message", message, "result", result)
 	})
 	hooks.AddOnError(func(ctx context.Context, id any, method mcp.MCPMethod, message any, err error) {
-		fmt.Printf("onError: %s, %v, %v, %v\n", method, id, message, err)
+		slog.DebugContext(ctx, "mcp onError", "method", method, "id", id, "message", message, "err", err)
 	})
 	hooks.AddBeforeInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest) {
-		fmt.Printf("beforeInitialize: %v, %v\n", id, message)
+		slog.DebugContext(ctx, "mcp beforeInitialize", "id", id, "message", message)
 	})
 	hooks.AddOnRequestInitialization(func(ctx context.Context, id any, message any) error {
-		fmt.Printf("AddOnRequestInitialization: %v, %v\n", id, message)
+		slog.DebugContext(ctx, "mcp onRequestInitialization", "id", id, "message", message)
 		// authorization verification and other preprocessing tasks are performed.
 		return nil
 	})
 	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
-		fmt.Printf("afterInitialize: %v, %v, %v\n", id, message, result)
+		slog.DebugContext(ctx, "mcp afterInitialize", "id", id, "message", message, "result", result)
 	})
 	hooks.AddAfterCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest, result *mcp.CallToolResult) {
-		fmt.Printf("afterCallTool: %v, %v, %v\n", id, message, result)
+		slog.DebugContext(ctx, "mcp afterCallTool", "id", id, "message", message, "result", result)
 	})
 	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest) {
-		fmt.Printf("beforeCallTool: %v, %v\n", id, message)
+		slog.DebugContext(ctx, "mcp beforeCallTool", "id", id, "message", message)
 	})
 
 	mcpServer := server.NewMCPServer(
@@ -493,6 +522,45 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		return handleGetSessionToolWithOptions(ctx, request, options)
 	})
 
+	mcpServer.AddTool(mcp.NewTool("tsplay.list_runs",
+		mcp.WithDescription("List recorded Flow and MCP browser runs of the calling session from the run index under the artifact root, newest first. Filter by flow name, status, session, tool, or time range."),
+		mcp.WithString("flow",
+			mcp.Description("Only runs of this flow name."),
+		),
+		mcp.WithString("status",
+			mcp.Description("Only runs with this status, for example succeeded, failed, manual_review_required, or an MCP run status such as timed_out."),
+		),
+		mcp.WithString("session",
+			mcp.Description("Only runs started by this session id."),
+		),
+		mcp.WithString("tool",
+			mcp.Description("Only MCP runs of this tool, for example tsplay.run_flow."),
+		),
+		mcp.WithString("since",
+			mcp.Description("Only runs started at or after this time: RFC 3339, YYYY-MM-DD, or a lookback such as 24h or 7d."),
+		),
+		mcp.WithString("until",
+			mcp.Description("Only runs started before this time, in the same formats as since."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum runs to return. Defaults to 50."),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return handleListRunsToolWithOptions(ctx, request, options)
+	})
+
+	mcpServer.AddTool(mcp.NewTool("tsplay.get_run",
+		mcp.WithDescription("Get one indexed run of the calling session in detail, including its MCP run audit and resumable checkpoint when they exist."),
+		mcp.WithString("run_id",
+			mcp.Description("Run id returned by tsplay.run_flow or tsplay.list_runs."),
+			mcp.Required(),
+		),
+		mcp.WithReadOnlyHintAnnotation(true),
+	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return handleGetRunToolWithOptions(ctx, request, options)
+	})
+
 	mcpServer.AddTool(mcp.NewTool("tsplay.export_session_flow_snippet",
 		mcp.WithDescription("Export copy-ready browser or Flow snippets for one named browser session. Supports YAML and JSON, plus recommended and expanded variants."),
 		mcp.WithString("name",
@@ -536,6 +604,15 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithString("session",
 			mcp.Description("Optional session name inside the persistent profile."),
 		),
+		mcp.WithString("engine",
+			mcp.Description("Optional browser engine of the persistent profile: chromium (default), firefox or webkit. Flows using the session run with it."),
+		),
+		mcp.WithObject("proxy",
+			mcp.Description("Optional proxy flows using this session go through: server (http://, https:// or socks5://), bypass, and connection naming TSPLAY_PROXY_<CONNECTION>_SERVER/BYPASS/USERNAME/PASSWORD env vars for credentials. An inline password is rejected. Omit to keep the saved proxy."),
+		),
+		mcp.WithObject("fingerprint",
+			mcp.Description("Optional browser identity to reuse with this session: user_agent, viewport, device, locale, timezone_id, geolocation, permissions, color_scheme, is_mobile, has_touch and extra_http_headers. Flows using the session start from it; their own browser fields win. Omit to keep the saved fingerprint."),
+		),
 		mcp.WithOpenWorldHintAnnotation(true),
 	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return handleSaveSessionToolWithOptions(ctx, request, options)
@@ -567,6 +644,7 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("headless",
 			mcp.Description("Run browser in headless mode when url is provided. Defaults to true."),
 		),
+		withBrowserEngineInput("Optional browser engine used when url is provided: chromium (default), firefox or webkit. CDP options require chromium."),
 		mcp.WithBoolean("browser_cdp_launch",
 			mcp.Description("Launch a local Chrome/Chromium/Edge with remote debugging enabled, then attach over CDP. Auto-detects the executable when browser_cdp_executable is omitted. Requires allow_browser_state=true."),
 		),
@@ -620,6 +698,9 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("allow_database",
 			mcp.Description("Allow database write actions such as db_insert during the auto validation pass."),
 		),
+		mcp.WithBoolean("allow_secrets",
+			mcp.Description("Allow {{ secret.NAME }} references during the auto validation pass."),
+		),
 		mcp.WithOpenWorldHintAnnotation(true),
 	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return handleDraftFlowToolWithOptions(ctx, request, options)
@@ -641,6 +722,7 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("headless",
 			mcp.Description("Run browser in headless mode when url is provided. Defaults to true."),
 		),
+		withBrowserEngineInput("Optional browser engine used when url is provided: chromium (default), firefox or webkit. CDP options require chromium."),
 		mcp.WithBoolean("browser_cdp_launch",
 			mcp.Description("Launch a local Chrome/Chromium/Edge with remote debugging enabled, then attach over CDP. Auto-detects the executable when browser_cdp_executable is omitted. Requires allow_browser_state=true."),
 		),
@@ -694,6 +776,9 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("allow_database",
 			mcp.Description("Allow database write actions such as db_insert during the auto validation pass."),
 		),
+		mcp.WithBoolean("allow_secrets",
+			mcp.Description("Allow {{ secret.NAME }} references during the auto validation pass."),
+		),
 		mcp.WithOpenWorldHintAnnotation(true),
 	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return handleFinalizeFlowToolWithOptions(ctx, request, options)
@@ -764,7 +849,7 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 	})
 
 	mcpServer.AddTool(mcp.NewTool("tsplay.observe_page",
-		mcp.WithDescription("Open a page and return an AI-friendly observation: page summary, DOM snapshot excerpt, content elements, screenshot path, DOM snapshot path, and interactive elements with selector candidates."),
+		mcp.WithDescription("Open a page and return an AI-friendly observation: page summary, DOM snapshot excerpt, content elements, screenshot path, DOM snapshot path, and interactive elements with selector candidates, semantic locators such as role= and label= first. Elements inside iframes carry a frame chain to use as the step's frame."),
 		mcp.WithString("url",
 			mcp.Description("URL to open and observe."),
 			mcp.Required(),
@@ -772,6 +857,7 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("headless",
 			mcp.Description("Run browser in headless mode. Defaults to true."),
 		),
+		withBrowserEngineInput("Optional browser engine used when url is provided: chromium (default), firefox or webkit. CDP options require chromium."),
 		mcp.WithBoolean("browser_cdp_launch",
 			mcp.Description("Launch a local Chrome/Chromium/Edge with remote debugging enabled, then attach over CDP. Auto-detects the executable when browser_cdp_executable is omitted. Requires allow_browser_state=true."),
 		),
@@ -845,6 +931,9 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("allow_database",
 			mcp.Description("Allow database write actions such as db_insert for this request. Defaults to false."),
 		),
+		mcp.WithBoolean("allow_secrets",
+			mcp.Description("Allow {{ secret.NAME }} references to stored secrets for this request. Defaults to false."),
+		),
 		mcp.WithReadOnlyHintAnnotation(true),
 	), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return handleValidateFlowToolWithOptions(ctx, request, options)
@@ -862,6 +951,12 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 			mcp.Description("Optional format hint: yaml or json."),
 			mcp.Enum("yaml", "json"),
 		),
+		mcp.WithObject("params",
+			mcp.Description("Values for the flow's declared parameters, keyed by parameter name. tsplay.validate_flow returns parameters_schema describing the accepted keys and types. Secret parameters are masked in the returned trace and vars."),
+		),
+		mcp.WithString("resume_run_id",
+			mcp.Description("Optional run.id of an earlier failed tsplay.run_flow call in this session. Restores its variables and continues from the failed step; completed steps are reported with status skipped."),
+		),
 		mcp.WithString("security_preset",
 			mcp.Description("Optional permission preset. Supported values: readonly, browser_write, full_automation. Explicit allow_* arguments override the preset."),
 			mcp.Enum(tsplaySecurityPresetReadOnly, tsplaySecurityPresetBrowserWrite, tsplaySecurityPresetFullAutomation),
@@ -869,6 +964,7 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("headless",
 			mcp.Description("Run browser in headless mode. Defaults to true."),
 		),
+		withBrowserEngineInput("Optional browser engine that overrides browser.engine: chromium, firefox or webkit. CDP options require chromium."),
 		mcp.WithBoolean("browser_cdp_launch",
 			mcp.Description("Launch a local Chrome/Chromium/Edge with remote debugging enabled, then attach over CDP. Auto-detects the executable when browser_cdp_executable is omitted. Requires allow_browser_state=true."),
 		),
@@ -909,6 +1005,9 @@ func registerTSPlayFlowTools(mcpServer *server.MCPServer, options TSPlayMCPServe
 		mcp.WithBoolean("allow_database",
 			mcp.Description("Allow database write actions such as db_insert for this request. Defaults to false."),
 		),
+		mcp.WithBoolean("allow_secrets",
+			mcp.Description("Allow {{ secret.NAME }} references to stored secrets for this request. Defaults to false."),
+		),
 		mcp.WithNumber("run_timeout",
 			mcp.Description("Total MCP browser run timeout in milliseconds, including queue wait and artifact capture. Defaults to the server runtime policy."),
 		),
@@ -960,6 +1059,88 @@ func handleListSessionsToolWithOptions(
 	})
 }
 
+func handleListRunsToolWithOptions(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+	options TSPlayMCPServerOptions,
+) (*mcp.CallToolResult, error) {
+	query, err := flowRunQueryFromValues(
+		request.GetString("flow", ""),
+		request.GetString("status", ""),
+		request.GetString("session", ""),
+		request.GetString("tool", ""),
+		request.GetString("since", ""),
+		request.GetString("until", ""),
+		request.GetInt("limit", defaultFlowRunQueryLimit),
+	)
+	if err != nil {
+		return newTSPlayToolResult("tsplay.list_runs", map[string]any{
+			"ok":    false,
+			"error": err.Error(),
+		})
+	}
+	if !options.AllowAllSessionRuns {
+		owner := tsplayMCPCallerFromContext(ctx).SessionID
+		query.Owner = &owner
+	}
+	runs, err := ListFlowRuns(options.ArtifactRoot, query)
+	if err != nil {
+		return newTSPlayToolResult("tsplay.list_runs", map[string]any{
+			"ok":    false,
+			"error": err.Error(),
+		})
+	}
+	return newTSPlayToolResult("tsplay.list_runs", map[string]any{
+		"ok":   true,
+		"runs": runs,
+	})
+}
+
+func handleGetRunToolWithOptions(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+	options TSPlayMCPServerOptions,
+) (*mcp.CallToolResult, error) {
+	var run *FlowRunDetails
+	var err error
+	if options.AllowAllSessionRuns {
+		run, err = GetFlowRun(options.ArtifactRoot, request.GetString("run_id", ""))
+	} else {
+		run, err = GetFlowRunForSession(options.ArtifactRoot, request.GetString("run_id", ""), tsplayMCPCallerFromContext(ctx).SessionID)
+	}
+	if err != nil {
+		return newTSPlayToolResult("tsplay.get_run", map[string]any{
+			"ok":    false,
+			"error": err.Error(),
+		})
+	}
+	return newTSPlayToolResult("tsplay.get_run", map[string]any{
+		"ok":  true,
+		"run": run,
+	})
+}
+
+// flowRunQueryFromValues builds a run query from the text filters shared by
+// the CLI, the workbench API and MCP.
+func flowRunQueryFromValues(flow string, status string, session string, tool string, since string, until string, limit int) (FlowRunQuery, error) {
+	query := FlowRunQuery{
+		Flow:    strings.TrimSpace(flow),
+		Status:  strings.TrimSpace(status),
+		Session: strings.TrimSpace(session),
+		Tool:    strings.TrimSpace(tool),
+		Limit:   limit,
+	}
+	now := time.Now()
+	var err error
+	if query.Since, err = ParseFlowRunTimeFilter(since, now); err != nil {
+		return FlowRunQuery{}, fmt.Errorf("since: %w", err)
+	}
+	if query.Until, err = ParseFlowRunTimeFilter(until, now); err != nil {
+		return FlowRunQuery{}, fmt.Errorf("until: %w", err)
+	}
+	return query, nil
+}
+
 func handleGetSessionTool(
 	ctx context.Context,
 	request mcp.CallToolRequest,
@@ -1081,6 +1262,17 @@ func handleSaveSessionToolWithOptions(
 	options TSPlayMCPServerOptions,
 ) (*mcp.CallToolResult, error) {
 	actor := flowSavedSessionAccessFromContext(ctx)
+	var proxy *FlowBrowserProxy
+	fingerprint, err := fingerprintFromToolRequest(request)
+	if err == nil {
+		proxy, err = proxyFromToolRequest(request)
+	}
+	if err != nil {
+		return newTSPlayToolResult("tsplay.save_session", map[string]any{
+			"ok":    false,
+			"error": err.Error(),
+		})
+	}
 	session, err := SaveFlowSavedSession(FlowSavedSessionSaveOptions{
 		Name:               request.GetString("name", ""),
 		ArtifactRoot:       options.ArtifactRoot,
@@ -1088,9 +1280,12 @@ func handleSaveSessionToolWithOptions(
 		StorageStatePath:   request.GetString("storage_state_path", ""),
 		Profile:            request.GetString("profile", ""),
 		Session:            request.GetString("session", ""),
+		Engine:             request.GetString("engine", ""),
 		OwnerSessionID:     actor.SessionID,
 		OwnerClientName:    actor.ClientName,
 		OwnerClientVersion: actor.ClientVersion,
+		Fingerprint:        fingerprint,
+		Proxy:              proxy,
 	})
 	if err != nil {
 		return newTSPlayToolResult("tsplay.save_session", map[string]any{
@@ -1288,6 +1483,7 @@ func draftFlowPayloadWithOptions(
 		observation, err = ObservePage(PageObservationOptions{
 			URL:            url,
 			Headless:       request.GetBool("headless", true),
+			BrowserEngine:  request.GetString("browser_engine", ""),
 			CDPLaunch:      cdpConfig.Launch,
 			CDPEndpoint:    cdpConfig.Endpoint,
 			CDPPort:        cdpConfig.Port,
@@ -1618,6 +1814,7 @@ func handleObservePageToolWithOptions(
 	observation, err := ObservePage(PageObservationOptions{
 		URL:            request.GetString("url", ""),
 		Headless:       request.GetBool("headless", true),
+		BrowserEngine:  request.GetString("browser_engine", ""),
 		CDPLaunch:      cdpConfig.Launch,
 		CDPEndpoint:    cdpConfig.Endpoint,
 		CDPPort:        cdpConfig.Port,
@@ -1711,12 +1908,16 @@ func handleValidateFlowToolWithOptions(
 		}
 		return newTSPlayToolResult("tsplay.validate_flow", payload)
 	}
-	return newTSPlayToolResult("tsplay.validate_flow", map[string]any{
+	payload := map[string]any{
 		"valid":    true,
 		"name":     flow.Name,
 		"steps":    len(flow.Steps),
 		"security": securityResolution,
-	})
+	}
+	if len(flow.Parameters) > 0 {
+		payload["parameters_schema"] = BuildFlowParametersJSONSchema(flow)
+	}
+	return newTSPlayToolResult("tsplay.validate_flow", payload)
 }
 
 func handleRunFlowTool(
@@ -1804,6 +2005,18 @@ func handleRunFlowToolWithOptions(
 		}
 		return newTSPlayToolResult("tsplay.run_flow", payload)
 	}
+	params, err := flowParamsFromToolRequest(request)
+	if err == nil {
+		_, err = resolveFlowParameters(flow, params)
+	}
+	if err != nil {
+		return newTSPlayToolResult("tsplay.run_flow", map[string]any{
+			"ok":                false,
+			"error":             err.Error(),
+			"parameters_schema": BuildFlowParametersJSONSchema(flow),
+			"security":          securityResolution,
+		})
+	}
 	runHandle, runCtx, err := beginTSPlayBrowserRun(ctx, request, "tsplay.run_flow", options, &security)
 	if err != nil {
 		return newTSPlayToolResult("tsplay.run_flow", map[string]any{
@@ -1813,6 +2026,13 @@ func handleRunFlowToolWithOptions(
 			"security": securityResolution,
 		})
 	}
+	resumeRunID := strings.TrimSpace(request.GetString("resume_run_id", ""))
+	resumeRunRoot := ""
+	if resumeRunID != "" {
+		// Runs are stored per caller session, so only runs of the same session
+		// can be resumed.
+		resumeRunRoot = filepath.Join(filepath.Dir(runHandle.run.RunRoot), resumeRunID)
+	}
 	result, err := RunFlow(flow, FlowRunOptions{
 		Security:      &security,
 		ArtifactRoot:  options.ArtifactRoot,
@@ -1822,6 +2042,10 @@ func handleRunFlowToolWithOptions(
 		SessionID:     runHandle.run.Caller.SessionID,
 		ClientName:    runHandle.run.Caller.ClientName,
 		ClientVersion: runHandle.run.Caller.ClientVersion,
+		Params:        params,
+		ResumeRunID:   resumeRunID,
+		ResumeRunRoot: resumeRunRoot,
+		tool:          runHandle.run.Tool,
 	})
 	if err != nil {
 		runDetails := map[string]any{
@@ -1861,6 +2085,48 @@ func handleRunFlowToolWithOptions(
 	return newTSPlayToolResult("tsplay.run_flow", payload)
 }
 
+func flowParamsFromToolRequest(request mcp.CallToolRequest) (map[string]any, error) {
+	value, ok := request.GetArguments()["params"]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	params, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("params must be an object keyed by parameter name")
+	}
+	return params, nil
+}
+
+func fingerprintFromToolRequest(request mcp.CallToolRequest) (*FlowBrowserFingerprint, error) {
+	value, ok := request.GetArguments()["fingerprint"]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	if text, ok := value.(string); ok {
+		return ParseFlowBrowserFingerprint(text)
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("fingerprint must be a JSON object: %w", err)
+	}
+	return ParseFlowBrowserFingerprint(string(content))
+}
+
+func proxyFromToolRequest(request mcp.CallToolRequest) (*FlowBrowserProxy, error) {
+	value, ok := request.GetArguments()["proxy"]
+	if !ok || value == nil {
+		return nil, nil
+	}
+	if text, ok := value.(string); ok {
+		return ParseFlowBrowserProxy(text)
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("proxy must be a JSON object: %w", err)
+	}
+	return ParseFlowBrowserProxy(string(content))
+}
+
 func flowResultForTool(result *FlowResult) *FlowResult {
 	if result == nil {
 		return nil
@@ -1964,6 +2230,7 @@ func buildFlowActionManifest() []map[string]any {
 	descriptions["lua"] = "Run an inline Lua code block. Prefer structured actions for normal browser steps and use lua only as an escape hatch."
 	descriptions["extract_text"] = "Read text from a selector, optionally wait first, and optionally extract the first regex match."
 	descriptions["set_var"] = "Set a flow variable from a resolved value. Requires save_as; for non-string literals use with.value."
+	descriptions["expr"] = "Evaluate a sandboxed expression such as total > 10 && status == 'ok' without Lua. Mostly used as an if, wait_until, while, or repeat_until condition."
 	descriptions["append_var"] = "Append one resolved value to a list variable. Initializes the list when save_as does not exist yet."
 	descriptions["assert_visible"] = "Fail the flow unless the selector is visible. Optional timeout waits before asserting."
 	descriptions["assert_text"] = "Fail the flow unless the selected element text contains the expected text. Optional timeout polls before asserting."
@@ -1973,6 +2240,10 @@ func buildFlowActionManifest() []map[string]any {
 	descriptions["foreach"] = "Run nested Flow steps once for each item in a list."
 	descriptions["on_error"] = "Run nested Flow steps and execute an error handler block if they fail."
 	descriptions["wait_until"] = "Poll a condition step until it returns a truthy result or times out."
+	descriptions["while"] = "Run nested Flow steps while a condition step stays truthy, up to max_iterations."
+	descriptions["repeat_until"] = "Run nested Flow steps, then stop once a condition step becomes truthy, up to max_iterations."
+	descriptions["break"] = "Leave the nearest while, repeat_until, or foreach loop."
+	descriptions["continue"] = "Skip the rest of the current loop iteration and start the next one."
 	descriptions["http_request"] = "Send an outbound HTTP request, optionally reuse browser cookies or user agent, and return structured response metadata."
 	descriptions["ocr_ready"] = "Check a goddddocr-compatible OCR service readiness endpoint. With mode=sidecar, TSPlay starts goddddocr-server automatically and waits for /ready."
 	descriptions["ocr_request"] = "Recognize an image with goddddocr over HTTP, managed sidecar, or direct CLI mode, returning text, confidence, optional filtering/probability details, and metadata."
@@ -1980,6 +2251,11 @@ func buildFlowActionManifest() []map[string]any {
 	descriptions["ocr_slide_comparison"] = "Send target and background images to a goddddocr-compatible slide comparison endpoint and return the detected gap center; managed sidecar is supported."
 	descriptions["ocr_slide_match"] = "Send target and background images to a goddddocr-compatible slide match endpoint and return the matched center plus confidence; managed sidecar is supported."
 	descriptions["send_email"] = "Send an outbound email through an SMTP connection resolved from environment variables or provided inline in Flow."
+	descriptions["mock_route"] = "Answer requests matching a URL glob with a canned response: status, headers, and a body from body, json, or a body_file under the file input root. Optional method and times narrow the match."
+	descriptions["modify_request"] = "Rewrite requests matching a URL glob before they are sent: merge headers, replace query parameters, or swap post_data/json. A null header or query value removes it."
+	descriptions["unroute"] = "Remove the mock_route, modify_request, and block_request handlers for a URL glob, or all page routes when pattern is omitted."
+	descriptions["wait_for_response"] = "Arm a response listener for a URL glob, run nested steps such as the click that fires the XHR, and return the first matching response with its decoded JSON body."
+	descriptions["capture_responses"] = "Like wait_for_response, but wait for count matching responses and return them as a list."
 	descriptions["json_extract"] = "Extract a value from JSON-like data using a path such as $.body.text or $.items[0]."
 	descriptions["read_json"] = "Read any local JSON file and return its decoded value."
 	descriptions["write_json"] = "Write any resolved value to a local JSON file."
@@ -1998,6 +2274,8 @@ func buildFlowActionManifest() []map[string]any {
 	descriptions["db_query_one"] = "Run a SELECT-style SQL query and return the first row object or null."
 	descriptions["db_execute"] = "Run a non-query SQL statement using database/sql and return execution metadata."
 	descriptions["db_transaction"] = "Run nested Flow steps inside a database transaction scope and commit or roll back automatically."
+	descriptions["within_frame"] = "Run nested Flow steps with every selector scoped to an iframe, for forms embedded in one or more iframes."
+	descriptions["call_flow"] = "Run a named fragment or another Flow file in its own variable scope, passing declared inputs and copying declared outputs back."
 
 	actions := make([]map[string]any, 0, len(flowActionSpecs))
 	for _, name := range FlowActionNames() {
@@ -2010,6 +2288,9 @@ func buildFlowActionManifest() []map[string]any {
 				"required": arg.Required,
 			})
 		}
+		if flowActionAcceptsFrame(name) {
+			args = append(args, map[string]any{"name": "frame", "type": "string", "required": false})
+		}
 		item := map[string]any{
 			"name":        name,
 			"description": descriptions[name],
@@ -2033,6 +2314,14 @@ func buildFlowActionManifest() []map[string]any {
 				"Use with.value when the literal is a boolean, number, list, or object.",
 			}
 		}
+		if name == "expr" {
+			item["returns"] = "any"
+			item["notes"] = []string{
+				"Expressions support comparisons, && || !, + - * / %, len(), lower(), upper(), trim(), default(), contains(), matches(), number(), string(), now(), and format_date().",
+				"The same expressions work inside {{ }} placeholders, for example {{ default(row.name, 'unknown') }}.",
+				"A condition may be written as a plain string such as \"{{ count > 0 }}\" instead of a nested expr step.",
+			}
+		}
 		if name == "append_var" {
 			item["requires_save_as"] = true
 			item["returns"] = "list<any>"
@@ -2063,6 +2352,7 @@ func buildFlowActionManifest() []map[string]any {
 				{"name": "items", "type": "items", "required": true},
 				{"name": "item_var", "type": "string", "required": true},
 				{"name": "index_var", "type": "string", "required": false},
+				{"name": "concurrency", "type": "int", "required": false},
 				{"name": "with.progress_key", "type": "string", "required": false},
 				{"name": "with.progress_connection", "type": "string", "required": false},
 				{"name": "with.progress_value", "type": "any", "required": false},
@@ -2073,6 +2363,8 @@ func buildFlowActionManifest() []map[string]any {
 				"Use with.progress_connection to choose a named Redis connection; omit it to use the default connection.",
 				"When with.progress_value is omitted, TSPlay writes the next source row from source_row/row_number/row, or falls back to the next iteration number.",
 				"Checkpointing requires allow_redis=true, but it is skipped when Redis is not configured in the environment.",
+				"Set concurrency above 1 to run iterations on that many pages of the same browser context. Each iteration gets a private copy of the variables and a fresh Lua state; only append_var results are merged back, in item order.",
+				"With concurrency, the progress checkpoint only advances past an item once every earlier item has succeeded.",
 			}
 		}
 		if name == "on_error" {
@@ -2088,6 +2380,26 @@ func buildFlowActionManifest() []map[string]any {
 				{"name": "interval_ms", "type": "int", "required": false, "default": 500},
 			}
 		}
+		if name == "while" || name == "repeat_until" {
+			item["args"] = []map[string]any{
+				{"name": "condition", "type": "condition", "required": true},
+				{"name": "steps", "type": "steps", "required": true},
+				{"name": "max_iterations", "type": "int", "required": false, "default": defaultFlowLoopMaxIterations},
+				{"name": "interval_ms", "type": "int", "required": false, "default": 0},
+			}
+			item["notes"] = []string{
+				"while checks the condition before each iteration; repeat_until runs the steps first and stops once the condition is truthy.",
+				"The loop fails when it would exceed max_iterations, so a stuck exit condition never spins forever.",
+				"Nested step traces carry the iteration number and use paths such as 3[2].1.",
+			}
+		}
+		if name == "break" || name == "continue" {
+			item["args"] = []map[string]any{}
+			item["notes"] = []string{
+				"Only valid inside a while, repeat_until, or foreach body, including inside if and on_error blocks there.",
+				"Not allowed inside conditions, db_transaction blocks, or called fragments unless they contain their own loop.",
+			}
+		}
 		if name == "read_csv" {
 			item["args"] = []map[string]any{
 				{"name": "file_path", "type": "string", "required": true},
@@ -2200,6 +2512,30 @@ func buildFlowActionManifest() []map[string]any {
 				"Recommended driver names are mysql, pgsql, sqlserver, and oracle; aliases such as postgres/postgresql remain accepted.",
 			}
 		}
+		if name == "wait_for_response" || name == "capture_responses" {
+			item["args"] = append(item["args"].([]map[string]any), map[string]any{"name": "steps", "type": "steps", "required": false})
+			item["returns"] = "object"
+			if name == "capture_responses" {
+				item["returns"] = "list<object>"
+			}
+			item["notes"] = []string{
+				"The listener is armed before the nested steps run, so put the triggering click inside steps.",
+				"timeout (default 30000 ms) counts from arming; fewer than count matches fails the step.",
+				"Each response has method, url, status, ok, headers, content_type, and body; JSON bodies are decoded unless response_as is text.",
+			}
+		}
+		if name == "within_frame" {
+			item["args"] = []map[string]any{
+				{"name": "frame", "type": "string", "required": true},
+				{"name": "steps", "type": "steps", "required": true},
+			}
+			item["returns"] = "object"
+			item["notes"] = []string{
+				"frame is name=<frame name>, url=<glob>, or iframe selectors joined by >> such as iframe#outer >> iframe[name=\"form\"].",
+				"Nested within_frame blocks continue inside the outer frame; name= and url= match a frame anywhere on the page.",
+				"Selector actions can also take frame directly for a single step.",
+			}
+		}
 		if name == "db_transaction" {
 			item["args"] = []map[string]any{
 				{"name": "steps", "type": "steps", "required": true},
@@ -2211,6 +2547,21 @@ func buildFlowActionManifest() []map[string]any {
 				"Transactions are started lazily per database connection and committed together when all nested steps succeed.",
 			}
 		}
+		if name == "call_flow" {
+			item["args"] = []map[string]any{
+				{"name": "fragment", "type": "string", "required": false},
+				{"name": "file_path", "type": "string", "required": false},
+				{"name": "inputs", "type": "object", "required": false},
+				{"name": "outputs", "type": "string_list", "required": false},
+			}
+			item["returns"] = "object"
+			item["notes"] = []string{
+				"Set exactly one of fragment or file_path; fragments are declared in the top-level fragments block.",
+				"The called steps only see inputs (plus the called file's vars defaults); only listed outputs are copied back to the caller.",
+				"Called flow files are resolved under the file input root, require allow_file_access=true, and must pass the caller's security policy.",
+				"Recursive includes are rejected during validation; nested step traces appear under children.",
+			}
+		}
 		if name == "read_excel" {
 			item["args"] = []map[string]any{
 				{"name": "file_path", "type": "string", "required": true},
@@ -2360,7 +2711,9 @@ func newJSONToolResult(value any) (*mcp.CallToolResult, error) {
 	if err != nil {
 		return nil, err
 	}
-	return mcp.NewToolResultText(string(encoded)), nil
+	// Every tool response passes through here, so secrets resolved by any run
+	// still in progress are masked even if a payload forgot to redact them.
+	return mcp.NewToolResultText(string(activeFlowSecrets.registry().maskJSON(encoded))), nil
 }
 
 func generateResources() []mcp.Resource {
@@ -2665,7 +3018,7 @@ func handleNotification(
 	ctx context.Context,
 	notification mcp.JSONRPCNotification,
 ) {
-	log.Printf("Received notification: %s", notification.Method)
+	slog.DebugContext(ctx, "received notification", "method", notification.Method)
 }
 
 func McpServerMCP(addr string, options ...TSPlayMCPServerOptions) {
@@ -2676,11 +3029,11 @@ func McpServerMCP(addr string, options ...TSPlayMCPServerOptions) {
 	normalizedOptions := normalizeTSPlayMCPServerOptions(options)
 	mcpServer := NewTSPlayMCPServer(normalizedOptions)
 
-	httpServer := server.NewStreamableHTTPServer(mcpServer)
-	log.Printf("HTTP server listening on %s/mcp", addr)
-	log.Printf("MCP flow_path root: %s", normalizedOptions.FlowPathRoot)
-	log.Printf("MCP artifact root: %s", normalizedOptions.ArtifactRoot)
-	if err := httpServer.Start(addr); err != nil {
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", server.NewStreamableHTTPServer(mcpServer))
+	mux.Handle("/metrics", NewMetricsHandler())
+	slog.Info("mcp http server listening", "addr", addr, "mcp_path", "/mcp", "metrics_path", "/metrics", "flow_path_root", normalizedOptions.FlowPathRoot, "artifact_root", normalizedOptions.ArtifactRoot)
+	if err := http.ListenAndServe(addr, mux); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
@@ -2689,9 +3042,7 @@ func McpServerStdio(options ...TSPlayMCPServerOptions) {
 	normalizedOptions := normalizeTSPlayMCPServerOptions(options)
 	mcpServer := NewTSPlayMCPServer(normalizedOptions)
 
-	log.Printf("TSPlay MCP stdio server starting")
-	log.Printf("MCP flow_path root: %s", normalizedOptions.FlowPathRoot)
-	log.Printf("MCP artifact root: %s", normalizedOptions.ArtifactRoot)
+	slog.Info("mcp stdio server starting", "flow_path_root", normalizedOptions.FlowPathRoot, "artifact_root", normalizedOptions.ArtifactRoot)
 	if err := server.ServeStdio(mcpServer); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
@@ -26,8 +26,10 @@ func TestNewTSPlayMCPServerOnlyRegistersTSPlayTools(t *testing.T) {
 		"tsplay.finalize_flow",
 		"tsplay.flow_examples",
 		"tsplay.flow_schema",
+		"tsplay.get_run",
 		"tsplay.get_session",
 		"tsplay.list_actions",
+		"tsplay.list_runs",
 		"tsplay.list_sessions",
 		"tsplay.observe_page",
 		"tsplay.repair_flow",
@@ -2795,6 +2797,134 @@ steps:
 	}
 }
 
+func TestNewJSONToolResultMasksResolvedSecrets(t *testing.T) {
+	secrets := newFlowSecretRegistry()
+	secrets.add(`mcp-"quoted"-secret`)
+	release := activeFlowSecrets.track(secrets)
+	t.Cleanup(release)
+	result, err := newJSONToolResult(map[string]any{
+		"error": `login failed for mcp-"quoted"-secret`,
+	})
+	if err != nil {
+		t.Fatalf("newJSONToolResult: %v", err)
+	}
+	var payload map[string]any
+	decodeToolText(t, result, &payload)
+	if payload["error"] != "login failed for "+flowSecretMask {
+		t.Fatalf("secret leaked into tool response: %#v", payload)
+	}
+
+	// Once the run ends its values are no longer held by the process.
+	release()
+	if masked := activeFlowSecrets.registry().snapshot(); containsFlowSecretValue(masked, `mcp-"quoted"-secret`) {
+		t.Fatalf("released secret is still tracked: %#v", masked)
+	}
+}
+
+func TestHandleRunFlowToolPassesParams(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := `
+schema_version: "1"
+name: mcp_params
+parameters:
+  customer:
+    required: true
+  quantity:
+    type: integer
+    default: 1
+steps:
+  - action: set_var
+    save_as: order
+    value: "{{customer}} x{{quantity}}"
+`
+	request := mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Arguments: map[string]any{
+				"flow":   flow,
+				"params": map[string]any{"customer": "acme", "quantity": float64(3)},
+			},
+		},
+	}
+
+	result, err := handleRunFlowToolWithOptions(context.Background(), request, TSPlayMCPServerOptions{ArtifactRoot: artifactRoot})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	var payload map[string]any
+	decodeToolText(t, result, &payload)
+	runResult, _ := payload["result"].(map[string]any)
+	vars, _ := runResult["vars"].(map[string]any)
+	if payload["ok"] != true || vars["order"] != "acme x3" {
+		t.Fatalf("unexpected payload: %#v", payload)
+	}
+
+	request.Params.Arguments = map[string]any{"flow": flow}
+	result, err = handleRunFlowToolWithOptions(context.Background(), request, TSPlayMCPServerOptions{ArtifactRoot: artifactRoot})
+	if err != nil {
+		t.Fatalf("run flow: %v", err)
+	}
+	payload = nil
+	decodeToolText(t, result, &payload)
+	if payload["ok"] != false || !strings.Contains(fmt.Sprint(payload["error"]), `parameter "customer" is required`) {
+		t.Fatalf("expected missing parameter error, got %#v", payload)
+	}
+	schema, _ := payload["parameters_schema"].(map[string]any)
+	if properties, _ := schema["properties"].(map[string]any); properties["quantity"] == nil {
+		t.Fatalf("parameters_schema = %#v", payload["parameters_schema"])
+	}
+}
+
+func TestHandleRunFlowToolResumesFailedRun(t *testing.T) {
+	artifactRoot := t.TempDir()
+	flow := `
+schema_version: "1"
+name: mcp_resume
+parameters:
+  ready:
+    type: boolean
+    default: false
+steps:
+  - action: set_var
+    save_as: first
+    value: a
+  - action: lua
+    code: "if not ready then error('not ready') end"
+`
+	call := func(arguments map[string]any) map[string]any {
+		arguments["flow"] = flow
+		arguments["allow_lua"] = true
+		result, err := handleRunFlowToolWithOptions(context.Background(), mcp.CallToolRequest{
+			Params: mcp.CallToolParams{Arguments: arguments},
+		}, TSPlayMCPServerOptions{ArtifactRoot: artifactRoot})
+		if err != nil {
+			t.Fatalf("run flow: %v", err)
+		}
+		var payload map[string]any
+		decodeToolText(t, result, &payload)
+		return payload
+	}
+
+	failed := call(map[string]any{})
+	run, _ := failed["run"].(map[string]any)
+	runID, _ := run["id"].(string)
+	if failed["ok"] != false || runID == "" {
+		t.Fatalf("expected failed run, got %#v", failed)
+	}
+
+	resumed := call(map[string]any{"resume_run_id": runID, "params": map[string]any{"ready": true}})
+	result, _ := resumed["result"].(map[string]any)
+	trace, _ := result["trace"].([]any)
+	first, _ := trace[0].(map[string]any)
+	if resumed["ok"] != true || first["status"] != "skipped" {
+		t.Fatalf("unexpected resumed payload: %#v", resumed)
+	}
+
+	unknown := call(map[string]any{"resume_run_id": "missing-run"})
+	if unknown["ok"] != false || !strings.Contains(fmt.Sprint(unknown["error"]), "no run checkpoint found") {
+		t.Fatalf("expected missing checkpoint error, got %#v", unknown)
+	}
+}
+
 func TestHandleRunFlowToolRejectsCDPWithoutBrowserStateBeforeRun(t *testing.T) {
 	artifactRoot := t.TempDir()
 	request := mcp.CallToolRequest{
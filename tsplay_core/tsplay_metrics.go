@@ -0,0 +1,288 @@
+package tsplay_core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics below are process-wide and exposed in the Prometheus text
+// format by NewMetricsHandler. The registry only implements what TSPlay
+// needs, so the binary does not depend on the Prometheus client library.
+var (
+	metricBrowserRuns = newMetricCounterVec(
+		"tsplay_browser_runs_total",
+		"Browser runs finished by the MCP server, by tool and final status.",
+		"tool", "status",
+	)
+	metricBrowserRunQueueWait = newMetricHistogramVec(
+		"tsplay_browser_run_queue_wait_seconds",
+		"Time browser runs waited for a concurrency slot.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120},
+		"tool",
+	)
+	metricFlowStepDuration = newMetricHistogramVec(
+		"tsplay_flow_step_duration_seconds",
+		"Flow step duration by action.",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		"action",
+	)
+	metricFlowStepErrors = newMetricCounterVec(
+		"tsplay_flow_step_errors_total",
+		"Flow steps that failed, by action.",
+		"action",
+	)
+	metricOCRSidecarsRunning atomic.Int64
+)
+
+// NewMetricsHandler serves the metrics for GET /metrics.
+func NewMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		_ = writeTSPlayMetrics(w)
+	})
+}
+
+func writeTSPlayMetrics(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	metricBrowserRuns.write(w)
+	metricBrowserRunQueueWait.write(w)
+	writeBrowserRunLimiterMetrics(w)
+	metricFlowStepDuration.write(w)
+	metricFlowStepErrors.write(w)
+	writeMetricGauge(w, "tsplay_ocr_sidecars_running", "goddddocr sidecar processes started by flows and not yet closed.", []metricSample{{value: float64(metricOCRSidecarsRunning.Load())}})
+	writeFlowDatabasePoolMetrics(w)
+	return w.Flush()
+}
+
+func recordFlowStepMetrics(action string, duration time.Duration, failed bool) {
+	metricFlowStepDuration.observe(duration.Seconds(), action)
+	if failed {
+		metricFlowStepErrors.add(1, action)
+	}
+}
+
+func writeBrowserRunLimiterMetrics(w *bufio.Writer) {
+	running := []metricSample{}
+	queued := []metricSample{}
+	limits := []metricSample{}
+	tsplayBrowserRunLimiterCache.Range(func(key, value any) bool {
+		limiter := value.(*tsplayBrowserRunLimiter)
+		labels := []string{fmt.Sprint(key)}
+		running = append(running, metricSample{labels: labels, value: float64(len(limiter.global))})
+		queued = append(queued, metricSample{labels: labels, value: float64(limiter.waiting.Load())})
+		limits = append(limits, metricSample{labels: labels, value: float64(cap(limiter.global))})
+		return true
+	})
+	writeMetricGauge(w, "tsplay_browser_runs_running", "Browser runs holding a concurrency slot.", running, "limiter")
+	writeMetricGauge(w, "tsplay_browser_runs_queued", "Browser runs waiting for a concurrency slot.", queued, "limiter")
+	writeMetricGauge(w, "tsplay_browser_runs_limit", "Global browser run concurrency limit.", limits, "limiter")
+}
+
+// writeFlowDatabasePoolMetrics reports the database/sql pool statistics of
+// every cached db_* connection. Connections are labeled by name and driver,
+// never by DSN, because DSNs carry credentials.
+func writeFlowDatabasePoolMetrics(w *bufio.Writer) {
+	type poolStats struct {
+		open, inUse, idle, maxOpen, waits float64
+	}
+	pools := map[string]*poolStats{}
+	flowDatabaseCache.Range(func(_, value any) bool {
+		entry := value.(flowDatabaseCacheEntry)
+		db, ok := entry.db.(*sqlFlowDatabase)
+		if !ok || db == nil || db.DB == nil {
+			return true
+		}
+		stats := db.Stats()
+		key := entry.name + "\n" + entry.driver
+		pool := pools[key]
+		if pool == nil {
+			pool = &poolStats{}
+			pools[key] = pool
+		}
+		pool.open += float64(stats.OpenConnections)
+		pool.inUse += float64(stats.InUse)
+		pool.idle += float64(stats.Idle)
+		pool.maxOpen += float64(stats.MaxOpenConnections)
+		pool.waits += float64(stats.WaitCount)
+		return true
+	})
+	var open, inUse, idle, maxOpen, waits []metricSample
+	for key, pool := range pools {
+		labels := strings.SplitN(key, "\n", 2)
+		open = append(open, metricSample{labels: labels, value: pool.open})
+		inUse = append(inUse, metricSample{labels: labels, value: pool.inUse})
+		idle = append(idle, metricSample{labels: labels, value: pool.idle})
+		maxOpen = append(maxOpen, metricSample{labels: labels, value: pool.maxOpen})
+		waits = append(waits, metricSample{labels: labels, value: pool.waits})
+	}
+	writeMetricGauge(w, "tsplay_db_pool_open_connections", "Open connections of the db_* connection pool.", open, "db", "driver")
+	writeMetricGauge(w, "tsplay_db_pool_in_use_connections", "Connections of the db_* pool currently in use.", inUse, "db", "driver")
+	writeMetricGauge(w, "tsplay_db_pool_idle_connections", "Idle connections of the db_* pool.", idle, "db", "driver")
+	writeMetricGauge(w, "tsplay_db_pool_max_open_connections", "Configured maximum open connections of the db_* pool; 0 is unlimited.", maxOpen, "db", "driver")
+	writeMetric(w, "tsplay_db_pool_wait_total", "Connections the db_* pool had to wait for.", "counter", waits, "db", "driver")
+}
+
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+type metricCounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*metricSample
+}
+
+func newMetricCounterVec(name string, help string, labels ...string) *metricCounterVec {
+	return &metricCounterVec{name: name, help: help, labels: labels, values: map[string]*metricSample{}}
+}
+
+func (vec *metricCounterVec) add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	sample := vec.values[key]
+	if sample == nil {
+		sample = &metricSample{labels: append([]string(nil), labelValues...)}
+		vec.values[key] = sample
+	}
+	sample.value += delta
+}
+
+func (vec *metricCounterVec) write(w *bufio.Writer) {
+	vec.mu.Lock()
+	samples := make([]metricSample, 0, len(vec.values))
+	for _, sample := range vec.values {
+		samples = append(samples, *sample)
+	}
+	vec.mu.Unlock()
+	writeMetric(w, vec.name, vec.help, "counter", samples, vec.labels...)
+}
+
+type metricHistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricHistogramSeries
+}
+
+type metricHistogramSeries struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newMetricHistogramVec(name string, help string, buckets []float64, labels ...string) *metricHistogramVec {
+	return &metricHistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*metricHistogramSeries{}}
+}
+
+func (vec *metricHistogramVec) observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	series := vec.series[key]
+	if series == nil {
+		series = &metricHistogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(vec.buckets))}
+		vec.series[key] = series
+	}
+	for i, bound := range vec.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (vec *metricHistogramVec) write(w *bufio.Writer) {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", vec.name, vec.help, vec.name)
+	keys := make([]string, 0, len(vec.series))
+	for key := range vec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bucketLabels := append(append([]string(nil), vec.labels...), "le")
+	for _, key := range keys {
+		series := vec.series[key]
+		for i, bound := range vec.buckets {
+			values := append(append([]string(nil), series.labels...), formatMetricValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, formatMetricLabels(bucketLabels, values), series.counts[i])
+		}
+		values := append(append([]string(nil), series.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, formatMetricLabels(bucketLabels, values), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", vec.name, formatMetricLabels(vec.labels, series.labels), formatMetricValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", vec.name, formatMetricLabels(vec.labels, series.labels), series.count)
+	}
+}
+
+func writeMetricGauge(w *bufio.Writer, name string, help string, samples []metricSample, labels ...string) {
+	writeMetric(w, name, help, "gauge", samples, labels...)
+}
+
+func writeMetric(w *bufio.Writer, name string, help string, kind string, samples []metricSample, labels ...string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labels, "\xff") < strings.Join(samples[j].labels, "\xff")
+	})
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatMetricLabels(labels, sample.labels), formatMetricValue(sample.value))
+	}
+}
+
+func formatMetricLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		parts = append(parts, name+`="`+escapeMetricLabelValue(value)+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var metricLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeMetricLabelValue(value string) string {
+	return metricLabelValueEscaper.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,77 @@
+package tsplay_core
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricVecsWritePrometheusText(t *testing.T) {
+	counter := newMetricCounterVec("test_runs_total", "Runs.", "tool", "status")
+	counter.add(1, "tsplay.run_flow", "ok")
+	counter.add(2, "tsplay.run_flow", "ok")
+	counter.add(1, "tsplay.run_flow", `bad "quote"`)
+	histogram := newMetricHistogramVec("test_wait_seconds", "Wait.", []float64{0.1, 1}, "tool")
+	histogram.observe(0.05, "tsplay.run_flow")
+	histogram.observe(0.5, "tsplay.run_flow")
+	histogram.observe(3, "tsplay.run_flow")
+
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	counter.write(w)
+	histogram.write(w)
+	writeMetricGauge(w, "test_running", "Running.", []metricSample{{value: 2}})
+	if err := w.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	for _, want := range []string{
+		"# TYPE test_runs_total counter\n",
+		`test_runs_total{tool="tsplay.run_flow",status="ok"} 3` + "\n",
+		`test_runs_total{tool="tsplay.run_flow",status="bad \"quote\""} 1` + "\n",
+		"# TYPE test_wait_seconds histogram\n",
+		`test_wait_seconds_bucket{tool="tsplay.run_flow",le="0.1"} 1` + "\n",
+		`test_wait_seconds_bucket{tool="tsplay.run_flow",le="1"} 2` + "\n",
+		`test_wait_seconds_bucket{tool="tsplay.run_flow",le="+Inf"} 3` + "\n",
+		`test_wait_seconds_sum{tool="tsplay.run_flow"} 3.55` + "\n",
+		`test_wait_seconds_count{tool="tsplay.run_flow"} 3` + "\n",
+		"test_running 2\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected metrics output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMetricsHandlerReportsFlowStepsAndLimiter(t *testing.T) {
+	limiter := getTSPlayBrowserRunLimiter(3, 1)
+	limiter.global <- struct{}{}
+	defer releaseTSPlayBrowserRunToken(limiter.global)
+	recordFlowStepMetrics("metrics_test_click", 120*time.Millisecond, true)
+
+	rec := httptest.NewRecorder()
+	NewMetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`tsplay_flow_step_duration_seconds_count{action="metrics_test_click"} 1`,
+		`tsplay_flow_step_errors_total{action="metrics_test_click"} 1`,
+		`tsplay_browser_runs_running{limiter="3:1"} 1`,
+		`tsplay_browser_runs_limit{limiter="3:1"} 3`,
+		"# TYPE tsplay_ocr_sidecars_running gauge",
+		"# TYPE tsplay_db_pool_open_connections gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	NewMetricsHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/metrics", nil))
+	if rec.Code != 405 {
+		t.Fatalf("POST /metrics status = %d, want 405", rec.Code)
+	}
+}
@@ -15,8 +15,10 @@ import (
 )
 
 type PageObservationOptions struct {
-	URL            string
-	Headless       bool
+	URL      string
+	Headless bool
+	// BrowserEngine is chromium (default), firefox or webkit.
+	BrowserEngine  string
 	CDPLaunch      bool
 	CDPEndpoint    string
 	CDPPort        int
@@ -66,6 +68,11 @@ type PageObservationElement struct {
 	SelectorDetails    []PageObservationSelector `json:"selector_details,omitempty"`
 	BoundingBox        *PageObservationBox       `json:"bounding_box,omitempty"`
 	Attributes         map[string]string         `json:"attributes,omitempty"`
+	// Frame is set for elements inside an iframe. It is the frame chain to pass
+	// as a step's frame parameter or to within_frame; selectors and bounding
+	// boxes are relative to that frame.
+	Frame    string `json:"frame,omitempty"`
+	FrameURL string `json:"frame_url,omitempty"`
 }
 
 type PageObservationBox struct {
@@ -115,6 +122,7 @@ func ObservePage(options PageObservationOptions) (*PageObservation, error) {
 	}
 
 	browserConfig := FlowBrowserConfig{
+		Engine:         strings.TrimSpace(options.BrowserEngine),
 		Headless:       playwright.Bool(options.Headless),
 		CDPLaunch:      options.CDPLaunch,
 		CDPEndpoint:    strings.TrimSpace(options.CDPEndpoint),
@@ -126,13 +134,16 @@ func ObservePage(options PageObservationOptions) (*PageObservation, error) {
 	if _, err := validateFlowBrowserCDPLaunchEndpoint(browserConfig); err != nil {
 		return nil, err
 	}
+	if err := validateFlowBrowserEngineConfig(browserConfig); err != nil {
+		return nil, err
+	}
 	if options.Security != nil {
 		if err := validateFlowBrowserConfigSecurity(&browserConfig, *options.Security); err != nil {
 			return nil, err
 		}
 	}
 
-	pw, err := StartPlaywright()
+	pw, err := StartPlaywright(browserConfig.engine())
 	if err != nil {
 		return nil, err
 	}
@@ -248,8 +259,9 @@ func ObserveLoadedPage(page playwright.Page, options PageObservationOptions) (*P
 	if maxElements <= 0 {
 		maxElements = 100
 	}
-	if len(elements) > maxElements {
-		elements = elements[:maxElements]
+	elements, droppedFrameElements := limitObservationElements(elements, maxElements)
+	if droppedFrameElements > 0 {
+		observation.Errors = append(observation.Errors, fmt.Sprintf("elements: %d iframe element(s) omitted to stay within max_elements %d", droppedFrameElements, maxElements))
 	}
 	for i := range elements {
 		elements[i].Index = i + 1
@@ -259,6 +271,49 @@ func ObserveLoadedPage(page playwright.Page, options PageObservationOptions) (*P
 	return observation, nil
 }
 
+// limitObservationElements keeps at most maxElements elements and shares the
+// budget between the main frame and each iframe, so a long main page does not
+// push every iframe element out. It returns how many iframe elements were
+// dropped.
+func limitObservationElements(elements []PageObservationElement, maxElements int) ([]PageObservationElement, int) {
+	if len(elements) <= maxElements {
+		return elements, 0
+	}
+	frames := []string{}
+	counts := map[string]int{}
+	for _, element := range elements {
+		if _, ok := counts[element.Frame]; !ok {
+			frames = append(frames, element.Frame)
+		}
+		counts[element.Frame]++
+	}
+	keep := map[string]int{}
+	for budget := maxElements; budget > 0; {
+		progressed := false
+		for _, frame := range frames {
+			if budget > 0 && keep[frame] < counts[frame] {
+				keep[frame]++
+				budget--
+				progressed = true
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	limited := make([]PageObservationElement, 0, maxElements)
+	dropped := 0
+	for _, element := range elements {
+		if keep[element.Frame] > 0 {
+			keep[element.Frame]--
+			limited = append(limited, element)
+		} else if element.Frame != "" {
+			dropped++
+		}
+	}
+	return limited, dropped
+}
+
 func buildObservationPageSummary(observation *PageObservation) string {
 	if observation == nil {
 		return ""
@@ -441,8 +496,39 @@ func truncateObservationText(value string, limit int) string {
 	return string(runes[:limit]) + "..."
 }
 
+// observeInteractiveElements collects the elements of the main frame and of
+// every iframe. Main-frame elements come first, then each frame in document
+// order, each group sorted by position.
 func observeInteractiveElements(page playwright.Page) ([]PageObservationElement, error) {
-	value, err := page.Evaluate(`() => {
+	elements, err := observeFrameInteractiveElements(page.MainFrame())
+	if err != nil {
+		return nil, err
+	}
+	for _, frame := range page.Frames() {
+		if frame.ParentFrame() == nil || frame.IsDetached() {
+			continue
+		}
+		// Frames can navigate or detach while the page is observed; skip
+		// them rather than failing the whole observation.
+		chain, err := flowFrameSelectorChain(frame)
+		if err != nil {
+			continue
+		}
+		frameElements, err := observeFrameInteractiveElements(frame)
+		if err != nil {
+			continue
+		}
+		for i := range frameElements {
+			frameElements[i].Frame = strings.Join(chain, " >> ")
+			frameElements[i].FrameURL = frame.URL()
+		}
+		elements = append(elements, frameElements...)
+	}
+	return elements, nil
+}
+
+func observeFrameInteractiveElements(frame playwright.Frame) ([]PageObservationElement, error) {
+	value, err := frame.Evaluate(`() => {
 		const candidates = [
 			'a[href]',
 			'button',
@@ -540,6 +626,22 @@ func observeInteractiveElements(page playwright.Page) ([]PageObservationElement,
 		};
 		const selectorCandidates = (element, tag, inputType, text, label, placeholder, ariaLabel, href, role) => {
 			const selectors = [];
+			// Semantic locators come first: they survive markup changes that
+			// break CSS and XPath.
+			const testID = element.getAttribute('data-testid');
+			if (testID) addUnique(selectors, 'testid=' + quote(testID));
+			// Role names come from a label, aria-label, or the text of a button
+			// or link, never from a form control's value or options, and
+			// truncated text is skipped because it would not match.
+			if (role) {
+				const formControl = ['input', 'select', 'textarea'].includes(tag);
+				const textName = !formControl && ['button', 'link'].includes(role) ? text : '';
+				for (const name of [label, ariaLabel, textName]) {
+					if (name && !name.endsWith('...')) addUnique(selectors, 'role=' + role + '[name=' + quote(name) + ']');
+				}
+			}
+			if (label) addUnique(selectors, 'label=' + quote(label));
+			if (text && ['button', 'a'].includes(tag)) addUnique(selectors, 'text=' + quote(text));
 			for (const attr of ['data-testid', 'data-test', 'data-cy']) {
 				const value = element.getAttribute(attr);
 				if (value) {
@@ -561,9 +663,6 @@ func observeInteractiveElements(page playwright.Page) ([]PageObservationElement,
 				addUnique(selectors, tag + '[aria-label=' + quote(ariaLabel) + ']');
 			}
 			if (href && tag === 'a') addUnique(selectors, 'a[href=' + quote(href) + ']');
-			if (label && role) addUnique(selectors, 'role=' + role + '[name=' + quote(label) + ']');
-			if (text && ['button', 'a'].includes(tag)) addUnique(selectors, 'text=' + quote(text));
-			if (text && role) addUnique(selectors, 'role=' + role + '[name=' + quote(text) + ']');
 			const xpath = generateXPath(element);
 			if (xpath) addUnique(selectors, 'xpath=' + xpath);
 			return selectors;
@@ -279,3 +279,26 @@ func containsString(values []string, want string) bool {
 	}
 	return false
 }
+
+func TestLimitObservationElementsSharesTheBudgetWithIframes(t *testing.T) {
+	elements := []PageObservationElement{}
+	for i := 0; i < 8; i++ {
+		elements = append(elements, PageObservationElement{ID: fmt.Sprintf("main-%d", i)})
+	}
+	for i := 0; i < 3; i++ {
+		elements = append(elements, PageObservationElement{ID: fmt.Sprintf("pay-%d", i), Frame: "iframe#pay"})
+	}
+	elements = append(elements, PageObservationElement{ID: "chat-0", Frame: "iframe#chat"})
+
+	limited, dropped := limitObservationElements(elements, 6)
+	ids := []string{}
+	for _, element := range limited {
+		ids = append(ids, element.ID)
+	}
+	if got := strings.Join(ids, ","); got != "main-0,main-1,main-2,pay-0,pay-1,chat-0" || dropped != 1 {
+		t.Fatalf("expected the budget to be shared by frame, got %s with %d dropped", got, dropped)
+	}
+	if limited, dropped := limitObservationElements(elements, 20); len(limited) != len(elements) || dropped != 0 {
+		t.Fatalf("expected every element under the limit, got %d with %d dropped", len(limited), dropped)
+	}
+}
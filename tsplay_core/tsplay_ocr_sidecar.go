@@ -252,6 +252,7 @@ func startGoddddocrSidecar(ctx *FlowContext, executable string, serverArgs []str
 		stdout:   stdout,
 		stderr:   stderr,
 	}
+	metricOCRSidecarsRunning.Add(1)
 	go func() {
 		sidecar.waitDone <- cmd.Wait()
 	}()
@@ -268,6 +269,7 @@ func (sidecar *goddddocrSidecar) Close() error {
 	}
 	var closeErr error
 	sidecar.close.Do(func() {
+		metricOCRSidecarsRunning.Add(-1)
 		select {
 		case <-sidecar.waitDone:
 			return
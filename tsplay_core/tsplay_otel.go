@@ -0,0 +1,604 @@
+package tsplay_core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// OpenTelemetry span kinds and status codes as defined by OTLP.
+const (
+	otelSpanKindInternal = 1
+	otelSpanKindServer   = 2
+	otelSpanKindClient   = 3
+
+	otelStatusOK    = 1
+	otelStatusError = 2
+
+	otelFlushInterval = 2 * time.Second
+	otelMaxBatchSize  = 256
+	// otelMaxQueueSize bounds the spans waiting for export while a collector
+	// is slow or down; spans beyond it are dropped and counted.
+	otelMaxQueueSize = 8 * otelMaxBatchSize
+)
+
+// otelCorrelationAttributes are copied from a span to its children, so an
+// http_request or db_query span can be found by run id and step path alone.
+var otelCorrelationAttributes = []string{"tsplay.run_id", "tsplay.session_id", "tsplay.client_name", "tsplay.step_path"}
+
+// OTelTracingOptions configures span export. Spans are exported to an OTLP/HTTP
+// collector with JSON encoding, to a file with one OTLP JSON export request per
+// line, or both. Tracing is off when neither is set.
+type OTelTracingOptions struct {
+	// Endpoint is the collector base URL, for example http://127.0.0.1:4318.
+	// /v1/traces is appended unless the URL already has a path.
+	Endpoint    string
+	Headers     map[string]string
+	File        string
+	ServiceName string
+}
+
+// OTelTracingOptionsFromEnv reads the standard OTEL_* variables and
+// TSPLAY_OTEL_TRACES_FILE for the file exporter.
+func OTelTracingOptionsFromEnv() OTelTracingOptions {
+	options := OTelTracingOptions{
+		Endpoint:    strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")),
+		File:        strings.TrimSpace(os.Getenv("TSPLAY_OTEL_TRACES_FILE")),
+		ServiceName: strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")),
+		Headers:     parseOTelHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+	}
+	if options.Endpoint == "" {
+		if base := strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")); base != "" {
+			options.Endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+		}
+	}
+	return options
+}
+
+func (options OTelTracingOptions) Enabled() bool {
+	return strings.TrimSpace(options.Endpoint) != "" || strings.TrimSpace(options.File) != ""
+}
+
+func parseOTelHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(strings.TrimSpace(val)); err == nil {
+			val = unescaped
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+var otelActiveTracer atomic.Pointer[otelTracer]
+
+// StartOTelTracing installs the process-wide tracer. The returned func flushes
+// pending spans and stops the exporter; it is safe to call when tracing is off.
+func StartOTelTracing(options OTelTracingOptions) (func(context.Context) error, error) {
+	if !options.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporters := []otelSpanExporter{}
+	if endpoint := strings.TrimSpace(options.Endpoint); endpoint != "" {
+		parsed, err := url.Parse(endpoint)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("otel endpoint %q must be an http(s) URL", endpoint)
+		}
+		if parsed.Path == "" || parsed.Path == "/" {
+			parsed.Path = "/v1/traces"
+		}
+		exporters = append(exporters, &otelHTTPExporter{
+			url:     parsed.String(),
+			headers: options.Headers,
+			client:  &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	if path := strings.TrimSpace(options.File); path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("create otel trace file directory: %w", err)
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open otel trace file: %w", err)
+		}
+		exporters = append(exporters, &otelFileExporter{writer: file, closer: file})
+	}
+	serviceName := strings.TrimSpace(options.ServiceName)
+	if serviceName == "" {
+		serviceName = "tsplay"
+	}
+	tracer := newOTelTracer(serviceName, exporters...)
+	otelActiveTracer.Store(tracer)
+	return func(ctx context.Context) error {
+		otelActiveTracer.CompareAndSwap(tracer, nil)
+		return tracer.shutdown(ctx)
+	}, nil
+}
+
+type otelSpanExporter interface {
+	export(payload []byte) error
+	close() error
+}
+
+type otelHTTPExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (exporter *otelHTTPExporter) export(payload []byte) error {
+	request, err := http.NewRequest(http.MethodPost, exporter.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range exporter.headers {
+		request.Header.Set(key, value)
+	}
+	response, err := exporter.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("collector %s returned %s", exporter.url, response.Status)
+	}
+	return nil
+}
+
+func (exporter *otelHTTPExporter) close() error {
+	return nil
+}
+
+type otelFileExporter struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+func (exporter *otelFileExporter) export(payload []byte) error {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	_, err := exporter.writer.Write(append(payload, '\n'))
+	return err
+}
+
+func (exporter *otelFileExporter) close() error {
+	if exporter.closer == nil {
+		return nil
+	}
+	return exporter.closer.Close()
+}
+
+// otelTracer batches ended spans and exports them every otelFlushInterval,
+// when otelMaxBatchSize spans are pending, and on shutdown.
+type otelTracer struct {
+	serviceName string
+	exporters   []otelSpanExporter
+
+	mu      sync.Mutex
+	pending []*otelSpan
+	dropped int
+	flush   chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newOTelTracer(serviceName string, exporters ...otelSpanExporter) *otelTracer {
+	tracer := &otelTracer{
+		serviceName: serviceName,
+		exporters:   exporters,
+		flush:       make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go tracer.loop()
+	return tracer
+}
+
+func (tracer *otelTracer) loop() {
+	defer close(tracer.done)
+	ticker := time.NewTicker(otelFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-tracer.flush:
+		case <-tracer.stop:
+			tracer.exportPending()
+			return
+		}
+		tracer.exportPending()
+	}
+}
+
+func (tracer *otelTracer) enqueue(span *otelSpan) {
+	tracer.mu.Lock()
+	if len(tracer.pending) >= otelMaxQueueSize {
+		tracer.dropped++
+	} else {
+		tracer.pending = append(tracer.pending, span)
+	}
+	full := len(tracer.pending) >= otelMaxBatchSize
+	tracer.mu.Unlock()
+	if full {
+		select {
+		case tracer.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (tracer *otelTracer) exportPending() {
+	tracer.mu.Lock()
+	spans := tracer.pending
+	dropped := tracer.dropped
+	tracer.pending = nil
+	tracer.dropped = 0
+	tracer.mu.Unlock()
+	if dropped > 0 {
+		slog.Warn("otel export queue full, spans dropped", "dropped", dropped, "queue_size", otelMaxQueueSize)
+	}
+	for start := 0; start < len(spans); start += otelMaxBatchSize {
+		end := min(start+otelMaxBatchSize, len(spans))
+		payload, err := json.Marshal(tracer.exportRequest(spans[start:end]))
+		if err != nil {
+			slog.Warn("otel export failed", "err", err)
+			continue
+		}
+		for _, exporter := range tracer.exporters {
+			if err := exporter.export(payload); err != nil {
+				slog.Warn("otel export failed", "err", err)
+			}
+		}
+	}
+}
+
+func (tracer *otelTracer) shutdown(ctx context.Context) error {
+	select {
+	case <-tracer.stop:
+		return nil
+	default:
+		close(tracer.stop)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-tracer.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	var closeErr error
+	for _, exporter := range tracer.exporters {
+		if err := exporter.close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// exportRequest builds an OTLP ExportTraceServiceRequest in its JSON
+// encoding: ids are hex strings and 64-bit integers are decimal strings.
+func (tracer *otelTracer) exportRequest(spans []*otelSpan) map[string]any {
+	encoded := make([]any, 0, len(spans))
+	for _, span := range spans {
+		encoded = append(encoded, span.otlp())
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otelAttributes(map[string]any{"service.name": tracer.serviceName}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "tsplay"},
+				"spans": encoded,
+			}},
+		}},
+	}
+}
+
+type otelSpan struct {
+	tracer       *otelTracer
+	traceID      [16]byte
+	spanID       [8]byte
+	parentSpanID [8]byte
+	name         string
+	kind         int
+	start        time.Time
+
+	mu            sync.Mutex
+	attributes    map[string]any
+	end           time.Time
+	statusCode    int
+	statusMessage string
+	ended         bool
+}
+
+type otelSpanContextKey struct{}
+
+func otelSpanFromContext(ctx context.Context) *otelSpan {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(otelSpanContextKey{}).(*otelSpan)
+	return span
+}
+
+// startOTelSpan starts a child of the span in ctx, or a new trace. When
+// tracing is off it returns ctx unchanged and a nil span, whose methods are
+// no-ops. attributes are key, value pairs.
+func startOTelSpan(ctx context.Context, name string, kind int, attributes ...any) (context.Context, *otelSpan) {
+	tracer := otelActiveTracer.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	span := &otelSpan{
+		tracer:     tracer,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]any{},
+	}
+	if parent := otelSpanFromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+		parent.mu.Lock()
+		for _, key := range otelCorrelationAttributes {
+			if value, ok := parent.attributes[key]; ok {
+				span.attributes[key] = value
+			}
+		}
+		parent.mu.Unlock()
+	} else {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+	span.setAttributes(attributes...)
+	return context.WithValue(ctx, otelSpanContextKey{}, span), span
+}
+
+func (span *otelSpan) setAttributes(attributes ...any) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	for i := 0; i+1 < len(attributes); i += 2 {
+		key, ok := attributes[i].(string)
+		if !ok || key == "" {
+			continue
+		}
+		value := attributes[i+1]
+		if text, ok := value.(string); ok && text == "" {
+			continue
+		}
+		span.attributes[key] = value
+	}
+}
+
+func (span *otelSpan) setError(message string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.statusCode = otelStatusError
+	span.statusMessage = message
+}
+
+// finish ends the span, marking it failed when err is not nil, and queues it
+// for export. Only the first call has an effect.
+func (span *otelSpan) finish(err error) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	span.end = time.Now()
+	if err != nil {
+		span.statusCode = otelStatusError
+		span.statusMessage = err.Error()
+	} else if span.statusCode == 0 {
+		span.statusCode = otelStatusOK
+	}
+	span.mu.Unlock()
+	span.tracer.enqueue(span)
+}
+
+// traceparent formats the W3C trace context header for outgoing requests.
+func (span *otelSpan) traceparent() string {
+	if span == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(span.traceID[:]) + "-" + hex.EncodeToString(span.spanID[:]) + "-01"
+}
+
+func (span *otelSpan) otlp() map[string]any {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	encoded := map[string]any{
+		"traceId":           hex.EncodeToString(span.traceID[:]),
+		"spanId":            hex.EncodeToString(span.spanID[:]),
+		"name":              span.name,
+		"kind":              span.kind,
+		"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+		"attributes":        otelAttributes(span.attributes),
+		"status":            map[string]any{"code": span.statusCode},
+	}
+	if span.parentSpanID != [8]byte{} {
+		encoded["parentSpanId"] = hex.EncodeToString(span.parentSpanID[:])
+	}
+	if span.statusMessage != "" {
+		encoded["status"] = map[string]any{"code": span.statusCode, "message": span.statusMessage}
+	}
+	return encoded
+}
+
+func otelAttributes(values map[string]any) []any {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attributes := make([]any, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, map[string]any{"key": key, "value": otelAnyValue(values[key])})
+	}
+	return attributes
+}
+
+func otelAnyValue(value any) map[string]any {
+	switch typed := value.(type) {
+	case bool:
+		return map[string]any{"boolValue": typed}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(typed)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(typed, 10)}
+	case float64:
+		return map[string]any{"doubleValue": typed}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+}
+
+// otelSpanURL drops credentials and the query string, which often carries
+// tokens, from a URL recorded on a span.
+func otelSpanURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+// otelToolHandlerMiddleware wraps every TSPlay MCP tool call in a server span.
+// Flow runs started by the tool become its children through the request
+// context.
+func otelToolHandlerMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		caller := tsplayMCPCallerFromContext(ctx)
+		ctx, span := startOTelSpan(ctx, "tools/call "+request.Params.Name, otelSpanKindServer,
+			"mcp.method.name", "tools/call",
+			"gen_ai.tool.name", request.Params.Name,
+			"tsplay.session_id", caller.SessionID,
+			"tsplay.client_name", caller.ClientName,
+		)
+		result, err := next(ctx, request)
+		if err == nil && result != nil && result.IsError {
+			span.setError("tool returned an error result")
+		}
+		span.finish(err)
+		return result, err
+	}
+}
+
+// otelTracedDBExecutor records a client span for every statement of a db_*
+// action, including statements inside db_transaction.
+type otelTracedDBExecutor struct {
+	executor   flowDBExecutor
+	connection dbConnectionConfig
+}
+
+func traceFlowDBExecutor(executor flowDBExecutor, connection dbConnectionConfig) flowDBExecutor {
+	if executor == nil || otelActiveTracer.Load() == nil {
+		return executor
+	}
+	return &otelTracedDBExecutor{executor: executor, connection: connection}
+}
+
+func (traced *otelTracedDBExecutor) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := traced.startSpan(ctx, query)
+	result, err := traced.executor.ExecContext(ctx, query, args...)
+	span.finish(err)
+	return result, err
+}
+
+func (traced *otelTracedDBExecutor) QueryContext(ctx context.Context, query string, args ...any) (flowRows, error) {
+	ctx, span := traced.startSpan(ctx, query)
+	rows, err := traced.executor.QueryContext(ctx, query, args...)
+	span.finish(err)
+	return rows, err
+}
+
+func (traced *otelTracedDBExecutor) startSpan(ctx context.Context, query string) (context.Context, *otelSpan) {
+	operation := ""
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	name := strings.TrimSpace(operation + " " + traced.connection.Name)
+	return startOTelSpan(ctx, name, otelSpanKindClient,
+		"db.system.name", string(traced.connection.Dialect),
+		"db.operation.name", operation,
+		"db.query.text", query,
+		"tsplay.db.connection", traced.connection.Name,
+	)
+}
+
+// startStepSpan starts the span of one flow step and makes it the parent of
+// everything the step does. The returned func ends the span and restores the
+// caller's context.
+func (ctx *FlowContext) startStepSpan(step FlowStep, stepPath string, attempt int, iteration int) func(error) {
+	if otelActiveTracer.Load() == nil {
+		return func(error) {}
+	}
+	parent := ctx.Context
+	spanCtx, span := startOTelSpan(parent, "step "+stepPath+" "+step.Action, otelSpanKindInternal,
+		"tsplay.run_id", ctx.RunID,
+		"tsplay.session_id", ctx.SessionID,
+		"tsplay.client_name", ctx.ClientName,
+		"tsplay.step_path", stepPath,
+		"tsplay.action", step.Action,
+		"tsplay.step_name", step.Name,
+	)
+	if attempt > 0 {
+		span.setAttributes("tsplay.attempt", attempt)
+	}
+	if iteration > 0 {
+		span.setAttributes("tsplay.iteration", iteration)
+	}
+	ctx.Context = spanCtx
+	return func(err error) {
+		ctx.Context = parent
+		span.finish(err)
+	}
+}
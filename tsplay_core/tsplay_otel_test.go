@@ -0,0 +1,202 @@
+package tsplay_core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunFlowExportsOTelSpansToFile(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer server.Close()
+
+	tracePath := filepath.Join(t.TempDir(), "spans.jsonl")
+	shutdown, err := StartOTelTracing(OTelTracingOptions{File: tracePath})
+	if err != nil {
+		t.Fatalf("StartOTelTracing returned error: %v", err)
+	}
+	flow := &Flow{
+		SchemaVersion: CurrentFlowSchemaVersion,
+		Name:          "otel_spans",
+		Steps: []FlowStep{
+			{Action: "set_var", SaveAs: "greeting", Value: "hi"},
+			{Action: "http_request", URL: server.URL + "/api?token=secret", SaveAs: "api"},
+		},
+	}
+	result, runErr := RunFlow(flow, FlowRunOptions{
+		ArtifactRoot: t.TempDir(),
+		SessionID:    "session-1",
+		Security:     &FlowSecurityPolicy{AllowHTTP: true},
+	})
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown returned error: %v", err)
+	}
+	if runErr != nil {
+		t.Fatalf("RunFlow returned error: %v", runErr)
+	}
+
+	spans := readOTelFileSpans(t, tracePath)
+	flowSpan, ok := spans["flow otel_spans"]
+	if !ok {
+		t.Fatalf("expected a flow span, got %v", spanNames(spans))
+	}
+	if flowSpan.attributes["tsplay.run_id"] != result.RunID || flowSpan.attributes["tsplay.session_id"] != "session-1" {
+		t.Fatalf("unexpected flow span attributes %#v", flowSpan.attributes)
+	}
+	stepSpan, ok := spans["step 2 http_request"]
+	if !ok || stepSpan.parentSpanID != flowSpan.spanID {
+		t.Fatalf("expected step 2 to be a child of the flow span, got %#v", stepSpan)
+	}
+	httpSpan, ok := spans["GET"]
+	if !ok || httpSpan.parentSpanID != stepSpan.spanID || httpSpan.traceID != flowSpan.traceID {
+		t.Fatalf("expected the http span to be a child of step 2, got %#v", httpSpan)
+	}
+	if httpSpan.attributes["tsplay.step_path"] != "2" || httpSpan.attributes["tsplay.run_id"] != result.RunID {
+		t.Fatalf("expected correlation attributes on the http span, got %#v", httpSpan.attributes)
+	}
+	if httpSpan.attributes["http.response.status_code"] != "200" || strings.Contains(httpSpan.attributes["url.full"], "token") {
+		t.Fatalf("unexpected http span attributes %#v", httpSpan.attributes)
+	}
+	if want := "00-" + httpSpan.traceID + "-" + httpSpan.spanID + "-01"; traceparent != want {
+		t.Fatalf("expected traceparent %q, got %q", want, traceparent)
+	}
+	if _, ok := spans["step 1 set_var"]; !ok {
+		t.Fatalf("expected a span for step 1, got %v", spanNames(spans))
+	}
+}
+
+func TestOTelSpansAreNoOpsWhenTracingIsOff(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := startOTelSpan(ctx, "noop", otelSpanKindInternal, "key", "value")
+	if span != nil || spanCtx != ctx {
+		t.Fatalf("expected no span without a tracer, got %#v", span)
+	}
+	span.setAttributes("key", "value")
+	span.setError("boom")
+	span.finish(nil)
+	if got := span.traceparent(); got != "" {
+		t.Fatalf("expected no traceparent, got %q", got)
+	}
+	if got := parseOTelHeaders("authorization=Bearer%20abc, x-team = qa"); got["authorization"] != "Bearer abc" || got["x-team"] != "qa" {
+		t.Fatalf("unexpected headers %#v", got)
+	}
+}
+
+type testOTelSpan struct {
+	traceID      string
+	spanID       string
+	parentSpanID string
+	attributes   map[string]string
+}
+
+func readOTelFileSpans(t *testing.T, path string) map[string]testOTelSpan {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open span file: %v", err)
+	}
+	defer file.Close()
+	spans := map[string]testOTelSpan{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceID      string `json:"traceId"`
+						SpanID       string `json:"spanId"`
+						ParentSpanID string `json:"parentSpanId"`
+						Name         string `json:"name"`
+						Attributes   []struct {
+							Key   string         `json:"key"`
+							Value map[string]any `json:"value"`
+						} `json:"attributes"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			t.Fatalf("decode span line: %v", err)
+		}
+		for _, resource := range request.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, span := range scope.Spans {
+					attributes := map[string]string{}
+					for _, attribute := range span.Attributes {
+						for _, value := range attribute.Value {
+							attributes[attribute.Key] = fmt.Sprint(value)
+						}
+					}
+					spans[span.Name] = testOTelSpan{traceID: span.TraceID, spanID: span.SpanID, parentSpanID: span.ParentSpanID, attributes: attributes}
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func spanNames(spans map[string]testOTelSpan) []string {
+	names := []string{}
+	for name := range spans {
+		names = append(names, name)
+	}
+	return names
+}
+
+type recordingOTelExporter struct {
+	payloads [][]byte
+}
+
+func (exporter *recordingOTelExporter) export(payload []byte) error {
+	exporter.payloads = append(exporter.payloads, payload)
+	return nil
+}
+
+func (exporter *recordingOTelExporter) close() error { return nil }
+
+func TestOTelTracerCapsQueueAndExportsInBatches(t *testing.T) {
+	exporter := &recordingOTelExporter{}
+	tracer := &otelTracer{serviceName: "tsplay", exporters: []otelSpanExporter{exporter}, flush: make(chan struct{}, 1)}
+	for i := 0; i < otelMaxQueueSize+5; i++ {
+		tracer.enqueue(&otelSpan{tracer: tracer, name: fmt.Sprintf("span-%d", i)})
+	}
+	if len(tracer.pending) != otelMaxQueueSize || tracer.dropped != 5 {
+		t.Fatalf("expected a full queue and 5 dropped spans, got %d pending and %d dropped", len(tracer.pending), tracer.dropped)
+	}
+
+	tracer.exportPending()
+	if tracer.pending != nil || tracer.dropped != 0 {
+		t.Fatalf("expected the queue to be drained, got %d pending and %d dropped", len(tracer.pending), tracer.dropped)
+	}
+	if len(exporter.payloads) != otelMaxQueueSize/otelMaxBatchSize {
+		t.Fatalf("expected %d payloads, got %d", otelMaxQueueSize/otelMaxBatchSize, len(exporter.payloads))
+	}
+	for _, payload := range exporter.payloads {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []json.RawMessage `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(payload, &request); err != nil {
+			t.Fatalf("decode payload: %v", err)
+		}
+		if spans := len(request.ResourceSpans[0].ScopeSpans[0].Spans); spans != otelMaxBatchSize {
+			t.Fatalf("expected batches of %d spans, got %d", otelMaxBatchSize, spans)
+		}
+	}
+}
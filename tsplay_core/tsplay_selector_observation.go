@@ -85,6 +85,12 @@ func scoreObservedSelectorCandidateForElement(element PageObservationElement, se
 	kind := observedSelectorKind(selector)
 	score := 40
 	switch kind {
+	case "testid":
+		score = 102
+	case "role":
+		score = 99
+	case "label":
+		score = 97
 	case "data-testid":
 		score = 100
 	case "data-test":
@@ -111,12 +117,10 @@ func scoreObservedSelectorCandidateForElement(element PageObservationElement, se
 		score = 78
 	case "aria-label":
 		score = 76
-	case "role":
-		score = 74
 	case "placeholder":
 		score = 70
 	case "text":
-		score = 64
+		score = 88
 	case "xpath":
 		score = 10
 	}
@@ -124,9 +128,18 @@ func scoreObservedSelectorCandidateForElement(element PageObservationElement, se
 	if selectorLooksGeneratedID(selector) {
 		score -= 18
 	}
-	if kind == "text" && len([]rune(observedSelectorQuotedValue(selector))) > 48 {
+	if (kind == "text" || kind == "role") && len([]rune(observedSelectorQuotedValue(selector))) > 48 {
 		score -= 10
 	}
+	if kind == "role" && !observedRoleNameTrusted(element, observedSelectorQuotedValue(selector)) {
+		// A name taken from a value, the options of a select or truncated
+		// text changes with the data and loses to a stable id.
+		score -= 30
+	}
+	if kind == "role" && !strings.Contains(selector, "[name=") {
+		// A bare role matches every element with that role.
+		score -= 30
+	}
 	if kind == "href" && selectorHasDynamicHref(selector) {
 		score -= 14
 	}
@@ -141,6 +154,14 @@ func scoreObservedSelectorCandidateForElement(element PageObservationElement, se
 
 func observedSelectorKind(selector string) string {
 	switch {
+	case strings.HasPrefix(selector, "testid="):
+		return "testid"
+	case strings.HasPrefix(selector, "role="):
+		return "role"
+	case strings.HasPrefix(selector, "label="):
+		return "label"
+	case strings.HasPrefix(selector, "text="):
+		return "text"
 	case strings.HasPrefix(selector, `[`+"data-testid="):
 		return "data-testid"
 	case strings.HasPrefix(selector, `[`+"data-test="):
@@ -163,16 +184,12 @@ func observedSelectorKind(selector string) string {
 		return "name"
 	case strings.HasPrefix(selector, "a[href="):
 		return "href"
-	case strings.HasPrefix(selector, "role="):
-		return "role"
 	case strings.Contains(selector, "[placeholder="):
 		return "placeholder"
 	case strings.HasPrefix(selector, "[aria-label="):
 		return "aria-label"
 	case strings.Contains(selector, "[aria-label="):
 		return "tag-aria-label"
-	case strings.HasPrefix(selector, "text="):
-		return "text"
 	case strings.HasPrefix(selector, "xpath="):
 		return "xpath"
 	default:
@@ -182,6 +199,10 @@ func observedSelectorKind(selector string) string {
 
 func observedSelectorReason(selector string) string {
 	switch observedSelectorKind(selector) {
+	case "testid":
+		return "Uses the explicit test id as a semantic locator, which survives layout, text and markup changes."
+	case "label":
+		return "Uses the form label a user reads, which survives markup changes around the field."
 	case "data-testid", "data-test", "data-cy", "tag-data-testid", "tag-data-test", "tag-data-cy":
 		return "Uses an explicit test attribute, which is usually the most stable selector across layout and text changes."
 	case "id", "tag-id":
@@ -196,11 +217,11 @@ func observedSelectorReason(selector string) string {
 	case "aria-label", "tag-aria-label":
 		return "Uses an accessibility label, which stays readable and resilient when layout changes."
 	case "role":
-		return "Uses accessible role and name, which is readable and often more stable than raw text."
+		return "Uses accessible role and name, which survives markup changes and is more specific than raw text."
 	case "placeholder":
 		return "Falls back to placeholder text because no stronger test attribute or stable id was available."
 	case "text":
-		return "Falls back to visible text because no stronger stable attribute was found."
+		return "Uses visible text as a semantic locator; it survives markup changes but breaks when the copy changes."
 	case "xpath":
 		return "XPath is kept only as a last resort when stronger selectors are unavailable."
 	default:
@@ -243,6 +264,23 @@ func observedSelectorIDValue(selector string) string {
 	return ""
 }
 
+// observedRoleNameTrusted reports whether a role locator's name is one the
+// accessibility tree would give the element: a form control is named by its
+// label or aria-label, and truncated text never matches.
+func observedRoleNameTrusted(element PageObservationElement, name string) bool {
+	if name == "" {
+		return true
+	}
+	if strings.HasSuffix(name, "...") {
+		return false
+	}
+	switch element.Tag {
+	case "input", "select", "textarea":
+		return name == element.Label || name == element.AriaLabel
+	}
+	return true
+}
+
 func observedSelectorQuotedValue(selector string) string {
 	start := strings.IndexByte(selector, '"')
 	end := strings.LastIndexByte(selector, '"')
@@ -0,0 +1,177 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Semantic locators name an element the way a user sees it instead of by its
+// markup:
+//
+//	role=button[name="Save"]   Playwright's role engine (getByRole)
+//	label=Email                the form control labelled "Email" (getByLabel)
+//	text=Submit                Playwright's text engine (getByText)
+//	testid=checkout            the element with data-testid="checkout" (getByTestId)
+//
+// role= and text= are native Playwright selector engines. label= and testid=
+// are rewritten into the internal engines behind getByLabel and getByTestId.
+// An unquoted label matches case-insensitively as a substring; a quoted label
+// must match exactly. Semantic locators can be chained with ">>" like any
+// other selector, which is also how frame scoping composes with them.
+
+// normalizeSemanticSelector rewrites the label= and testid= parts of a selector
+// chain into selectors Playwright understands. Other selectors are returned
+// unchanged.
+func normalizeSemanticSelector(selector string) string {
+	if !strings.Contains(selector, "label=") && !strings.Contains(selector, "testid=") {
+		return selector
+	}
+	parts := splitSelectorChain(selector)
+	changed := false
+	for i, part := range parts {
+		if normalized, ok := normalizeSemanticSelectorPart(part); ok {
+			parts[i] = normalized
+			changed = true
+		}
+	}
+	if !changed {
+		return selector
+	}
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return strings.Join(parts, " >> ")
+}
+
+func normalizeSemanticSelectorPart(part string) (string, bool) {
+	part = strings.TrimSpace(part)
+	if value, ok := strings.CutPrefix(part, "label="); ok {
+		text, exact := semanticSelectorValue(value)
+		flag := "i"
+		if exact {
+			flag = "s"
+		}
+		return "internal:label=" + quoteSemanticSelectorValue(text) + flag, true
+	}
+	if value, ok := strings.CutPrefix(part, "testid="); ok {
+		text, _ := semanticSelectorValue(value)
+		return "internal:testid=[data-testid=" + quoteSemanticSelectorValue(text) + "s]", true
+	}
+	return "", false
+}
+
+// semanticSelectorValue trims a locator value and strips its quotes. A quoted
+// value asks for an exact match.
+func semanticSelectorValue(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 {
+		return value, false
+	}
+	switch {
+	case value[0] == '"' && value[len(value)-1] == '"':
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted, true
+		}
+		return value[1 : len(value)-1], true
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], true
+	}
+	return value, false
+}
+
+// quoteSemanticSelectorValue quotes text the way Playwright escapes attribute
+// selector values.
+func quoteSemanticSelectorValue(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+// splitSelectorChain splits a selector on ">>" outside quotes and brackets.
+func splitSelectorChain(selector string) []string {
+	parts := []string{}
+	start := 0
+	depth := 0
+	var quote byte
+	for i := 0; i < len(selector); i++ {
+		c := selector[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case (c == ']' || c == ')') && depth > 0:
+			depth--
+		case c == '>' && depth == 0 && i+1 < len(selector) && selector[i+1] == '>':
+			parts = append(parts, selector[start:i])
+			i++
+			start = i + 1
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+// validateSemanticSelector reports semantic locators without a value, such as
+// "label=" or "role=[name=Save]", before the flow runs.
+func validateSemanticSelector(selector string) error {
+	for _, part := range splitSelectorChain(selector) {
+		part = strings.TrimSpace(part)
+		for _, prefix := range []string{"role=", "label=", "text=", "testid="} {
+			value, ok := strings.CutPrefix(part, prefix)
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if prefix == "role=" {
+				value, _, _ = strings.Cut(value, "[")
+			} else {
+				value, _ = semanticSelectorValue(value)
+			}
+			if strings.TrimSpace(value) == "" {
+				return fmt.Errorf("%s requires a value, such as %s", prefix, semanticSelectorExample(prefix))
+			}
+		}
+	}
+	return nil
+}
+
+func semanticSelectorExample(prefix string) string {
+	switch prefix {
+	case "role=":
+		return `role=button[name="Save"]`
+	case "label=":
+		return "label=Email"
+	case "text=":
+		return "text=Submit"
+	default:
+		return "testid=checkout"
+	}
+}
+
+// checkLuaSelector reads a required selector argument of a Lua action and
+// resolves semantic locators.
+func checkLuaSelector(L *lua.LState, n int) string {
+	return normalizeSemanticSelector(L.CheckString(n))
+}
+
+// optLuaSelector is checkLuaSelector for an optional selector argument.
+func optLuaSelector(L *lua.LState, n int, def string) string {
+	return normalizeSemanticSelector(L.OptString(n, def))
+}
+
+// flowStepSelectorParam resolves the selector of a Flow runner step, including
+// semantic locators.
+func flowStepSelectorParam(ctx *FlowContext, step FlowStep) (string, error) {
+	selector, err := flowStepStringParam(ctx, step, "selector")
+	if err != nil {
+		return "", err
+	}
+	return normalizeSemanticSelector(selector), nil
+}
@@ -0,0 +1,120 @@
+package tsplay_core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSemanticSelector(t *testing.T) {
+	for selector, want := range map[string]string{
+		`label=Email`:                  `internal:label="Email"i`,
+		`label="E-mail \"work\""`:      `internal:label="E-mail \"work\""s`,
+		`testid=checkout`:              `internal:testid=[data-testid="checkout"s]`,
+		`testid="order-query"`:         `internal:testid=[data-testid="order-query"s]`,
+		`role=button[name="Save"]`:     `role=button[name="Save"]`,
+		`text=Submit`:                  `text=Submit`,
+		`[data-testid="checkout"]`:     `[data-testid="checkout"]`,
+		`form#login >> label=Password`: `form#login >> internal:label="Password"i`,
+		`text="a >> label=b"`:          `text="a >> label=b"`,
+		`iframe#workspace >> internal:control=enter-frame >> testid=save`: `iframe#workspace >> internal:control=enter-frame >> internal:testid=[data-testid="save"s]`,
+	} {
+		if got := normalizeSemanticSelector(selector); got != want {
+			t.Fatalf("normalizeSemanticSelector(%q) = %q, want %q", selector, got, want)
+		}
+		if got := normalizeSemanticSelector(want); got != want {
+			t.Fatalf("expected %q to be left alone, got %q", want, got)
+		}
+	}
+
+	for name, yaml := range map[string]string{
+		"blank label":  "  - action: click\n    selector: 'label='\n",
+		"blank testid": "  - action: click\n    selector: 'testid=\"\"'\n",
+		"blank role":   "  - action: click\n    selector: 'role=[name=\"Save\"]'\n",
+	} {
+		flow, err := ParseFlow([]byte("schema_version: \"1\"\nname: bad_locator\nsteps:\n"+yaml), "yaml")
+		if err != nil {
+			t.Fatalf("ParseFlow(%s) returned error: %v", name, err)
+		}
+		if err := ValidateFlow(flow); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestSemanticLocatorsRankFirstInDraftRepair(t *testing.T) {
+	element := PageObservationElement{
+		Index:   1,
+		Tag:     "button",
+		Type:    "button",
+		ID:      "save",
+		Text:    "Save",
+		Visible: true,
+		Enabled: true,
+		SelectorCandidates: []string{
+			`#save`,
+			`[data-cy="save"]`,
+			`text="Save"`,
+			`role=button[name="Save"]`,
+			`xpath=//*[@id="save"]`,
+		},
+	}
+	if got := preferredObservedSelector(element); got != `role=button[name="Save"]` {
+		t.Fatalf("preferred selector = %q, want the role locator", got)
+	}
+	element.SelectorCandidates = append(element.SelectorCandidates, `testid="save-button"`)
+	if got := preferredObservedSelector(element); got != `testid="save-button"` {
+		t.Fatalf("preferred selector = %q, want the test id locator", got)
+	}
+
+	for _, probe := range []PageObservationElement{
+		{Tag: "input", Type: "date", Role: "textbox", ID: "start-date", Value: "2024-01-01", SelectorCandidates: []string{`role=textbox[name="2024-01-01"]`, `#start-date`}},
+		{Tag: "select", Type: "select", Role: "combobox", ID: "country", Text: "China Japan Korea", SelectorCandidates: []string{`role=combobox[name="China Japan Korea"]`, `#country`}},
+		{Tag: "a", Type: "link", Role: "link", ID: "news", Text: strings.Repeat("Long headline ", 12) + "...", SelectorCandidates: []string{`role=link[name="` + strings.Repeat("Long headline ", 12) + `..."]`, `#news`}},
+	} {
+		if got, want := preferredObservedSelector(probe), probe.SelectorCandidates[1]; got != want {
+			t.Fatalf("preferred selector for %s = %q, want %q", probe.ID, got, want)
+		}
+	}
+	labelled := PageObservationElement{Tag: "input", Type: "date", Role: "textbox", ID: "start-date", Label: "Start date", SelectorCandidates: []string{`#start-date`, `role=textbox[name="Start date"]`}}
+	if got := preferredObservedSelector(labelled); got != `role=textbox[name="Start date"]` {
+		t.Fatalf("preferred selector = %q, want the labelled role locator", got)
+	}
+
+	steps := []FlowStep{{Action: "click", Selector: "#save"}}
+	repairs := []FlowDraftSelectorRepair{}
+	repairDraftSelectorStepSequence(steps, "", "", buildDraftSelectorRepairTargets([]PageObservationElement{element}, nil), &repairs)
+	if len(repairs) != 1 || steps[0].Selector != `testid="save-button"` {
+		t.Fatalf("expected the repair to switch to the semantic locator, got %#v and %#v", repairs, steps)
+	}
+}
+
+func TestRunFlowWaitForTextWithSemanticLocators(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<section aria-label="Bob's order"><h2 data-testid="order-status">pending</h2></section>
+<script>setTimeout(() => { document.querySelector("h2").textContent = "Bob's order shipped" }, 300)</script>`)
+	}))
+	defer server.Close()
+
+	flow, err := ParseFlow([]byte(`
+schema_version: "1"
+name: semantic_wait_for_text
+steps:
+  - action: navigate
+    url: `+server.URL+`
+  - action: wait_for_text
+    args: ["testid=order-status", "Bob's order shipped", 5000]
+  - action: lua
+    code: |
+      wait_for_text([[role=region[name="Bob's order"] >> role=heading]], "Bob's order shipped", 1000)
+`), "yaml")
+	if err != nil {
+		t.Fatalf("ParseFlow returned error: %v", err)
+	}
+	if _, err := RunFlow(flow, FlowRunOptions{Headless: true}); err != nil {
+		t.Fatalf("expected wait_for_text to accept semantic locators, got %v", err)
+	}
+}
@@ -26,6 +26,7 @@ type flowSavedSessionSnippetBrowser struct {
 	Persistent   bool   `json:"persistent,omitempty" yaml:"persistent,omitempty"`
 	Profile      string `json:"profile,omitempty" yaml:"profile,omitempty"`
 	Session      string `json:"session,omitempty" yaml:"session,omitempty"`
+	Engine       string `json:"engine,omitempty" yaml:"engine,omitempty"`
 }
 
 type flowSavedSessionSnippetFlow struct {
@@ -151,6 +152,7 @@ func buildFlowSavedSessionSnippetBrowser(session FlowSavedSession, artifactRoot
 		Persistent:   config.Persistent,
 		Profile:      config.Profile,
 		Session:      config.Session,
+		Engine:       config.Engine,
 	}
 }
 
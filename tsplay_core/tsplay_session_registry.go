@@ -41,6 +41,15 @@ type FlowSavedSession struct {
 	OwnerClientVersion  string `json:"owner_client_version,omitempty"`
 	LastUsedBySessionID string `json:"last_used_by_session_id,omitempty"`
 	LastUsedByRunID     string `json:"last_used_by_run_id,omitempty"`
+	// Fingerprint is the browser identity flows using this session start
+	// from, so the site keeps seeing the same device.
+	Fingerprint *FlowBrowserFingerprint `json:"fingerprint,omitempty"`
+	// Proxy is the proxy flows using this session go through unless they set
+	// browser.proxy themselves.
+	Proxy *FlowBrowserProxy `json:"proxy,omitempty"`
+	// Engine is the browser engine a profile session belongs to. Profiles are
+	// not portable between engines, so flows using the session run with it.
+	Engine string `json:"engine,omitempty"`
 }
 
 type FlowSavedSessionSaveOptions struct {
@@ -53,6 +62,13 @@ type FlowSavedSessionSaveOptions struct {
 	OwnerSessionID     string
 	OwnerClientName    string
 	OwnerClientVersion string
+	// Fingerprint replaces the saved fingerprint. Nil keeps the existing one.
+	Fingerprint *FlowBrowserFingerprint
+	// Proxy replaces the saved proxy. Nil keeps the existing one.
+	Proxy *FlowBrowserProxy
+	// Engine is the browser engine of a profile session. It defaults to
+	// chromium.
+	Engine string
 }
 
 type FlowSavedSessionDeleteResult struct {
@@ -102,8 +118,29 @@ func SaveFlowSavedSession(options FlowSavedSessionSaveOptions) (*FlowSavedSessio
 		session.OwnerClientVersion = existing.OwnerClientVersion
 		session.LastUsedBySessionID = existing.LastUsedBySessionID
 		session.LastUsedByRunID = existing.LastUsedByRunID
+		session.Fingerprint = existing.Fingerprint
+		session.Proxy = existing.Proxy
 	}
 	applyFlowSavedSessionOwner(session, actor)
+	if options.Fingerprint != nil {
+		if err := validateFlowBrowserFingerprint(options.Fingerprint); err != nil {
+			return nil, fmt.Errorf("save_session fingerprint: %w", err)
+		}
+		session.Fingerprint = options.Fingerprint
+		if session.Fingerprint.empty() {
+			session.Fingerprint = nil
+		}
+	}
+	if options.Proxy != nil {
+		if err := validateFlowBrowserProxy(FlowBrowserConfig{Proxy: options.Proxy}); err != nil {
+			return nil, fmt.Errorf("save_session %w", err)
+		}
+		if options.Proxy.Password != "" {
+			connection := firstNonEmpty(strings.TrimSpace(options.Proxy.Connection), proxyDefaultConnection)
+			return nil, fmt.Errorf("save_session does not store proxy passwords; set %s instead", strings.Join(proxyConfigEnvKeys(connection, "PASSWORD"), " or "))
+		}
+		session.Proxy = options.Proxy
+	}
 
 	storageStateJSON := strings.TrimSpace(options.StorageStateJSON)
 	storageStatePath := strings.TrimSpace(options.StorageStatePath)
@@ -137,9 +174,13 @@ func SaveFlowSavedSession(options FlowSavedSessionSaveOptions) (*FlowSavedSessio
 			session.Source = fmt.Sprintf("copied from storage_state_path %s", filepath.ToSlash(storageStatePath))
 		}
 	case profile != "":
+		if err := validateFlowBrowserEngine(options.Engine); err != nil {
+			return nil, fmt.Errorf("save_session %w", err)
+		}
 		session.Kind = flowSavedSessionKindProfile
 		session.Profile = profile
 		session.Session = profileSession
+		session.Engine = normalizeFlowBrowserEngine(options.Engine)
 		session.SourceType = "persistent_profile"
 		session.Source = fmt.Sprintf("registered persistent profile %s", profile)
 		if profileSession != "" {
@@ -226,14 +267,36 @@ func ResolveFlowSavedSessionBrowserConfig(name string, artifactRoot string, acce
 	if err := validateFlowSavedSessionAccess(session, normalizeFlowSavedSessionAccess(access), "used"); err != nil {
 		return nil, err
 	}
+	var config *FlowBrowserConfig
 	switch session.Kind {
 	case flowSavedSessionKindStorageState:
-		return &FlowBrowserConfig{StorageState: session.StorageStatePath}, nil
+		config = &FlowBrowserConfig{StorageState: session.StorageStatePath}
 	case flowSavedSessionKindProfile:
-		return &FlowBrowserConfig{Persistent: true, Profile: session.Profile, Session: session.Session}, nil
+		config = &FlowBrowserConfig{Persistent: true, Profile: session.Profile, Session: session.Session, Engine: session.Engine}
 	default:
 		return nil, fmt.Errorf("saved session %q uses unsupported kind %q", session.Name, session.Kind)
 	}
+	applyFlowBrowserFingerprint(config, session.Fingerprint)
+	if session.Proxy != nil {
+		proxy := *session.Proxy
+		proxy.savedSession = true
+		config.Proxy = &proxy
+	}
+	return config, nil
+}
+
+// applyFlowSavedSessionEngine runs a profile session with the engine it was
+// saved for. An explicit engine that differs is rejected rather than opening
+// an empty profile directory for the other engine.
+func applyFlowSavedSessionEngine(config *FlowBrowserConfig, saved FlowBrowserConfig, name string) error {
+	if saved.Engine == "" {
+		return nil
+	}
+	if strings.TrimSpace(config.Engine) != "" && config.engine() != saved.engine() {
+		return fmt.Errorf("saved session %q is a %s profile and cannot run with browser.engine %q", name, saved.engine(), config.engine())
+	}
+	config.Engine = saved.Engine
+	return nil
 }
 
 func MarkFlowSavedSessionUsed(name string, artifactRoot string, access ...FlowSavedSessionAccessInfo) (*FlowSavedSession, error) {
@@ -401,6 +464,12 @@ func buildFlowSavedSessionView(session FlowSavedSession, artifactRoot string, in
 		}
 		view["last_used_by"] = lastUsedBy
 	}
+	if session.Fingerprint != nil {
+		view["fingerprint"] = flowBrowserFingerprintView(session.Fingerprint, includeSensitive)
+	}
+	if session.Proxy != nil {
+		view["proxy"] = flowBrowserProxyView(session.Proxy, includeSensitive)
+	}
 	if includeSensitive {
 		switch session.Kind {
 		case flowSavedSessionKindStorageState:
@@ -465,6 +534,9 @@ func buildFlowSavedSessionResolvedBrowser(session FlowSavedSession, artifactRoot
 		if session.Session != "" {
 			resolved["session"] = session.Session
 		}
+		if session.Engine != "" {
+			resolved["engine"] = session.Engine
+		}
 		return resolved
 	default:
 		return map[string]any{}
@@ -490,7 +562,7 @@ func buildFlowSavedSessionPhysicalPaths(session FlowSavedSession, root string) m
 			paths["storage_state_path"] = resolved
 		}
 	case flowSavedSessionKindProfile:
-		if dir, err := flowSavedSessionProfileDir(root, session.Profile, session.Session); err == nil {
+		if dir, err := flowSavedSessionProfileDir(root, session.Profile, session.Session, session.Engine); err == nil {
 			paths["profile_dir"] = dir
 		}
 	}
@@ -524,7 +596,10 @@ func flowSavedSessionMetadataPath(root string, name string) string {
 	return filepath.Join(root, "sessions", "registry", name+".json")
 }
 
-func flowSavedSessionProfileDir(root string, profile string, session string) (string, error) {
+// flowSavedSessionProfileDir returns the directory of a persistent profile.
+// Profiles are not portable between engines, so firefox and webkit keep their
+// own directory next to the chromium one.
+func flowSavedSessionProfileDir(root string, profile string, session string, engine string) (string, error) {
 	root = strings.TrimSpace(root)
 	if root == "" {
 		return "", fmt.Errorf("profile root is required")
@@ -538,7 +613,11 @@ func flowSavedSessionProfileDir(root string, profile string, session string) (st
 	} else {
 		segments = append(segments, "default")
 	}
-	return filepath.Join(segments...), nil
+	dir := filepath.Join(segments...)
+	if engine := normalizeFlowBrowserEngine(engine); engine != FlowBrowserEngineChromium {
+		dir += "-" + engine
+	}
+	return dir, nil
 }
 
 func writeFlowSavedSession(root string, session *FlowSavedSession) error {
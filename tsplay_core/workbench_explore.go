@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"html"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
@@ -100,7 +100,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 	apiCardsByID := map[string]WorkbenchAPICard{}
 	entityCardsByID := map[string]WorkbenchEntityCard{}
 	exploreMode := workbenchExploreModeForSite(site)
-	log.Printf("workbench explore start site=%s run_id=%s mode=%s start_url=%s headless=%v max_pages=%d timeout_ms=%d", site.SiteID, runID, exploreMode, site.StartURL, options.Headless, maxPages, timeoutMS)
+	slog.Info("workbench explore start", "site", site.SiteID, "run_id", runID, "mode", exploreMode, "start_url", site.StartURL, "headless", options.Headless, "max_pages", maxPages, "timeout_ms", timeoutMS)
 	writeWorkbenchExploreStatus(runRoot, map[string]any{
 		"site_id":      site.SiteID,
 		"run_id":       runID,
@@ -125,7 +125,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 		pageIndex := len(pageCards) + 1
 		segmentStart := recorder.Len()
 		eventSegmentStart := eventRecorder.Len()
-		log.Printf("workbench explore visit site=%s run_id=%s page_index=%d url=%s", site.SiteID, runID, pageIndex, currentURL)
+		slog.Info("workbench explore visit", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "url", currentURL)
 		writeWorkbenchExploreStatus(runRoot, map[string]any{
 			"site_id":      site.SiteID,
 			"run_id":       runID,
@@ -139,7 +139,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 			Timeout:   playwright.Float(float64(timeoutMS)),
 			WaitUntil: playwright.WaitUntilStateCommit,
 		}); err != nil {
-			log.Printf("workbench explore goto failed site=%s run_id=%s url=%s err=%v", site.SiteID, runID, currentURL, err)
+			slog.Warn("workbench explore goto failed", "site", site.SiteID, "run_id", runID, "url", currentURL, "err", err)
 			writeWorkbenchExploreStatus(runRoot, map[string]any{
 				"site_id":      site.SiteID,
 				"run_id":       runID,
@@ -152,7 +152,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 			})
 			continue
 		}
-		log.Printf("workbench explore goto done site=%s run_id=%s page_index=%d landed_url=%s", site.SiteID, runID, pageIndex, page.URL())
+		slog.Info("workbench explore goto done", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "landed_url", page.URL())
 		writeWorkbenchExploreStatus(runRoot, map[string]any{
 			"site_id":      site.SiteID,
 			"run_id":       runID,
@@ -169,18 +169,18 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 		if settleTimeout > 5000 {
 			settleTimeout = 5000
 		}
-		log.Printf("workbench explore wait_domcontentloaded site=%s run_id=%s page_index=%d timeout_ms=%d", site.SiteID, runID, pageIndex, settleTimeout)
+		slog.Info("workbench explore wait_domcontentloaded", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "timeout_ms", settleTimeout)
 		_ = page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 			State:   playwright.LoadStateDomcontentloaded,
 			Timeout: playwright.Float(float64(settleTimeout)),
 		})
-		log.Printf("workbench explore wait_load site=%s run_id=%s page_index=%d timeout_ms=%d", site.SiteID, runID, pageIndex, minWorkbenchInt(settleTimeout, 2500))
+		slog.Info("workbench explore wait_load", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "timeout_ms", minWorkbenchInt(settleTimeout, 2500))
 		_ = page.WaitForLoadState(playwright.PageWaitForLoadStateOptions{
 			State:   playwright.LoadStateLoad,
 			Timeout: playwright.Float(float64(minWorkbenchInt(settleTimeout, 2500))),
 		})
 		page.WaitForTimeout(float64(minWorkbenchInt(settleTimeout/2, 1200)))
-		log.Printf("workbench explore settled site=%s run_id=%s page_index=%d url=%s", site.SiteID, runID, pageIndex, page.URL())
+		slog.Info("workbench explore settled", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "url", page.URL())
 		writeWorkbenchExploreStatus(runRoot, map[string]any{
 			"site_id":      site.SiteID,
 			"run_id":       runID,
@@ -197,27 +197,27 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 		}
 
 		shapeURL := page.URL()
-		log.Printf("workbench explore shape start site=%s run_id=%s page_index=%d url=%s", site.SiteID, runID, pageIndex, shapeURL)
+		slog.Info("workbench explore shape start", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "url", shapeURL)
 		shape := buildWorkbenchFallbackShape(shapeURL, "dom probe disabled in safe mode")
 		if strings.TrimSpace(site.SessionName) == "" {
 			if httpShape, httpErr := extractWorkbenchPageShapeFromHTTP(shapeURL); httpErr == nil && httpShape != nil {
 				httpShape.Title = firstNonEmpty(strings.TrimSpace(httpShape.Title), workbenchSafePageTitle(page), normalizeWorkbenchRoute(shapeURL), shapeURL)
 				shape = httpShape
 			} else if httpErr != nil {
-				log.Printf("workbench explore http shape fallback failed site=%s run_id=%s url=%s err=%v", site.SiteID, runID, shapeURL, httpErr)
+				slog.Warn("workbench explore http shape fallback failed", "site", site.SiteID, "run_id", runID, "url", shapeURL, "err", httpErr)
 			}
 			if workbenchShapeNeedsElementProbe(shape) {
-				log.Printf("workbench explore public key probe start site=%s run_id=%s url=%s", site.SiteID, runID, shapeURL)
+				slog.Info("workbench explore public key probe start", "site", site.SiteID, "run_id", runID, "url", shapeURL)
 				if probedShape, probeErr := probeWorkbenchPublicKeyElementsSafe(context, shapeURL, timeoutMS); probeErr == nil && probedShape != nil {
 					shape = mergeWorkbenchShapes(shape, probedShape, shapeURL)
-					log.Printf("workbench explore public key probe done site=%s run_id=%s url=%s forms=%d actions=%d links=%d", site.SiteID, runID, shapeURL, len(shape.Forms), len(shape.Actions), len(shape.Links))
+					slog.Info("workbench explore public key probe done", "site", site.SiteID, "run_id", runID, "url", shapeURL, "forms", len(shape.Forms), "actions", len(shape.Actions), "links", len(shape.Links))
 				} else if probeErr != nil {
-					log.Printf("workbench explore public key probe failed site=%s run_id=%s url=%s err=%v", site.SiteID, runID, shapeURL, probeErr)
+					slog.Warn("workbench explore public key probe failed", "site", site.SiteID, "run_id", runID, "url", shapeURL, "err", probeErr)
 				}
 			}
 		}
 		shape.Title = firstNonEmpty(strings.TrimSpace(shape.Title), workbenchSafePageTitle(page), normalizeWorkbenchRoute(shapeURL), shapeURL)
-		log.Printf("workbench explore shape done site=%s run_id=%s page_index=%d forms=%d actions=%d links=%d", site.SiteID, runID, pageIndex, len(shape.Forms), len(shape.Actions), len(shape.Links))
+		slog.Info("workbench explore shape done", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "forms", len(shape.Forms), "actions", len(shape.Actions), "links", len(shape.Links))
 		writeWorkbenchExploreStatus(runRoot, map[string]any{
 			"site_id":      site.SiteID,
 			"run_id":       runID,
@@ -230,7 +230,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 			"links":        len(shape.Links),
 			"updated_at":   time.Now().Format(time.RFC3339Nano),
 		})
-		log.Printf("workbench explore observe start site=%s run_id=%s page_index=%d url=%s", site.SiteID, runID, pageIndex, shapeURL)
+		slog.Info("workbench explore observe start", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "url", shapeURL)
 		observation := observeWorkbenchPageNoEvaluate(nil, shape, PageObservationOptions{
 			URL:          shapeURL,
 			Headless:     options.Headless,
@@ -238,7 +238,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 			TimeoutMS:    timeoutMS,
 			RunRoot:      pageRunRoot,
 		})
-		log.Printf("workbench explore observe done site=%s run_id=%s page_index=%d interactive=%d content=%d errors=%d", site.SiteID, runID, pageIndex, len(observation.Elements), len(observation.ContentElements), len(observation.Errors))
+		slog.Info("workbench explore observe done", "site", site.SiteID, "run_id", runID, "page_index", pageIndex, "interactive", len(observation.Elements), "content", len(observation.ContentElements), "errors", len(observation.Errors))
 		writeWorkbenchExploreStatus(runRoot, map[string]any{
 			"site_id":            site.SiteID,
 			"run_id":             runID,
@@ -260,7 +260,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 		pageCard := buildWorkbenchPageCard(site, runID, exploreMode, shapeURL, shape, observation, observationPath, pageRecords, eventRecorder.Since(eventSegmentStart))
 		pageCards = append(pageCards, pageCard)
 		explored = append(explored, shapeURL)
-		log.Printf("workbench explore observed site=%s run_id=%s route=%s inputs=%d actions=%d links=%d events=%d", site.SiteID, runID, pageCard.NormalizedRoute, len(pageCard.InputFields), len(pageCard.Actions), len(pageCard.Links), len(pageCard.Events))
+		slog.Info("workbench explore observed", "site", site.SiteID, "run_id", runID, "route", pageCard.NormalizedRoute, "inputs", len(pageCard.InputFields), "actions", len(pageCard.Actions), "links", len(pageCard.Links), "events", len(pageCard.Events))
 
 		for _, apiCard := range buildWorkbenchAPICards(site, pageCard, pageRecords) {
 			apiCardsByID[apiCard.ID] = apiCard
@@ -320,7 +320,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 		APIs:         apiCards,
 		Entities:     entityCards,
 	}
-	log.Printf("workbench explore save site=%s run_id=%s pages=%d apis=%d entities=%d", site.SiteID, runID, len(pageCards), len(apiCards), len(entityCards))
+	slog.Info("workbench explore save", "site", site.SiteID, "run_id", runID, "pages", len(pageCards), "apis", len(apiCards), "entities", len(entityCards))
 	writeWorkbenchExploreStatus(runRoot, map[string]any{
 		"site_id":      site.SiteID,
 		"run_id":       runID,
@@ -353,7 +353,7 @@ func ExploreWorkbenchSite(options WorkbenchExploreOptions) (*WorkbenchExploreRes
 		"entities":     len(entityCards),
 		"updated_at":   time.Now().Format(time.RFC3339Nano),
 	})
-	log.Printf("workbench explore persist done site=%s run_id=%s result=%s", site.SiteID, runID, filepath.Join(runRoot, "result.json"))
+	slog.Info("workbench explore persist done", "site", site.SiteID, "run_id", runID, "result", filepath.Join(runRoot, "result.json"))
 	return savedResult, nil
 }
 
@@ -373,6 +373,12 @@ func resolveWorkbenchExploreSite(options WorkbenchExploreOptions) (WorkbenchSite
 		if strings.TrimSpace(options.SessionName) != "" {
 			site.SessionName = strings.TrimSpace(options.SessionName)
 		}
+		if strings.TrimSpace(options.BrowserEngine) != "" {
+			if err := validateFlowBrowserEngine(options.BrowserEngine); err != nil {
+				return WorkbenchSiteConfig{}, err
+			}
+			site.BrowserEngine = normalizeFlowBrowserEngine(options.BrowserEngine)
+		}
 		return *site, nil
 	}
 
@@ -398,6 +404,7 @@ func resolveWorkbenchExploreSite(options WorkbenchExploreOptions) (WorkbenchSite
 		StartURL:       startURL,
 		AllowedDomains: options.AllowedDomains,
 		SessionName:    options.SessionName,
+		BrowserEngine:  options.BrowserEngine,
 	}, options.ArtifactRoot)
 	if err != nil {
 		return WorkbenchSiteConfig{}, err
@@ -406,17 +413,13 @@ func resolveWorkbenchExploreSite(options WorkbenchExploreOptions) (WorkbenchSite
 }
 
 func launchWorkbenchBrowser(site WorkbenchSiteConfig, artifactRoot string, headless bool) (*playwright.Playwright, playwright.Browser, playwright.BrowserContext, playwright.Page, func() error, error) {
-	pw, err := StartPlaywright()
-	if err != nil {
-		return nil, nil, nil, nil, nil, err
-	}
 	config := FlowBrowserConfig{
+		Engine:   site.BrowserEngine,
 		Headless: &headless,
 	}
 	if strings.TrimSpace(site.SessionName) != "" {
 		savedConfig, err := ResolveFlowSavedSessionBrowserConfig(site.SessionName, artifactRoot)
 		if err != nil {
-			_ = pw.Stop()
 			return nil, nil, nil, nil, nil, err
 		}
 		if savedConfig != nil {
@@ -429,8 +432,25 @@ func launchWorkbenchBrowser(site WorkbenchSiteConfig, artifactRoot string, headl
 			config.Persistent = savedConfig.Persistent
 			config.Profile = savedConfig.Profile
 			config.Session = savedConfig.Session
+			if err := applyFlowSavedSessionEngine(&config, *savedConfig, site.SessionName); err != nil {
+				return nil, nil, nil, nil, nil, err
+			}
+			applyFlowBrowserFingerprint(&config, savedConfig.fingerprint())
+			config.Proxy = savedConfig.Proxy
 		}
 	}
+	pw, err := StartPlaywright(config.engine())
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	config, err = resolveFlowBrowserDevice(pw, config)
+	if err == nil {
+		config.Proxy, err = config.Proxy.resolve()
+	}
+	if err != nil {
+		_ = pw.Stop()
+		return nil, nil, nil, nil, nil, err
+	}
 
 	options := FlowRunOptions{
 		Headless:     headless,
@@ -664,7 +684,7 @@ func observeWorkbenchPageLightUnsafe(page playwright.Page, options PageObservati
 	if page == nil {
 		return nil, fmt.Errorf("page is nil")
 	}
-	log.Printf("workbench observe start url=%s run_root=%s", page.URL(), options.RunRoot)
+	slog.Info("workbench observe start", "url", page.URL(), "run_root", options.RunRoot)
 
 	fullOptions := options
 	if fullOptions.MaxElements <= 0 {
@@ -672,11 +692,11 @@ func observeWorkbenchPageLightUnsafe(page playwright.Page, options PageObservati
 	}
 	observation, err := ObserveLoadedPage(page, fullOptions)
 	if err == nil {
-		log.Printf("workbench observe content url=%s skipped=false count=%d errors=%d", observation.URL, len(observation.ContentElements), len(observation.Errors))
-		log.Printf("workbench observe elements url=%s skipped=false count=%d errors=%d", observation.URL, len(observation.Elements), len(observation.Errors))
+		slog.Info("workbench observe content", "url", observation.URL, "skipped", false, "count", len(observation.ContentElements), "errors", len(observation.Errors))
+		slog.Info("workbench observe elements", "url", observation.URL, "skipped", false, "count", len(observation.Elements), "errors", len(observation.Errors))
 		return observation, nil
 	}
-	log.Printf("workbench observe full failed url=%s err=%v", page.URL(), err)
+	slog.Warn("workbench observe full failed", "url", page.URL(), "err", err)
 
 	artifactRoot := strings.TrimSpace(options.ArtifactRoot)
 	if artifactRoot == "" {
@@ -719,7 +739,7 @@ func observeWorkbenchPageLightUnsafe(page playwright.Page, options PageObservati
 	} else {
 		observation.ContentElements = contentElements
 	}
-	log.Printf("workbench observe content url=%s skipped=false count=%d errors=%d", observation.URL, len(observation.ContentElements), len(observation.Errors))
+	slog.Info("workbench observe content", "url", observation.URL, "skipped", false, "count", len(observation.ContentElements), "errors", len(observation.Errors))
 
 	elements, err := observeWorkbenchInteractiveElementsLight(page)
 	if err != nil {
@@ -727,7 +747,7 @@ func observeWorkbenchPageLightUnsafe(page playwright.Page, options PageObservati
 	} else {
 		observation.Elements = elements
 	}
-	log.Printf("workbench observe elements url=%s skipped=false count=%d errors=%d", observation.URL, len(observation.Elements), len(observation.Errors))
+	slog.Info("workbench observe elements", "url", observation.URL, "skipped", false, "count", len(observation.Elements), "errors", len(observation.Errors))
 
 	observation.PageSummary = buildObservationPageSummary(observation)
 	return observation, nil
@@ -3345,7 +3365,7 @@ func writeWorkbenchExploreStatus(runRoot string, payload map[string]any) {
 	}
 	path := filepath.Join(runRoot, "status.json")
 	if err := writeWorkbenchJSON(path, payload); err != nil {
-		log.Printf("workbench explore status write_failed run_root=%s err=%v", runRoot, err)
+		slog.Warn("workbench explore status write_failed", "run_root", runRoot, "err", err)
 	}
 }
 
@@ -8,6 +8,7 @@ type WorkbenchSiteConfig struct {
 	StartURL       string   `json:"start_url"`
 	AllowedDomains []string `json:"allowed_domains,omitempty"`
 	SessionName    string   `json:"session_name,omitempty"`
+	BrowserEngine  string   `json:"browser_engine,omitempty"`
 	ProviderID     string   `json:"provider_id,omitempty"`
 	CreatedAt      string   `json:"created_at,omitempty"`
 	UpdatedAt      string   `json:"updated_at,omitempty"`
@@ -55,6 +56,7 @@ type WorkbenchExploreOptions struct {
 	StartURL       string
 	AllowedDomains []string
 	SessionName    string
+	BrowserEngine  string
 	ArtifactRoot   string
 	Headless       bool
 	TimeoutMS      int
@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"sort"
@@ -35,23 +35,23 @@ func BuildWorkbenchTaskPlan(options WorkbenchTaskPlanOptions) (*WorkbenchTaskPla
 	if err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
-	log.Printf(
-		"workbench planner knowledge site=%s pages=%d apis=%d realtime_context=%t intent=%q",
-		siteID,
-		len(pages),
-		len(apis),
-		options.realtimeContext != nil,
-		workbenchCleanText(intent, 120),
+	slog.Info(
+		"workbench planner knowledge",
+		"site", siteID,
+		"pages", len(pages),
+		"apis", len(apis),
+		"realtime_context", options.realtimeContext != nil,
+		"intent", workbenchCleanText(intent, 120),
 	)
 
 	pageCandidates := rankWorkbenchPages(intent, pages)
 	pageCandidates = boostWorkbenchPageCandidatesWithRealtimeContext(pageCandidates, pages, options.realtimeContext)
 	apiCandidates := rankWorkbenchAPIs(intent, apis)
-	log.Printf(
-		"workbench planner ranked site=%s top_page=%s top_api=%s",
-		siteID,
-		workbenchCandidateDebugLabel(firstWorkbenchCandidate(pageCandidates)),
-		workbenchCandidateDebugLabel(firstWorkbenchCandidate(apiCandidates)),
+	slog.Info(
+		"workbench planner ranked",
+		"site", siteID,
+		"top_page", workbenchCandidateDebugLabel(firstWorkbenchCandidate(pageCandidates)),
+		"top_api", workbenchCandidateDebugLabel(firstWorkbenchCandidate(apiCandidates)),
 	)
 	plan := &WorkbenchTaskPlan{
 		SiteID:         siteID,
@@ -68,10 +68,10 @@ func BuildWorkbenchTaskPlan(options WorkbenchTaskPlanOptions) (*WorkbenchTaskPla
 			flow = drafted
 			plan.Strategy = "ui_live"
 			plan.Reason = "Used real-time page observation passed by caller, so TSPlay can draft directly from the current page context."
-			log.Printf("workbench planner chose ui_live site=%s url=%s", siteID, firstNonEmpty(options.realtimeContext.URL, site.StartURL))
+			slog.Info("workbench planner chose ui_live", "site", siteID, "url", firstNonEmpty(options.realtimeContext.URL, site.StartURL))
 		} else if err != nil {
 			addWorkbenchPlanWarning(plan, fmt.Sprintf("Real-time page context could not be drafted directly: %s. Falling back to stored site knowledge.", err.Error()))
-			log.Printf("workbench planner realtime_draft_failed site=%s err=%v", siteID, err)
+			slog.Warn("workbench planner realtime_draft_failed", "site", siteID, "err", err)
 		}
 	}
 	if flow == nil && len(pageCandidates) > 0 {
@@ -81,7 +81,7 @@ func BuildWorkbenchTaskPlan(options WorkbenchTaskPlanOptions) (*WorkbenchTaskPla
 				flow = drafted
 				plan.Strategy = "ui_first"
 				plan.Reason = "Matched a known page card with saved observation data, so TSPlay can draft a selector-aware flow."
-				log.Printf("workbench planner chose ui_first_draft site=%s page=%s", siteID, pageCard.ID)
+				slog.Info("workbench planner chose ui_first_draft", "site", siteID, "page", pageCard.ID)
 			}
 		}
 	}
@@ -90,7 +90,7 @@ func BuildWorkbenchTaskPlan(options WorkbenchTaskPlanOptions) (*WorkbenchTaskPla
 			flow = buildWorkbenchAPIFallbackFlow(*site, *apiCard, intent)
 			plan.Strategy = "api_first"
 			plan.Reason = "Matched a readable API card, so the planner generated an API-first flow that reuses browser cookies."
-			log.Printf("workbench planner chose api_first site=%s api=%s", siteID, apiCard.ID)
+			slog.Info("workbench planner chose api_first", "site", siteID, "api", apiCard.ID)
 		}
 	}
 	if flow == nil && len(pageCandidates) > 0 {
@@ -98,13 +98,13 @@ func BuildWorkbenchTaskPlan(options WorkbenchTaskPlanOptions) (*WorkbenchTaskPla
 			flow = buildWorkbenchPageFallbackFlow(*site, *pageCard, intent)
 			plan.Strategy = "ui_first"
 			plan.Reason = "Matched a page card, but no saved observation was available for richer drafting, so the planner generated a navigation-first fallback flow."
-			log.Printf("workbench planner chose ui_first_fallback site=%s page=%s", siteID, pageCard.ID)
+			slog.Info("workbench planner chose ui_first_fallback", "site", siteID, "page", pageCard.ID)
 		}
 	}
 	if flow == nil {
 		plan.Strategy = "needs_input"
 		plan.Reason = "No high-confidence page or API candidates were found in the local knowledge store."
-		log.Printf("workbench planner no_match site=%s", siteID)
+		slog.Info("workbench planner no_match", "site", siteID)
 		return plan, nil
 	}
 	plan.Flow = flow
@@ -114,7 +114,7 @@ func BuildWorkbenchTaskPlan(options WorkbenchTaskPlanOptions) (*WorkbenchTaskPla
 		return nil, err
 	}
 	plan.FlowYAML = flowYAML
-	log.Printf("workbench planner flow_ready site=%s flow=%s strategy=%s steps=%d", siteID, flow.Name, plan.Strategy, len(flow.Steps))
+	slog.Info("workbench planner flow_ready", "site", siteID, "flow", flow.Name, "strategy", plan.Strategy, "steps", len(flow.Steps))
 	return plan, nil
 }
 
@@ -419,6 +419,9 @@ func buildWorkbenchPageFlow(site *WorkbenchSiteConfig, card WorkbenchPageCard, i
 	if site != nil && strings.TrimSpace(site.SessionName) != "" {
 		draft.Flow.Browser.UseSession = site.SessionName
 	}
+	if site != nil && site.BrowserEngine != "" {
+		draft.Flow.Browser.Engine = site.BrowserEngine
+	}
 	return draft.Flow, nil
 }
 
@@ -452,6 +455,9 @@ func buildWorkbenchRealtimeContextFlow(site *WorkbenchSiteConfig, realtimeContex
 	if site != nil && strings.TrimSpace(site.SessionName) != "" {
 		draft.Flow.Browser.UseSession = site.SessionName
 	}
+	if site != nil && site.BrowserEngine != "" {
+		draft.Flow.Browser.Engine = site.BrowserEngine
+	}
 	return draft.Flow, nil
 }
 
@@ -471,8 +477,8 @@ func buildWorkbenchPageFallbackFlow(site WorkbenchSiteConfig, card WorkbenchPage
 			},
 		},
 	}
-	if site.SessionName != "" {
-		flow.Browser = &FlowBrowserConfig{UseSession: site.SessionName}
+	if site.SessionName != "" || site.BrowserEngine != "" {
+		flow.Browser = &FlowBrowserConfig{UseSession: site.SessionName, Engine: site.BrowserEngine}
 	}
 	if len(card.Tables) > 0 {
 		tableSelector := firstNonEmpty(card.Tables[0].Selector, "table")
@@ -506,8 +512,10 @@ func buildWorkbenchAPIFallbackFlow(site WorkbenchSiteConfig, card WorkbenchAPICa
 		},
 		Steps: []FlowStep{},
 	}
+	if site.SessionName != "" || site.BrowserEngine != "" {
+		flow.Browser = &FlowBrowserConfig{UseSession: site.SessionName, Engine: site.BrowserEngine}
+	}
 	if site.SessionName != "" {
-		flow.Browser = &FlowBrowserConfig{UseSession: site.SessionName}
 		flow.Steps = append(flow.Steps, FlowStep{
 			Name:   "open site for browser cookies",
 			Action: "navigate",
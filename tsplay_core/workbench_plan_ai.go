@@ -497,6 +497,7 @@ func compactWorkbenchObservationForAI(observation *PageObservation) map[string]a
 				"near_text":           item.NearText,
 				"primary_selector":    item.PrimarySelector,
 				"selector_candidates": trimWorkbenchStringList(item.SelectorCandidates, 4),
+				"frame":               item.Frame,
 			})
 		}
 		value["interactive_elements"] = elements
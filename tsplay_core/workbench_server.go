@@ -3,10 +3,11 @@ package tsplay_core
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,6 +24,8 @@ func NewWorkbenchAPIHandler(artifactRoot string) http.Handler {
 	mux.HandleFunc("/api/workbench/health", server.handleHealth)
 	mux.HandleFunc("/api/workbench/sessions", server.handleSessions)
 	mux.HandleFunc("/api/workbench/sessions/", server.handleSessionByName)
+	mux.HandleFunc("/api/workbench/runs", server.handleRuns)
+	mux.HandleFunc("/api/workbench/runs/", server.handleRunByID)
 	mux.HandleFunc("/api/workbench/providers", server.handleProviders)
 	mux.HandleFunc("/api/workbench/providers/", server.handleProviderByID)
 	mux.HandleFunc("/api/workbench/sites", server.handleSites)
@@ -36,7 +39,10 @@ func NewWorkbenchAPIHandler(artifactRoot string) http.Handler {
 }
 
 func StartWorkbenchAPIServer(addr string, artifactRoot string) error {
-	return http.ListenAndServe(addr, NewWorkbenchAPIHandler(artifactRoot))
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", NewMetricsHandler())
+	mux.Handle("/", NewWorkbenchAPIHandler(artifactRoot))
+	return http.ListenAndServe(addr, mux)
 }
 
 func withWorkbenchCORS(next http.Handler) http.Handler {
@@ -61,12 +67,12 @@ func withWorkbenchRequestLogging(next http.Handler) http.Handler {
 		if workbenchShouldSkipAccessLog(r.Method, r.URL.Path, recorder.status, duration) {
 			return
 		}
-		log.Printf(
-			"workbench api method=%s path=%s status=%d duration_ms=%d",
-			r.Method,
-			r.URL.Path,
-			recorder.status,
-			duration.Milliseconds(),
+		slog.Info(
+			"workbench api",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", duration.Milliseconds(),
 		)
 	})
 }
@@ -82,6 +88,7 @@ func workbenchShouldSkipAccessLog(method string, path string, status int, durati
 	switch {
 	case path == "/api/workbench/health",
 		path == "/api/workbench/sessions",
+		path == "/api/workbench/runs",
 		path == "/api/workbench/providers",
 		path == "/api/workbench/sites":
 		return true
@@ -132,11 +139,14 @@ func (s *workbenchServer) handleSessions(w http.ResponseWriter, r *http.Request)
 		})
 	case http.MethodPost:
 		var payload struct {
-			Name             string `json:"name"`
-			StorageState     string `json:"storage_state"`
-			StorageStatePath string `json:"storage_state_path"`
-			Profile          string `json:"profile"`
-			Session          string `json:"session"`
+			Name             string                  `json:"name"`
+			StorageState     string                  `json:"storage_state"`
+			StorageStatePath string                  `json:"storage_state_path"`
+			Profile          string                  `json:"profile"`
+			Session          string                  `json:"session"`
+			Engine           string                  `json:"engine"`
+			Fingerprint      *FlowBrowserFingerprint `json:"fingerprint"`
+			Proxy            *FlowBrowserProxy       `json:"proxy"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			writeWorkbenchError(w, http.StatusBadRequest, fmt.Errorf("decode session request: %w", err))
@@ -149,12 +159,15 @@ func (s *workbenchServer) handleSessions(w http.ResponseWriter, r *http.Request)
 			StorageStatePath: payload.StorageStatePath,
 			Profile:          payload.Profile,
 			Session:          payload.Session,
+			Engine:           payload.Engine,
+			Fingerprint:      payload.Fingerprint,
+			Proxy:            payload.Proxy,
 		})
 		if err != nil {
 			writeWorkbenchError(w, http.StatusBadRequest, err)
 			return
 		}
-		log.Printf("workbench session saved name=%s kind=%s", saved.Name, saved.Kind)
+		slog.Info("workbench session saved", "name", saved.Name, "kind", saved.Kind)
 		writeWorkbenchResponse(w, http.StatusOK, BuildFlowSavedSessionDetail(*saved, s.artifactRoot))
 	default:
 		workbenchMethodNotAllowed(w, http.MethodGet, http.MethodPost)
@@ -180,6 +193,56 @@ func (s *workbenchServer) handleSessionByName(w http.ResponseWriter, r *http.Req
 	writeWorkbenchResponse(w, http.StatusOK, BuildFlowSavedSessionDetail(*session, s.artifactRoot))
 }
 
+func (s *workbenchServer) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		workbenchMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	values := r.URL.Query()
+	limit := defaultFlowRunQueryLimit
+	if text := strings.TrimSpace(values.Get("limit")); text != "" {
+		parsed, err := strconv.Atoi(text)
+		if err != nil {
+			writeWorkbenchError(w, http.StatusBadRequest, fmt.Errorf("limit must be an integer"))
+			return
+		}
+		limit = parsed
+	}
+	query, err := flowRunQueryFromValues(values.Get("flow"), values.Get("status"), values.Get("session"), values.Get("tool"), values.Get("since"), values.Get("until"), limit)
+	if err != nil {
+		writeWorkbenchError(w, http.StatusBadRequest, err)
+		return
+	}
+	// The workbench has no MCP session, so MCP runs stay with their callers.
+	query.Owner = new(string)
+	runs, err := ListFlowRuns(firstNonEmpty(s.artifactRoot, DefaultFlowArtifactRoot), query)
+	if err != nil {
+		writeWorkbenchError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeWorkbenchResponse(w, http.StatusOK, map[string]any{
+		"runs": runs,
+	})
+}
+
+func (s *workbenchServer) handleRunByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		workbenchMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	runID := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/workbench/runs/"))
+	if runID == "" {
+		writeWorkbenchError(w, http.StatusBadRequest, fmt.Errorf("run id is required"))
+		return
+	}
+	run, err := GetFlowRunForSession(firstNonEmpty(s.artifactRoot, DefaultFlowArtifactRoot), runID, "")
+	if err != nil {
+		writeWorkbenchError(w, http.StatusNotFound, err)
+		return
+	}
+	writeWorkbenchResponse(w, http.StatusOK, run)
+}
+
 func (s *workbenchServer) handleProviders(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -206,7 +269,7 @@ func (s *workbenchServer) handleProviders(w http.ResponseWriter, r *http.Request
 			writeWorkbenchError(w, http.StatusBadRequest, err)
 			return
 		}
-		log.Printf("workbench provider saved provider_id=%s type=%s enabled=%v", saved.ProviderID, saved.Type, saved.Enabled)
+		slog.Info("workbench provider saved", "provider_id", saved.ProviderID, "type", saved.Type, "enabled", saved.Enabled)
 		writeWorkbenchResponse(w, http.StatusOK, BuildWorkbenchProviderView(*saved))
 	default:
 		workbenchMethodNotAllowed(w, http.MethodGet, http.MethodPost)
@@ -254,13 +317,13 @@ func (s *workbenchServer) handleSites(w http.ResponseWriter, r *http.Request) {
 			writeWorkbenchError(w, http.StatusBadRequest, err)
 			return
 		}
-		log.Printf(
-			"workbench site saved site_id=%s start_url=%s session=%s provider=%s domains=%d",
-			saved.SiteID,
-			saved.StartURL,
-			saved.SessionName,
-			saved.ProviderID,
-			len(saved.AllowedDomains),
+		slog.Info(
+			"workbench site saved",
+			"site_id", saved.SiteID,
+			"start_url", saved.StartURL,
+			"session", saved.SessionName,
+			"provider", saved.ProviderID,
+			"domains", len(saved.AllowedDomains),
 		)
 		writeWorkbenchResponse(w, http.StatusOK, saved)
 	default:
@@ -297,37 +360,39 @@ func (s *workbenchServer) handleSiteSubroutes(w http.ResponseWriter, r *http.Req
 			return
 		}
 		var payload struct {
-			Headless  bool `json:"headless"`
-			TimeoutMS int  `json:"timeout_ms"`
-			MaxPages  int  `json:"max_pages"`
+			Headless      bool   `json:"headless"`
+			TimeoutMS     int    `json:"timeout_ms"`
+			MaxPages      int    `json:"max_pages"`
+			BrowserEngine string `json:"browser_engine"`
 		}
 		_ = json.NewDecoder(r.Body).Decode(&payload)
-		log.Printf(
-			"workbench explore request site=%s headless=%v max_pages=%d timeout_ms=%d",
-			siteID,
-			payload.Headless,
-			payload.MaxPages,
-			payload.TimeoutMS,
+		slog.Info(
+			"workbench explore request",
+			"site", siteID,
+			"headless", payload.Headless,
+			"max_pages", payload.MaxPages,
+			"timeout_ms", payload.TimeoutMS,
 		)
 		result, err := ExploreWorkbenchSite(WorkbenchExploreOptions{
-			SiteID:       siteID,
-			ArtifactRoot: s.artifactRoot,
-			Headless:     payload.Headless,
-			TimeoutMS:    payload.TimeoutMS,
-			MaxPages:     payload.MaxPages,
+			SiteID:        siteID,
+			ArtifactRoot:  s.artifactRoot,
+			Headless:      payload.Headless,
+			BrowserEngine: payload.BrowserEngine,
+			TimeoutMS:     payload.TimeoutMS,
+			MaxPages:      payload.MaxPages,
 		})
 		if err != nil {
 			writeWorkbenchError(w, http.StatusBadRequest, err)
 			return
 		}
-		log.Printf(
-			"workbench explore response site=%s run_id=%s mode=%s pages=%d apis=%d entities=%d",
-			siteID,
-			result.RunID,
-			result.ExploreMode,
-			len(result.Pages),
-			len(result.APIs),
-			len(result.Entities),
+		slog.Info(
+			"workbench explore response",
+			"site", siteID,
+			"run_id", result.RunID,
+			"mode", result.ExploreMode,
+			"pages", len(result.Pages),
+			"apis", len(result.APIs),
+			"entities", len(result.Entities),
 		)
 		writeWorkbenchResponse(w, http.StatusOK, result)
 	case "pages":
@@ -379,27 +444,27 @@ func (s *workbenchServer) handleTaskPlan(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	payload.ArtifactRoot = firstNonEmpty(payload.ArtifactRoot, s.artifactRoot)
-	log.Printf(
-		"workbench plan request site=%s provider_id=%s intent=%q",
-		payload.SiteID,
-		payload.ProviderID,
-		workbenchCleanText(payload.Intent, 120),
+	slog.Info(
+		"workbench plan request",
+		"site", payload.SiteID,
+		"provider_id", payload.ProviderID,
+		"intent", workbenchCleanText(payload.Intent, 120),
 	)
 	plan, err := s.buildWorkbenchTaskPlan(payload)
 	if err != nil {
 		writeWorkbenchError(w, http.StatusBadRequest, err)
 		return
 	}
-	log.Printf(
-		"workbench plan response site=%s strategy=%s generation=%s matched_pages=%d matched_apis=%d flow_name=%s requires_confirm=%v warnings=%d",
-		plan.SiteID,
-		plan.Strategy,
-		plan.GenerationMode,
-		len(plan.MatchedPages),
-		len(plan.MatchedAPIs),
-		plan.FlowName,
-		plan.RequiresUserConfirm,
-		len(plan.Warnings),
+	slog.Info(
+		"workbench plan response",
+		"site", plan.SiteID,
+		"strategy", plan.Strategy,
+		"generation", plan.GenerationMode,
+		"matched_pages", len(plan.MatchedPages),
+		"matched_apis", len(plan.MatchedAPIs),
+		"flow_name", plan.FlowName,
+		"requires_confirm", plan.RequiresUserConfirm,
+		"warnings", len(plan.Warnings),
 	)
 	writeWorkbenchResponse(w, http.StatusOK, plan)
 }
@@ -480,12 +545,12 @@ func (s *workbenchServer) handleTaskRun(w http.ResponseWriter, r *http.Request)
 		writeWorkbenchError(w, http.StatusBadRequest, fmt.Errorf("decode task run request: %w", err))
 		return
 	}
-	log.Printf(
-		"workbench run request site=%s flow_yaml=%t headless_override=%t intent=%q",
-		payload.SiteID,
-		strings.TrimSpace(payload.FlowYAML) != "",
-		payload.Headless != nil,
-		workbenchCleanText(payload.Intent, 120),
+	slog.Info(
+		"workbench run request",
+		"site", payload.SiteID,
+		"flow_yaml", strings.TrimSpace(payload.FlowYAML) != "",
+		"headless_override", payload.Headless != nil,
+		"intent", workbenchCleanText(payload.Intent, 120),
 	)
 
 	artifactRoot := firstNonEmpty(strings.TrimSpace(payload.ArtifactRoot), s.artifactRoot)
@@ -498,7 +563,7 @@ func (s *workbenchServer) handleTaskRun(w http.ResponseWriter, r *http.Request)
 	if flowYAML != "" {
 		flow, err = ParseFlow([]byte(flowYAML), "yaml")
 		if err != nil {
-			log.Printf("workbench run parse_flow_failed site=%s err=%v", payload.SiteID, err)
+			slog.Warn("workbench run parse_flow_failed", "site", payload.SiteID, "err", err)
 			writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 				"ok":    false,
 				"error": err.Error(),
@@ -506,7 +571,7 @@ func (s *workbenchServer) handleTaskRun(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	} else {
-		log.Printf("workbench run planning_inline site=%s provider_id=%s", payload.SiteID, payload.ProviderID)
+		slog.Info("workbench run planning_inline", "site", payload.SiteID, "provider_id", payload.ProviderID)
 		plan, err = s.buildWorkbenchTaskPlan(WorkbenchTaskPlanOptions{
 			SiteID:       payload.SiteID,
 			ArtifactRoot: artifactRoot,
@@ -514,7 +579,7 @@ func (s *workbenchServer) handleTaskRun(w http.ResponseWriter, r *http.Request)
 			ProviderID:   payload.ProviderID,
 		})
 		if err != nil {
-			log.Printf("workbench run planning_failed site=%s err=%v", payload.SiteID, err)
+			slog.Warn("workbench run planning_failed", "site", payload.SiteID, "err", err)
 			writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 				"ok":    false,
 				"error": err.Error(),
@@ -522,7 +587,7 @@ func (s *workbenchServer) handleTaskRun(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		if plan.Flow == nil {
-			log.Printf("workbench run no_runnable_flow site=%s strategy=%s reason=%q", payload.SiteID, plan.Strategy, plan.Reason)
+			slog.Info("workbench run no_runnable_flow", "site", payload.SiteID, "strategy", plan.Strategy, "reason", plan.Reason)
 			writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 				"ok":    false,
 				"error": firstNonEmpty(plan.Reason, "planner did not produce a runnable flow"),
@@ -593,14 +658,14 @@ func (s *workbenchServer) handleTaskRun(w http.ResponseWriter, r *http.Request)
 	if runErr != nil {
 		runErrorText = runErr.Error()
 	}
-	log.Printf(
-		"workbench run response site=%s flow=%s ok=%v run_id=%s trace_steps=%d err=%q",
-		payload.SiteID,
-		flow.Name,
-		runErr == nil,
-		runID,
-		stepCount,
-		runErrorText,
+	slog.Warn(
+		"workbench run response",
+		"site", payload.SiteID,
+		"flow", flow.Name,
+		"ok", runErr == nil,
+		"run_id", runID,
+		"trace_steps", stepCount,
+		"err", runErrorText,
 	)
 	writeWorkbenchResponse(w, http.StatusOK, response)
 }
@@ -682,7 +747,7 @@ func (s *workbenchServer) handleTaskRepair(w http.ResponseWriter, r *http.Reques
 		writeWorkbenchError(w, http.StatusBadRequest, fmt.Errorf("decode task repair request: %w", err))
 		return
 	}
-	log.Printf("workbench repair request artifact_root=%s error=%q", payload.ArtifactRoot, workbenchCleanText(payload.Error, 120))
+	slog.Info("workbench repair request", "artifact_root", payload.ArtifactRoot, "error", workbenchCleanText(payload.Error, 120))
 
 	repairData, err := s.buildWorkbenchRepairData(workbenchRepairRequest{
 		ArtifactRoot:       payload.ArtifactRoot,
@@ -692,17 +757,17 @@ func (s *workbenchServer) handleTaskRepair(w http.ResponseWriter, r *http.Reques
 		MaxArtifactExcerpt: payload.MaxArtifactExcerpt,
 	})
 	if err != nil {
-		log.Printf("workbench repair build_failed err=%v", err)
+		slog.Warn("workbench repair build_failed", "err", err)
 		writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 			"ok":    false,
 			"error": err.Error(),
 		})
 		return
 	}
-	log.Printf(
-		"workbench repair response failed_step=%s hints=%d",
-		repairData.Context.FailedStepPath,
-		len(repairData.Context.RepairHints),
+	slog.Info(
+		"workbench repair response",
+		"failed_step", repairData.Context.FailedStepPath,
+		"hints", len(repairData.Context.RepairHints),
 	)
 	writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 		"ok":      true,
@@ -729,11 +794,11 @@ func (s *workbenchServer) handleTaskRepairAuto(w http.ResponseWriter, r *http.Re
 		writeWorkbenchError(w, http.StatusBadRequest, fmt.Errorf("decode auto repair request: %w", err))
 		return
 	}
-	log.Printf(
-		"workbench repair_auto request site=%s provider_id=%s error=%q",
-		payload.SiteID,
-		payload.ProviderID,
-		workbenchCleanText(payload.Error, 120),
+	slog.Info(
+		"workbench repair_auto request",
+		"site", payload.SiteID,
+		"provider_id", payload.ProviderID,
+		"error", workbenchCleanText(payload.Error, 120),
 	)
 
 	repairData, err := s.buildWorkbenchRepairData(workbenchRepairRequest{
@@ -744,7 +809,7 @@ func (s *workbenchServer) handleTaskRepairAuto(w http.ResponseWriter, r *http.Re
 		MaxArtifactExcerpt: payload.MaxArtifactExcerpt,
 	})
 	if err != nil {
-		log.Printf("workbench repair_auto build_failed err=%v", err)
+		slog.Warn("workbench repair_auto build_failed", "err", err)
 		writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 			"ok":    false,
 			"error": err.Error(),
@@ -754,7 +819,7 @@ func (s *workbenchServer) handleTaskRepairAuto(w http.ResponseWriter, r *http.Re
 
 	providerConfig, providerView, err := s.resolveWorkbenchProvider(payload.ProviderID, payload.SiteID)
 	if err != nil {
-		log.Printf("workbench repair_auto resolve_provider_failed site=%s provider_id=%s err=%v", payload.SiteID, payload.ProviderID, err)
+		slog.Warn("workbench repair_auto resolve_provider_failed", "site", payload.SiteID, "provider_id", payload.ProviderID, "err", err)
 		writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 			"ok":       false,
 			"error":    err.Error(),
@@ -769,7 +834,7 @@ func (s *workbenchServer) handleTaskRepairAuto(w http.ResponseWriter, r *http.Re
 		firstNonEmpty(repairData.Repair.Prompt, repairData.Context.Prompt),
 	)
 	if err != nil {
-		log.Printf("workbench repair_auto provider_failed provider_id=%s err=%v", runtimeProviderView.ProviderID, err)
+		slog.Warn("workbench repair_auto provider_failed", "provider_id", runtimeProviderView.ProviderID, "err", err)
 		writeWorkbenchResponse(w, http.StatusOK, map[string]any{
 			"ok":       false,
 			"error":    err.Error(),
@@ -788,7 +853,7 @@ func (s *workbenchServer) handleTaskRepairAuto(w http.ResponseWriter, r *http.Re
 		"repaired_flow_yaml": repairedFlowYAML,
 	}
 	if _, err := ParseFlow([]byte(repairedFlowYAML), "yaml"); err != nil {
-		log.Printf("workbench repair_auto validation_failed provider_id=%s err=%v", runtimeProviderView.ProviderID, err)
+		slog.Warn("workbench repair_auto validation_failed", "provider_id", runtimeProviderView.ProviderID, "err", err)
 		response["ok"] = false
 		response["validation_error"] = err.Error()
 		writeWorkbenchResponse(w, http.StatusOK, map[string]any{
@@ -802,11 +867,11 @@ func (s *workbenchServer) handleTaskRepairAuto(w http.ResponseWriter, r *http.Re
 		})
 		return
 	}
-	log.Printf(
-		"workbench repair_auto response provider_id=%s model=%s repaired_flow=%t",
-		runtimeProviderView.ProviderID,
-		runtimeProviderView.ResolvedModel,
-		strings.TrimSpace(repairedFlowYAML) != "",
+	slog.Info(
+		"workbench repair_auto response",
+		"provider_id", runtimeProviderView.ProviderID,
+		"model", runtimeProviderView.ResolvedModel,
+		"repaired_flow", strings.TrimSpace(repairedFlowYAML) != "",
 	)
 	writeWorkbenchResponse(w, http.StatusOK, response)
 }
@@ -29,6 +29,10 @@ func SaveWorkbenchSiteConfig(config WorkbenchSiteConfig, artifactRoot string) (*
 		return nil, fmt.Errorf("start_url %q must be a valid absolute URL", startURL)
 	}
 
+	if err := validateFlowBrowserEngine(config.BrowserEngine); err != nil {
+		return nil, err
+	}
+
 	root, err := workbenchRoot(artifactRoot)
 	if err != nil {
 		return nil, err
@@ -41,6 +45,7 @@ func SaveWorkbenchSiteConfig(config WorkbenchSiteConfig, artifactRoot string) (*
 		StartURL:       startURL,
 		AllowedDomains: normalizeAllowedDomains(config.AllowedDomains, parsed.Hostname()),
 		SessionName:    strings.TrimSpace(config.SessionName),
+		BrowserEngine:  strings.ToLower(strings.TrimSpace(config.BrowserEngine)),
 		ProviderID:     strings.TrimSpace(config.ProviderID),
 		CreatedAt:      now,
 		UpdatedAt:      now,
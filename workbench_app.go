@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,10 +17,13 @@ func serveWorkbenchApp(addr string, staticRoot string, artifactRoot string) erro
 		return err
 	}
 	baseURL := staticServerBaseURL(addr)
-	fmt.Printf("Serving Workbench UI from %s\n", sourceLabel)
-	fmt.Printf("Workbench listening on %s\n", baseURL)
-	fmt.Printf("Workbench page: %s/demo/workbench.html\n", baseURL)
-	fmt.Printf("Workbench API health: %s/api/workbench/health\n", baseURL)
+	slog.Info("workbench listening",
+		"url", baseURL,
+		"ui_source", sourceLabel,
+		"page", baseURL+"/demo/workbench.html",
+		"health", baseURL+"/api/workbench/health",
+		"metrics", baseURL+"/metrics",
+	)
 	return http.ListenAndServe(addr, handler)
 }
 
@@ -49,6 +53,7 @@ func newWorkbenchServer(staticRoot string, artifactRoot string) (http.Handler, s
 	})
 	mux.Handle("/api/workbench/", apiHandler)
 	mux.Handle("/workbench-artifacts/", artifactHandler)
+	mux.Handle("/metrics", tsplay_core.NewMetricsHandler())
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" || strings.TrimSpace(r.URL.Path) == "" {
 			http.Redirect(w, r, "/demo/workbench.html", http.StatusFound)
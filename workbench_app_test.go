@@ -56,6 +56,15 @@ func TestNewWorkbenchServerServesUIAndAPI(t *testing.T) {
 	if body := rec.Body.String(); !strings.Contains(body, "\"artifact_base_path\"") {
 		t.Fatalf("unexpected app-meta body: %q", body)
 	}
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("metrics status = %d, want 200", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "# TYPE tsplay_browser_runs_total counter") {
+		t.Fatalf("unexpected metrics body: %q", body)
+	}
 }
 
 func TestNewWorkbenchServerServesArtifacts(t *testing.T) {